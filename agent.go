@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gpu-monitor/nvidia"
+	"gpu-monitor/release"
+	"gpu-monitor/report"
+	"gpu-monitor/rocm"
+)
+
+// version is stamped in at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0" -o gpumon-agent agent.go
+var version = "dev"
+
+const (
+	defaultConfigPath  = "/etc/gpumon/agent.json"
+	defaultInstallPath = "/usr/local/bin/gpumon-agent"
+	servicePath        = "/etc/systemd/system/gpumon-agent.service"
+)
+
+var (
+	configPath     = flag.String("config", defaultConfigPath, "agent config written by `gpumon-agent enroll`")
+	serverURL      = flag.String("server", "", "collector server URL (default from -config)")
+	interval       = flag.Duration("interval", 30*time.Second, "time between reports")
+	once           = flag.Bool("once", false, "send one report and exit")
+	releaseKey     = flag.String("release-key", "/etc/gpumon/release.pub", "public key agent releases must be signed with")
+	updateInterval = flag.Duration("update-interval", 10*time.Minute, "how often to check for a new agent release (0 disables updates)")
+	allowDowngrade = flag.Bool("allow-downgrade", false, "install releases older than the running version")
+	showVersion    = flag.Bool("version", false, "print the agent version and exit")
+)
+
+// credential authenticates this host's reports once it is enrolled.
+var credential string
+
+// hostLabels are sent with every heartbeat; the server replaces the
+// host's agent-provided labels with them.
+var hostLabels = map[string]string{}
+
+// agentConfig is what `gpumon-agent enroll` writes to -config.
+type agentConfig struct {
+	Server     string `json:"server"`
+	Hostname   string `json:"hostname"`
+	Credential string `json:"credential"`
+	ReleaseKey string `json:"release_key"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "enroll" {
+		enroll(os.Args[2:])
+		return
+	}
+
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(version)
+		return
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Flags given on the command line win over the config file, which is
+	// optional for hosts that never enrolled.
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if data, err := os.ReadFile(*configPath); err == nil {
+		var cfg agentConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			log.Fatalf("%s: %v", *configPath, err)
+		}
+		if !set["server"] {
+			*serverURL = cfg.Server
+		}
+		if !set["release-key"] && cfg.ReleaseKey != "" {
+			*releaseKey = cfg.ReleaseKey
+		}
+		if cfg.Hostname != "" {
+			hostname = cfg.Hostname
+		}
+		credential = cfg.Credential
+		if cfg.Labels != nil {
+			hostLabels = cfg.Labels
+		}
+	} else if !os.IsNotExist(err) || set["config"] {
+		log.Fatal(err)
+	}
+	if *serverURL == "" {
+		log.Fatal("No server configured: run `gpumon-agent enroll` or pass -server")
+	}
+	startedAt := time.Now()
+	log.Printf("gpumon-agent %s starting", version)
+
+	if *updateInterval > 0 && !*once {
+		go func() {
+			for {
+				if err := selfUpdate(hostname); err != nil {
+					log.Println("Update check failed:", err)
+				}
+				time.Sleep(*updateInterval)
+			}
+		}()
+	}
+
+	// XID errors are picked up from the kernel log written since the
+	// previous report, so each one is reported exactly once. When the log
+	// could not be read the next report looks back further instead.
+	lastScan := time.Now().Add(-*interval)
+	for {
+		scanStart := time.Now()
+		if xidRead, err := reportGPUs(hostname, lastScan); err != nil {
+			log.Println("GPU report failed:", err)
+		} else if xidRead {
+			lastScan = scanStart
+		}
+		if err := reportHost(hostname); err != nil {
+			log.Println("Host report failed:", err)
+		}
+		err := post("/agent/heartbeat", report.Heartbeat{
+			Hostname:  hostname,
+			Version:   version,
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			StartedAt: startedAt.Format(time.RFC3339),
+			Labels:    hostLabels,
+		})
+		if err != nil {
+			log.Println("Heartbeat failed:", err)
+		}
+
+		if *once {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// reportGPUs sends the GPUs with the XID errors logged since the given
+// time. xidRead is false when the kernel log could not be read, so the
+// caller keeps looking back from the same time.
+func reportGPUs(hostname string, since time.Time) (xidRead bool, err error) {
+	gpus, xidRead, err := collectGPUs(since)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range gpus {
+		gpus[i].Name = fmt.Sprintf("%d@%s@%s", gpus[i].Index, hostname, gpus[i].Name)
+	}
+	return xidRead, post("/gpu/report", gpus)
+}
+
+// collectGPUs queries whichever of nvidia-smi and rocm-smi is installed.
+// Mixed hosts report both sets of GPUs.
+func collectGPUs(since time.Time) (gpus []report.GPUReport, xidRead bool, err error) {
+	found := false
+	xidRead = true
+
+	if _, err := exec.LookPath("nvidia-smi"); err == nil {
+		found = true
+		nv, err := nvidia.Query()
+		if err != nil {
+			return nil, false, fmt.Errorf("nvidia-smi: %w", err)
+		}
+		events, err := nvidia.RecentXID(since)
+		if err != nil {
+			// Not fatal: containers and non-systemd hosts have no journal.
+			log.Println("Could not read kernel log:", err)
+			xidRead = false
+		}
+		nvidia.AttachXID(nv, events)
+		gpus = append(gpus, nv...)
+	}
+
+	if _, err := exec.LookPath("rocm-smi"); err == nil {
+		found = true
+		amd, err := rocm.Query()
+		if err != nil {
+			return nil, false, fmt.Errorf("rocm-smi: %w", err)
+		}
+		gpus = append(gpus, amd...)
+	}
+
+	if !found {
+		return nil, false, fmt.Errorf("neither nvidia-smi nor rocm-smi is installed")
+	}
+	return gpus, xidRead, nil
+}
+
+func reportHost(hostname string) error {
+	host := report.HostReport{Hostname: hostname}
+
+	// Same approximation as mon.sh: 1-minute load average over core count.
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			load, _ := strconv.ParseFloat(fields[0], 64)
+			host.CPUUsagePercent = load / float64(runtime.NumCPU()) * 100
+		}
+	}
+
+	meminfo, err := readMeminfo()
+	if err != nil {
+		return err
+	}
+	host.MemoryTotalMB = int(meminfo["MemTotal"] / 1024)
+	host.MemoryUsedMB = int((meminfo["MemTotal"] - meminfo["MemAvailable"]) / 1024)
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs("/", &fs); err != nil {
+		return err
+	}
+	total := fs.Blocks * uint64(fs.Bsize)
+	used := total - fs.Bfree*uint64(fs.Bsize)
+	host.DiskUsed = fmt.Sprintf("%dG", used>>30)
+	host.DiskTotal = fmt.Sprintf("%dG", total>>30)
+
+	return post("/host/report", host)
+}
+
+// selfUpdate asks the server which release this host should run and, if
+// it differs from the running one, installs it and re-executes. Nothing is
+// installed unless the manifest verifies against the release key and the
+// binary matches the manifest's checksum.
+func selfUpdate(hostname string) error {
+	key, err := release.ReadPublicKey(*releaseKey)
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{
+		"hostname": {hostname},
+		"version":  {version},
+		"os":       {runtime.GOOS},
+		"arch":     {runtime.GOARCH},
+	}
+	resp, err := http.Get(*serverURL + "/agent/update?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("/agent/update: %s", resp.Status)
+	}
+
+	var signed release.Signed
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return err
+	}
+	manifest, err := release.Verify(signed, key)
+	if err != nil {
+		return err
+	}
+	if manifest.Version == version {
+		return nil
+	}
+	if release.Compare(manifest.Version, version) < 0 && !*allowDowngrade {
+		return fmt.Errorf("refusing to downgrade from %s to %s", version, manifest.Version)
+	}
+	artifact, ok := manifest.Artifact(runtime.GOOS, runtime.GOARCH)
+	if !ok {
+		return fmt.Errorf("release %s has no %s/%s build", manifest.Version, runtime.GOOS, runtime.GOARCH)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	// Download next to the running binary so the final rename is atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".gpumon-agent-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = download(*serverURL+"/agent/releases/"+url.PathEscape(manifest.Version)+"/"+url.PathEscape(artifact.File), tmp, artifact)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return err
+	}
+
+	log.Printf("Updated gpumon-agent %s -> %s, restarting", version, manifest.Version)
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
+
+// download writes the artifact to w and checks its size and checksum.
+func download(url string, w io.Writer, artifact release.Artifact) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(resp.Body, artifact.Size+1))
+	if err != nil {
+		return err
+	}
+	if n != artifact.Size {
+		return fmt.Errorf("%s: got %d bytes, manifest says %d", url, n, artifact.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != artifact.SHA256 {
+		return fmt.Errorf("%s: checksum %s does not match manifest", url, sum)
+	}
+	return nil
+}
+
+// readMeminfo returns the /proc/meminfo values in kB.
+func readMeminfo() (map[string]uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return values, scanner.Err()
+}
+
+func post(path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, *serverURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+const serviceUnit = `[Unit]
+Description=GPU Monitoring Agent
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%s -config %s
+Restart=always
+RestartSec=30
+User=root
+Nice=10
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// enroll registers this host with the server using a one-time join token,
+// stores the returned credential in the agent config and, unless told not
+// to, installs the agent as a systemd service.
+func enroll(args []string) {
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	server := fs.String("server", "", "collector server URL")
+	token := fs.String("token", "", "join token from POST /admin/join-tokens")
+	hostname := fs.String("hostname", "", "name to enroll as (default the system hostname)")
+	cfgPath := fs.String("config", defaultConfigPath, "where to write the agent config")
+	keyPath := fs.String("release-key", "/etc/gpumon/release.pub", "public key agent releases must be signed with")
+	installPath := fs.String("install", defaultInstallPath, "where to install the agent binary for the service")
+	systemd := fs.Bool("systemd", true, "install and start the gpumon-agent systemd service")
+	labels := map[string]string{}
+	fs.Func("label", "host label as KEY=VALUE (repeatable)", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("want KEY=VALUE, got %q", s)
+		}
+		labels[k] = v
+		return nil
+	})
+	fs.Parse(args)
+
+	if *server == "" || *token == "" {
+		fmt.Fprintln(os.Stderr, "Usage: gpumon-agent enroll -server URL -token JOIN_TOKEN [-label KEY=VALUE]...")
+		os.Exit(2)
+	}
+	if *hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			log.Fatal(err)
+		}
+		*hostname = h
+	}
+	*server = strings.TrimRight(*server, "/")
+
+	body, _ := json.Marshal(report.EnrollRequest{
+		Token:    *token,
+		Hostname: *hostname,
+		Labels:   labels,
+		Version:  version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+	})
+	resp, err := http.Post(*server+"/agent/enroll", "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("Enrollment failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var enrolled report.EnrollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&enrolled); err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, _ := json.MarshalIndent(agentConfig{
+		Server:     *server,
+		Hostname:   enrolled.Hostname,
+		Credential: enrolled.Credential,
+		ReleaseKey: *keyPath,
+		Labels:     labels,
+	}, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(*cfgPath), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*cfgPath, append(cfg, '\n'), 0600); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Enrolled %s with %s, config written to %s\n", enrolled.Hostname, *server, *cfgPath)
+
+	if *systemd {
+		if err := installService(*installPath, *cfgPath); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Installed and started gpumon-agent.service")
+	}
+	if enrolled.Status == report.HostPending {
+		fmt.Println("The host is pending approval; reports are rejected until an admin approves it.")
+	}
+}
+
+// installService copies the running binary to installPath and installs a
+// systemd unit that runs it with the given config.
+func installService(installPath, cfgPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if exe != installPath {
+		if err := copyExecutable(exe, installPath); err != nil {
+			return err
+		}
+	}
+
+	unit := fmt.Sprintf(serviceUnit, installPath, cfgPath)
+	if err := os.WriteFile(servicePath, []byte(unit), 0644); err != nil {
+		return err
+	}
+	for _, args := range [][]string{
+		{"daemon-reload"},
+		{"enable", "gpumon-agent.service"},
+		{"restart", "gpumon-agent.service"},
+	} {
+		if out, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+// copyExecutable installs src at dst through a temporary file so a running
+// copy of dst is never overwritten in place.
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".gpumon-agent-install-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
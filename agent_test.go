@@ -0,0 +1,65 @@
+// The repository root holds several programs, so its tests are run one
+// program at a time:
+//
+//	go test agent.go agent_test.go
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gpu-monitor/release"
+)
+
+func TestDownload(t *testing.T) {
+	binary := []byte("\x7fELF gpumon-agent 1.5.0")
+	sum := sha256.Sum256(binary)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent":
+			w.Write(binary)
+		case "/longer":
+			w.Write(append(binary, "and a payload"...))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	good := release.Artifact{Size: int64(len(binary)), SHA256: hex.EncodeToString(sum[:])}
+	tests := []struct {
+		name     string
+		path     string
+		artifact release.Artifact
+		err      string // empty if the download should pass
+	}{
+		{"matches the manifest", "/agent", good, ""},
+		{"wrong checksum", "/agent", release.Artifact{Size: good.Size, SHA256: strings.Repeat("0", 64)}, "checksum "},
+		{"longer than the manifest", "/longer", good, "got 24 bytes, manifest says 23"},
+		{"shorter than the manifest", "/agent", release.Artifact{Size: good.Size + 1, SHA256: good.SHA256}, "got 23 bytes, manifest says 24"},
+		{"missing", "/gone", good, "404 Not Found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := download(srv.URL+tt.path, &buf, tt.artifact)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("download: %v", err)
+			case tt.err == "" && !bytes.Equal(buf.Bytes(), binary):
+				t.Errorf("downloaded %q", buf.Bytes())
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("download: %v, want ...%s...", err, tt.err)
+			}
+			// An oversized body is cut off at one byte past the manifest size.
+			if buf.Len() > int(tt.artifact.Size)+1 {
+				t.Errorf("wrote %d bytes for a %d byte artifact", buf.Len(), tt.artifact.Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package alerts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rank orders severities so subscriptions can ask for "warning and up".
+func (s Severity) Rank() int {
+	switch s {
+	case Warning:
+		return 1
+	case Critical:
+		return 2
+	}
+	return 0
+}
+
+func ParseSeverity(s string) (Severity, error) {
+	switch sev := Severity(strings.ToLower(s)); sev {
+	case Warning, Critical:
+		return sev, nil
+	}
+	return "", fmt.Errorf("unknown severity %q, want warning or critical", s)
+}
+
+// Fingerprint identifies an alert across evaluations. A change of
+// severity counts as a new alert.
+func (a Alert) Fingerprint() string {
+	parts := []string{a.Rule, string(a.Severity), a.Host, a.GPU}
+	if a.Check != 0 {
+		parts = append(parts, strconv.Itoa(a.Check))
+	}
+	return strings.Join(parts, "|")
+}
+
+// Event is an alert the server has seen firing, from the first evaluation
+// that raised it until it resolves.
+type Event struct {
+	ID int64 `json:"id"`
+	Alert
+	StartedAt    string `json:"started_at"`
+	ResolvedAt   string `json:"resolved_at,omitempty"`
+	AckedBy      string `json:"acked_by,omitempty"`
+	AckedAt      string `json:"acked_at,omitempty"`
+	SnoozedUntil string `json:"snoozed_until,omitempty"`
+}
+
+// Notification kinds.
+const (
+	Firing   = "firing"
+	Reminder = "reminder"
+	Resolved = "resolved"
+)
+
+// Notification is an event waiting to be delivered to a chat.
+type Notification struct {
+	ID     int64  `json:"id"`
+	ChatID int64  `json:"chat_id"`
+	Kind   string `json:"kind"`
+	Event  Event  `json:"event"`
+}
+
+// Subscription asks for a chat to be told about alerts of at least
+// MinSeverity on hosts matching Selector.
+type Subscription struct {
+	ID          int64    `json:"id"`
+	ChatID      int64    `json:"chat_id"`
+	Selector    string   `json:"selector"`
+	MinSeverity Severity `json:"severity"`
+	CreatedAt   string   `json:"created_at"`
+}
+
+// Mute kinds.
+const (
+	MuteHost = "host"
+	MuteGPU  = "gpu"
+	MuteRule = "rule"
+)
+
+// Mute silences a host, a GPU or a rule for one chat until a time.
+type Mute struct {
+	ID        int64  `json:"id"`
+	ChatID    int64  `json:"chat_id"`
+	Kind      string `json:"kind"`
+	Value     string `json:"value"`
+	Until     string `json:"until"`
+	CreatedBy string `json:"created_by"`
+}
+
+// Matches reports whether the mute covers an alert. GPUs may be given by
+// full name or as HOST:INDEX.
+func (m Mute) Matches(a Alert) bool {
+	switch m.Kind {
+	case MuteHost:
+		return a.Host == m.Value
+	case MuteRule:
+		return a.Rule == m.Value
+	case MuteGPU:
+		if a.GPU == "" {
+			return false
+		}
+		if a.GPU == m.Value {
+			return true
+		}
+		if host, index, ok := strings.Cut(m.Value, ":"); ok {
+			return strings.HasPrefix(a.GPU, index+"@"+host+"@")
+		}
+	}
+	return false
+}
+
+// Rules lists the rule names CheckGPU, CheckHost and CheckService can
+// raise.
+var Rules = []string{
+	"gpu_stale", "gpu_idle", "gpu_temperature", "gpu_ecc_uncorrected",
+	"gpu_retired_pages_pending", "gpu_xid", "gpu_throttle", "gpu_pcie_width",
+	"gpu_pcie_replay", "gpu_mig_memory",
+	"host_stale", "host_cpu", "host_memory", "host_disk",
+	"check_down", "check_flapping",
+}
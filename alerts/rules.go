@@ -0,0 +1,203 @@
+// Package alerts holds the health rules the server evaluates against the
+// latest GPU and host reports and the state of its service checks.
+package alerts
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gpu-monitor/checks"
+	"gpu-monitor/report"
+)
+
+type Severity string
+
+const (
+	Warning  Severity = "warning"
+	Critical Severity = "critical"
+)
+
+type Alert struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Host     string   `json:"host"`
+	GPU      string   `json:"gpu,omitempty"`
+	// Check is the ID of the service check the alert is about. Host is
+	// then the check's target.
+	Check   int    `json:"check,omitempty"`
+	Message string `json:"message"`
+}
+
+const (
+	// StaleAfter is how long a report may go without an update before the
+	// host or GPU is considered gone.
+	StaleAfter = 5 * time.Minute
+
+	HighTemperatureC = 90
+	HighUsagePercent = 90
+
+	// A MIG slice whose memory is this full will start failing
+	// allocations long before the parent GPU looks busy.
+	HighMIGMemoryPercent = 95
+
+	// PCIe replays are retransmissions on the link; a few are normal, a
+	// steadily growing counter points at a bad riser or slot.
+	HighPCIeReplayCount = 1000
+)
+
+// criticalXIDs are the XID codes that mean the GPU needs to be drained:
+// fallen off the bus, uncorrectable memory errors, row remapping failures
+// and GSP/driver hangs.
+var criticalXIDs = map[int]bool{
+	48: true, 62: true, 63: true, 64: true, 74: true, 79: true,
+	92: true, 94: true, 95: true, 119: true, 120: true,
+}
+
+// slowdownReasons are the throttle reasons that indicate a hardware
+// problem rather than normal power management.
+var slowdownReasons = map[string]bool{
+	"hw_slowdown":             true,
+	"hw_thermal_slowdown":     true,
+	"hw_power_brake_slowdown": true,
+	"sw_thermal_slowdown":     true,
+}
+
+// CheckGPU evaluates the GPU rules against one report.
+func CheckGPU(gpu report.GPUReport, now time.Time) []Alert {
+	var alerts []Alert
+	add := func(rule string, sev Severity, format string, args ...interface{}) {
+		alerts = append(alerts, Alert{
+			Rule:     rule,
+			Severity: sev,
+			Host:     gpu.Host(),
+			GPU:      gpu.Name,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if updatedAt, err := time.Parse(time.RFC3339, gpu.UpdatedAt); err == nil && updatedAt.Before(now.Add(-StaleAfter)) {
+		add("gpu_stale", Critical, "GPU %s data is stale (last update: %s)", gpu.Name, gpu.UpdatedAt)
+	}
+	if gpu.ProcessCount < 1 {
+		add("gpu_idle", Warning, "GPU %s has no running processes", gpu.Name)
+	}
+	if gpu.TemperatureC >= HighTemperatureC {
+		add("gpu_temperature", Critical, "GPU %s temperature is high (%d°C)", gpu.Name, gpu.TemperatureC)
+	}
+
+	if gpu.ECCVolatileUncorrected > 0 {
+		add("gpu_ecc_uncorrected", Critical, "GPU %s has %d uncorrected ECC errors since boot", gpu.Name, gpu.ECCVolatileUncorrected)
+	}
+	if gpu.RetiredPagesPending {
+		add("gpu_retired_pages_pending", Warning, "GPU %s has page retirements pending a reset", gpu.Name)
+	}
+
+	var critical, other []string
+	for _, code := range gpu.XIDErrors {
+		if criticalXIDs[code] {
+			critical = append(critical, fmt.Sprint(code))
+		} else {
+			other = append(other, fmt.Sprint(code))
+		}
+	}
+	if len(critical) > 0 {
+		add("gpu_xid", Critical, "GPU %s reported XID %s", gpu.Name, strings.Join(critical, ", "))
+	}
+	if len(other) > 0 {
+		add("gpu_xid", Warning, "GPU %s reported XID %s", gpu.Name, strings.Join(other, ", "))
+	}
+
+	var slowdowns []string
+	for _, reason := range gpu.ThrottleReasons {
+		if slowdownReasons[reason] {
+			slowdowns = append(slowdowns, reason)
+		}
+	}
+	if len(slowdowns) > 0 {
+		add("gpu_throttle", Warning, "GPU %s clocks are throttled (%s)", gpu.Name, strings.Join(slowdowns, ", "))
+	}
+
+	if gpu.PCIeWidthMax > 0 && gpu.PCIeWidth > 0 && gpu.PCIeWidth < gpu.PCIeWidthMax {
+		add("gpu_pcie_width", Warning, "GPU %s PCIe link is degraded (x%d of x%d)", gpu.Name, gpu.PCIeWidth, gpu.PCIeWidthMax)
+	}
+	if gpu.PCIeReplayCount >= HighPCIeReplayCount {
+		add("gpu_pcie_replay", Warning, "GPU %s PCIe replay counter is high (%d)", gpu.Name, gpu.PCIeReplayCount)
+	}
+
+	for _, mig := range gpu.MIGInstances {
+		if mig.MemoryTotalMiB == 0 {
+			continue
+		}
+		usage := float64(mig.MemoryUsedMiB) / float64(mig.MemoryTotalMiB) * 100
+		if usage >= HighMIGMemoryPercent {
+			add("gpu_mig_memory", Warning, "GPU %s MIG instance %d/%d (%s) memory is nearly full (%.0f%%)",
+				gpu.Name, mig.GPUInstanceID, mig.ComputeInstanceID, mig.Profile, usage)
+		}
+	}
+
+	return alerts
+}
+
+// CheckHost evaluates the host rules against one report.
+func CheckHost(host report.HostReport, now time.Time) []Alert {
+	var alerts []Alert
+	add := func(rule string, sev Severity, format string, args ...interface{}) {
+		alerts = append(alerts, Alert{
+			Rule:     rule,
+			Severity: sev,
+			Host:     host.Hostname,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if updatedAt, err := time.Parse(time.RFC3339, host.UpdatedAt); err == nil && updatedAt.Before(now.Add(-StaleAfter)) {
+		add("host_stale", Critical, "Host %s data is stale (last update: %s)", host.Hostname, host.UpdatedAt)
+	}
+
+	var memUsage float64
+	if host.MemoryTotalMB > 0 {
+		memUsage = float64(host.MemoryUsedMB) / float64(host.MemoryTotalMB) * 100
+	}
+
+	var diskUsed, diskTotal int64
+	fmt.Sscanf(host.DiskUsed, "%d", &diskUsed)
+	fmt.Sscanf(host.DiskTotal, "%d", &diskTotal)
+	var diskUsage float64
+	if diskTotal > 0 {
+		diskUsage = float64(diskUsed) / float64(diskTotal) * 100
+	}
+
+	if host.CPUUsagePercent > HighUsagePercent {
+		add("host_cpu", Warning, "Host %s CPU usage is high (%.1f%%)", host.Hostname, host.CPUUsagePercent)
+	}
+	if memUsage > HighUsagePercent {
+		add("host_memory", Warning, "Host %s memory usage is high (%.1f%%)", host.Hostname, memUsage)
+	}
+	if diskUsage > HighUsagePercent {
+		add("host_disk", Warning, "Host %s disk usage is high (%.1f%%)", host.Hostname, diskUsage)
+	}
+
+	return alerts
+}
+
+// CheckService raises check_down for a service check that is down, or
+// check_flapping instead while it keeps going up and down, matching the
+// single notice the check's state gives for flapping.
+func CheckService(c checks.Service) []Alert {
+	a := Alert{Host: c.Target, Check: c.ID}
+	switch {
+	case c.Flapping:
+		a.Rule, a.Severity = "check_flapping", Warning
+		a.Message = fmt.Sprintf("%s %s is flapping: its state changed %d times in the last %d checks", c.Type, c.Target, c.Flips(), len(c.Recent))
+	case !c.Up:
+		a.Rule, a.Severity = "check_down", Critical
+		a.Message = fmt.Sprintf("%s %s is down", c.Type, c.Target)
+		if c.LastError != "" {
+			a.Message += ": " + c.LastError
+		}
+	default:
+		return nil
+	}
+	return []Alert{a}
+}
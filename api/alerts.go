@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"gpu-monitor/alerts"
+)
+
+// ActiveAlerts returns the alerts that are firing now, with their
+// acknowledgement and snooze state.
+func (c *Client) ActiveAlerts(selector string) ([]alerts.Event, error) {
+	var events []alerts.Event
+	err := c.get("/alerts/active", selectorQuery(selector), &events)
+	return events, err
+}
+
+// Ack acknowledges an alert, which stops reminders for it.
+func (c *Client) Ack(id int64, by string) (alerts.Event, error) {
+	var ev alerts.Event
+	err := c.post("/admin/alerts/ack", map[string]interface{}{"id": id, "by": by}, &ev)
+	return ev, err
+}
+
+// Snooze holds off reminders for an alert for d.
+func (c *Client) Snooze(id int64, d time.Duration, by string) (alerts.Event, error) {
+	var ev alerts.Event
+	err := c.post("/admin/alerts/snooze", map[string]interface{}{"id": id, "for": d.String(), "by": by}, &ev)
+	return ev, err
+}
+
+func (c *Client) Subscriptions(chatID int64) ([]alerts.Subscription, error) {
+	var subs []alerts.Subscription
+	err := c.get("/admin/subscriptions", chatQuery(chatID), &subs)
+	return subs, err
+}
+
+func (c *Client) Subscribe(chatID int64, selector string, min alerts.Severity) (alerts.Subscription, error) {
+	var sub alerts.Subscription
+	err := c.post("/admin/subscriptions", alerts.Subscription{ChatID: chatID, Selector: selector, MinSeverity: min}, &sub)
+	return sub, err
+}
+
+// Unsubscribe removes one of a chat's subscriptions, or all of them when
+// id is 0, and returns how many were removed.
+func (c *Client) Unsubscribe(chatID, id int64) (int, error) {
+	var res struct {
+		Deleted int `json:"deleted"`
+	}
+	err := c.post("/admin/subscriptions/delete", map[string]int64{"chat_id": chatID, "id": id}, &res)
+	return res.Deleted, err
+}
+
+// Mutes returns a chat's mutes that have not expired.
+func (c *Client) Mutes(chatID int64) ([]alerts.Mute, error) {
+	var mutes []alerts.Mute
+	err := c.get("/admin/mutes", chatQuery(chatID), &mutes)
+	return mutes, err
+}
+
+func (c *Client) Mute(chatID int64, kind, value string, d time.Duration, by string) (alerts.Mute, error) {
+	var m alerts.Mute
+	err := c.post("/admin/mutes", map[string]interface{}{
+		"chat_id": chatID, "kind": kind, "value": value, "for": d.String(), "created_by": by,
+	}, &m)
+	return m, err
+}
+
+// Unmute removes one of a chat's mutes, or all of them when id is 0.
+func (c *Client) Unmute(chatID, id int64) (int, error) {
+	var res struct {
+		Deleted int `json:"deleted"`
+	}
+	err := c.post("/admin/mutes/delete", map[string]int64{"chat_id": chatID, "id": id}, &res)
+	return res.Deleted, err
+}
+
+// Notification sources, for PendingNotifications.
+const (
+	HostNotifications  = "hosts"  // GPU and host alerts, by subscription
+	CheckNotifications = "checks" // service check alerts, to their watchers
+)
+
+// PendingNotifications returns alert notifications from source not yet
+// delivered, oldest first, or those of every source if source is empty.
+// Call MarkSent once they are.
+func (c *Client) PendingNotifications(source string) ([]alerts.Notification, error) {
+	q := url.Values{}
+	if source != "" {
+		q.Set("source", source)
+	}
+	var ns []alerts.Notification
+	err := c.get("/admin/notifications", q, &ns)
+	return ns, err
+}
+
+func (c *Client) MarkSent(ids []int64) error {
+	return c.post("/admin/notifications/sent", map[string][]int64{"ids": ids}, nil)
+}
+
+func chatQuery(chatID int64) url.Values {
+	return url.Values{"chat_id": {strconv.FormatInt(chatID, 10)}}
+}
@@ -0,0 +1,214 @@
+// Package api is a Go client for the collector server's HTTP API, shared
+// by the command-line tools and the Telegram bot.
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/report"
+)
+
+type Client struct {
+	BaseURL string
+	// Token is sent as a bearer token when set. Read endpoints do not
+	// need one; admin endpoints take GPUMON_ADMIN_TOKEN.
+	Token string
+	HTTP  *http.Client
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Health is the result of /healthcheck.
+type Health struct {
+	Healthy bool           `json:"healthy"`
+	Alerts  []alerts.Alert `json:"alerts"`
+}
+
+// HistoryQuery selects samples from /gpu/history or /host/history.
+type HistoryQuery struct {
+	Name     string // GPU name, /gpu/history only
+	Host     string
+	Selector string
+	Since    time.Duration
+	Step     time.Duration
+}
+
+func (c *Client) GPUs(selector string) ([]report.GPUReport, error) {
+	var gpus []report.GPUReport
+	err := c.get("/gpu/list", selectorQuery(selector), &gpus)
+	return gpus, err
+}
+
+func (c *Client) Hosts(selector string) ([]report.HostReport, error) {
+	var hosts []report.HostReport
+	err := c.get("/host/list", selectorQuery(selector), &hosts)
+	return hosts, err
+}
+
+func (c *Client) Hardware() ([]report.HardwareReport, error) {
+	var hw []report.HardwareReport
+	err := c.get("/hardware/list", nil, &hw)
+	return hw, err
+}
+
+func (c *Client) Groups(by, selector string) ([]report.GroupSummary, error) {
+	q := selectorQuery(selector)
+	q.Set("by", by)
+	var groups []report.GroupSummary
+	err := c.get("/group/summary", q, &groups)
+	return groups, err
+}
+
+// Health runs the server's health check. An unhealthy fleet is not an
+// error; the alerts are returned instead.
+func (c *Client) Health(selector string) (Health, error) {
+	resp, err := c.do(http.MethodGet, "/healthcheck", selectorQuery(selector), nil)
+	if err != nil {
+		return Health{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Health{Healthy: true, Alerts: []alerts.Alert{}}, nil
+	case http.StatusServiceUnavailable:
+		var body struct {
+			Alerts []alerts.Alert `json:"alerts"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return Health{}, err
+		}
+		return Health{Alerts: body.Alerts}, nil
+	}
+	return Health{}, statusError(resp)
+}
+
+func (c *Client) GPUHistory(q HistoryQuery) ([]report.GPUHistory, error) {
+	params := historyParams(q)
+	if q.Name != "" {
+		params.Set("name", q.Name)
+	}
+	if q.Host != "" {
+		params.Set("host", q.Host)
+	}
+	var history []report.GPUHistory
+	err := c.get("/gpu/history", params, &history)
+	return history, err
+}
+
+func (c *Client) HostHistory(q HistoryQuery) ([]report.HostHistory, error) {
+	params := historyParams(q)
+	if q.Host != "" {
+		params.Set("hostname", q.Host)
+	}
+	var history []report.HostHistory
+	err := c.get("/host/history", params, &history)
+	return history, err
+}
+
+func historyParams(q HistoryQuery) url.Values {
+	params := selectorQuery(q.Selector)
+	if q.Since > 0 {
+		params.Set("since", q.Since.String())
+	}
+	if q.Step > 0 {
+		params.Set("step", q.Step.String())
+	}
+	return params
+}
+
+func selectorQuery(selector string) url.Values {
+	q := url.Values{}
+	if selector != "" {
+		q.Set("selector", selector)
+	}
+	return q
+}
+
+func (c *Client) get(path string, q url.Values, v interface{}) error {
+	resp, err := c.do(http.MethodGet, path, q, nil)
+	if err != nil {
+		return err
+	}
+	return decode(resp, v)
+}
+
+// post sends body as JSON and decodes the reply into v unless v is nil.
+func (c *Client) post(path string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, path, nil, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return decode(resp, v)
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) do(method, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTP.Do(req)
+}
+
+// Error is a request the server answered with an error status.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string // the body of the reply
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &Error{
+		Method:     resp.Request.Method,
+		Path:       resp.Request.URL.Path,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    strings.TrimSpace(string(msg)),
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"gpu-monitor/checks"
+	"gpu-monitor/report"
+)
+
+// CheckReport is a check with its uptime report, from /checks/uptime.
+type CheckReport struct {
+	Check  checks.Service `json:"check"`
+	Report checks.Report  `json:"report"`
+}
+
+// Checks returns every service check.
+func (c *Client) Checks() ([]checks.Service, error) {
+	var list []checks.Service
+	err := c.get("/checks", nil, &list)
+	return list, err
+}
+
+// CheckResults returns a check's latest results, newest first.
+func (c *Client) CheckResults(id, limit int) ([]checks.Result, error) {
+	q := url.Values{"id": {strconv.Itoa(id)}, "limit": {strconv.Itoa(limit)}}
+	var results []checks.Result
+	err := c.get("/checks/results", q, &results)
+	return results, err
+}
+
+// CheckUptime reports on the checks over the period up to now: one check,
+// or all of them if id is 0.
+func (c *Client) CheckUptime(id int, period time.Duration) ([]CheckReport, error) {
+	q := url.Values{"period": {period.String()}}
+	if id != 0 {
+		q.Set("id", strconv.Itoa(id))
+	}
+	var reports []CheckReport
+	err := c.get("/checks/uptime", q, &reports)
+	return reports, err
+}
+
+// ChatChecks returns the checks a chat watches, directly or through its
+// teams.
+func (c *Client) ChatChecks(chatID int64) ([]checks.Service, error) {
+	var list []checks.Service
+	err := c.get("/admin/checks", chatQuery(chatID), &list)
+	return list, err
+}
+
+// FindCheck looks a check up by #ID or target, among the ones chatID sees
+// or, with visible false, all of them. The server answers 404 when there
+// is no such check and 409 when the target is checked by several types.
+func (c *Client) FindCheck(chatID int64, ref string, visible bool) (checks.Service, error) {
+	q := chatQuery(chatID)
+	q.Set("ref", ref)
+	q.Set("visible", strconv.FormatBool(visible))
+	var check checks.Service
+	err := c.get("/admin/checks/find", q, &check)
+	return check, err
+}
+
+// AddCheck adds a check watched by req.ChatID. When the target is already
+// checked the chat watches that check instead, added is false and
+// req.Settings are not applied.
+func (c *Client) AddCheck(req report.CheckRequest) (check checks.Service, added bool, err error) {
+	var res struct {
+		Check checks.Service `json:"check"`
+		Added bool           `json:"added"`
+	}
+	err = c.post("/admin/checks", req, &res)
+	return res.Check, res.Added, err
+}
+
+// UpdateCheck applies req.Settings to check req.ID.
+func (c *Client) UpdateCheck(req report.CheckRequest) (checks.Service, error) {
+	var check checks.Service
+	err := c.post("/admin/checks/update", req, &check)
+	return check, err
+}
+
+// DeleteCheck deletes a check for everyone watching it.
+func (c *Client) DeleteCheck(id int) error {
+	return c.post("/admin/checks/delete", report.CheckRequest{ID: id}, nil)
+}
+
+// Watch makes req.ChatID watch check req.ID, or join team req.Team. It
+// returns how many checks the chat now watches through the team.
+func (c *Client) Watch(req report.CheckRequest) (int, error) {
+	var res struct {
+		Checks int `json:"checks"`
+	}
+	err := c.post("/admin/checks/watch", req, &res)
+	return res.Checks, err
+}
+
+// Unwatch stops req.ChatID watching check req.ID, or leaves team
+// req.Team. A check nobody watches any more is deleted.
+func (c *Client) Unwatch(req report.CheckRequest) (deleted bool, err error) {
+	var res struct {
+		Deleted bool `json:"deleted"`
+	}
+	err = c.post("/admin/checks/unwatch", req, &res)
+	return res.Deleted, err
+}
+
+// Share adds check req.ID to team req.Team, creating the team with
+// req.ChatID as its first member if there is none of that name.
+func (c *Client) Share(req report.CheckRequest) error {
+	return c.post("/admin/checks/share", req, nil)
+}
+
+// Unshare takes check req.ID out of team req.Team, deleting the check if
+// nobody else watches it.
+func (c *Client) Unshare(req report.CheckRequest) (deleted bool, err error) {
+	var res struct {
+		Deleted bool `json:"deleted"`
+	}
+	err = c.post("/admin/checks/unshare", req, &res)
+	return res.Deleted, err
+}
+
+// Teams lists the teams, marking the ones chatID is in.
+func (c *Client) Teams(chatID int64) ([]checks.Team, error) {
+	var teams []checks.Team
+	err := c.get("/admin/checks/teams", chatQuery(chatID), &teams)
+	return teams, err
+}
+
+// ChatOutages returns the latest outages of the checks chatID sees, or of
+// one of them if id is set.
+func (c *Client) ChatOutages(chatID int64, id, limit int) ([]checks.Outage, error) {
+	q := chatQuery(chatID)
+	q.Set("limit", strconv.Itoa(limit))
+	if id != 0 {
+		q.Set("id", strconv.Itoa(id))
+	}
+	var outages []checks.Outage
+	err := c.get("/admin/checks/outages", q, &outages)
+	return outages, err
+}
+
+// SummaryChats returns the chats that get the weekly uptime summary.
+func (c *Client) SummaryChats() ([]int64, error) {
+	var chats []int64
+	err := c.get("/admin/checks/summaries", nil, &chats)
+	return chats, err
+}
+
+// SetSummary turns a chat's weekly uptime summary on or off.
+func (c *Client) SetSummary(chatID int64, on bool) error {
+	return c.post("/admin/checks/summaries", map[string]interface{}{"chat_id": chatID, "on": on}, nil)
+}
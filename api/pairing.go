@@ -0,0 +1,24 @@
+package api
+
+import "gpu-monitor/report"
+
+func (c *Client) PairingCodes() ([]report.PairingCode, error) {
+	var codes []report.PairingCode
+	err := c.get("/admin/pairing-codes", nil, &codes)
+	return codes, err
+}
+
+// CreatePairingCode returns the new code, the only time it is shown.
+func (c *Client) CreatePairingCode(req report.PairingRequest) (report.PairingCode, error) {
+	var code report.PairingCode
+	err := c.post("/admin/pairing-codes", req, &code)
+	return code, err
+}
+
+// RedeemPairingCode uses up a code for a chat and subscribes the chat as
+// the code says. The caller grants the code's role.
+func (c *Client) RedeemPairingCode(code string, chatID, userID int64) (report.PairingCode, error) {
+	var pc report.PairingCode
+	err := c.post("/admin/pairing-codes/redeem", report.PairingRedemption{Code: code, ChatID: chatID, UserID: userID}, &pc)
+	return pc, err
+}
@@ -0,0 +1,22 @@
+package api
+
+import "gpu-monitor/report"
+
+// Incidents lists the status page incidents, open ones first.
+func (c *Client) Incidents() ([]report.Incident, error) {
+	var incidents []report.Incident
+	err := c.get("/admin/status/incidents", nil, &incidents)
+	return incidents, err
+}
+
+func (c *Client) CreateIncident(req report.IncidentRequest) (report.Incident, error) {
+	var inc report.Incident
+	err := c.post("/admin/status/incidents", req, &inc)
+	return inc, err
+}
+
+func (c *Client) UpdateIncident(req report.IncidentUpdateRequest) (report.Incident, error) {
+	var inc report.Incident
+	err := c.post("/admin/status/incidents/update", req, &inc)
+	return inc, err
+}
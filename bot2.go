@@ -1,22 +1,97 @@
 package main
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api"
+	_ "github.com/mattn/go-sqlite3"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/api"
+	"gpu-monitor/botauth"
+	"gpu-monitor/chart"
+	"gpu-monitor/hardware"
+	"gpu-monitor/labels"
+	"gpu-monitor/report"
+	"gpu-monitor/telegram"
 )
 
 var telegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
 var apiUrl = "http://localhost:1101" // The URL of your backend API
 
+var auth *botauth.Store
+
+// client talks to the server's alert endpoints, which need the admin
+// token in GPUMON_TOKEN.
+var client = api.New(apiUrl, os.Getenv("GPUMON_TOKEN"))
+
+// alertPollInterval is how often queued alert notifications are fetched.
+const alertPollInterval = 15 * time.Second
+
+// commandRoles is the role each command needs. The hardware inventory
+// shows kernels, PCI devices and network details, so it is kept from
+// viewers.
+var commandRoles = map[string]botauth.Role{
+	"start":       botauth.Viewer,
+	"gpus":        botauth.Viewer,
+	"gpu":         botauth.Viewer,
+	"hosts":       botauth.Viewer,
+	"host":        botauth.Viewer,
+	"healthcheck": botauth.Viewer,
+	"health":      botauth.Viewer,
+	"groups":      botauth.Viewer,
+	"hardware":    botauth.Operator,
+	"chart":       botauth.Viewer,
+
+	// Buttons of the paged summaries and drill-downs.
+	"noop":   botauth.Viewer,
+	"hchart": botauth.Viewer,
+	"hw":     botauth.Operator,
+	"hwd":    botauth.Operator,
+	"hwfull": botauth.Operator,
+
+	"subscribe":     botauth.Viewer,
+	"unsubscribe":   botauth.Viewer,
+	"subscriptions": botauth.Viewer,
+	"mute":          botauth.Operator,
+	"unmute":        botauth.Operator,
+	"ack":           botauth.Operator,
+	"snooze":        botauth.Operator,
 
+	// Anyone may try a pairing code, that is how they get a role.
+	"pair": botauth.None,
+}
 
 func main() {
-	bot, err := tgbotapi.NewBotAPI(telegramBotToken)
+	admins, err := botauth.ParseIDs(os.Getenv("TELEGRAM_ADMINS"))
+	if err != nil {
+		log.Fatal("TELEGRAM_ADMINS: ", err)
+	}
+	db, err := sql.Open("sqlite3", "./gpubot.db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	auth, err = botauth.Open(db, admins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth.Empty() {
+		log.Println("Nobody can use the bot: set TELEGRAM_ADMINS to your Telegram user ID")
+	}
+
+	bot, err := telegram.NewBot(telegramBotToken)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -27,38 +102,110 @@ func main() {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
+	go deliverAlerts(bot)
+
 	// Create an update channel for receiving updates
 	updates, err := bot.GetUpdatesChan(u)
 
 	for update := range updates {
-		if update.CallbackQuery != nil {
-			// Handle callback queries (button clicks)
-			handleCallback(update.CallbackQuery, bot)
-		} else if update.Message != nil {
-			// Handle messages
-			if update.Message.IsCommand() {
-				switch update.Message.Command() {
-				case "start":
-					handleStart(update.Message.Chat.ID, bot)
-				case "gpus":
-					handleGPUs(update.Message.Chat.ID, bot)
-				case "hosts":
-					handleHosts(update.Message.Chat.ID, bot)
-				case "healthcheck":
-					handleHealthCheck(update.Message.Chat.ID, bot)
-				case "hardware":
-					handleHardware(update.Message.Chat.ID, bot)
-				default:
-					handleUnknown(update.Message.Chat.ID, bot)
+		handleUpdate(update, bot)
+	}
+}
+
+// handleUpdate dispatches a command or a button press.
+func handleUpdate(update tgbotapi.Update, bot telegram.Messenger) {
+	if update.CallbackQuery != nil {
+		// Handle callback queries (button clicks)
+		handleCallback(update.CallbackQuery, bot)
+	} else if update.Message != nil {
+		// Handle messages
+		if update.Message.IsCommand() {
+			chatID := update.Message.Chat.ID
+			caller := callerOf(update.Message.From, update.Message.Chat)
+			command := update.Message.Command()
+			if reply, ok := auth.Command(caller, command, update.Message.CommandArguments()); ok {
+				bot.Send(tgbotapi.NewMessage(chatID, reply))
+				return
+			}
+			need, ok := commandRoles[command]
+			if !ok {
+				need = botauth.Viewer
+			}
+			if err := auth.Check(caller, need); err != nil {
+				bot.Send(tgbotapi.NewMessage(chatID, err.Error()))
+				return
+			}
+
+			switch command {
+			case "start":
+				handleStart(chatID, bot, caller)
+			case "gpus":
+				handleGPUs(chatID, 0, bot, update.Message.CommandArguments(), 0)
+			case "gpu":
+				args := strings.Fields(update.Message.CommandArguments())
+				if len(args) != 2 {
+					bot.Send(tgbotapi.NewMessage(chatID, "Usage: /gpu HOST INDEX"))
+					return
+				}
+				handleGPU(chatID, bot, args[0], args[1])
+			case "hosts":
+				handleHosts(chatID, 0, bot, update.Message.CommandArguments(), 0)
+			case "host":
+				name := strings.TrimSpace(update.Message.CommandArguments())
+				if name == "" {
+					bot.Send(tgbotapi.NewMessage(chatID, "Usage: /host NAME"))
+					return
 				}
+				handleHost(chatID, bot, name)
+			case "healthcheck":
+				handleHealthCheck(chatID, bot, update.Message.CommandArguments())
+			case "groups":
+				handleGroups(chatID, bot, update.Message.CommandArguments())
+			case "hardware":
+				handleHardware(chatID, 0, bot, strings.TrimSpace(update.Message.CommandArguments()), 0)
+			case "chart":
+				handleChart(chatID, bot, update.Message.CommandArguments())
+			case "subscribe":
+				handleSubscribe(chatID, bot, update.Message.CommandArguments())
+			case "unsubscribe":
+				handleUnsubscribe(chatID, bot, update.Message.CommandArguments())
+			case "subscriptions":
+				handleSubscriptions(chatID, bot)
+			case "pair":
+				handlePair(chatID, bot, caller, update.Message.CommandArguments())
+			case "mute":
+				handleMute(chatID, bot, caller, update.Message.CommandArguments())
+			case "unmute":
+				handleUnmute(chatID, bot, update.Message.CommandArguments())
+			default:
+				handleUnknown(chatID, bot)
 			}
 		}
 	}
 }
 
+// callerOf describes who sent an update for access checks. Channel posts
+// have no sender, so they only get the channel's own role.
+func callerOf(from *tgbotapi.User, chat *tgbotapi.Chat) botauth.Caller {
+	c := botauth.Caller{UserID: chat.ID, ChatID: chat.ID, ChatTitle: chat.Title}
+	if from != nil {
+		c.UserID = int64(from.ID)
+		c.UserName = from.UserName
+	}
+	return c
+}
+
 // /start command handler
-func handleStart(chatID int64, bot *tgbotapi.BotAPI) {
-	msg := tgbotapi.NewMessage(chatID, "Hello 💖! I'm your server bot, here to help you manage your backend! 🌸✨")
+func handleStart(chatID int64, bot telegram.Messenger, caller botauth.Caller) {
+	msg := tgbotapi.NewMessage(chatID, "Hello 💖! I'm your server bot, here to help you manage your backend! 🌸✨\n\n"+
+		"/gpus [HOST|selector] – 🖥️ GPU summary, /gpu HOST INDEX for one GPU\n"+
+		"/hosts [selector] – 📡 Host summary, /host NAME for one host\n"+
+		"/hardware [HOST] – 🛠️ Hardware inventory\n"+
+		"/chart HOST|HOST:GPU METRIC [RANGE] – 📈 Plot temp, util, power, vram, cpu or mem\n"+
+		"/subscribe [warning|critical] [selector] – 🔔 Get alerts in this chat\n"+
+		"/subscriptions – 📋 Show subscriptions and mutes\n"+
+		"/mute [host|gpu|rule] NAME DURATION – 🔕 Silence alerts for a while\n"+
+		auth.Help(caller))
 	inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("/gpus 🖥️", "gpus"),
@@ -75,25 +222,74 @@ func handleStart(chatID int64, bot *tgbotapi.BotAPI) {
 }
 
 // Callback query handler
-func handleCallback(callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
+func handleCallback(callback *tgbotapi.CallbackQuery, bot telegram.Messenger) {
 	// Get the callback data to identify which button was pressed
 	data := callback.Data
 	chatID := callback.Message.Chat.ID
 
+	// Buttons stay in the chat, so check whoever pressed this one. Alert
+	// buttons carry the alert ID after the action, e.g. "snooze:12:1h".
+	action, arg, _ := strings.Cut(data, ":")
+	need, ok := commandRoles[action]
+	if !ok {
+		need = botauth.Viewer
+	}
+	if err := auth.Check(callerOf(callback.From, callback.Message.Chat), need); err != nil {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callback.ID, "You are not allowed to do that 💔"))
+		return
+	}
+
 	var response string
-	switch data {
-	case "gpus":
-		response = "Fetching GPU information... 🖥️💖"
-		handleGPUs(chatID, bot)
-	case "hosts":
-		response = "Fetching Host information... 📡💖"
-		handleHosts(chatID, bot)
+	switch action {
+	case "ack", "snooze":
+		response = handleAlertButton(callback, bot, action, arg)
+	case "chart":
+		response = "Drawing the last 24h... 📈💖"
+		handleGPUChart(chatID, bot, arg)
+	case "gpus", "hosts", "hw":
+		// Paged lists carry "PAGE:FILTER" and edit the message they were
+		// paged from, the /start buttons send a new one.
+		editID, page, filter := 0, 0, ""
+		if p, f, ok := strings.Cut(arg, ":"); ok {
+			editID = callback.Message.MessageID
+			page, _ = strconv.Atoi(p)
+			filter = f
+		}
+		switch action {
+		case "gpus":
+			response = "Fetching GPU information... 🖥️💖"
+			handleGPUs(chatID, editID, bot, filter, page)
+		case "hosts":
+			response = "Fetching Host information... 📡💖"
+			handleHosts(chatID, editID, bot, filter, page)
+		default:
+			response = "Fetching Hardware information... 🛠️💖"
+			handleHardware(chatID, editID, bot, "", page)
+		}
 	case "hardware":
 		response = "Fetching Hardware information... 🛠️💖"
-		handleHardware(chatID, bot)
+		handleHardware(chatID, 0, bot, "", 0)
+	case "gpu":
+		if i := strings.LastIndex(arg, ":"); i > 0 {
+			response = "Fetching GPU information... 🖥️💖"
+			handleGPU(chatID, bot, arg[:i], arg[i+1:])
+		}
+	case "host":
+		response = "Fetching Host information... 📡💖"
+		handleHost(chatID, bot, arg)
+	case "hchart":
+		response = "Drawing the last 24h... 📈💖"
+		handleChart(chatID, bot, arg+" cpu 24h")
+	case "hwd":
+		response = "Fetching Hardware information... 🛠️💖"
+		handleHardware(chatID, 0, bot, arg, 0)
+	case "hwfull":
+		response = "Sending the full hardware detail... 📋💖"
+		handleHardwareDetail(chatID, bot, arg)
+	case "noop":
 	case "health":
 		response = "Checking system health... 🩺💖"
-		handleHealthCheck(chatID, bot)
+		handleHealthCheck(chatID, bot, "")
 	default:
 		response = "Unknown command. Please use the buttons again 💔"
 	}
@@ -108,142 +304,472 @@ func handleCallback(callback *tgbotapi.CallbackQuery, bot *tgbotapi.BotAPI) {
 
 
 
-// /gpus command handler
-func handleGPUs(chatID int64, bot *tgbotapi.BotAPI) {
-	msg := tgbotapi.NewMessage(chatID, "Fetching GPUs 💖✨")
-	bot.Send(msg)
+// withSelector appends a label selector such as "cluster=training" given
+// as command arguments to an API path.
+func withSelector(path, selector string) string {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return apiUrl + path
+	}
+	return apiUrl + path + "?" + url.Values{"selector": {selector}}.Encode()
+}
 
-	// Get GPUs data from backend API
-	resp, err := http.Get(fmt.Sprintf("%s/gpu/list", apiUrl))
-	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the GPU data 😔💔")
-		bot.Send(msg)
-		return
+// pageSize is how many rows a summary table shows per message.
+const pageSize = 15
+
+// listFilter splits the argument of /gpus and /hosts: anything that
+// looks like a label selector is one, otherwise it names a host.
+func listFilter(arg string) (host, selector string) {
+	arg = strings.TrimSpace(arg)
+	if strings.ContainsAny(arg, "=!,") {
+		return "", arg
 	}
-	defer resp.Body.Close()
+	return arg, ""
+}
 
-	var gpus []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&gpus); err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Oops! Something went wrong while processing the data 😕💦")
-		bot.Send(msg)
+// sendPage sends a page of a summary table, or replaces the message it
+// was paged from when editID is set.
+func sendPage(chatID int64, editID int, bot telegram.Messenger, text string, rows [][]tgbotapi.InlineKeyboardButton) {
+	var markup *tgbotapi.InlineKeyboardMarkup
+	if len(rows) > 0 {
+		m := tgbotapi.NewInlineKeyboardMarkup(rows...)
+		markup = &m
+	}
+	if editID != 0 {
+		edit := tgbotapi.NewEditMessageText(chatID, editID, text)
+		edit.ParseMode = "Markdown"
+		edit.ReplyMarkup = markup
+		bot.Send(edit)
 		return
 	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	bot.Send(msg)
+}
 
-	if len(gpus) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "No GPUs found 😢💔")
-		bot.Send(msg)
-		return
+// pageButtons returns the ◀ page/total ▶ row for a list whose callback
+// data is "ACTION:PAGE:FILTER", or nothing when it fits on one page or
+// the filter is too long for Telegram's 64 byte callback data.
+func pageButtons(action, filter string, page, pages int) []tgbotapi.InlineKeyboardButton {
+	if pages <= 1 || len(action)+len(filter)+6 > 64 {
+		return nil
+	}
+	data := func(p int) string {
+		return fmt.Sprintf("%s:%d:%s", action, p, filter)
 	}
+	prev, next := (page+pages-1)%pages, (page+1)%pages
+	return tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("◀", data(prev)),
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), "noop"),
+		tgbotapi.NewInlineKeyboardButtonData("▶", data(next)),
+	)
+}
 
-	// Split GPU details into separate messages
-	for _, gpu := range gpus {
-		response := fmt.Sprintf("💎 *%s*\n", gpu["name"])
-		response += fmt.Sprintf("Temperature: %d°C 🌡️\n", int(gpu["temperature_c"].(float64)))
-		response += fmt.Sprintf("Fan Speed: %d%% 🌀\n", int(gpu["fan_percent"].(float64)))
-		response += fmt.Sprintf("Power Usage: %.2f W ⚡\n", gpu["power_watt"])
-		response += fmt.Sprintf("Memory Usage: %d MiB/%d MiB 💾\n", int(gpu["memory_used_mib"].(float64)), int(gpu["memory_total_mib"].(float64)))
-		response += fmt.Sprintf("GPU Usage: %d%% 💪\n", int(gpu["utilization_gpu_percent"].(float64)))
-		response += fmt.Sprintf("Processes: %d 👾\n", int(gpu["process_count"].(float64)))
-		response += fmt.Sprintf("Updated At: %s ⏳\n", gpu["updated_at"])
+// paginate clamps page and returns the bounds of its rows.
+func paginate(n, page int) (int, int, int, int) {
+	pages := max(1, (n+pageSize-1)/pageSize)
+	page = max(0, min(page, pages-1))
+	return page, pages, page * pageSize, min(n, (page+1)*pageSize)
+}
 
-		msg := tgbotapi.NewMessage(chatID, response)
-		msg.ParseMode = "Markdown"
-		bot.Send(msg)
+// buttonGrid lays buttons out perRow to a row.
+func buttonGrid(buttons []tgbotapi.InlineKeyboardButton, perRow int) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for len(buttons) > 0 {
+		n := min(perRow, len(buttons))
+		rows = append(rows, buttons[:n])
+		buttons = buttons[n:]
 	}
+	return rows
 }
 
-// /hosts command handler
-func handleHosts(chatID int64, bot *tgbotapi.BotAPI) {
-	msg := tgbotapi.NewMessage(chatID, "Fetching Hosts 🖥️💖")
-	bot.Send(msg)
+func fit(s string, width int) string {
+	if r := []rune(s); len(r) > width {
+		return string(r[:width-1]) + "…"
+	}
+	return s
+}
 
-	// Get Host data from backend API
-	resp, err := http.Get(fmt.Sprintf("%s/host/list", apiUrl))
+// /gpus [host|selector] command handler: one summary table for the fleet
+// with a host picker, or for one host with a button per GPU.
+func handleGPUs(chatID int64, editID int, bot telegram.Messenger, filter string, page int) {
+	host, selector := listFilter(filter)
+	gpus, err := client.GPUs(selector)
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the host data 😔💔")
-		bot.Send(msg)
+		log.Println("Fetching GPUs failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the GPU data 😔💔 If you gave a label selector, try something like cluster=training,env!=staging"))
 		return
 	}
-	defer resp.Body.Close()
+	if host != "" {
+		var onHost []report.GPUReport
+		for _, g := range gpus {
+			if g.Host() == host {
+				onHost = append(onHost, g)
+			}
+		}
+		gpus = onHost
+	}
+	if len(gpus) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No GPUs found 😢💔"))
+		return
+	}
+	sort.Slice(gpus, func(i, j int) bool {
+		if gpus[i].Host() != gpus[j].Host() {
+			return gpus[i].Host() < gpus[j].Host()
+		}
+		return gpus[i].Index < gpus[j].Index
+	})
 
-	var hosts []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&hosts); err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Oops! Something went wrong while processing the data 😕💦")
-		bot.Send(msg)
+	busy := 0
+	for _, g := range gpus {
+		if g.ProcessCount > 0 {
+			busy++
+		}
+	}
+	page, pages, start, end := paginate(len(gpus), page)
+
+	var b strings.Builder
+	title := "all hosts"
+	if filter != "" {
+		title = "`" + filter + "`"
+	}
+	fmt.Fprintf(&b, "💎 *GPUs* on %s: %d GPUs, %d busy\n```\n", title, len(gpus), busy)
+	fmt.Fprintf(&b, "%-12s %2s %4s %4s %4s %5s\n", "HOST", "#", "UTIL", "TEMP", "MEM", "POWER")
+	var buttons []tgbotapi.InlineKeyboardButton
+	seen := map[string]bool{}
+	for _, g := range gpus[start:end] {
+		mem := 0
+		if g.MemoryTotalMiB > 0 {
+			mem = g.MemoryUsedMiB * 100 / g.MemoryTotalMiB
+		}
+		fmt.Fprintf(&b, "%-12s %2d %3d%% %3d° %3d%% %4.0fW\n", fit(g.Host(), 12), g.Index, g.UtilizationGpuPercent, g.TemperatureC, mem, g.PowerWatt)
+		if host != "" {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("GPU %d", g.Index), fmt.Sprintf("gpu:%s:%d", g.Host(), g.Index)))
+		} else if !seen[g.Host()] && len(g.Host()) <= 50 {
+			seen[g.Host()] = true
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(g.Host(), "gpus:0:"+g.Host()))
+		}
+	}
+	b.WriteString("```")
+
+	rows := buttonGrid(buttons, 4)
+	if nav := pageButtons("gpus", filter, page, pages); nav != nil {
+		rows = append(rows, nav)
+	}
+	if host != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀ All GPUs", "gpus:0:"),
+		))
+	}
+	sendPage(chatID, editID, bot, b.String(), rows)
+}
+
+// /gpu HOST INDEX command handler
+func handleGPU(chatID int64, bot telegram.Messenger, host, index string) {
+	gpus, err := client.GPUs("")
+	if err != nil {
+		log.Println("Fetching GPUs failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the GPU data 😔💔"))
+		return
+	}
+	var gpu *report.GPUReport
+	for i := range gpus {
+		if gpus[i].Host() == host && strconv.Itoa(gpus[i].Index) == index {
+			gpu = &gpus[i]
+		}
+	}
+	if gpu == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("No GPU %s on %s 😢 See /gpus %s", index, host, host)))
 		return
 	}
 
+	response := fmt.Sprintf("💎 *%s*\n", gpu.Name)
+	response += fmt.Sprintf("Vendor: %s 🏷️\n", strings.ToUpper(gpu.Vendor))
+	if l := labels.Format(gpu.Labels); l != "" {
+		response += fmt.Sprintf("Labels: %s 🔖\n", l)
+	}
+	response += fmt.Sprintf("Temperature: %d°C 🌡️\n", gpu.TemperatureC)
+	response += fmt.Sprintf("Fan Speed: %d%% 🌀\n", gpu.FanPercent)
+	response += fmt.Sprintf("Power Usage: %.2f W ⚡\n", gpu.PowerWatt)
+	response += fmt.Sprintf("Memory Usage: %d MiB/%d MiB 💾\n", gpu.MemoryUsedMiB, gpu.MemoryTotalMiB)
+	response += fmt.Sprintf("GPU Usage: %d%% 💪\n", gpu.UtilizationGpuPercent)
+	response += fmt.Sprintf("Processes: %d 👾\n", gpu.ProcessCount)
+	if gpu.ProcessNames != "" {
+		response += fmt.Sprintf("  ↳ %s\n", gpu.ProcessNames)
+	}
+	if len(gpu.MIGInstances) > 0 {
+		response += "MIG Instances 🧩\n"
+		for _, mig := range gpu.MIGInstances {
+			response += fmt.Sprintf("  ↳ %s (GI %d / CI %d): %d MiB/%d MiB, %d processes\n",
+				mig.Profile, mig.GPUInstanceID, mig.ComputeInstanceID, mig.MemoryUsedMiB, mig.MemoryTotalMiB, mig.ProcessCount)
+		}
+	}
+	response += fmt.Sprintf("Updated At: %s ⏳\n", gpu.UpdatedAt)
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	// Callback data is limited to 64 bytes, so very long hostnames go
+	// without buttons.
+	if len(host) <= 50 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📈 Last 24h", fmt.Sprintf("chart:%s:%d", host, gpu.Index)),
+			tgbotapi.NewInlineKeyboardButtonData("🌟 Host", "host:"+host),
+		))
+	}
+	bot.Send(msg)
+}
+
+// /hosts [selector] command handler
+func handleHosts(chatID int64, editID int, bot telegram.Messenger, selector string, page int) {
+	hosts, err := client.Hosts(selector)
+	if err != nil {
+		log.Println("Fetching hosts failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the host data 😔💔 If you gave a label selector, try something like cluster=training,env!=staging"))
+		return
+	}
 	if len(hosts) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "No hosts found 😢💔")
-		bot.Send(msg)
+		bot.Send(tgbotapi.NewMessage(chatID, "No hosts found 😢💔"))
 		return
 	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Hostname < hosts[j].Hostname })
+	page, pages, start, end := paginate(len(hosts), page)
 
-	// Split host details into separate messages
-	for _, host := range hosts {
-		response := fmt.Sprintf("🌟 *%s*\n", host["hostname"])
-		response += fmt.Sprintf("CPU Usage: %.1f%% 🧠\n", host["cpu_usage_percent"].(float64))
-		response += fmt.Sprintf("Memory Usage: %d MB/%d MB 🧑‍💻\n", int(host["memory_used_mb"].(float64)), int(host["memory_total_mb"].(float64)))
-		response += fmt.Sprintf("Disk Usage: %s/%s 🧳\n", host["disk_used"], host["disk_total"])
-		response += fmt.Sprintf("Last Updated: %s ⏳\n", host["updated_at"])
+	var b strings.Builder
+	if selector != "" {
+		fmt.Fprintf(&b, "🌟 *Hosts* matching `%s`: %d\n```\n", selector, len(hosts))
+	} else {
+		fmt.Fprintf(&b, "🌟 *Hosts*: %d\n```\n", len(hosts))
+	}
+	fmt.Fprintf(&b, "%-14s %4s %4s %5s %5s\n", "HOST", "CPU", "MEM", "DISK", "AGE")
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, h := range hosts[start:end] {
+		mem := 0
+		if h.MemoryTotalMB > 0 {
+			mem = h.MemoryUsedMB * 100 / h.MemoryTotalMB
+		}
+		age := "?"
+		if t, err := time.Parse(time.RFC3339, h.UpdatedAt); err == nil {
+			age = formatAge(time.Since(t))
+		}
+		fmt.Fprintf(&b, "%-14s %3.0f%% %3d%% %5s %5s\n", fit(h.Hostname, 14), h.CPUUsagePercent, mem, h.DiskUsed, age)
+		if len(h.Hostname) <= 50 {
+			buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(h.Hostname, "host:"+h.Hostname))
+		}
+	}
+	b.WriteString("```")
 
-		msg := tgbotapi.NewMessage(chatID, response)
-		msg.ParseMode = "Markdown"
-		bot.Send(msg)
+	rows := buttonGrid(buttons, 3)
+	if nav := pageButtons("hosts", selector, page, pages); nav != nil {
+		rows = append(rows, nav)
 	}
+	sendPage(chatID, editID, bot, b.String(), rows)
 }
 
-// /hardware command handler
-func handleHardware(chatID int64, bot *tgbotapi.BotAPI) {
-	msg := tgbotapi.NewMessage(chatID, "Fetching Hardware Reports 🛠️💖")
+// formatAge prints how long ago a report came in: 45s, 12m, 3h, 2d.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// /host NAME command handler: the host's metrics, its GPUs and alerts.
+func handleHost(chatID int64, bot telegram.Messenger, name string) {
+	hosts, err := client.Hosts("")
+	var gpus []report.GPUReport
+	if err == nil {
+		gpus, err = client.GPUs("")
+	}
+	var health api.Health
+	if err == nil {
+		health, err = client.Health("")
+	}
+	if err != nil {
+		log.Println("Fetching host failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the host data 😔💔"))
+		return
+	}
+	var host *report.HostReport
+	for i := range hosts {
+		if hosts[i].Hostname == name {
+			host = &hosts[i]
+		}
+	}
+	if host == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "No host called "+name+" 😢 See /hosts"))
+		return
+	}
+
+	response := fmt.Sprintf("🌟 %s\n", host.Hostname)
+	if l := labels.Format(host.Labels); l != "" {
+		response += fmt.Sprintf("Labels: %s 🔖\n", l)
+	}
+	response += fmt.Sprintf("CPU Usage: %.1f%% 🧠\n", host.CPUUsagePercent)
+	response += fmt.Sprintf("Memory Usage: %d MB/%d MB 🧑‍💻\n", host.MemoryUsedMB, host.MemoryTotalMB)
+	response += fmt.Sprintf("Disk Usage: %s/%s 🧳\n", host.DiskUsed, host.DiskTotal)
+	response += fmt.Sprintf("Last Updated: %s ⏳\n", host.UpdatedAt)
+	for _, g := range gpus {
+		if g.Host() == name {
+			response += fmt.Sprintf("💎 GPU %d: %d%%, %d°C, %d/%d MiB, %d processes\n",
+				g.Index, g.UtilizationGpuPercent, g.TemperatureC, g.MemoryUsedMiB, g.MemoryTotalMiB, g.ProcessCount)
+		}
+	}
+	for _, a := range health.Alerts {
+		if a.Host == name {
+			response += fmt.Sprintf("⚠️ %s\n", a.Message)
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	if len(name) <= 50 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💎 GPUs", "gpus:0:"+name),
+			tgbotapi.NewInlineKeyboardButtonData("🛠️ Hardware", "hwd:"+name),
+			tgbotapi.NewInlineKeyboardButtonData("📈 CPU 24h", "hchart:"+name),
+		))
+	}
 	bot.Send(msg)
+}
 
-	// Get Hardware data from backend API
-	resp, err := http.Get(fmt.Sprintf("%s/hardware/list", apiUrl))
+// /hardware [HOST] command handler: a host picker, or a host's hardware
+// summary with a button for the full detail.
+func handleHardware(chatID int64, editID int, bot telegram.Messenger, host string, page int) {
+	reports, err := client.Hardware()
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the hardware data 😔💔")
-		bot.Send(msg)
+		log.Println("Fetching hardware failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the hardware data 😔💔"))
 		return
 	}
-	defer resp.Body.Close()
+	if len(reports) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No hardware reports found 😢💔"))
+		return
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Hostname < reports[j].Hostname })
 
-	var hardwareReports []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&hardwareReports); err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Oops! Something went wrong while processing the data 😕💦")
-		bot.Send(msg)
+	if host == "" {
+		page, pages, start, end := paginate(len(reports), page)
+		var buttons []tgbotapi.InlineKeyboardButton
+		for _, hr := range reports[start:end] {
+			if len(hr.Hostname) <= 50 {
+				buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(hr.Hostname, "hwd:"+hr.Hostname))
+			}
+		}
+		rows := buttonGrid(buttons, 3)
+		if nav := pageButtons("hw", "", page, pages); nav != nil {
+			rows = append(rows, nav)
+		}
+		sendPage(chatID, editID, bot, fmt.Sprintf("🔧 *Hardware* reports from %d hosts. Pick one:", len(reports)), rows)
 		return
 	}
 
-	if len(hardwareReports) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "No hardware reports found 😢💔")
+	for _, hr := range reports {
+		if hr.Hostname != host {
+			continue
+		}
+		d := hardware.Parse(hr)
+		response := fmt.Sprintf("🔧 %s\n", d.Hostname)
+		response += fmt.Sprintf("OS: %s, kernel %s 🐧\n", d.Distro, d.Kernel)
+		response += fmt.Sprintf("Uptime: %s ⏳\n", d.Uptime)
+		response += fmt.Sprintf("CPU: %s, %d sockets, %d cores, %d threads 🧠\n", d.CPUModel, d.Sockets, d.Cores, d.Threads)
+		response += fmt.Sprintf("Memory: %s total, %s used 🧑‍💻\n", d.MemoryTotal, d.MemoryUsed)
+		for _, disk := range d.Disks {
+			response += fmt.Sprintf("Disk %s: %s 🧳\n", disk.Name, disk.Size)
+		}
+		for _, dev := range d.PCI {
+			if dev.GPU() {
+				response += fmt.Sprintf("GPU %s: %s %s 💎\n", dev.Slot, dev.Vendor, dev.Device)
+			}
+		}
+		for _, iface := range d.Interfaces {
+			if iface.State == "UP" {
+				response += fmt.Sprintf("Network %s: %s 🌐\n", iface.Name, strings.Join(iface.Addresses, ", "))
+			}
+		}
+		msg := tgbotapi.NewMessage(chatID, response)
+		if len(host) <= 50 {
+			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("📋 Full detail", "hwfull:"+host),
+			))
+		}
 		bot.Send(msg)
 		return
 	}
+	bot.Send(tgbotapi.NewMessage(chatID, "No hardware report from "+host+" 😢 See /hardware"))
+}
 
-	// Split hardware details into separate messages
-	for _, report := range hardwareReports {
-		response := fmt.Sprintf("🔧 *%s*\n", report["hostname"])
-		response += fmt.Sprintf("Uptime: %s ⏳\n", report["uptime"])
-		response += fmt.Sprintf("Kernel: %s 🐧\n", report["kernel"])
+// handleHardwareDetail sends everything parsed from a host's hardware
+// report, split over several messages if needed.
+func handleHardwareDetail(chatID int64, bot telegram.Messenger, host string) {
+	reports, err := client.Hardware()
+	if err != nil {
+		log.Println("Fetching hardware failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the hardware data 😔💔"))
+		return
+	}
+	for _, hr := range reports {
+		if hr.Hostname != host {
+			continue
+		}
+		d := hardware.Parse(hr)
+		var b strings.Builder
+		fmt.Fprintf(&b, "🔧 %s, full hardware detail\n", d.Hostname)
+		b.WriteString("\n🧳 Disks\n")
+		for _, disk := range d.Disks {
+			fmt.Fprintf(&b, "%s %s %s\n", disk.Name, disk.Size, strings.Join(disk.Mounts, " "))
+		}
+		b.WriteString("\n🗂️ Filesystems\n")
+		for _, fs := range d.Filesystems {
+			fmt.Fprintf(&b, "%s (%s) %s of %s used, %s free\n", fs.Mount, fs.Type, fs.Used, fs.Size, fs.Available)
+		}
+		b.WriteString("\n🌐 Network\n")
+		for _, iface := range d.Interfaces {
+			fmt.Fprintf(&b, "%s %s %s %s\n", iface.Name, iface.State, iface.MAC, strings.Join(iface.Addresses, ", "))
+		}
+		b.WriteString("\n🔌 PCI\n")
+		for _, dev := range d.PCI {
+			fmt.Fprintf(&b, "%s %s: %s %s\n", dev.Slot, dev.Class, dev.Vendor, dev.Device)
+		}
+		b.WriteString("\n🖱️ USB\n")
+		for _, dev := range d.USB {
+			fmt.Fprintf(&b, "%s\n", dev)
+		}
+		sendLong(chatID, bot, b.String())
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, "No hardware report from "+host+" 😢"))
+}
 
-		msg := tgbotapi.NewMessage(chatID, response)
-		msg.ParseMode = "Markdown"
-		bot.Send(msg)
+// sendLong sends text as plain messages, split to stay under Telegram's
+// message length limit.
+func sendLong(chatID int64, bot telegram.Messenger, text string) {
+	for _, part := range telegram.Split(text, telegram.MaxMessageLength) {
+		bot.Send(tgbotapi.NewMessage(chatID, part))
 	}
 }
 
-func handleHealthCheck(chatID int64, bot *tgbotapi.BotAPI) {
+func handleHealthCheck(chatID int64, bot telegram.Messenger, selector string) {
 	msg := tgbotapi.NewMessage(chatID, "Checking system health... 🌸✨")
 	bot.Send(msg)
 
 	// Get health check data from backend API
-	resp, err := http.Get(fmt.Sprintf("%s/healthcheck", apiUrl))
+	resp, err := http.Get(withSelector("/healthcheck", selector))
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "Sorry, I couldn't reach the health check endpoint 😔💔")
 		bot.Send(msg)
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		bot.Send(tgbotapi.NewMessage(chatID, "That label selector doesn't look right 😕 Try something like cluster=training,env!=staging"))
+		return
+	}
 
 	// Parse health check response
 	if resp.StatusCode == http.StatusOK {
@@ -268,10 +794,563 @@ func handleHealthCheck(chatID int64, bot *tgbotapi.BotAPI) {
 	}
 }
 
+// /groups LABEL [selector] command handler
+func handleGroups(chatID int64, bot telegram.Messenger, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "Tell me which label to group by 💕 e.g. /groups cluster"))
+		return
+	}
+	q := url.Values{"by": {fields[0]}}
+	if len(fields) > 1 {
+		q.Set("selector", strings.Join(fields[1:], ","))
+	}
+
+	resp, err := http.Get(apiUrl + "/group/summary?" + q.Encode())
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the group summary 😔💔"))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bot.Send(tgbotapi.NewMessage(chatID, "That label or selector doesn't look right 😕"))
+		return
+	}
+
+	var groups []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "Oops! Something went wrong while processing the data 😕💦"))
+		return
+	}
+	if len(groups) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No hosts found 😢💔"))
+		return
+	}
+
+	response := fmt.Sprintf("📊 *Grouped by %s*\n", fields[0])
+	for _, g := range groups {
+		value := fmt.Sprint(g["value"])
+		if value == "" {
+			value = "(unlabeled)"
+		}
+		response += fmt.Sprintf("\n*%s*\n", value)
+		response += fmt.Sprintf("Hosts: %d 📡 GPUs: %d (%d busy) 🖥️\n", int(g["hosts"].(float64)), int(g["gpus"].(float64)), int(g["busy_gpus"].(float64)))
+		response += fmt.Sprintf("Avg GPU Usage: %.0f%% 💪\n", g["avg_utilization_percent"].(float64))
+		response += fmt.Sprintf("Memory: %d MiB/%d MiB 💾\n", int(g["memory_used_mib"].(float64)), int(g["memory_total_mib"].(float64)))
+		response += fmt.Sprintf("Power: %.0f W ⚡\n", g["power_watt"].(float64))
+		if alerts := int(g["alerts"].(float64)); alerts > 0 {
+			response += fmt.Sprintf("Alerts: %d ❌\n", alerts)
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	msg.ParseMode = "Markdown"
+	bot.Send(msg)
+}
+
 // /unknown command handler
-func handleUnknown(chatID int64, bot *tgbotapi.BotAPI) {
+func handleUnknown(chatID int64, bot telegram.Messenger) {
 	msg := tgbotapi.NewMessage(chatID, "Sorry 💕 I don't understand that command 😕💔\nPlease use /start to see available options.")
 	bot.Send(msg)
 }
 
+// parseDuration accepts Go durations plus days and weeks: 30m, 2h, 1d, 1w.
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// who names a Telegram user for acknowledgements and mutes.
+func who(c botauth.Caller) string {
+	if c.UserName != "" {
+		return "@" + c.UserName
+	}
+	return strconv.FormatInt(c.UserID, 10)
+}
+
+// /subscribe [warning|critical] [selector] command handler
+func handleSubscribe(chatID int64, bot telegram.Messenger, args string) {
+	fields := strings.Fields(args)
+	severity := alerts.Warning
+	if len(fields) > 0 {
+		if sev, err := alerts.ParseSeverity(fields[0]); err == nil {
+			severity = sev
+			fields = fields[1:]
+		}
+	}
+	selector := strings.Join(fields, "")
+
+	sub, err := client.Subscribe(chatID, selector, severity)
+	if err != nil {
+		log.Println("Subscribe failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't save that subscription 😔💔\nUsage: /subscribe [warning|critical] [selector]"))
+		return
+	}
+	scope := "all hosts"
+	if sub.Selector != "" {
+		scope = "hosts matching " + sub.Selector
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🔔 Subscribed (#%d): %s alerts and up on %s.", sub.ID, sub.MinSeverity, scope)))
+}
+
+// pairRetryDelay is how long someone has to wait after a wrong pairing
+// code, so codes cannot be guessed quickly.
+const pairRetryDelay = 10 * time.Second
+
+var lastFailedPair = map[int64]time.Time{}
+
+// /pair CODE command handler: redeems a code made with `gpumon pair`,
+// giving this chat the code's role and subscriptions.
+func handlePair(chatID int64, bot telegram.Messenger, caller botauth.Caller, args string) {
+	code := strings.TrimSpace(args)
+	if code == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "Usage: /pair CODE\nAsk an admin for a code, they make one with gpumon pair."))
+		return
+	}
+	if time.Since(lastFailedPair[caller.UserID]) < pairRetryDelay {
+		bot.Send(tgbotapi.NewMessage(chatID, "⏳ Please wait a few seconds before trying another code."))
+		return
+	}
+	pc, err := client.RedeemPairingCode(code, chatID, caller.UserID)
+	if err != nil {
+		log.Println("Pairing failed:", err)
+		lastFailedPair[caller.UserID] = time.Now()
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ That code is invalid, already used or expired. Ask an admin for a new one."))
+		return
+	}
+	delete(lastFailedPair, caller.UserID)
+	role, _ := botauth.ParseRole(pc.Role)
+	if _, err := auth.GrantHere(caller, role); err != nil {
+		log.Println("Granting paired role failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't save your access 😔💔 Ask an admin to /grant it."))
+		return
+	}
+
+	who := "You now have"
+	if caller.ChatID != caller.UserID {
+		who = "This group now has"
+	}
+	response := fmt.Sprintf("✅ Paired! %s the %s role.", who, auth.Role(caller))
+	for _, sub := range pc.Subscriptions {
+		scope := "all hosts"
+		if sub.Selector != "" {
+			scope = "hosts matching " + sub.Selector
+		}
+		response += fmt.Sprintf("\n🔔 Subscribed to %s alerts and up on %s.", sub.Severity, scope)
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, response+"\n\nSend /start to see what you can do."))
+}
+
+// /unsubscribe [ID|all] command handler
+func handleUnsubscribe(chatID int64, bot telegram.Messenger, args string) {
+	id, ok := parseID(chatID, bot, args, "/unsubscribe [ID|all]")
+	if !ok {
+		return
+	}
+	n, err := client.Unsubscribe(chatID, id)
+	if err != nil {
+		log.Println("Unsubscribe failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't remove that subscription 😔💔"))
+		return
+	}
+	if n == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No matching subscription. See /subscriptions"))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🔕 Removed %d subscription(s).", n)))
+}
+
+// parseID reads the optional ID argument of /unsubscribe and /unmute,
+// where no argument or "all" means every entry.
+func parseID(chatID int64, bot telegram.Messenger, args, usage string) (int64, bool) {
+	args = strings.TrimPrefix(strings.TrimSpace(args), "#")
+	if args == "" || args == "all" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(args, 10, 64)
+	if err != nil || id <= 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "Usage: "+usage))
+		return 0, false
+	}
+	return id, true
+}
+
+// /subscriptions command handler
+func handleSubscriptions(chatID int64, bot telegram.Messenger) {
+	subs, err := client.Subscriptions(chatID)
+	var mutes []alerts.Mute
+	if err == nil {
+		mutes, err = client.Mutes(chatID)
+	}
+	if err != nil {
+		log.Println("Listing subscriptions failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the subscriptions 😔💔"))
+		return
+	}
+
+	var b strings.Builder
+	if len(subs) == 0 {
+		b.WriteString("🔕 This chat gets no alerts. Use /subscribe to change that.\n")
+	} else {
+		b.WriteString("🔔 Subscriptions:\n")
+	}
+	for _, sub := range subs {
+		selector := sub.Selector
+		if selector == "" {
+			selector = "all hosts"
+		}
+		fmt.Fprintf(&b, "#%d %s and up on %s\n", sub.ID, sub.MinSeverity, selector)
+	}
+	if len(mutes) > 0 {
+		b.WriteString("\n🔇 Muted:\n")
+	}
+	for _, m := range mutes {
+		until, _ := time.Parse(time.RFC3339, m.Until)
+		fmt.Fprintf(&b, "#%d %s %s for %s more (by %s)\n", m.ID, m.Kind, m.Value, time.Until(until).Round(time.Minute), m.CreatedBy)
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, b.String()))
+}
 
+// /mute [host|gpu|rule] NAME DURATION command handler. Without a kind,
+// rule names are recognized, HOST:INDEX and full GPU names (with "@")
+// are GPUs and anything else is a host.
+func handleMute(chatID int64, bot telegram.Messenger, caller botauth.Caller, args string) {
+	usage := "Usage: /mute [host|gpu|rule] NAME DURATION, e.g. /mute node-7 2h, /mute gpu node-7:3 1d, /mute gpu_idle 30m"
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	d, err := parseDuration(fields[len(fields)-1])
+	if err != nil || d <= 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	fields = fields[:len(fields)-1]
+
+	kind := ""
+	switch fields[0] {
+	case alerts.MuteHost, alerts.MuteGPU, alerts.MuteRule:
+		kind, fields = fields[0], fields[1:]
+	}
+	// GPU names contain spaces, so the rest is one value.
+	value := strings.Join(fields, " ")
+	if value == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	if kind == "" {
+		kind = alerts.MuteHost
+		for _, rule := range alerts.Rules {
+			if value == rule {
+				kind = alerts.MuteRule
+			}
+		}
+		if strings.Contains(value, "@") || strings.Contains(value, ":") {
+			kind = alerts.MuteGPU
+		}
+	}
+
+	m, err := client.Mute(chatID, kind, value, d, who(caller))
+	if err != nil {
+		log.Println("Mute failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't mute that 😔💔\n"+usage))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🔇 Muted %s %s for %s (#%d). /unmute %d to undo.", m.Kind, m.Value, d, m.ID, m.ID)))
+}
+
+// /unmute [ID|all] command handler
+func handleUnmute(chatID int64, bot telegram.Messenger, args string) {
+	id, ok := parseID(chatID, bot, args, "/unmute [ID|all]")
+	if !ok {
+		return
+	}
+	n, err := client.Unmute(chatID, id)
+	if err != nil {
+		log.Println("Unmute failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't unmute that 😔💔"))
+		return
+	}
+	if n == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No matching mute. See /subscriptions"))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🔊 Removed %d mute(s).", n)))
+}
+
+// deliverAlerts sends the alert notifications the server queued for
+// subscribed chats.
+func deliverAlerts(bot telegram.Messenger) {
+	for {
+		pending, err := client.PendingNotifications(api.HostNotifications)
+		if err != nil {
+			log.Println("Fetching alert notifications failed:", err)
+		}
+		var sent []int64
+		for _, n := range pending {
+			msg := tgbotapi.NewMessage(n.ChatID, formatAlert(n.Event, n.Kind))
+			if n.Kind != alerts.Resolved {
+				msg.ReplyMarkup = alertButtons(n.Event.ID)
+			}
+			_, err := bot.Send(msg)
+			if _, network := err.(*url.Error); network {
+				// Telegram is unreachable, try again next round.
+				log.Println("Sending alert failed:", err)
+				break
+			}
+			if err != nil {
+				// The chat blocked the bot or no longer exists; retrying
+				// would not help.
+				log.Printf("Sending alert to chat %d failed: %v", n.ChatID, err)
+			}
+			sent = append(sent, n.ID)
+		}
+		if len(sent) > 0 {
+			if err := client.MarkSent(sent); err != nil {
+				log.Println("Marking alerts sent failed:", err)
+			}
+		}
+		time.Sleep(alertPollInterval)
+	}
+}
+
+func formatAlert(ev alerts.Event, kind string) string {
+	icon := "⚠️"
+	if ev.Severity == alerts.Critical {
+		icon = "🔥"
+	}
+	var b strings.Builder
+	switch kind {
+	case alerts.Resolved:
+		fmt.Fprintf(&b, "✅ Resolved: %s\n", ev.Message)
+	case alerts.Reminder:
+		fmt.Fprintf(&b, "⏰ Still firing %s %s: %s\n", icon, strings.ToUpper(string(ev.Severity)), ev.Message)
+	default:
+		fmt.Fprintf(&b, "%s %s: %s\n", icon, strings.ToUpper(string(ev.Severity)), ev.Message)
+	}
+	fmt.Fprintf(&b, "Rule: %s · Host: %s · #%d\n", ev.Rule, ev.Host, ev.ID)
+	started, _ := time.Parse(time.RFC3339, ev.StartedAt)
+	if kind == alerts.Resolved {
+		resolved, _ := time.Parse(time.RFC3339, ev.ResolvedAt)
+		fmt.Fprintf(&b, "Lasted %s", resolved.Sub(started).Round(time.Second))
+	} else {
+		fmt.Fprintf(&b, "Since %s (%s ago)", started.Format("2006-01-02 15:04 MST"), time.Since(started).Round(time.Minute))
+	}
+	if ev.AckedBy != "" {
+		fmt.Fprintf(&b, "\nAcknowledged by %s", ev.AckedBy)
+	}
+	return b.String()
+}
+
+func alertButtons(id int64) tgbotapi.InlineKeyboardMarkup {
+	data := func(action string) string {
+		return action + ":" + strconv.FormatInt(id, 10)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Ack", data("ack")),
+			tgbotapi.NewInlineKeyboardButtonData("😴 1h", data("snooze")+":1h"),
+			tgbotapi.NewInlineKeyboardButtonData("😴 4h", data("snooze")+":4h"),
+			tgbotapi.NewInlineKeyboardButtonData("😴 1d", data("snooze")+":1d"),
+		),
+	)
+}
+
+// handleAlertButton acknowledges or snoozes the alert behind an alert
+// message, updates the message and returns the text for the callback
+// answer.
+func handleAlertButton(callback *tgbotapi.CallbackQuery, bot telegram.Messenger, action, arg string) string {
+	idText, durText, _ := strings.Cut(arg, ":")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		return "Unknown alert 💔"
+	}
+	by := who(callerOf(callback.From, callback.Message.Chat))
+
+	var ev alerts.Event
+	var note string
+	if action == "ack" {
+		ev, err = client.Ack(id, by)
+		note = "✅ Acknowledged by " + by
+	} else {
+		var d time.Duration
+		if d, err = parseDuration(durText); err == nil {
+			ev, err = client.Snooze(id, d, by)
+			note = fmt.Sprintf("😴 Snoozed for %s by %s", durText, by)
+		}
+	}
+	if err != nil {
+		log.Printf("Alert %s failed: %v", action, err)
+		return "Couldn't update the alert, it may have resolved 💔"
+	}
+
+	// Keep the snooze buttons until someone acknowledges the alert.
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, callback.Message.Text+"\n"+note)
+	if ev.AckedBy == "" {
+		markup := alertButtons(ev.ID)
+		edit.ReplyMarkup = &markup
+	}
+	bot.Send(edit)
+	return note
+}
+
+// chartMetric is a metric /chart can plot.
+type chartMetric struct {
+	title string
+	unit  string
+	// max is the top of the Y axis unless the data goes higher; 0 fits
+	// the axis to the data.
+	max  float64
+	host bool // a host metric rather than a GPU one
+	gpu  func(report.GPUSample) float64
+	cpu  func(report.HostSample) float64
+}
+
+var chartMetrics = map[string]chartMetric{
+	"temp":  {title: "temperature", unit: "°C", max: 100, gpu: func(s report.GPUSample) float64 { return float64(s.TemperatureC) }},
+	"util":  {title: "utilization", unit: "%", max: 100, gpu: func(s report.GPUSample) float64 { return float64(s.UtilizationGpuPercent) }},
+	"power": {title: "power", unit: "W", gpu: func(s report.GPUSample) float64 { return s.PowerWatt }},
+	"vram":  {title: "GPU memory", unit: "MiB", gpu: func(s report.GPUSample) float64 { return float64(s.MemoryUsedMiB) }},
+	"cpu":   {title: "CPU usage", unit: "%", max: 100, host: true, cpu: func(s report.HostSample) float64 { return s.CPUUsagePercent }},
+	"mem":   {title: "memory used", unit: "MB", host: true, cpu: func(s report.HostSample) float64 { return float64(s.MemoryUsedMB) }},
+}
+
+// chartPoints is how many samples a chart asks the server for; the step
+// is the range divided by this.
+const chartPoints = 240
+
+// /chart HOST|HOST:GPU METRIC [RANGE] command handler
+func handleChart(chatID int64, bot telegram.Messenger, args string) {
+	usage := "Usage: /chart HOST|HOST:GPU METRIC [RANGE]\nMetrics: temp, util, power, vram for GPUs, cpu and mem for hosts. Range: e.g. 1h, 24h, 7d (default 24h)."
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 {
+		bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+	target, name := fields[0], fields[1]
+	metric, ok := chartMetrics[name]
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "Unknown metric 😕\n"+usage))
+		return
+	}
+	since := 24 * time.Hour
+	if len(fields) == 3 {
+		d, err := parseDuration(fields[2])
+		if err != nil || d < time.Minute {
+			bot.Send(tgbotapi.NewMessage(chatID, "That range doesn't look right 😕\n"+usage))
+			return
+		}
+		since = d
+	}
+	host, gpu, isGPU := strings.Cut(target, ":")
+	if isGPU && metric.host {
+		bot.Send(tgbotapi.NewMessage(chatID, name+" is a host metric, use /chart "+host+" "+name))
+		return
+	}
+
+	c := chart.Chart{
+		Title: fmt.Sprintf("%s %s, last %s", target, metric.title, formatRange(since)),
+		Unit:  metric.unit,
+		YMax:  metric.max,
+	}
+	q := api.HistoryQuery{Host: host, Since: since, Step: max(since/chartPoints, time.Second)}
+	if metric.host {
+		history, err := client.HostHistory(q)
+		if err != nil {
+			log.Println("Fetching host history failed:", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the history 😔💔"))
+			return
+		}
+		for _, h := range history {
+			s := chart.Series{Name: h.Hostname}
+			for _, sample := range h.Samples {
+				t, _ := time.Parse(time.RFC3339, sample.Time)
+				s.Points = append(s.Points, chart.Point{Time: t, Value: metric.cpu(sample)})
+			}
+			c.Series = append(c.Series, s)
+		}
+	} else {
+		history, err := client.GPUHistory(q)
+		if err != nil {
+			log.Println("Fetching GPU history failed:", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the history 😔💔"))
+			return
+		}
+		for _, h := range history {
+			index := strings.SplitN(h.Name, "@", 2)[0]
+			if isGPU && index != gpu {
+				continue
+			}
+			s := chart.Series{Name: "GPU " + index}
+			for _, sample := range h.Samples {
+				t, _ := time.Parse(time.RFC3339, sample.Time)
+				s.Points = append(s.Points, chart.Point{Time: t, Value: metric.gpu(sample)})
+			}
+			c.Series = append(c.Series, s)
+		}
+	}
+	if len(c.Series) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No history for "+target+" 😢 Check the name with /hosts or /gpus"))
+		return
+	}
+	sendChart(chatID, bot, &c)
+}
+
+// handleGPUChart plots the last 24h of temperature and utilization of one
+// GPU given as HOST:INDEX, for the button under each GPU message.
+func handleGPUChart(chatID int64, bot telegram.Messenger, target string) {
+	host, index, _ := strings.Cut(target, ":")
+	history, err := client.GPUHistory(api.HistoryQuery{Host: host, Since: 24 * time.Hour, Step: 24 * time.Hour / chartPoints})
+	if err != nil {
+		log.Println("Fetching GPU history failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't fetch the history 😔💔"))
+		return
+	}
+	c := chart.Chart{Title: fmt.Sprintf("%s GPU %s, last 24h", host, index), YMin: 0, YMax: 100}
+	for _, h := range history {
+		if strings.SplitN(h.Name, "@", 2)[0] != index {
+			continue
+		}
+		temp := chart.Series{Name: "temperature °C"}
+		util := chart.Series{Name: "utilization %"}
+		for _, sample := range h.Samples {
+			t, _ := time.Parse(time.RFC3339, sample.Time)
+			temp.Points = append(temp.Points, chart.Point{Time: t, Value: float64(sample.TemperatureC)})
+			util.Points = append(util.Points, chart.Point{Time: t, Value: float64(sample.UtilizationGpuPercent)})
+		}
+		c.Series = append(c.Series, temp, util)
+	}
+	if len(c.Series) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No history for that GPU yet 😢"))
+		return
+	}
+	sendChart(chatID, bot, &c)
+}
+
+func sendChart(chatID int64, bot telegram.Messenger, c *chart.Chart) {
+	var buf bytes.Buffer
+	if err := c.WritePNG(&buf); err != nil {
+		log.Println("Rendering chart failed:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "Sorry, I couldn't draw that chart 😔💔"))
+		return
+	}
+	photo := tgbotapi.NewPhotoUpload(chatID, tgbotapi.FileBytes{Name: "chart.png", Bytes: buf.Bytes()})
+	photo.Caption = c.Title
+	if _, err := bot.Send(photo); err != nil {
+		log.Println("Sending chart failed:", err)
+	}
+}
+
+// formatRange prints a chart range the way it is usually typed: 7d
+// rather than 168h0m0s.
+func formatRange(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(d.String(), "0s"), "0m")
+}
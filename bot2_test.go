@@ -0,0 +1,607 @@
+// The repository root holds several programs, so its tests are run one
+// program at a time:
+//
+//	go test bot2.go bot2_test.go
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/api"
+	"gpu-monitor/botauth"
+	"gpu-monitor/report"
+	"gpu-monitor/telegram/telegramtest"
+)
+
+const testToken = "test-token"
+
+// fakeAPI stands in for the collector server. It answers "METHOD /path"
+// with a canned reply, encoded as JSON, and records the requests. Admin
+// endpoints need the bot's token like on the real server.
+type fakeAPI struct {
+	mu      sync.Mutex
+	replies map[string]interface{}
+	calls   []apiCall
+}
+
+type apiCall struct {
+	Route string // e.g. "POST /admin/mutes"
+	Query url.Values
+	Body  map[string]interface{}
+}
+
+// apiStatus is a reply with an error status. A string body is sent as
+// plain text, like the server's http.Error replies.
+type apiStatus struct {
+	code int
+	body interface{}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + r.URL.Path
+	call := apiCall{Route: route, Query: r.URL.Query()}
+	json.NewDecoder(r.Body).Decode(&call.Body)
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	reply, ok := f.replies[route]
+	f.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/admin/") && r.Header.Get("Authorization") != "Bearer "+testToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if s, ok := reply.(apiStatus); ok {
+		if text, ok := s.body.(string); ok {
+			http.Error(w, text, s.code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(s.code)
+		json.NewEncoder(w).Encode(s.body)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (f *fakeAPI) reply(route string, v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[route] = v
+}
+
+// last returns the latest request to a route.
+func (f *fakeAPI) last(t *testing.T, route string) apiCall {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if f.calls[i].Route == route {
+			return f.calls[i]
+		}
+	}
+	t.Fatalf("no %s request", route)
+	return apiCall{}
+}
+
+// Users of the tests, by role.
+var (
+	admin    = tgbotapi.User{ID: 1, FirstName: "alice", UserName: "alice"}
+	operator = tgbotapi.User{ID: 2, FirstName: "bob", UserName: "bob"}
+	viewer   = tgbotapi.User{ID: 3, FirstName: "carol", UserName: "carol"}
+	stranger = tgbotapi.User{ID: 4, FirstName: "mallory", UserName: "mallory"}
+)
+
+// teamChat is a group the viewer role is granted to.
+var teamChat = tgbotapi.Chat{ID: -100, Type: "group", Title: "gpu team"}
+
+type testBot struct {
+	srv    *telegramtest.Server
+	bot    *tgbotapi.BotAPI
+	api    *fakeAPI
+	offset int
+}
+
+// newTestBot points the bot at a fake Telegram and a fake collector
+// serving a small fleet, with a fresh access database.
+func newTestBot(t *testing.T) *testBot {
+	t.Helper()
+	srv := telegramtest.NewServer()
+	t.Cleanup(srv.Close)
+	bot, err := srv.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeAPI{replies: fleetReplies()}
+	hs := httptest.NewServer(fake)
+	t.Cleanup(hs.Close)
+	apiUrl = hs.URL
+	client = api.New(hs.URL, testToken)
+	lastFailedPair = map[int64]time.Time{}
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "gpubot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	auth, err = botauth.Open(db, []int64{int64(admin.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, r := range map[int64]botauth.Role{int64(operator.ID): botauth.Operator, int64(viewer.ID): botauth.Viewer, teamChat.ID: botauth.Viewer} {
+		if err := auth.Grant(id, "", r, int64(admin.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &testBot{srv: srv, bot: bot, api: fake}
+}
+
+// deliver fetches the queued updates and handles them like main does.
+func (b *testBot) deliver(t *testing.T) {
+	t.Helper()
+	updates, err := b.bot.GetUpdates(tgbotapi.UpdateConfig{Offset: b.offset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range updates {
+		b.offset = u.UpdateID + 1
+		handleUpdate(u, b.bot)
+	}
+}
+
+// command sends text in chat and returns what the bot sent back.
+func (b *testBot) command(t *testing.T, from tgbotapi.User, chat tgbotapi.Chat, text string) []telegramtest.Sent {
+	t.Helper()
+	b.srv.Reset()
+	b.srv.SendMessage(from, chat, text)
+	b.deliver(t)
+	return b.srv.Sent()
+}
+
+// press presses a button under a message and returns what the bot sent
+// and how it answered the callback query.
+func (b *testBot) press(t *testing.T, from tgbotapi.User, on telegramtest.Sent, data string) ([]telegramtest.Sent, telegramtest.Answer) {
+	t.Helper()
+	b.srv.Reset()
+	id := b.srv.PressButton(from, on, data)
+	b.deliver(t)
+	answers := b.srv.Answers()
+	if len(answers) != 1 || answers[0].CallbackQueryID != id {
+		t.Fatalf("pressing %s: answers %+v, want one to query %s", data, answers, id)
+	}
+	return b.srv.Sent(), answers[0]
+}
+
+func private(u tgbotapi.User) tgbotapi.Chat {
+	return tgbotapi.Chat{ID: int64(u.ID), Type: "private"}
+}
+
+// fleetReplies is a fleet of two hosts: node-a with 12 GPUs and node-b
+// with 8, so the GPU summary takes two pages.
+func fleetReplies() map[string]interface{} {
+	now := time.Now().UTC()
+	var gpus []report.GPUReport
+	for host, n := range map[string]int{"node-a": 12, "node-b": 8} {
+		for i := 0; i < n; i++ {
+			g := report.GPUReport{
+				Index:                 i,
+				Name:                  fmt.Sprintf("%d@%s@A100", i, host),
+				Vendor:                report.VendorNVIDIA,
+				TemperatureC:          40 + i,
+				PowerWatt:             100,
+				MemoryUsedMiB:         1024,
+				MemoryTotalMiB:        81920,
+				UtilizationGpuPercent: 10 * (i % 10),
+				UpdatedAt:             now.Format(time.RFC3339),
+			}
+			if i < 2 {
+				g.ProcessCount, g.ProcessNames = 1, "python"
+			}
+			gpus = append(gpus, g)
+		}
+	}
+	hosts := []report.HostReport{
+		{Hostname: "node-b", CPUUsagePercent: 5, MemoryUsedMB: 1000, MemoryTotalMB: 64000, DiskUsed: "10G", DiskTotal: "1T", UpdatedAt: now.Format(time.RFC3339)},
+		{Hostname: "node-a", CPUUsagePercent: 37.5, MemoryUsedMB: 32000, MemoryTotalMB: 64000, DiskUsed: "200G", DiskTotal: "1T", UpdatedAt: now.Format(time.RFC3339),
+			Labels: map[string]string{"cluster": "training"}},
+	}
+	hardware := []report.HardwareReport{
+		{Hostname: "node-b", Kernel: "6.8.0-45-generic", Uptime: "up 3 days"},
+		{Hostname: "node-a", Kernel: "6.8.0-45-generic", Uptime: "up 2 hours", USB: "Bus 001 Device 002: ID 8087:0024 Intel Corp. Hub"},
+	}
+	var samples []report.GPUSample
+	var hostSamples []report.HostSample
+	for i := 0; i < 48; i++ {
+		at := now.Add(time.Duration(i-48) * 30 * time.Minute).Format(time.RFC3339)
+		samples = append(samples, report.GPUSample{Time: at, TemperatureC: 40 + i%10, UtilizationGpuPercent: i * 2})
+		hostSamples = append(hostSamples, report.HostSample{Time: at, CPUUsagePercent: float64(i)})
+	}
+	hot := alerts.Alert{Rule: "gpu_temperature", Severity: alerts.Critical, Host: "node-a", GPU: "1@node-a@A100", Message: "GPU 1 on node-a is at 91°C"}
+
+	return map[string]interface{}{
+		"GET /gpu/list":      gpus,
+		"GET /host/list":     hosts,
+		"GET /hardware/list": hardware,
+		"GET /healthcheck": apiStatus{http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "unhealthy", "issues": []string{hot.Message}, "alerts": []alerts.Alert{hot},
+		}},
+		"GET /group/summary": []report.GroupSummary{
+			{Label: "cluster", Value: "training", Hosts: 1, GPUs: 12, BusyGPUs: 2, AvgUtilization: 45, MemoryUsedMiB: 12288, MemoryTotalMiB: 983040, PowerWatt: 1200, Alerts: 1},
+			{Label: "cluster", Value: "", Hosts: 1, GPUs: 8, BusyGPUs: 2, AvgUtilization: 30, MemoryUsedMiB: 8192, MemoryTotalMiB: 655360, PowerWatt: 800},
+		},
+		"GET /gpu/history": []report.GPUHistory{
+			{Name: "0@node-a@A100", Samples: samples},
+			{Name: "1@node-a@A100", Samples: samples},
+		},
+		"GET /host/history": []report.HostHistory{{Hostname: "node-a", Samples: hostSamples}},
+
+		"POST /admin/subscriptions":        alerts.Subscription{ID: 7, ChatID: 2, Selector: "cluster=training", MinSeverity: alerts.Critical},
+		"POST /admin/subscriptions/delete": map[string]int{"deleted": 1},
+		"GET /admin/subscriptions":         []alerts.Subscription{{ID: 7, ChatID: 2, Selector: "cluster=training", MinSeverity: alerts.Critical}, {ID: 8, ChatID: 2, MinSeverity: alerts.Warning}},
+		"POST /admin/mutes":                alerts.Mute{ID: 5, ChatID: 2, Kind: alerts.MuteGPU, Value: "node-a:1", Until: now.Add(2 * time.Hour).Format(time.RFC3339), CreatedBy: "@bob"},
+		"POST /admin/mutes/delete":         map[string]int{"deleted": 1},
+		"GET /admin/mutes":                 []alerts.Mute{{ID: 5, ChatID: 2, Kind: alerts.MuteGPU, Value: "node-a:1", Until: now.Add(2 * time.Hour).Format(time.RFC3339), CreatedBy: "@bob"}},
+		"POST /admin/pairing-codes/redeem": apiStatus{http.StatusNotFound, "no such pairing code"},
+	}
+}
+
+// texts joins what was sent, for matching replies that span messages.
+func texts(sent []telegramtest.Sent) string {
+	var s []string
+	for _, m := range sent {
+		s = append(s, m.Text)
+	}
+	return strings.Join(s, "\n---\n")
+}
+
+func TestCommands(t *testing.T) {
+	b := newTestBot(t)
+
+	tests := []struct {
+		name    string
+		from    tgbotapi.User
+		chat    *tgbotapi.Chat // the sender's private chat if nil
+		text    string
+		want    []string // in the text of what was sent
+		buttons [][]string
+	}{
+		{name: "start", from: viewer, text: "/start",
+			want:    []string{"/gpus [HOST|selector]", "/whoami"},
+			buttons: [][]string{{"gpus", "hosts"}, {"hardware", "health"}}},
+		{name: "whoami", from: operator, text: "/whoami", want: []string{"Your ID is 2, role: operator"}},
+		{name: "gpus", from: viewer, text: "/gpus",
+			want:    []string{"*GPUs* on all hosts: 20 GPUs, 4 busy", "node-a       11"},
+			buttons: [][]string{{"gpus:0:node-a", "gpus:0:node-b"}, {"gpus:1:", "noop", "gpus:1:"}}},
+		{name: "gpus of a host", from: viewer, text: "/gpus node-b",
+			want: []string{"*GPUs* on `node-b`: 8 GPUs, 2 busy"},
+			buttons: [][]string{
+				{"gpu:node-b:0", "gpu:node-b:1", "gpu:node-b:2", "gpu:node-b:3"},
+				{"gpu:node-b:4", "gpu:node-b:5", "gpu:node-b:6", "gpu:node-b:7"},
+				{"gpus:0:"},
+			}},
+		{name: "gpu usage", from: viewer, text: "/gpu node-a", want: []string{"Usage: /gpu HOST INDEX"}},
+		{name: "gpu", from: viewer, text: "/gpu node-a 1",
+			want:    []string{"*1@node-a@A100*", "Temperature: 41°C", "Processes: 1 👾\n  ↳ python"},
+			buttons: [][]string{{"chart:node-a:1", "host:node-a"}}},
+		{name: "no such gpu", from: viewer, text: "/gpu node-a 99", want: []string{"No GPU 99 on node-a"}},
+		{name: "hosts", from: viewer, text: "/hosts",
+			want:    []string{"*Hosts*: 2", "node-a          38%  50%"},
+			buttons: [][]string{{"host:node-a", "host:node-b"}}},
+		{name: "host usage", from: viewer, text: "/host", want: []string{"Usage: /host NAME"}},
+		{name: "host", from: viewer, text: "/host node-a",
+			want:    []string{"🌟 node-a", "Labels: cluster=training", "CPU Usage: 37.5%", "💎 GPU 11:", "⚠️ GPU 1 on node-a is at 91°C"},
+			buttons: [][]string{{"gpus:0:node-a", "hwd:node-a", "hchart:node-a"}}},
+		{name: "healthcheck", from: viewer, text: "/healthcheck",
+			want: []string{"Checking system health", "System health is not OK ❌💔\nIssues:\n- [GPU 1 on node-a is at 91°C]"}},
+		{name: "groups usage", from: viewer, text: "/groups", want: []string{"Tell me which label to group by"}},
+		{name: "groups", from: viewer, text: "/groups cluster",
+			want: []string{"*Grouped by cluster*", "*training*\nHosts: 1 📡 GPUs: 12 (2 busy)", "Alerts: 1 ❌", "*(unlabeled)*"}},
+		{name: "hardware needs operator", from: viewer, text: "/hardware", want: []string{"needs the operator role, you have viewer"}},
+		{name: "hardware", from: operator, text: "/hardware",
+			want:    []string{"*Hardware* reports from 2 hosts"},
+			buttons: [][]string{{"hwd:node-a", "hwd:node-b"}}},
+		{name: "hardware of a host", from: operator, text: "/hardware node-a",
+			want:    []string{"🔧 node-a", "kernel 6.8.0-45-generic", "Uptime: 2 hours"},
+			buttons: [][]string{{"hwfull:node-a"}}},
+		{name: "chart usage", from: viewer, text: "/chart node-a", want: []string{"Usage: /chart"}},
+		{name: "chart of a host metric for a gpu", from: viewer, text: "/chart node-a:1 cpu", want: []string{"cpu is a host metric, use /chart node-a cpu"}},
+		{name: "chart bad range", from: viewer, text: "/chart node-a cpu 10s", want: []string{"That range doesn't look right"}},
+		{name: "subscribe", from: operator, text: "/subscribe critical cluster=training",
+			want: []string{"Subscribed (#7): critical alerts and up on hosts matching cluster=training."}},
+		{name: "unsubscribe usage", from: operator, text: "/unsubscribe seven", want: []string{"Usage: /unsubscribe [ID|all]"}},
+		{name: "unsubscribe", from: operator, text: "/unsubscribe #7", want: []string{"Removed 1 subscription(s)."}},
+		{name: "subscriptions", from: viewer, text: "/subscriptions",
+			want: []string{"#7 critical and up on cluster=training", "#8 warning and up on all hosts", "🔇 Muted:\n#5 gpu node-a:1 for 2h0m0s more (by @bob)"}},
+		{name: "mute needs operator", from: viewer, text: "/mute node-a 2h", want: []string{"needs the operator role, you have viewer"}},
+		{name: "mute usage", from: operator, text: "/mute node-a forever", want: []string{"Usage: /mute [host|gpu|rule] NAME DURATION"}},
+		{name: "mute", from: operator, text: "/mute node-a:1 2h", want: []string{"🔇 Muted gpu node-a:1 for 2h0m0s (#5). /unmute 5 to undo."}},
+		{name: "unmute needs operator", from: viewer, text: "/unmute 5", want: []string{"needs the operator role, you have viewer"}},
+		{name: "unmute", from: operator, text: "/unmute 5", want: []string{"🔊 Removed 1 mute(s)."}},
+		{name: "no access", from: stranger, text: "/gpus", want: []string{"You are not authorized to use this bot", "/grant 4 viewer"}},
+		{name: "group access", from: stranger, chat: &teamChat, text: "/hosts", want: []string{"*Hosts*: 2"}},
+		{name: "group roles stop at viewer", from: stranger, chat: &teamChat, text: "/hardware", want: []string{"needs the operator role, you have viewer"}},
+		{name: "pair usage", from: stranger, text: "/pair", want: []string{"Usage: /pair CODE"}},
+		{name: "unknown", from: viewer, text: "/frobnicate", want: []string{"I don't understand that command"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := private(tt.from)
+			if tt.chat != nil {
+				chat = *tt.chat
+			}
+			sent := b.command(t, tt.from, chat, tt.text)
+			if len(sent) == 0 {
+				t.Fatal("nothing sent")
+			}
+			for _, m := range sent {
+				if m.ChatID != chat.ID {
+					t.Errorf("sent to chat %d, want %d", m.ChatID, chat.ID)
+				}
+			}
+			got := texts(sent)
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("reply %q does not contain %q", got, w)
+				}
+			}
+			if tt.buttons != nil {
+				if b := sent[len(sent)-1].Buttons(); !reflect.DeepEqual(b, tt.buttons) {
+					t.Errorf("buttons %q, want %q", b, tt.buttons)
+				}
+			}
+		})
+	}
+
+	// What the commands asked the server for.
+	if call := b.api.last(t, "POST /admin/subscriptions"); call.Body["selector"] != "cluster=training" || call.Body["severity"] != "critical" || call.Body["chat_id"] != 2.0 {
+		t.Errorf("subscribe sent %v", call.Body)
+	}
+	if call := b.api.last(t, "POST /admin/subscriptions/delete"); call.Body["id"] != 7.0 {
+		t.Errorf("unsubscribe sent %v", call.Body)
+	}
+	if call := b.api.last(t, "POST /admin/mutes"); call.Body["kind"] != alerts.MuteGPU || call.Body["value"] != "node-a:1" || call.Body["created_by"] != "@bob" || call.Body["for"] != "2h0m0s" {
+		t.Errorf("mute sent %v", call.Body)
+	}
+	if call := b.api.last(t, "GET /group/summary"); call.Query.Get("by") != "cluster" {
+		t.Errorf("groups asked for %v", call.Query)
+	}
+}
+
+func TestSelectors(t *testing.T) {
+	b := newTestBot(t)
+	for _, tt := range []struct{ text, route string }{
+		{"/gpus cluster=training", "GET /gpu/list"},
+		{"/hosts cluster=training", "GET /host/list"},
+		{"/healthcheck cluster=training", "GET /healthcheck"},
+	} {
+		b.command(t, viewer, private(viewer), tt.text)
+		if got := b.api.last(t, tt.route).Query.Get("selector"); got != "cluster=training" {
+			t.Errorf("%s: selector %q", tt.text, got)
+		}
+	}
+
+	b.api.reply("GET /host/list", apiStatus{http.StatusBadRequest, "invalid selector"})
+	sent := b.command(t, viewer, private(viewer), "/hosts cluster=")
+	if got := texts(sent); !strings.Contains(got, "couldn't fetch the host data") {
+		t.Errorf("bad selector: %q", got)
+	}
+}
+
+func TestChart(t *testing.T) {
+	b := newTestBot(t)
+	for _, tt := range []struct{ text, caption string }{
+		{"/chart node-a:1 temp 6h", "node-a:1 temperature, last 6h"},
+		{"/chart node-a power", "node-a power, last 1d"},
+		{"/chart node-a mem 7d", "node-a memory used, last 7d"},
+	} {
+		sent := b.command(t, viewer, private(viewer), tt.text)
+		if len(sent) != 1 || sent[0].Method != "sendPhoto" {
+			t.Fatalf("%s: sent %+v, want one photo", tt.text, sent)
+		}
+		if sent[0].Text != tt.caption {
+			t.Errorf("%s: caption %q, want %q", tt.text, sent[0].Text, tt.caption)
+		}
+		if !bytes.HasPrefix(sent[0].File, []byte("\x89PNG")) {
+			t.Errorf("%s: the upload is not a PNG", tt.text)
+		}
+	}
+	if q := b.api.last(t, "GET /host/history").Query; q.Get("hostname") != "node-a" || q.Get("since") != "168h0m0s" {
+		t.Errorf("host history asked for %v", q)
+	}
+
+	b.api.reply("GET /gpu/history", []report.GPUHistory{})
+	sent := b.command(t, viewer, private(viewer), "/chart node-c:0 temp")
+	if got := texts(sent); !strings.Contains(got, "No history for node-c:0") {
+		t.Errorf("chart without history: %q", got)
+	}
+}
+
+func TestPair(t *testing.T) {
+	b := newTestBot(t)
+
+	sent := b.command(t, stranger, private(stranger), "/pair WRONG-CODE")
+	if got := texts(sent); !strings.Contains(got, "That code is invalid") {
+		t.Fatalf("wrong code: %q", got)
+	}
+	sent = b.command(t, stranger, private(stranger), "/pair ABCD-EFGH")
+	if got := texts(sent); !strings.Contains(got, "Please wait") {
+		t.Fatalf("retry right away: %q", got)
+	}
+
+	lastFailedPair = map[int64]time.Time{}
+	b.api.reply("POST /admin/pairing-codes/redeem", report.PairingCode{
+		Role:          "viewer",
+		Subscriptions: []report.PairingSubscription{{Selector: "cluster=training", Severity: "critical"}},
+	})
+	sent = b.command(t, stranger, private(stranger), "/pair ABCD-EFGH")
+	want := "✅ Paired! You now have the viewer role.\n🔔 Subscribed to critical alerts and up on hosts matching cluster=training."
+	if got := texts(sent); !strings.Contains(got, want) {
+		t.Errorf("pairing: %q, want %q", got, want)
+	}
+	if call := b.api.last(t, "POST /admin/pairing-codes/redeem"); call.Body["code"] != "ABCD-EFGH" || call.Body["user_id"] != 4.0 {
+		t.Errorf("redeem sent %v", call.Body)
+	}
+	if sent := b.command(t, stranger, private(stranger), "/hosts"); !strings.Contains(texts(sent), "*Hosts*: 2") {
+		t.Errorf("after pairing: %q", texts(sent))
+	}
+}
+
+func TestButtons(t *testing.T) {
+	b := newTestBot(t)
+
+	start := b.command(t, viewer, private(viewer), "/start")[0]
+	for _, tt := range []struct{ data, answer, want string }{
+		{"gpus", "Fetching GPU information", "*GPUs* on all hosts"},
+		{"hosts", "Fetching Host information", "*Hosts*: 2"},
+		{"health", "Checking system health", "System health is not OK"},
+	} {
+		sent, answer := b.press(t, viewer, start, tt.data)
+		if !strings.Contains(answer.Text, tt.answer) {
+			t.Errorf("%s: answered %q", tt.data, answer.Text)
+		}
+		if got := texts(sent); sent[0].Method != "sendMessage" || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: sent %q", tt.data, got)
+		}
+	}
+	if _, answer := b.press(t, viewer, start, "hardware"); answer.Text != "You are not allowed to do that 💔" {
+		t.Errorf("hardware as viewer: answered %q", answer.Text)
+	}
+
+	// Paging edits the message in place.
+	list := b.command(t, viewer, private(viewer), "/gpus")[0]
+	sent, _ := b.press(t, viewer, list, "gpus:1:")
+	if len(sent) != 1 || sent[0].Method != "editMessageText" || sent[0].MessageID != list.MessageID {
+		t.Fatalf("next page: sent %+v, want an edit of message %d", sent, list.MessageID)
+	}
+	if !strings.Contains(sent[0].Text, "node-b        7") || strings.Contains(sent[0].Text, "node-a") {
+		t.Errorf("second page: %q", sent[0].Text)
+	}
+	if got, want := sent[0].Buttons(), [][]string{{"gpus:0:node-b"}, {"gpus:0:", "noop", "gpus:0:"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second page buttons %q, want %q", got, want)
+	}
+	if sent, answer := b.press(t, viewer, sent[0], "noop"); len(sent) != 0 || answer.Text != "" {
+		t.Errorf("page number: sent %+v, answered %q", sent, answer.Text)
+	}
+	sent, _ = b.press(t, viewer, list, "gpus:0:node-b")
+	if len(sent) != 1 || sent[0].MessageID != list.MessageID || !strings.Contains(sent[0].Text, "on `node-b`") {
+		t.Errorf("host picker: sent %+v", sent)
+	}
+
+	// Drill-downs.
+	gpu := b.command(t, viewer, private(viewer), "/gpu node-a 1")[0]
+	sent, answer := b.press(t, viewer, gpu, "chart:node-a:1")
+	if len(sent) != 1 || sent[0].Method != "sendPhoto" || sent[0].Text != "node-a GPU 1, last 24h" || !strings.Contains(answer.Text, "Drawing") {
+		t.Errorf("GPU chart: sent %+v, answered %q", sent, answer.Text)
+	}
+	if sent, _ := b.press(t, viewer, gpu, "host:node-a"); !strings.Contains(texts(sent), "🌟 node-a") {
+		t.Errorf("host button: %q", texts(sent))
+	}
+	if sent, _ := b.press(t, viewer, list, "gpu:node-b:3"); !strings.Contains(texts(sent), "*3@node-b@A100*") {
+		t.Errorf("GPU button: %q", texts(sent))
+	}
+
+	host := b.command(t, viewer, private(viewer), "/host node-a")[0]
+	if sent, _ := b.press(t, viewer, host, "hchart:node-a"); len(sent) != 1 || sent[0].Text != "node-a CPU usage, last 1d" {
+		t.Errorf("host chart: sent %+v", sent)
+	}
+	if sent, answer := b.press(t, viewer, host, "hwd:node-a"); len(sent) != 0 || answer.Text != "You are not allowed to do that 💔" {
+		t.Errorf("hardware as viewer: sent %+v, answered %q", sent, answer.Text)
+	}
+	sent, _ = b.press(t, operator, host, "hwd:node-a")
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, "🔧 node-a") {
+		t.Fatalf("hardware button: sent %+v", sent)
+	}
+	if sent, _ := b.press(t, operator, sent[0], "hwfull:node-a"); !strings.Contains(texts(sent), "full hardware detail") || !strings.Contains(texts(sent), "🖱️ USB\nIntel Corp. Hub") {
+		t.Errorf("full detail: %q", texts(sent))
+	}
+	hw := b.command(t, operator, private(operator), "/hardware")[0]
+	if sent, _ := b.press(t, operator, hw, "hw:0:"); len(sent) != 1 || sent[0].Method != "editMessageText" || sent[0].MessageID != hw.MessageID {
+		t.Errorf("hardware page: sent %+v", sent)
+	}
+
+	if _, answer := b.press(t, viewer, list, "bogus"); !strings.Contains(answer.Text, "Unknown command") {
+		t.Errorf("unknown button: answered %q", answer.Text)
+	}
+}
+
+func TestAlertButtons(t *testing.T) {
+	b := newTestBot(t)
+
+	// An alert as deliverAlerts sends it.
+	ev := alerts.Event{
+		ID:        12,
+		Alert:     alerts.Alert{Rule: "gpu_temperature", Severity: alerts.Critical, Host: "node-a", Message: "GPU 1 on node-a is at 91°C"},
+		StartedAt: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+	}
+	msg := tgbotapi.NewMessage(teamChat.ID, formatAlert(ev, alerts.Firing))
+	msg.ReplyMarkup = alertButtons(ev.ID)
+	if _, err := b.bot.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	alert := b.srv.Sent()[0]
+	if want := [][]string{{"ack:12", "snooze:12:1h", "snooze:12:4h", "snooze:12:1d"}}; !reflect.DeepEqual(alert.Buttons(), want) {
+		t.Fatalf("alert buttons %q, want %q", alert.Buttons(), want)
+	}
+	if !strings.Contains(alert.Text, "🔥 CRITICAL: GPU 1 on node-a is at 91°C\nRule: gpu_temperature · Host: node-a · #12") {
+		t.Errorf("alert text %q", alert.Text)
+	}
+
+	// The group has the viewer role, which may not touch alerts.
+	if sent, answer := b.press(t, stranger, alert, "ack:12"); len(sent) != 0 || answer.Text != "You are not allowed to do that 💔" {
+		t.Errorf("ack as viewer: sent %+v, answered %q", sent, answer.Text)
+	}
+
+	b.api.reply("POST /admin/alerts/snooze", ev)
+	sent, answer := b.press(t, operator, alert, "snooze:12:4h")
+	if answer.Text != "😴 Snoozed for 4h by @bob" {
+		t.Errorf("snooze answered %q", answer.Text)
+	}
+	if len(sent) != 1 || sent[0].Method != "editMessageText" || sent[0].MessageID != alert.MessageID || !strings.HasSuffix(sent[0].Text, "\n😴 Snoozed for 4h by @bob") {
+		t.Fatalf("snooze: sent %+v", sent)
+	}
+	if !reflect.DeepEqual(sent[0].Buttons(), alert.Buttons()) {
+		t.Errorf("snoozing dropped the buttons: %q", sent[0].Buttons())
+	}
+	if call := b.api.last(t, "POST /admin/alerts/snooze"); call.Body["id"] != 12.0 || call.Body["for"] != "4h0m0s" || call.Body["by"] != "@bob" {
+		t.Errorf("snooze sent %v", call.Body)
+	}
+
+	acked := ev
+	acked.AckedBy = "@bob"
+	b.api.reply("POST /admin/alerts/ack", acked)
+	sent, answer = b.press(t, operator, alert, "ack:12")
+	if answer.Text != "✅ Acknowledged by @bob" {
+		t.Errorf("ack answered %q", answer.Text)
+	}
+	if len(sent) != 1 || !strings.HasSuffix(sent[0].Text, "\n✅ Acknowledged by @bob") || sent[0].Markup != nil {
+		t.Errorf("ack: sent %+v, want the edit without buttons", sent)
+	}
+
+	b.api.reply("POST /admin/alerts/ack", apiStatus{http.StatusNotFound, "no such alert"})
+	if sent, answer := b.press(t, operator, alert, "ack:12"); len(sent) != 0 || !strings.Contains(answer.Text, "Couldn't update the alert") {
+		t.Errorf("ack of a resolved alert: sent %+v, answered %q", sent, answer.Text)
+	}
+	if _, answer := b.press(t, operator, alert, "snooze:12:soon"); !strings.Contains(answer.Text, "Couldn't update the alert") {
+		t.Errorf("snooze without a duration: answered %q", answer.Text)
+	}
+}
@@ -0,0 +1,373 @@
+// Package botauth is the access control shared by the Telegram bots. Users
+// and group chats are granted a role that is kept in the bot's database;
+// a message is allowed when the sender or the chat it was sent in holds a
+// role at least as high as the command needs. Admins listed in the bot's
+// configuration always have the admin role and cannot be revoked from
+// the chat.
+package botauth
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Role int
+
+const (
+	None Role = iota
+	Viewer
+	Operator
+	Admin
+)
+
+var roleNames = map[Role]string{
+	None:     "none",
+	Viewer:   "viewer",
+	Operator: "operator",
+	Admin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "role(" + strconv.Itoa(int(r)) + ")"
+}
+
+func ParseRole(s string) (Role, error) {
+	for r, name := range roleNames {
+		if r != None && strings.EqualFold(s, name) {
+			return r, nil
+		}
+	}
+	return None, fmt.Errorf("unknown role %q, want viewer, operator or admin", s)
+}
+
+// ParseIDs parses a comma- or space-separated list of Telegram user or
+// chat IDs, as found in the TELEGRAM_ADMINS environment variable.
+func ParseIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Telegram ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Caller identifies who sent a command and where. In a private chat
+// ChatID equals UserID.
+type Caller struct {
+	UserID    int64
+	UserName  string
+	ChatID    int64
+	ChatTitle string
+}
+
+func (c Caller) inGroup() bool {
+	return c.ChatID != c.UserID
+}
+
+type Grant struct {
+	ID        int64
+	Name      string
+	Role      Role
+	GrantedBy int64
+	GrantedAt time.Time
+
+	// Configured is set for the admins from the bot's configuration.
+	Configured bool
+}
+
+// Group reports whether the grant is for a group chat. Telegram gives
+// groups negative IDs.
+func (g Grant) Group() bool {
+	return g.ID < 0
+}
+
+type Store struct {
+	db     *sql.DB
+	admins map[int64]bool
+}
+
+// Open creates the grants table if needed. The admins are the bot's
+// configured administrators.
+func Open(db *sql.DB, admins []int64) (*Store, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bot_grants (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		granted_by INTEGER,
+		granted_at DATETIME
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, admins: map[int64]bool{}}
+	for _, id := range admins {
+		s.admins[id] = true
+	}
+	return s, nil
+}
+
+// Empty reports whether nobody can use the bot yet, which is worth a
+// warning at startup.
+func (s *Store) Empty() bool {
+	var n int
+	s.db.QueryRow("SELECT COUNT(*) FROM bot_grants").Scan(&n)
+	return n == 0 && len(s.admins) == 0
+}
+
+func (s *Store) granted(id int64) Role {
+	if s.admins[id] {
+		return Admin
+	}
+	var name string
+	if err := s.db.QueryRow("SELECT role FROM bot_grants WHERE id = ?", id).Scan(&name); err != nil {
+		return None
+	}
+	r, _ := ParseRole(name)
+	return r
+}
+
+// Role returns the caller's effective role: the higher of their own and
+// that of the group chat they are writing in.
+func (s *Store) Role(c Caller) Role {
+	r := s.granted(c.UserID)
+	if c.inGroup() {
+		if g := s.granted(c.ChatID); g > r {
+			r = g
+		}
+	}
+	return r
+}
+
+// Check returns an error suitable for sending back to the caller when
+// they may not run a command that needs the given role.
+func (s *Store) Check(c Caller, need Role) error {
+	r := s.Role(c)
+	if r >= need {
+		return nil
+	}
+	if r == None {
+		return fmt.Errorf("❌ You are not authorized to use this bot. Ask an admin for a pairing code and send /pair CODE, or for /grant %d viewer", c.UserID)
+	}
+	return fmt.Errorf("❌ This command needs the %s role, you have %s.", need, r)
+}
+
+func (s *Store) Grant(id int64, name string, r Role, by int64) error {
+	_, err := s.db.Exec(`INSERT INTO bot_grants (id, name, role, granted_by, granted_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name=CASE WHEN excluded.name != '' THEN excluded.name ELSE bot_grants.name END,
+			role=excluded.role,
+			granted_by=excluded.granted_by,
+			granted_at=excluded.granted_at`,
+		id, name, r.String(), by, time.Now().UTC())
+	return err
+}
+
+// GrantHere gives the role to where the caller is writing from: the group
+// when in a group, otherwise the user. It never lowers an existing role,
+// and returns the ID the role now applies to.
+func (s *Store) GrantHere(c Caller, r Role) (int64, error) {
+	id, name := c.UserID, c.UserName
+	if c.inGroup() {
+		id, name = c.ChatID, c.ChatTitle
+	}
+	if s.granted(id) >= r {
+		return id, nil
+	}
+	return id, s.Grant(id, name, r, c.UserID)
+}
+
+// Revoke removes a grant and reports whether there was one.
+func (s *Store) Revoke(id int64) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM bot_grants WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Grants lists the stored grants followed by the configured admins.
+func (s *Store) Grants() ([]Grant, error) {
+	rows, err := s.db.Query("SELECT id, name, role, granted_by, granted_at FROM bot_grants ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var g Grant
+		var role string
+		var by sql.NullInt64
+		var at sql.NullTime
+		if err := rows.Scan(&g.ID, &g.Name, &role, &by, &at); err != nil {
+			return nil, err
+		}
+		g.Role, _ = ParseRole(role)
+		g.GrantedBy = by.Int64
+		g.GrantedAt = at.Time
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var admins []int64
+	for id := range s.admins {
+		admins = append(admins, id)
+	}
+	sort.Slice(admins, func(i, j int) bool { return admins[i] < admins[j] })
+	for _, id := range admins {
+		grants = append(grants, Grant{ID: id, Role: Admin, Configured: true})
+	}
+	return grants, nil
+}
+
+// Help lists the access commands for the caller's role.
+func (s *Store) Help(c Caller) string {
+	if s.Role(c) < Admin {
+		return "/whoami – 🪪 Show your ID and role"
+	}
+	return `/whoami – 🪪 Show your ID and role
+/users – 👥 List who has access
+/grant ID|here ROLE – 🔑 Give a user or group viewer, operator or admin
+/revoke ID|here – 🚫 Remove access`
+}
+
+// Command handles the access management commands every bot understands:
+// /whoami, /users, /grant and /revoke. It reports false for any other
+// command so the bot can handle it.
+func (s *Store) Command(c Caller, command, args string) (string, bool) {
+	switch command {
+	case "whoami":
+		return s.whoami(c), true
+	case "users", "grant", "revoke":
+	default:
+		return "", false
+	}
+
+	if err := s.Check(c, Admin); err != nil {
+		return err.Error(), true
+	}
+	fields := strings.Fields(args)
+	switch command {
+	case "users":
+		return s.users(), true
+	case "grant":
+		if len(fields) != 2 {
+			return "⚠️ Usage: /grant ID|here viewer|operator|admin", true
+		}
+		return s.grant(c, fields[0], fields[1]), true
+	default:
+		if len(fields) != 1 {
+			return "⚠️ Usage: /revoke ID|here", true
+		}
+		return s.revoke(c, fields[0]), true
+	}
+}
+
+func (s *Store) whoami(c Caller) string {
+	msg := fmt.Sprintf("🪪 Your ID is %d, role: %s", c.UserID, s.granted(c.UserID))
+	if c.inGroup() {
+		msg += fmt.Sprintf("\nThis chat's ID is %d, role: %s", c.ChatID, s.granted(c.ChatID))
+	}
+	return msg
+}
+
+// target resolves the ID argument of /grant and /revoke, where "here"
+// means the current chat.
+func target(c Caller, arg string) (int64, string, error) {
+	if arg == "here" {
+		if c.inGroup() {
+			return c.ChatID, c.ChatTitle, nil
+		}
+		return c.UserID, c.UserName, nil
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("❌ %q is not a Telegram ID. Users can find theirs with /whoami", arg)
+	}
+	return id, "", nil
+}
+
+func (s *Store) grant(c Caller, arg, roleName string) string {
+	id, name, err := target(c, arg)
+	if err != nil {
+		return err.Error()
+	}
+	r, err := ParseRole(roleName)
+	if err != nil {
+		return "❌ " + err.Error()
+	}
+	if s.admins[id] {
+		return fmt.Sprintf("ℹ️ %d is a configured admin and always has full access.", id)
+	}
+	if id == c.UserID && r < Admin {
+		return "❌ You cannot lower your own role, ask another admin."
+	}
+	if err := s.Grant(id, name, r, c.UserID); err != nil {
+		return "❌ Failed to save the grant."
+	}
+	return fmt.Sprintf("✅ %d now has the %s role.", id, r)
+}
+
+func (s *Store) revoke(c Caller, arg string) string {
+	id, _, err := target(c, arg)
+	if err != nil {
+		return err.Error()
+	}
+	if s.admins[id] {
+		return fmt.Sprintf("❌ %d is a configured admin, remove it from TELEGRAM_ADMINS instead.", id)
+	}
+	if id == c.UserID {
+		return "❌ You cannot revoke your own access, ask another admin."
+	}
+	ok, err := s.Revoke(id)
+	switch {
+	case err != nil:
+		return "❌ Failed to revoke access."
+	case !ok:
+		return fmt.Sprintf("ℹ️ %d had no access.", id)
+	}
+	return fmt.Sprintf("🚫 Access for %d revoked.", id)
+}
+
+func (s *Store) users() string {
+	grants, err := s.Grants()
+	if err != nil {
+		return "❌ Could not list users."
+	}
+	if len(grants) == 0 {
+		return "👥 Nobody has access yet."
+	}
+	var b strings.Builder
+	b.WriteString("👥 Access:\n")
+	for _, g := range grants {
+		kind := "user"
+		if g.Group() {
+			kind = "group"
+		}
+		fmt.Fprintf(&b, "%s %d", kind, g.ID)
+		if g.Name != "" {
+			fmt.Fprintf(&b, " (%s)", g.Name)
+		}
+		fmt.Fprintf(&b, ": %s", g.Role)
+		if g.Configured {
+			b.WriteString(", from TELEGRAM_ADMINS")
+		} else if g.GrantedBy != 0 {
+			fmt.Fprintf(&b, ", granted by %d on %s", g.GrantedBy, g.GrantedAt.Format("2006-01-02"))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,213 @@
+package botauth
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	configured = 100 // from TELEGRAM_ADMINS
+	alice      = 200 // granted admin
+	bob        = 300
+	carol      = 400
+	team       = -500 // a group chat
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own empty in-memory database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	s, err := Open(db, []int64{configured})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Grant(alice, "alice", Admin, configured); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func private(id int64) Caller {
+	return Caller{UserID: id, ChatID: id}
+}
+
+func inTeam(id int64) Caller {
+	return Caller{UserID: id, ChatID: team, ChatTitle: "GPU team"}
+}
+
+// command runs an access command and fails unless the store handled it.
+func command(t *testing.T, s *Store, c Caller, line string) string {
+	t.Helper()
+	name, args, _ := strings.Cut(line, " ")
+	reply, ok := s.Command(c, name, args)
+	if !ok {
+		t.Fatalf("/%s was not handled", line)
+	}
+	return reply
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller Caller
+		lines  []string
+		reply  string // of the last command
+		roles  map[Caller]Role
+	}{
+		{"grant a user", private(alice), []string{"grant 300 operator"}, "✅ 300 now has the operator role.",
+			map[Caller]Role{private(bob): Operator}},
+		{"roles are case-insensitive", private(alice), []string{"grant 300 Viewer"}, "✅ 300 now has the viewer role.",
+			map[Caller]Role{private(bob): Viewer}},
+		{"raise own role", private(alice), []string{"grant 200 admin"}, "✅ 200 now has the admin role.",
+			map[Caller]Role{private(alice): Admin}},
+		{"lower own role", private(alice), []string{"grant 200 viewer"}, "❌ You cannot lower your own role, ask another admin.",
+			map[Caller]Role{private(alice): Admin}},
+		{"lower own role with here", private(alice), []string{"grant here operator"}, "❌ You cannot lower your own role, ask another admin.",
+			map[Caller]Role{private(alice): Admin}},
+		{"revoke self", private(alice), []string{"revoke 200"}, "❌ You cannot revoke your own access, ask another admin.",
+			map[Caller]Role{private(alice): Admin}},
+		{"grant a configured admin", private(alice), []string{"grant 100 viewer"}, "ℹ️ 100 is a configured admin and always has full access.",
+			map[Caller]Role{private(configured): Admin}},
+		{"revoke a configured admin", private(alice), []string{"revoke 100"}, "❌ 100 is a configured admin, remove it from TELEGRAM_ADMINS instead.",
+			map[Caller]Role{private(configured): Admin}},
+		{"configured admins revoke others", private(configured), []string{"revoke 200"}, "🚫 Access for 200 revoked.",
+			map[Caller]Role{private(alice): None}},
+		{"revoke nobody", private(alice), []string{"revoke 300"}, "ℹ️ 300 had no access.", nil},
+		// In a group, "here" is the group rather than the admin.
+		{"grant here in a group", inTeam(alice), []string{"grant here viewer"}, "✅ -500 now has the viewer role.",
+			map[Caller]Role{inTeam(alice): Admin, inTeam(bob): Viewer, private(bob): None}},
+		{"revoke here in a group", inTeam(alice), []string{"grant here viewer", "revoke here"}, "🚫 Access for -500 revoked.",
+			map[Caller]Role{inTeam(bob): None, private(alice): Admin}},
+		// Every member has the group's role in the group, and the higher
+		// of it and their own.
+		{"group role", private(alice), []string{"grant -500 operator", "grant 300 admin", "grant 400 viewer"}, "✅ 400 now has the viewer role.",
+			map[Caller]Role{inTeam(bob): Admin, inTeam(carol): Operator, private(carol): Viewer, inTeam(12345): Operator, private(12345): None}},
+		{"not an ID", private(alice), []string{"grant @bob viewer"}, `❌ "@bob" is not a Telegram ID. Users can find theirs with /whoami`, nil},
+		{"unknown role", private(alice), []string{"grant 300 root"}, `❌ unknown role "root", want viewer, operator or admin`, nil},
+		{"usage", private(alice), []string{"grant 300"}, "⚠️ Usage: /grant ID|here viewer|operator|admin", nil},
+		{"strangers cannot grant", private(bob), []string{"grant 300 admin"}, "❌ You are not authorized to use this bot.",
+			map[Caller]Role{private(bob): None}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openStore(t)
+			var reply string
+			for _, line := range tt.lines {
+				reply = command(t, s, tt.caller, line)
+			}
+			if !strings.HasPrefix(reply, tt.reply) {
+				t.Errorf("reply %q, want %q", reply, tt.reply)
+			}
+			for c, want := range tt.roles {
+				if got := s.Role(c); got != want {
+					t.Errorf("%+v has role %s, want %s", c, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := openStore(t)
+	s.Grant(bob, "bob", Viewer, alice)
+	if err := s.Check(private(alice), Admin); err != nil {
+		t.Errorf("admin: %v", err)
+	}
+	if err := s.Check(private(bob), Viewer); err != nil {
+		t.Errorf("viewer: %v", err)
+	}
+	if err := s.Check(private(bob), Operator); err == nil || err.Error() != "❌ This command needs the operator role, you have viewer." {
+		t.Errorf("viewer running an operator command: %v", err)
+	}
+	if reply := command(t, s, private(bob), "grant 300 admin"); reply != "❌ This command needs the admin role, you have viewer." {
+		t.Errorf("viewer granting: %q", reply)
+	}
+	if err := s.Check(private(carol), Viewer); err == nil || !strings.Contains(err.Error(), "/grant 400 viewer") {
+		t.Errorf("stranger: %v", err)
+	}
+	// A member of a group with a role is not a stranger there.
+	s.Grant(team, "GPU team", Viewer, alice)
+	if err := s.Check(inTeam(carol), Viewer); err != nil {
+		t.Errorf("group member: %v", err)
+	}
+}
+
+func TestGrantHere(t *testing.T) {
+	s := openStore(t)
+	if id, err := s.GrantHere(inTeam(bob), Operator); err != nil || id != team {
+		t.Fatalf("GrantHere in a group = %d, %v", id, err)
+	}
+	if id, err := s.GrantHere(private(bob), Viewer); err != nil || id != bob {
+		t.Fatalf("GrantHere in private = %d, %v", id, err)
+	}
+	// It never lowers a role.
+	if _, err := s.GrantHere(inTeam(carol), Viewer); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Role(inTeam(carol)); got != Operator {
+		t.Errorf("group role %s after granting viewer, want operator", got)
+	}
+	if got := s.Role(private(bob)); got != Viewer {
+		t.Errorf("bob has %s in private, want viewer", got)
+	}
+
+	grants, err := s.Grants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, g := range grants {
+		got = append(got, g.Role.String())
+		if g.ID == team && (!g.Group() || g.Name != "GPU team" || g.GrantedBy != bob) {
+			t.Errorf("group grant %+v", g)
+		}
+	}
+	// By ID, then the configured admins.
+	if strings.Join(got, " ") != "operator admin viewer admin" {
+		t.Errorf("grants %v", got)
+	}
+	if !grants[len(grants)-1].Configured {
+		t.Errorf("last grant %+v, want the configured admin", grants[len(grants)-1])
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+	s, err := Open(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Empty() {
+		t.Error("a new store without admins is not empty")
+	}
+	s.Grant(bob, "", Viewer, 0)
+	if s.Empty() {
+		t.Error("a store with a grant is empty")
+	}
+	if s, _ := Open(db, []int64{configured}); s.Empty() {
+		t.Error("a store with configured admins is empty")
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	ids, err := ParseIDs("100, 200 -500,,")
+	if err != nil || len(ids) != 3 || ids[0] != 100 || ids[1] != 200 || ids[2] != -500 {
+		t.Errorf("ParseIDs = %v, %v", ids, err)
+	}
+	if _, err := ParseIDs("100,@alice"); err == nil || err.Error() != `invalid Telegram ID "@alice"` {
+		t.Errorf("ParseIDs of a username: %v", err)
+	}
+}
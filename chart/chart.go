@@ -0,0 +1,345 @@
+// Package chart renders time series as PNG line charts using only the
+// standard library, for sending metric history as images from the bot.
+package chart
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+type Chart struct {
+	Title string
+	// Unit is appended to the Y axis labels, e.g. "%" or "°C".
+	Unit   string
+	Series []Series
+
+	// Width and Height default to 960x480.
+	Width, Height int
+
+	// The Y axis always covers YMin..YMax when YMax > YMin, and grows to
+	// fit data outside it. Otherwise it fits the data.
+	YMin, YMax float64
+
+	// Location is the time zone of the X axis labels, UTC by default.
+	Location *time.Location
+}
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	axisColor  = color.RGBA{0x44, 0x44, 0x44, 0xff}
+	gridColor  = color.RGBA{0xe4, 0xe4, 0xe4, 0xff}
+	textColor  = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	palette    = []color.RGBA{
+		{0x1f, 0x77, 0xb4, 0xff},
+		{0xff, 0x7f, 0x0e, 0xff},
+		{0x2c, 0xa0, 0x2c, 0xff},
+		{0xd6, 0x27, 0x28, 0xff},
+		{0x94, 0x67, 0xbd, 0xff},
+		{0x8c, 0x56, 0x4b, 0xff},
+		{0xe3, 0x77, 0xc2, 0xff},
+		{0x17, 0xbe, 0xcf, 0xff},
+	}
+)
+
+const (
+	marginLeft   = 80
+	marginRight  = 24
+	marginTop    = 44
+	axisLabelGap = 8
+	legendRow    = 16
+)
+
+// WritePNG renders the chart and encodes it as PNG.
+func (c *Chart) WritePNG(w io.Writer) error {
+	return png.Encode(w, c.Render())
+}
+
+func (c *Chart) Render() *image.RGBA {
+	width, height := c.Width, c.Height
+	if width <= 0 || height <= 0 {
+		width, height = 960, 480
+	}
+	loc := c.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, 0, 0, width, height, background)
+	drawText(img, marginLeft, 14, c.Title, textColor, 2)
+
+	// The legend sits under the X axis labels and may wrap, which decides
+	// how tall the plot can be.
+	legend := c.layoutLegend(width)
+	bottom := 2*axisLabelGap + glyphHeight + 12 + len(legend)*legendRow
+	plot := image.Rect(marginLeft, marginTop, width-marginRight, height-bottom)
+
+	t0, t1, v0, v1, ok := c.bounds()
+	if !ok {
+		drawRectOutline(img, plot, axisColor)
+		msg := "NO DATA"
+		drawText(img, plot.Min.X+(plot.Dx()-textWidth(msg, 2))/2, plot.Min.Y+plot.Dy()/2-glyphHeight, msg, axisColor, 2)
+		return img
+	}
+	step := niceStep((v1 - v0) / 5)
+	v0 = math.Floor(v0/step) * step
+	v1 = math.Ceil(v1/step) * step
+	if v1 == v0 {
+		v1 = v0 + step
+	}
+
+	x := func(t time.Time) int {
+		return plot.Min.X + int(float64(plot.Dx())*float64(t.Sub(t0))/float64(t1.Sub(t0)))
+	}
+	y := func(v float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*(v-v0)/(v1-v0))
+	}
+
+	// Y grid and labels.
+	decimals := 0
+	if step < 1 {
+		decimals = int(math.Ceil(-math.Log10(step)))
+	}
+	for v := v0; v <= v1+step/2; v += step {
+		py := y(v)
+		hline(img, plot.Min.X, plot.Max.X, py, gridColor)
+		label := strconv.FormatFloat(v, 'f', decimals, 64) + c.Unit
+		drawText(img, plot.Min.X-axisLabelGap-textWidth(label, 1), py-glyphHeight/2, label, textColor, 1)
+	}
+
+	// X grid and labels.
+	tstep := timeStep(t1.Sub(t0))
+	layout := "15:04"
+	if tstep >= 24*time.Hour {
+		layout = "01-02"
+	} else if t1.Sub(t0) > 24*time.Hour {
+		layout = "01-02 15:04"
+	}
+	for _, t := range timeTicks(t0, t1, tstep, loc) {
+		px := x(t)
+		vline(img, px, plot.Min.Y, plot.Max.Y, gridColor)
+		label := t.In(loc).Format(layout)
+		drawText(img, px-textWidth(label, 1)/2, plot.Max.Y+axisLabelGap, label, textColor, 1)
+	}
+	zone := t0.In(loc).Format("MST")
+	drawText(img, plot.Max.X-textWidth(zone, 1), 14+glyphHeight, zone, axisColor, 1)
+
+	drawRectOutline(img, plot, axisColor)
+
+	for i, s := range c.Series {
+		col := palette[i%len(palette)]
+		gap := gapThreshold(s.Points)
+		for j, p := range s.Points {
+			if j == 0 || p.Time.Sub(s.Points[j-1].Time) > gap {
+				// Start of a run: draw a dot so isolated samples show.
+				fillRect(img, x(p.Time)-1, y(p.Value)-1, 3, 3, col)
+				continue
+			}
+			prev := s.Points[j-1]
+			line(img, x(prev.Time), y(prev.Value), x(p.Time), y(p.Value), col)
+		}
+	}
+
+	// Legend.
+	ly := plot.Max.Y + 2*axisLabelGap + glyphHeight + 4
+	for _, row := range legend {
+		for _, e := range row {
+			fillRect(img, e.x, ly, 10, glyphHeight, palette[e.index%len(palette)])
+			drawText(img, e.x+14, ly, c.Series[e.index].Name, textColor, 1)
+		}
+		ly += legendRow
+	}
+	return img
+}
+
+type legendEntry struct {
+	index, x int
+}
+
+func (c *Chart) layoutLegend(width int) [][]legendEntry {
+	if len(c.Series) < 2 {
+		return nil
+	}
+	var rows [][]legendEntry
+	var row []legendEntry
+	x := marginLeft
+	for i, s := range c.Series {
+		w := 14 + textWidth(s.Name, 1) + 20
+		if len(row) > 0 && x+w > width-marginRight {
+			rows = append(rows, row)
+			row, x = nil, marginLeft
+		}
+		row = append(row, legendEntry{index: i, x: x})
+		x += w
+	}
+	return append(rows, row)
+}
+
+// bounds returns the time and value ranges of the data, widened to YMin
+// and YMax. ok is false when there is nothing to draw.
+func (c *Chart) bounds() (t0, t1 time.Time, v0, v1 float64, ok bool) {
+	v0, v1 = math.Inf(1), math.Inf(-1)
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			if !ok || p.Time.Before(t0) {
+				t0 = p.Time
+			}
+			if !ok || p.Time.After(t1) {
+				t1 = p.Time
+			}
+			v0 = math.Min(v0, p.Value)
+			v1 = math.Max(v1, p.Value)
+			ok = true
+		}
+	}
+	if !ok {
+		return
+	}
+	if c.YMax > c.YMin {
+		v0 = math.Min(v0, c.YMin)
+		v1 = math.Max(v1, c.YMax)
+	}
+	if !t1.After(t0) {
+		t0, t1 = t0.Add(-time.Minute), t1.Add(time.Minute)
+	}
+	if v1 == v0 {
+		v0, v1 = v0-1, v1+1
+	}
+	return
+}
+
+// niceStep rounds a raw tick spacing up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+var timeSteps = []time.Duration{
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// timeStep picks the X tick spacing for about six ticks.
+func timeStep(span time.Duration) time.Duration {
+	for _, s := range timeSteps {
+		if span/s <= 7 {
+			return s
+		}
+	}
+	return timeSteps[len(timeSteps)-1]
+}
+
+// timeTicks returns the X axis ticks between t0 and t1, tstep apart.
+// Steps of a day or more fall on midnights in loc and advance by calendar
+// days, as days around a DST change are 23 or 25 hours long.
+func timeTicks(t0, t1 time.Time, tstep time.Duration, loc *time.Location) []time.Time {
+	var ticks []time.Time
+	if tstep >= 24*time.Hour {
+		days := int(tstep / (24 * time.Hour))
+		l := t0.In(loc)
+		for t := time.Date(l.Year(), l.Month(), l.Day(), 0, 0, 0, 0, loc); !t.After(t1); t = t.AddDate(0, 0, days) {
+			if !t.Before(t0) {
+				ticks = append(ticks, t)
+			}
+		}
+		return ticks
+	}
+	for t := t0.In(loc).Truncate(tstep); !t.After(t1); t = t.Add(tstep) {
+		if !t.Before(t0) {
+			ticks = append(ticks, t)
+		}
+	}
+	return ticks
+}
+
+// gapThreshold is how far apart two samples may be before the line is
+// broken, so periods without data are not drawn as a straight line.
+func gapThreshold(points []Point) time.Duration {
+	if len(points) < 3 {
+		return math.MaxInt64
+	}
+	gaps := make([]time.Duration, 0, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		gaps = append(gaps, points[i].Time.Sub(points[i-1].Time))
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[len(gaps)/2] * 3
+}
+
+func hline(img *image.RGBA, x0, x1, y int, c color.Color) {
+	for x := x0; x <= x1; x++ {
+		img.Set(x, y, c)
+	}
+}
+
+func vline(img *image.RGBA, x, y0, y1 int, c color.Color) {
+	for y := y0; y <= y1; y++ {
+		img.Set(x, y, c)
+	}
+}
+
+func drawRectOutline(img *image.RGBA, r image.Rectangle, c color.Color) {
+	hline(img, r.Min.X, r.Max.X, r.Min.Y, c)
+	hline(img, r.Min.X, r.Max.X, r.Max.Y, c)
+	vline(img, r.Min.X, r.Min.Y, r.Max.Y, c)
+	vline(img, r.Max.X, r.Min.Y, r.Max.Y, c)
+}
+
+// line draws a two pixel wide line with Bresenham's algorithm.
+func line(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		fillRect(img, x0, y0, 2, 2, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,169 @@
+package chart
+
+import (
+	"math"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// series returns hourly points from start over the given number of
+// hours.
+func series(start time.Time, hours int) []Point {
+	points := make([]Point, hours)
+	for i := range points {
+		points[i] = Point{Time: start.Add(time.Duration(i) * time.Hour), Value: float64(i % 50)}
+	}
+	return points
+}
+
+func TestTimeTicksDST(t *testing.T) {
+	tests := []struct {
+		name  string
+		loc   string
+		start time.Time
+	}{
+		// DST ends on 1 November 2026 in New York, so that day has 25
+		// hours, and starts on 29 March 2026 in Berlin, 23 hours.
+		{"fall back", "America/New_York", time.Date(2026, 10, 25, 15, 0, 0, 0, time.UTC)},
+		{"spring forward", "Europe/Berlin", time.Date(2026, 3, 25, 15, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := mustLoad(t, tt.loc)
+			// One and two day steps.
+			for _, days := range []int{6, 14} {
+				t0, t1 := tt.start, tt.start.AddDate(0, 0, days)
+				tstep := timeStep(t1.Sub(t0))
+				if tstep < 24*time.Hour {
+					t.Fatalf("a %d day span uses %s steps", days, tstep)
+				}
+				checkTicks(t, timeTicks(t0, t1, tstep, loc), t0, t1, loc)
+			}
+		})
+	}
+}
+
+func checkTicks(t *testing.T, ticks []time.Time, t0, t1 time.Time, loc *time.Location) {
+	t.Helper()
+	if len(ticks) < 5 || len(ticks) > 8 {
+		t.Fatalf("got %d ticks: %v", len(ticks), ticks)
+	}
+	for i, tick := range ticks {
+		l := tick.In(loc)
+		if l.Hour() != 0 || l.Minute() != 0 {
+			t.Errorf("tick %d at %s is not midnight in %s", i, l, loc)
+		}
+		if tick.Before(t0) || tick.After(t1) {
+			t.Errorf("tick %d at %s is outside %s..%s", i, tick, t0, t1)
+		}
+		if i > 0 && !tick.After(ticks[i-1]) {
+			t.Errorf("tick %d at %s does not advance from %s", i, tick, ticks[i-1])
+		}
+	}
+}
+
+func TestTimeTicksHours(t *testing.T) {
+	t0 := time.Date(2026, 5, 4, 10, 17, 0, 0, time.UTC)
+	ticks := timeTicks(t0, t0.Add(6*time.Hour), time.Hour, time.UTC)
+	if len(ticks) != 6 {
+		t.Fatalf("got %d ticks: %v", len(ticks), ticks)
+	}
+	if want := time.Date(2026, 5, 4, 11, 0, 0, 0, time.UTC); !ticks[0].Equal(want) {
+		t.Errorf("first tick %s, want %s", ticks[0], want)
+	}
+}
+
+func TestRenderLocation(t *testing.T) {
+	start := time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)
+	for _, loc := range []*time.Location{nil, time.UTC, mustLoad(t, "America/New_York"), mustLoad(t, "Asia/Kolkata")} {
+		c := &Chart{
+			Title:    "GPU 0",
+			Unit:     "%",
+			Series:   []Series{{Name: "util", Points: series(start, 21*24)}},
+			Width:    640,
+			Height:   320,
+			Location: loc,
+		}
+		img := c.Render()
+		if b := img.Bounds(); b.Dx() != 640 || b.Dy() != 320 {
+			t.Errorf("%v: image is %dx%d, want 640x320", loc, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		chart  Chart
+		t0, t1 time.Time
+		v0, v1 float64
+		ok     bool
+	}{
+		{name: "no data", chart: Chart{Series: []Series{{Name: "empty"}}}},
+		{
+			name:  "fits the data",
+			chart: Chart{Series: []Series{{Points: []Point{{at, 40}, {at.Add(time.Hour), 55}}}}},
+			t0:    at, t1: at.Add(time.Hour), v0: 40, v1: 55, ok: true,
+		},
+		{
+			name: "widened to YMin and YMax",
+			chart: Chart{YMin: 0, YMax: 100, Series: []Series{
+				{Points: []Point{{at, 40}}},
+				{Points: []Point{{at.Add(time.Hour), 120}}},
+			}},
+			t0: at, t1: at.Add(time.Hour), v0: 0, v1: 120, ok: true,
+		},
+		{
+			name:  "a single sample",
+			chart: Chart{Series: []Series{{Points: []Point{{at, 7}}}}},
+			t0:    at.Add(-time.Minute), t1: at.Add(time.Minute), v0: 6, v1: 8, ok: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t0, t1, v0, v1, ok := tt.chart.bounds()
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !t0.Equal(tt.t0) || !t1.Equal(tt.t1) || v0 != tt.v0 || v1 != tt.v1 {
+				t.Errorf("bounds %s..%s %v..%v, want %s..%s %v..%v", t0, t1, v0, v1, tt.t0, tt.t1, tt.v0, tt.v1)
+			}
+		})
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	tests := []struct {
+		raw, want float64
+	}{
+		{0, 1},
+		{-3, 1},
+		{0.7, 1},
+		{1, 1},
+		{1.5, 2},
+		{3, 5},
+		{7, 10},
+		{18, 20},
+		{0.03, 0.05},
+		{420, 500},
+	}
+	for _, tt := range tests {
+		if got := niceStep(tt.raw); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("niceStep(%v) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Package checks probes network services on a schedule. Each Monitor has
+// its own interval, timeout and retry count, and a Scheduler runs the
+// checks that are due on a fixed pool of workers, so a handful of dead
+// targets waiting out their timeouts cannot hold up the rest.
+//
+// A monitor's Type picks the probe: a TCP connect, an HTTP(S) request, a
+// look at a TLS certificate's expiry, or a DNS lookup.
+package checks
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Monitor types.
+const (
+	TCP  = "tcp"
+	HTTP = "http"
+	TLS  = "tls"
+	DNS  = "dns"
+)
+
+// Types lists the monitor types.
+var Types = []string{TCP, HTTP, TLS, DNS}
+
+// Defaults for monitors that do not set their own.
+const (
+	DefaultInterval  = 30 * time.Second
+	DefaultTimeout   = 5 * time.Second
+	DefaultRetries   = 1
+	DefaultWarnDays  = 14
+	DefaultDownAfter = 2
+	DefaultUpAfter   = 1
+
+	MinInterval  = 10 * time.Second
+	MaxRetries   = 5
+	MaxThreshold = 10
+)
+
+// retryDelay is the pause between the attempts of one check.
+var retryDelay = time.Second
+
+// Monitor is one target to check.
+type Monitor struct {
+	ID   int
+	Type string // TCP if empty
+	// Target is host:port for TCP and TLS, a URL for HTTP and a name for
+	// DNS.
+	Target   string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Retries is how many more attempts a failed check gets before the
+	// target counts as down, to ride out a dropped packet.
+	Retries int
+	// DownAfter and UpAfter are how many checks in a row must fail or
+	// succeed before the monitor changes state, see State.
+	DownAfter int
+	UpAfter   int
+	Options
+}
+
+// Options are the settings of the types other than TCP.
+type Options struct {
+	// HTTP
+	Status     int           `json:"status,omitempty"`   // expected status, any 2xx or 3xx if 0
+	Contains   string        `json:"contains,omitempty"` // text the body must contain
+	MaxLatency time.Duration `json:"max_latency,omitempty"`
+
+	// HTTP and TLS
+	Insecure bool `json:"insecure,omitempty"` // don't verify the certificate chain
+
+	// TLS: fail once the certificate expires within WarnDays.
+	WarnDays int `json:"warn_days,omitempty"`
+
+	// DNS
+	Record   string   `json:"record,omitempty"`   // A (the default), AAAA, CNAME, MX, NS or TXT
+	Expect   []string `json:"expect,omitempty"`   // records the answer must include
+	Resolver string   `json:"resolver,omitempty"` // host:port of the server to ask, the system's if empty
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	MonitorID int
+	Time      time.Time // when the check started
+	Up        bool
+	Latency   time.Duration // of the successful attempt, or the last one
+	Attempts  int
+	Err       error
+}
+
+// Set changes one setting given as text, as in "interval=2m". Durations
+// are whole seconds, since that is how monitors are stored.
+func (m *Monitor) Set(key, value string) error {
+	switch key {
+	case "interval", "timeout", "latency":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%s must be a duration, e.g. 30s or 2m", key)
+		}
+		switch key {
+		case "interval", "timeout":
+			if d%time.Second != 0 {
+				return fmt.Errorf("%s must be whole seconds", key)
+			}
+			if key == "interval" {
+				m.Interval = d
+			} else {
+				m.Timeout = d
+			}
+		case "latency":
+			m.MaxLatency = d
+		}
+	case "retries", "status", "warn", "down_after", "up_after":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", key)
+		}
+		switch key {
+		case "retries":
+			m.Retries = n
+		case "status":
+			m.Status = n
+		case "warn":
+			m.WarnDays = n
+		case "down_after":
+			m.DownAfter = n
+		case "up_after":
+			m.UpAfter = n
+		}
+	case "contains":
+		m.Contains = value
+	case "insecure":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("insecure must be true or false")
+		}
+		m.Insecure = b
+	case "record":
+		m.Record = strings.ToUpper(value)
+	case "expect":
+		m.Expect = nil
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				m.Expect = append(m.Expect, v)
+			}
+		}
+	case "resolver":
+		m.Resolver = value
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// SetAll applies key=value settings in order, see Set. Errors name the
+// settings the monitor's type takes.
+func (m *Monitor) SetAll(settings []string) error {
+	for _, arg := range settings {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", arg)
+		}
+		if err := m.Set(strings.ToLower(key), value); err != nil {
+			return fmt.Errorf("%v, %s monitors take %s", err, m.Type, strings.Join(Settings(m.Type), ", "))
+		}
+	}
+	return nil
+}
+
+// Settings lists the settings a monitor type takes.
+func Settings(typ string) []string {
+	common := []string{"interval", "timeout", "retries", "down_after", "up_after"}
+	switch typ {
+	case HTTP:
+		return append(common, "status", "contains", "latency", "insecure")
+	case TLS:
+		return append(common, "warn", "insecure")
+	case DNS:
+		return append(common, "record", "expect", "resolver")
+	}
+	return common
+}
+
+// Validate fills in the defaults and rejects settings out of range or
+// that don't apply to the monitor's type.
+func (m *Monitor) Validate() error {
+	if m.Type == "" {
+		m.Type = TCP
+	}
+	if m.Interval == 0 {
+		m.Interval = DefaultInterval
+	}
+	if m.Timeout == 0 {
+		m.Timeout = DefaultTimeout
+	}
+	if m.DownAfter == 0 {
+		m.DownAfter = DefaultDownAfter
+	}
+	if m.UpAfter == 0 {
+		m.UpAfter = DefaultUpAfter
+	}
+	switch {
+	case m.Interval < MinInterval:
+		return fmt.Errorf("interval must be at least %s", MinInterval)
+	case m.Timeout <= 0 || m.Timeout > m.Interval:
+		return fmt.Errorf("timeout must be between 0 and the interval")
+	case m.Retries < 0 || m.Retries > MaxRetries:
+		return fmt.Errorf("retries must be between 0 and %d", MaxRetries)
+	case m.DownAfter < 1 || m.DownAfter > MaxThreshold || m.UpAfter < 1 || m.UpAfter > MaxThreshold:
+		return fmt.Errorf("down_after and up_after must be between 1 and %d", MaxThreshold)
+	}
+
+	var unused []string
+	if m.Type != HTTP && (m.Status != 0 || m.Contains != "" || m.MaxLatency != 0) {
+		unused = append(unused, "status, contains and latency")
+	}
+	if m.Type != HTTP && m.Type != TLS && m.Insecure {
+		unused = append(unused, "insecure")
+	}
+	if m.Type != TLS && m.WarnDays != 0 {
+		unused = append(unused, "warn")
+	}
+	if m.Type != DNS && (m.Record != "" || len(m.Expect) > 0 || m.Resolver != "") {
+		unused = append(unused, "record, expect and resolver")
+	}
+	if len(unused) > 0 {
+		return fmt.Errorf("%s monitors don't take %s", m.Type, strings.Join(unused, " or "))
+	}
+
+	switch m.Type {
+	case TCP:
+		return ValidateAddress(m.Target)
+	case HTTP:
+		return validateHTTP(m)
+	case TLS:
+		if !strings.Contains(m.Target, ":") {
+			m.Target = net.JoinHostPort(m.Target, "443")
+		}
+		if m.WarnDays == 0 {
+			m.WarnDays = DefaultWarnDays
+		}
+		if m.WarnDays < 0 {
+			return fmt.Errorf("warn must be a number of days")
+		}
+		return ValidateAddress(m.Target)
+	case DNS:
+		return validateDNS(m)
+	}
+	return fmt.Errorf("unknown monitor type %q, want one of %s", m.Type, strings.Join(Types, ", "))
+}
+
+// ValidateAddress checks that address is host:port with an IP address or
+// host name.
+func ValidateAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(host) == nil && !validHostname(host) {
+		return fmt.Errorf("%q is not an IP address or host name", host)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Check runs one attempt of the probe for the monitor's type and returns
+// how long it took.
+func Check(ctx context.Context, m Monitor) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	switch m.Type {
+	case TCP, "":
+		return checkTCP(ctx, m)
+	case HTTP:
+		return checkHTTP(ctx, m)
+	case TLS:
+		return checkTLS(ctx, m)
+	case DNS:
+		return checkDNS(ctx, m)
+	}
+	return 0, fmt.Errorf("unknown monitor type %q", m.Type)
+}
+
+func checkTCP(ctx context.Context, m Monitor) (time.Duration, error) {
+	var d net.Dialer
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", m.Target)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	conn.Close()
+	return latency, nil
+}
+
+// Run checks m with check, retrying failures up to m.Retries times.
+func Run(ctx context.Context, m Monitor, check func(context.Context, Monitor) (time.Duration, error)) Result {
+	r := Result{MonitorID: m.ID, Time: time.Now()}
+	for {
+		r.Attempts++
+		r.Latency, r.Err = check(ctx, m)
+		if r.Err == nil {
+			r.Up = true
+			return r
+		}
+		if r.Attempts > m.Retries {
+			return r
+		}
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return r
+		}
+	}
+}
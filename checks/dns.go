@@ -0,0 +1,128 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS record types a monitor can look up.
+var recordTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "TXT"}
+
+func validateDNS(m *Monitor) error {
+	if m.Record == "" {
+		m.Record = "A"
+	}
+	known := false
+	for _, t := range recordTypes {
+		known = known || m.Record == t
+	}
+	if !known {
+		return fmt.Errorf("record must be one of %s", strings.Join(recordTypes, ", "))
+	}
+	if m.Resolver != "" {
+		if !strings.Contains(m.Resolver, ":") {
+			m.Resolver = net.JoinHostPort(m.Resolver, "53")
+		}
+		if err := ValidateAddress(m.Resolver); err != nil {
+			return fmt.Errorf("resolver: %v", err)
+		}
+	}
+	if !validHostname(m.Target) {
+		return fmt.Errorf("%q is not a host name", m.Target)
+	}
+	return nil
+}
+
+// checkDNS looks up the target's records and checks that they include
+// every expected one. Without expectations any answer will do.
+func checkDNS(ctx context.Context, m Monitor) (time.Duration, error) {
+	r := net.DefaultResolver
+	if m.Resolver != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, m.Resolver)
+			},
+		}
+	}
+	start := time.Now()
+	records, err := lookup(ctx, r, m.Record, m.Target)
+	latency := time.Since(start)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && m.Resolver != "" {
+		// The error names the system's server, which was not asked.
+		dnsErr.Server = m.Resolver
+	}
+	if err != nil {
+		return latency, err
+	}
+	if len(records) == 0 {
+		return latency, fmt.Errorf("no %s records", m.Record)
+	}
+
+	have := map[string]bool{}
+	for _, rec := range records {
+		have[normalizeRecord(rec)] = true
+	}
+	var missing []string
+	for _, want := range m.Expect {
+		if !have[normalizeRecord(want)] {
+			missing = append(missing, want)
+		}
+	}
+	if len(missing) > 0 {
+		return latency, fmt.Errorf("got %s, missing %s", strings.Join(records, ", "), strings.Join(missing, ", "))
+	}
+	return latency, nil
+}
+
+func lookup(ctx context.Context, r *net.Resolver, record, name string) ([]string, error) {
+	var out []string
+	switch record {
+	case "A", "AAAA":
+		network := "ip4"
+		if record == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, name)
+		for _, ip := range ips {
+			out = append(out, ip.String())
+		}
+		return out, err
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{cname}, nil
+	case "MX":
+		mxs, err := r.LookupMX(ctx, name)
+		for _, mx := range mxs {
+			out = append(out, mx.Host)
+		}
+		return out, err
+	case "NS":
+		nss, err := r.LookupNS(ctx, name)
+		for _, ns := range nss {
+			out = append(out, ns.Host)
+		}
+		return out, err
+	case "TXT":
+		return r.LookupTXT(ctx, name)
+	}
+	return nil, fmt.Errorf("unknown record type %q", record)
+}
+
+// normalizeRecord makes names compare equal with or without the final
+// dot and in any case. IP addresses are compared in canonical form.
+func normalizeRecord(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(strings.TrimSuffix(s, "."))
+}
@@ -0,0 +1,186 @@
+package checks
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Record types and response codes of the DNS wire format.
+const (
+	typeA   = 1
+	typeMX  = 15
+	typeTXT = 16
+
+	rcodeNXDomain = 3
+)
+
+// dnsRecord is one answer of the stub server: the record's data already
+// encoded for the wire.
+type dnsRecord struct {
+	Type uint16
+	Data []byte
+}
+
+// dnsServer answers queries over UDP on 127.0.0.1 from zone, keyed by
+// the lower-case name with its final dot. Names not in zone get
+// NXDOMAIN. It returns the address to use as Options.Resolver.
+func dnsServer(t *testing.T, zone map[string][]dnsRecord) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := answer(buf[:n], zone); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// answer builds the response to one query, or returns nil if it cannot
+// be parsed.
+func answer(query []byte, zone map[string][]dnsRecord) []byte {
+	if len(query) < 12 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return nil
+	}
+	// Read the question's name, then its type and class.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	end := i + 5
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+
+	records, found := zone[name]
+	var answers []dnsRecord
+	for _, r := range records {
+		if r.Type == qtype {
+			answers = append(answers, r)
+		}
+	}
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])   // ID
+	flags := uint16(0x8180) // QR, RD and RA
+	if !found {
+		flags |= rcodeNXDomain
+	}
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	resp = append(resp, query[12:end]...)
+	for _, r := range answers {
+		resp = append(resp, 0xc0, 12) // the name in the question
+		resp = binary.BigEndian.AppendUint16(resp, r.Type)
+		resp = binary.BigEndian.AppendUint16(resp, 1) // IN
+		resp = binary.BigEndian.AppendUint32(resp, 300)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(r.Data)))
+		resp = append(resp, r.Data...)
+	}
+	return resp
+}
+
+func dnsName(name string) []byte {
+	var b []byte
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+func recordA(ip string) dnsRecord {
+	return dnsRecord{typeA, net.ParseIP(ip).To4()}
+}
+
+func recordMX(pref uint16, host string) dnsRecord {
+	return dnsRecord{typeMX, append(binary.BigEndian.AppendUint16(nil, pref), dnsName(host)...)}
+}
+
+func recordTXT(s string) dnsRecord {
+	return dnsRecord{typeTXT, append([]byte{byte(len(s))}, s...)}
+}
+
+func TestCheckDNS(t *testing.T) {
+	resolver := dnsServer(t, map[string][]dnsRecord{
+		"web.example.org.": {recordA("192.0.2.10"), recordA("192.0.2.11")},
+		"example.org.": {
+			recordMX(10, "mx1.example.org"),
+			recordMX(20, "mx2.example.org"),
+			recordTXT("v=spf1 mx -all"),
+		},
+		"empty.example.org.": nil,
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		record string
+		expect []string
+		err    string // empty if the check should pass
+	}{
+		{"any A record", "web.example.org.", "A", nil, ""},
+		{"expected A records", "web.example.org.", "A", []string{"192.0.2.11", "192.0.2.10"}, ""},
+		{"missing A record", "web.example.org.", "A", []string{"192.0.2.10", "192.0.2.99"},
+			"got 192.0.2.10, 192.0.2.11, missing 192.0.2.99"},
+		{"MX in any case, with or without the dot", "example.org.", "MX", []string{"MX1.example.org", "mx2.example.org."}, ""},
+		{"missing MX", "example.org.", "MX", []string{"mx3.example.org"}, "got mx1.example.org., mx2.example.org., missing mx3.example.org"},
+		{"TXT", "example.org.", "TXT", []string{"v=spf1 mx -all"}, ""},
+		{"no records of the type", "empty.example.org.", "TXT", nil, "lookup empty.example.org."},
+		{"unknown name", "gone.example.org.", "A", nil, "lookup gone.example.org. on " + resolver + ": no such host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Monitor{
+				Type:    DNS,
+				Target:  tt.target,
+				Timeout: 5 * time.Second,
+				Options: Options{Record: tt.record, Expect: tt.expect, Resolver: resolver},
+			}
+			_, err := Check(context.Background(), m)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Check: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("Check: %v, want %s...", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecord(t *testing.T) {
+	for in, want := range map[string]string{
+		"Mail.Example.ORG.": "mail.example.org",
+		"mail.example.org":  "mail.example.org",
+		"2001:DB8::0:1":     "2001:db8::1",
+		"192.0.2.1":         "192.0.2.1",
+		"v=spf1 mx -all":    "v=spf1 mx -all",
+	} {
+		if got := normalizeRecord(in); got != want {
+			t.Errorf("normalizeRecord(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
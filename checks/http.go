@@ -0,0 +1,82 @@
+package checks
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxBody is how much of a response is searched for Options.Contains.
+const maxBody = 1 << 20
+
+var (
+	httpClient     = &http.Client{}
+	insecureClient = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+)
+
+func validateHTTP(m *Monitor) error {
+	u, err := url.Parse(m.Target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http:// or https:// URL", m.Target)
+	}
+	if m.Status != 0 && (m.Status < 100 || m.Status > 599) {
+		return fmt.Errorf("status must be an HTTP status code")
+	}
+	if m.MaxLatency > m.Timeout {
+		return fmt.Errorf("latency must be below the timeout")
+	}
+	return nil
+}
+
+// checkHTTP fetches the URL, following redirects, and checks the final
+// response's status, body and how long the whole exchange took.
+func checkHTTP(ctx context.Context, m Monitor) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.Target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "gpumon-checks")
+	client := httpClient
+	if m.Insecure {
+		client = insecureClient
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Since(start), err
+	}
+	defer resp.Body.Close()
+	var body []byte
+	if m.Contains != "" {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	} else {
+		_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	}
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+
+	switch {
+	case m.Status != 0 && resp.StatusCode != m.Status:
+		return latency, fmt.Errorf("status %d, want %d", resp.StatusCode, m.Status)
+	case m.Status == 0 && resp.StatusCode >= 400:
+		return latency, fmt.Errorf("status %d", resp.StatusCode)
+	case m.Contains != "" && !bytes.Contains(body, []byte(m.Contains)):
+		return latency, fmt.Errorf("body does not contain %q", m.Contains)
+	case m.MaxLatency > 0 && latency > m.MaxLatency:
+		return latency, fmt.Errorf("took %s, more than %s", latency.Round(time.Millisecond), m.MaxLatency)
+	}
+	return latency, nil
+}
@@ -0,0 +1,99 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "gpumon-checks" {
+			http.Error(w, "User-Agent "+ua, http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintln(w, "<h1>All systems operational</h1>")
+		case "/moved":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		case "/down":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintln(w, "finally")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		path string
+		opts Options
+		err  string // empty if the check should pass
+	}{
+		{"up", "/", Options{}, ""},
+		{"follows redirects", "/moved", Options{Contains: "operational"}, ""},
+		{"any 2xx", "/created", Options{}, ""},
+		{"expected status", "/created", Options{Status: 201}, ""},
+		{"expected status on an error page", "/down", Options{Status: 503}, ""},
+		{"server error", "/down", Options{}, "status 503"},
+		{"not found", "/missing", Options{}, "status 404"},
+		{"wrong status", "/", Options{Status: 204}, "status 200, want 204"},
+		{"contains", "/", Options{Contains: "All systems operational"}, ""},
+		{"does not contain", "/", Options{Contains: "degraded"}, `body does not contain "degraded"`},
+		{"fast enough", "/slow", Options{MaxLatency: 2 * time.Second}, ""},
+		{"too slow", "/slow", Options{MaxLatency: 20 * time.Millisecond}, "took "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Monitor{Type: HTTP, Target: srv.URL + tt.path, Timeout: 5 * time.Second, Options: tt.opts}
+			latency, err := Check(context.Background(), m)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Check: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("Check: %v, want %s...", err, tt.err)
+			}
+			if latency <= 0 {
+				t.Errorf("latency %v", latency)
+			}
+		})
+	}
+}
+
+func TestCheckHTTPTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	m := Monitor{Type: HTTP, Target: srv.URL, Timeout: 50 * time.Millisecond}
+	if _, err := Check(context.Background(), m); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("Check: %v, want a timeout", err)
+	}
+}
+
+func TestCheckHTTPInsecure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	m := Monitor{Type: HTTP, Target: srv.URL, Timeout: 5 * time.Second}
+	if _, err := Check(context.Background(), m); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Check of a self-signed server: %v", err)
+	}
+	m.Insecure = true
+	if _, err := Check(context.Background(), m); err != nil {
+		t.Errorf("Check with insecure: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package checks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample is one stored check result.
+type Sample struct {
+	Time    time.Time
+	Up      bool
+	Latency time.Duration
+}
+
+// Outage is a stretch of time a monitor was down. End is zero while it
+// still is.
+type Outage struct {
+	CheckID int       `json:"check_id"`
+	Target  string    `json:"target,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Report sums up a monitor's checks and outages over a period, for
+// uptime and SLA reporting.
+type Report struct {
+	From   time.Time
+	To     time.Time
+	Checks int
+	Failed int
+	// Uptime is the percentage of the period the monitor was not in an
+	// outage. The period starts at the first check if that is later, so a
+	// new monitor is not charged for the time before it existed.
+	Uptime   float64
+	Downtime time.Duration
+	Outages  int
+	// MTTR is the mean time to recovery of the outages that ended in the
+	// period.
+	MTTR       time.Duration
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+}
+
+type reportJSON struct {
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Checks          int       `json:"checks"`
+	Failed          int       `json:"failed_checks"`
+	Uptime          float64   `json:"uptime_percent"`
+	DowntimeSeconds float64   `json:"downtime_seconds"`
+	Outages         int       `json:"outages"`
+	MTTRSeconds     float64   `json:"mttr_seconds"`
+	LatencyP50      float64   `json:"latency_p50_ms"`
+	LatencyP95      float64   `json:"latency_p95_ms"`
+}
+
+// MarshalJSON gives durations in seconds and latencies in milliseconds.
+func (r Report) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return json.Marshal(reportJSON{r.From, r.To, r.Checks, r.Failed, r.Uptime, r.Downtime.Seconds(), r.Outages, r.MTTR.Seconds(), ms(r.LatencyP50), ms(r.LatencyP95)})
+}
+
+func (r *Report) UnmarshalJSON(data []byte) error {
+	var j reportJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	ms := func(m float64) time.Duration { return time.Duration(m * float64(time.Millisecond)) }
+	*r = Report{j.From, j.To, j.Checks, j.Failed, j.Uptime, seconds(j.DowntimeSeconds), j.Outages, seconds(j.MTTRSeconds), ms(j.LatencyP50), ms(j.LatencyP95)}
+	return nil
+}
+
+// Summarize builds the report for [from, to) from the samples and the
+// outages that overlap it.
+func Summarize(from, to time.Time, samples []Sample, outages []Outage) Report {
+	r := Report{From: from, To: to, Uptime: 100}
+	var latencies []time.Duration
+	start := to
+	for _, s := range samples {
+		if s.Time.Before(from) || !s.Time.Before(to) {
+			continue
+		}
+		r.Checks++
+		if s.Time.Before(start) {
+			start = s.Time
+		}
+		if s.Up {
+			latencies = append(latencies, s.Latency)
+		} else {
+			r.Failed++
+		}
+	}
+
+	var recovered time.Duration
+	var ended int
+	for _, o := range outages {
+		end := o.End
+		if end.IsZero() || end.After(to) {
+			end = to
+		}
+		begin := o.Start
+		if begin.Before(from) {
+			begin = from
+		}
+		if !end.After(begin) {
+			continue
+		}
+		r.Outages++
+		r.Downtime += end.Sub(begin)
+		if begin.Before(start) {
+			start = begin
+		}
+		if !o.End.IsZero() && !o.End.After(to) {
+			recovered += o.End.Sub(o.Start)
+			ended++
+		}
+	}
+	if ended > 0 {
+		r.MTTR = recovered / time.Duration(ended)
+	}
+	if span := to.Sub(start); span > 0 {
+		r.Uptime = 100 * (1 - float64(r.Downtime)/float64(span))
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.LatencyP50 = percentile(latencies, 50)
+	r.LatencyP95 = percentile(latencies, 95)
+	return r
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (p*len(sorted)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// ParsePeriod parses a reporting period such as 7d, 30d or 12h.
+func ParsePeriod(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid period %q, want e.g. 7d or 12h", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid period %q, want e.g. 7d or 12h", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,127 @@
+package checks
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	from := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+	at := func(d time.Duration) time.Time { return from.Add(d) }
+	// A check every hour of the period, all up.
+	hourly := func(latency time.Duration) []Sample {
+		var samples []Sample
+		for h := time.Duration(0); h < 10; h++ {
+			samples = append(samples, Sample{Time: at(h * time.Hour), Up: true, Latency: latency})
+		}
+		return samples
+	}
+
+	tests := []struct {
+		name    string
+		samples []Sample
+		outages []Outage
+		want    Report
+	}{
+		{"no data", nil, nil, Report{Uptime: 100}},
+		{"all up", hourly(20 * time.Millisecond), nil,
+			Report{Checks: 10, Uptime: 100, LatencyP50: 20 * time.Millisecond, LatencyP95: 20 * time.Millisecond}},
+		{"samples outside the period",
+			[]Sample{{Time: at(-time.Minute)}, {Time: at(time.Hour), Up: true}, {Time: to}},
+			nil, Report{Checks: 1, Uptime: 100}},
+		{"failed checks without an outage",
+			[]Sample{{Time: at(0), Up: true, Latency: time.Millisecond}, {Time: at(time.Hour)}, {Time: at(2 * time.Hour)}},
+			nil, Report{Checks: 3, Failed: 2, Uptime: 100, LatencyP50: time.Millisecond, LatencyP95: time.Millisecond}},
+		{"outage in the period", hourly(0),
+			[]Outage{{Start: at(2 * time.Hour), End: at(3 * time.Hour)}},
+			Report{Checks: 10, Uptime: 90, Downtime: time.Hour, Outages: 1, MTTR: time.Hour}},
+		// Only the part in the period is downtime, but the recovery took
+		// the whole outage.
+		{"outage spanning from", hourly(0),
+			[]Outage{{Start: at(-2 * time.Hour), End: at(time.Hour)}},
+			Report{Checks: 10, Uptime: 90, Downtime: time.Hour, Outages: 1, MTTR: 3 * time.Hour}},
+		{"open outage", hourly(0),
+			[]Outage{{Start: at(8 * time.Hour)}},
+			Report{Checks: 10, Uptime: 80, Downtime: 2 * time.Hour, Outages: 1}},
+		{"outage ending after to", hourly(0),
+			[]Outage{{Start: at(9 * time.Hour), End: to.Add(time.Hour)}},
+			Report{Checks: 10, Uptime: 90, Downtime: time.Hour, Outages: 1}},
+		{"outages outside the period", hourly(0),
+			[]Outage{{Start: at(-3 * time.Hour), End: at(-time.Hour)}, {Start: to, End: to.Add(time.Hour)}},
+			Report{Checks: 10, Uptime: 100}},
+		{"mean time to recovery", hourly(0),
+			[]Outage{{Start: at(time.Hour), End: at(time.Hour + 10*time.Minute)}, {Start: at(5 * time.Hour), End: at(5*time.Hour + 50*time.Minute)}},
+			Report{Checks: 10, Uptime: 90, Downtime: time.Hour, Outages: 2, MTTR: 30 * time.Minute}},
+		// A monitor created halfway through is charged for its own half
+		// only.
+		{"created mid-period",
+			[]Sample{{Time: at(5 * time.Hour), Up: true}, {Time: at(9 * time.Hour)}},
+			[]Outage{{Start: at(9 * time.Hour), End: at(9*time.Hour + 30*time.Minute)}},
+			Report{Checks: 2, Failed: 1, Uptime: 90, Downtime: 30 * time.Minute, Outages: 1, MTTR: 30 * time.Minute}},
+		// An outage before the first check in the period starts it.
+		{"created down",
+			[]Sample{{Time: at(8 * time.Hour), Up: true}},
+			[]Outage{{Start: at(5 * time.Hour), End: at(8 * time.Hour)}},
+			Report{Checks: 1, Uptime: 40, Downtime: 3 * time.Hour, Outages: 1, MTTR: 3 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Summarize(from, to, tt.samples, tt.outages)
+			want := tt.want
+			want.From, want.To = from, to
+			if math.Abs(got.Uptime-want.Uptime) < 1e-9 {
+				got.Uptime = want.Uptime
+			}
+			if got != want {
+				t.Errorf("Summarize = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		var d []time.Duration
+		for _, v := range values {
+			d = append(d, time.Duration(v)*time.Millisecond)
+		}
+		return d
+	}
+	tests := []struct {
+		sorted   []time.Duration
+		p50, p95 time.Duration
+	}{
+		{nil, 0, 0},
+		{ms(7), 7 * time.Millisecond, 7 * time.Millisecond},
+		{ms(1, 9), time.Millisecond, 9 * time.Millisecond},
+		{ms(1, 2, 3), 2 * time.Millisecond, 3 * time.Millisecond},
+		{ms(1, 2, 3, 4), 2 * time.Millisecond, 4 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 5 * time.Millisecond, 10 * time.Millisecond},
+		{ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20), 10 * time.Millisecond, 19 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, 50); got != tt.p50 {
+			t.Errorf("p50 of %v = %v, want %v", tt.sorted, got, tt.p50)
+		}
+		if got := percentile(tt.sorted, 95); got != tt.p95 {
+			t.Errorf("p95 of %v = %v, want %v", tt.sorted, got, tt.p95)
+		}
+	}
+	if got := percentile(ms(1, 2, 3), 0); got != time.Millisecond {
+		t.Errorf("p0 = %v, want the smallest value", got)
+	}
+}
+
+func TestSummarizeSortsLatencies(t *testing.T) {
+	from := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	var samples []Sample
+	for i, v := range []int{30, 10, 50, 20, 40} {
+		samples = append(samples, Sample{Time: from.Add(time.Duration(i) * time.Minute), Up: true, Latency: time.Duration(v) * time.Millisecond})
+	}
+	r := Summarize(from, from.Add(time.Hour), samples, nil)
+	if r.LatencyP50 != 30*time.Millisecond || r.LatencyP95 != 50*time.Millisecond {
+		t.Errorf("p50 %v, p95 %v; want 30ms, 50ms", r.LatencyP50, r.LatencyP95)
+	}
+}
@@ -0,0 +1,160 @@
+package checks
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is how many checks run at once unless Scheduler.Workers
+// says otherwise.
+const DefaultWorkers = 20
+
+// Scheduler checks each monitor every Interval, at most Workers at a
+// time. A monitor is never checked twice at once: its next check is due
+// an Interval after the previous one started, or as soon as a worker is
+// free if that has passed.
+type Scheduler struct {
+	Workers int
+	// Check probes one target, with the package's Check if nil.
+	Check func(context.Context, Monitor) (time.Duration, error)
+	// OnResult is called with every result, from the worker that ran the
+	// check, so it must be safe for concurrent use.
+	OnResult func(Result)
+
+	mu       sync.Mutex
+	monitors map[int]*scheduled
+	wake     chan struct{}
+}
+
+type scheduled struct {
+	Monitor
+	next    time.Time
+	running bool
+}
+
+// Set replaces the monitors to check. New monitors and ones whose
+// settings changed are checked right away, the others keep their place.
+// A changed monitor whose check is running waits for it to finish.
+func (s *Scheduler) Set(monitors []Monitor) {
+	s.mu.Lock()
+	old := s.monitors
+	s.monitors = make(map[int]*scheduled, len(monitors))
+	now := time.Now()
+	for _, m := range monitors {
+		prev, ok := old[m.ID]
+		if ok && reflect.DeepEqual(prev.Monitor, m) {
+			s.monitors[m.ID] = prev
+			continue
+		}
+		next := &scheduled{Monitor: m, next: now}
+		if ok {
+			next.running = prev.running
+		}
+		s.monitors[m.ID] = next
+	}
+	s.mu.Unlock()
+	s.poke()
+}
+
+// wakeup returns the channel that tells Run to look for due checks.
+func (s *Scheduler) wakeup() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wake == nil {
+		s.wake = make(chan struct{}, 1)
+	}
+	return s.wake
+}
+
+func (s *Scheduler) poke() {
+	select {
+	case s.wakeup() <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches due checks to the workers until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	check := s.Check
+	if check == nil {
+		check = Check
+	}
+	wake := s.wakeup()
+
+	jobs := make(chan *scheduled)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				r := Run(ctx, job.Monitor, check)
+				s.mu.Lock()
+				job.running = false
+				job.next = r.Time.Add(job.Interval)
+				if cur := s.monitors[job.ID]; cur != nil && cur != job {
+					// Set changed the monitor while it was checked, and
+					// the new settings are due now.
+					cur.running = false
+				}
+				s.mu.Unlock()
+				if ctx.Err() == nil && s.OnResult != nil {
+					s.OnResult(r)
+				}
+				s.poke()
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for {
+		job, wait := s.due()
+		if job != nil {
+			select {
+			case jobs <- job:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// due claims the most overdue monitor, or returns how long until the
+// next one is due.
+func (s *Scheduler) due() (*scheduled, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first *scheduled
+	for _, m := range s.monitors {
+		if !m.running && (first == nil || m.next.Before(first.next)) {
+			first = m
+		}
+	}
+	if first == nil {
+		return nil, time.Hour
+	}
+	if wait := time.Until(first.next); wait > 0 {
+		return nil, wait
+	}
+	first.running = true
+	return first, 0
+}
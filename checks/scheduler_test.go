@@ -0,0 +1,250 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// prober is a fake Check that counts what it was asked and how many
+// checks ran at once, overall and per monitor.
+type prober struct {
+	mu      sync.Mutex
+	calls   map[int]int
+	targets []string
+	running map[int]int
+	now     int
+	max     int
+	twice   bool // a monitor was checked twice at once
+
+	delay time.Duration
+	fail  func(m Monitor, attempt int) error
+}
+
+func newProber() *prober {
+	return &prober{calls: map[int]int{}, running: map[int]int{}}
+}
+
+func (p *prober) Check(ctx context.Context, m Monitor) (time.Duration, error) {
+	p.mu.Lock()
+	p.calls[m.ID]++
+	attempt := p.calls[m.ID]
+	p.targets = append(p.targets, m.Target)
+	p.running[m.ID]++
+	p.twice = p.twice || p.running[m.ID] > 1
+	p.now++
+	p.max = max(p.max, p.now)
+	p.mu.Unlock()
+
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	p.running[m.ID]--
+	p.now--
+	p.mu.Unlock()
+	if p.fail != nil {
+		return p.delay, p.fail(m, attempt)
+	}
+	return p.delay, nil
+}
+
+// results collects what the scheduler reports.
+type results struct {
+	mu  sync.Mutex
+	all []Result
+}
+
+func (r *results) add(res Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.all = append(r.all, res)
+}
+
+func (r *results) get() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Result(nil), r.all...)
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func startScheduler(t *testing.T, s *Scheduler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestSchedulerWorkers(t *testing.T) {
+	p := newProber()
+	p.delay = 20 * time.Millisecond
+	var res results
+	s := &Scheduler{Workers: 3, Check: p.Check, OnResult: res.add}
+	var monitors []Monitor
+	for id := 1; id <= 12; id++ {
+		monitors = append(monitors, Monitor{ID: id, Target: "127.0.0.1:1", Interval: time.Hour})
+	}
+	s.Set(monitors)
+	startScheduler(t, s)
+
+	waitFor(t, "every monitor to be checked", func() bool { return len(res.get()) == len(monitors) })
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.max != 3 {
+		t.Errorf("%d checks ran at once, want 3", p.max)
+	}
+	for _, m := range monitors {
+		if p.calls[m.ID] != 1 {
+			t.Errorf("monitor %d checked %d times within its interval", m.ID, p.calls[m.ID])
+		}
+	}
+}
+
+func TestSchedulerIntervals(t *testing.T) {
+	p := newProber()
+	var res results
+	s := &Scheduler{Workers: 4, Check: p.Check, OnResult: res.add}
+	s.Set([]Monitor{
+		{ID: 1, Interval: 50 * time.Millisecond},
+		{ID: 2, Interval: 250 * time.Millisecond},
+		{ID: 3, Interval: time.Hour},
+	})
+	startScheduler(t, s)
+	time.Sleep(time.Second)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Allow for a slow machine, but the ratio must follow the intervals.
+	if n := p.calls[1]; n < 10 || n > 21 {
+		t.Errorf("monitor 1 checked %d times in a second at 50ms", n)
+	}
+	if n := p.calls[2]; n < 3 || n > 5 {
+		t.Errorf("monitor 2 checked %d times in a second at 250ms", n)
+	}
+	if n := p.calls[3]; n != 1 {
+		t.Errorf("monitor 3 checked %d times in a second at 1h", n)
+	}
+
+	var last time.Time
+	for _, r := range res.get() {
+		if r.MonitorID != 1 {
+			continue
+		}
+		if !last.IsZero() && r.Time.Sub(last) < 50*time.Millisecond {
+			t.Errorf("monitor 1 checked %v after the previous check", r.Time.Sub(last))
+		}
+		last = r.Time
+	}
+}
+
+func TestSchedulerRetries(t *testing.T) {
+	defer func(d time.Duration) { retryDelay = d }(retryDelay)
+	retryDelay = time.Millisecond
+
+	p := newProber()
+	refused := errors.New("connection refused")
+	p.fail = func(m Monitor, attempt int) error {
+		switch {
+		case m.ID == 1:
+			return refused
+		case m.ID == 2 && attempt == 1:
+			return refused
+		}
+		return nil
+	}
+	var res results
+	s := &Scheduler{Check: p.Check, OnResult: res.add}
+	s.Set([]Monitor{
+		{ID: 1, Interval: time.Hour, Retries: 2},
+		{ID: 2, Interval: time.Hour, Retries: 2},
+		{ID: 3, Interval: time.Hour, Retries: 0},
+	})
+	startScheduler(t, s)
+	waitFor(t, "three results", func() bool { return len(res.get()) == 3 })
+
+	want := map[int]struct {
+		up       bool
+		attempts int
+	}{
+		1: {false, 3},
+		2: {true, 2},
+		3: {true, 1},
+	}
+	for _, r := range res.get() {
+		w := want[r.MonitorID]
+		if r.Up != w.up || r.Attempts != w.attempts {
+			t.Errorf("monitor %d: up %v after %d attempts, want up %v after %d", r.MonitorID, r.Up, r.Attempts, w.up, w.attempts)
+		}
+		if !r.Up && r.Err != refused {
+			t.Errorf("monitor %d: error %v", r.MonitorID, r.Err)
+		}
+	}
+}
+
+// A monitor changed while its check runs is checked again with the new
+// settings, but only once the running check is done.
+func TestSchedulerSetWhileRunning(t *testing.T) {
+	p := newProber()
+	p.delay = 200 * time.Millisecond
+	var res results
+	s := &Scheduler{Workers: 4, Check: p.Check, OnResult: res.add}
+	s.Set([]Monitor{{ID: 1, Target: "old:80", Interval: time.Hour}})
+	startScheduler(t, s)
+
+	waitFor(t, "the first check", func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.now == 1
+	})
+	s.Set([]Monitor{{ID: 1, Target: "new:80", Interval: time.Hour}})
+	waitFor(t, "two results", func() bool { return len(res.get()) == 2 })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.twice {
+		t.Error("the monitor was checked twice at once")
+	}
+	if len(p.targets) != 2 || p.targets[0] != "old:80" || p.targets[1] != "new:80" {
+		t.Errorf("checked %q", p.targets)
+	}
+}
+
+// Unchanged monitors keep their place, so setting the same list again
+// does not trigger checks.
+func TestSchedulerSetUnchanged(t *testing.T) {
+	p := newProber()
+	var res results
+	s := &Scheduler{Check: p.Check, OnResult: res.add}
+	monitors := []Monitor{{ID: 1, Interval: time.Hour}, {ID: 2, Interval: time.Hour}}
+	s.Set(monitors)
+	startScheduler(t, s)
+	waitFor(t, "two results", func() bool { return len(res.get()) == 2 })
+
+	s.Set(monitors)
+	time.Sleep(100 * time.Millisecond)
+	if n := len(res.get()); n != 2 {
+		t.Errorf("%d results after setting the same monitors, want 2", n)
+	}
+}
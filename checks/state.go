@@ -0,0 +1,100 @@
+package checks
+
+import "time"
+
+// Flap detection looks at the changes between the last FlapWindow
+// results. A monitor starts flapping at FlapStart changes and stops once
+// they drop to FlapStop, so it does not flip in and out of flapping.
+const (
+	FlapWindow = 20
+	FlapStart  = 6
+	FlapStop   = 2
+)
+
+// State is what a monitor's results add up to. A single failed check
+// does not take a monitor down: it changes state only after DownAfter
+// failures or UpAfter successes in a row. While the results keep going
+// back and forth the monitor is flapping, and the changes in between
+// are not worth telling anyone about.
+type State struct {
+	Up       bool
+	Flapping bool
+	// Streak counts the results in a row that disagree with Up, and
+	// StreakStart is when the first of them was checked.
+	Streak      int
+	StreakStart time.Time
+	// Recent holds the last FlapWindow results, oldest first, as + for
+	// up and - for down.
+	Recent string
+}
+
+// Change is what a result did to a State.
+type Change struct {
+	// Changed is set when the monitor went up or down, Since is when the
+	// first result that led to it was checked.
+	Changed bool
+	Since   time.Time
+
+	Flapping    bool // after this result
+	FlapStarted bool
+	FlapEnded   bool
+	Flips       int // changes in the window
+}
+
+// Notify reports whether the change is worth a notification: a state
+// change while not flapping, or flapping starting or ending.
+func (c Change) Notify() bool {
+	return c.FlapStarted || c.FlapEnded || c.Changed && !c.Flapping
+}
+
+// Update adds a result for monitor m to the state.
+func (s *State) Update(m Monitor, r Result) Change {
+	s.Recent += map[bool]string{true: "+", false: "-"}[r.Up]
+	if len(s.Recent) > FlapWindow {
+		s.Recent = s.Recent[len(s.Recent)-FlapWindow:]
+	}
+
+	var c Change
+	if r.Up == s.Up {
+		s.Streak = 0
+		s.StreakStart = time.Time{}
+	} else {
+		if s.Streak == 0 {
+			s.StreakStart = r.Time
+		}
+		s.Streak++
+		need := m.DownAfter
+		if r.Up {
+			need = m.UpAfter
+		}
+		if s.Streak >= need {
+			c.Changed, c.Since = true, s.StreakStart
+			s.Up = r.Up
+			s.Streak = 0
+			s.StreakStart = time.Time{}
+		}
+	}
+
+	c.Flips = s.Flips()
+	switch {
+	case !s.Flapping && c.Flips >= FlapStart:
+		s.Flapping = true
+		c.FlapStarted = true
+	case s.Flapping && c.Flips <= FlapStop:
+		s.Flapping = false
+		c.FlapEnded = true
+	}
+	c.Flapping = s.Flapping
+	return c
+}
+
+// Flips counts the changes between consecutive results in Recent.
+func (s *State) Flips() int {
+	n := 0
+	for i := 1; i < len(s.Recent); i++ {
+		if s.Recent[i] != s.Recent[i-1] {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,150 @@
+package checks
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// updates feeds results to a state, one a minute, given as + for up and
+// - for down. It logs what every result changed as "i:event" with i the
+// result's index: down@j or up@j when the monitor went down or up with
+// result j first, flapping or steady when flapping started or ended, and
+// a trailing ! when the change notifies.
+func updates(s *State, m Monitor, results string) string {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	var log []string
+	for i, r := range results {
+		c := s.Update(m, Result{Time: start.Add(time.Duration(i) * time.Minute), Up: r == '+'})
+		var events []string
+		if c.Changed {
+			events = append(events, fmt.Sprintf("%s@%d", map[bool]string{true: "up", false: "down"}[s.Up], c.Since.Sub(start)/time.Minute))
+		}
+		if c.FlapStarted {
+			events = append(events, "flapping")
+		}
+		if c.FlapEnded {
+			events = append(events, "steady")
+		}
+		if c.Flapping != s.Flapping || c.Flips != s.Flips() {
+			events = append(events, fmt.Sprintf("change %+v disagrees with state %+v", c, *s))
+		}
+		if len(events) == 0 {
+			continue
+		}
+		entry := fmt.Sprintf("%d:%s", i, strings.Join(events, ","))
+		if c.Notify() {
+			entry += "!"
+		}
+		log = append(log, entry)
+	}
+	return strings.Join(log, " ")
+}
+
+func TestStateUpdate(t *testing.T) {
+	flaps := "-+-+-+-+" // six changes in the first seven results
+	tests := []struct {
+		name      string
+		down      bool // before the results
+		downAfter int
+		upAfter   int
+		results   string
+		log       string
+		up        bool // after the results
+		flapping  bool
+	}{
+		{"one failure", false, 2, 1, "+-+", "", true, false},
+		{"down after two failures", false, 2, 1, "+--", "2:down@1!", false, false},
+		{"down after three failures", false, 3, 1, "+--+---", "6:down@4!", false, false},
+		{"down at once", false, 1, 1, "+-", "1:down@1!", false, false},
+		{"up at once", true, 2, 1, "-+", "1:up@1!", true, false},
+		{"up after two successes", true, 2, 2, "+-++", "3:up@2!", true, false},
+		{"single successes while down", true, 2, 2, "-+-+-", "", false, false},
+		{"a success resets the streak", false, 2, 1, "+-+--", "4:down@3!", false, false},
+
+		// The change that starts flapping notifies, the ones after it
+		// do not.
+		{"starts flapping", false, 1, 1, flaps[:7], "0:down@0! 1:up@1! 2:down@2! 3:up@3! 4:down@4! 5:up@5! 6:down@6,flapping!", false, true},
+		{"quiet while flapping", false, 1, 1, flaps + "-", "0:down@0! 1:up@1! 2:down@2! 3:up@3! 4:down@4! 5:up@5! 6:down@6,flapping! 7:up@7 8:down@8", false, true},
+		// Flapping counts results, not state changes: failures that never
+		// take the monitor down still flap it.
+		{"flaps without going down", false, 3, 1, flaps[:7], "6:flapping!", true, true},
+		// Flapping ends only at FlapStop changes in the window, once the
+		// window slid past all but two of the flips.
+		{"flapping above FlapStop", false, 1, 1, flaps + strings.Repeat("+", 16), "0:down@0! 1:up@1! 2:down@2! 3:up@3! 4:down@4! 5:up@5! 6:down@6,flapping! 7:up@7", true, true},
+		{"steady again", false, 1, 1, flaps + strings.Repeat("+", 17), "0:down@0! 1:up@1! 2:down@2! 3:up@3! 4:down@4! 5:up@5! 6:down@6,flapping! 7:up@7 24:steady!", true, false},
+		{"down once steady", false, 1, 1, flaps + strings.Repeat("+", 17) + "-", "0:down@0! 1:up@1! 2:down@2! 3:up@3! 4:down@4! 5:up@5! 6:down@6,flapping! 7:up@7 24:steady! 25:down@25!", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := State{Up: !tt.down}
+			m := Monitor{DownAfter: tt.downAfter, UpAfter: tt.upAfter}
+			if got := updates(&s, m, tt.results); got != tt.log {
+				t.Errorf("log\n%s\nwant\n%s", got, tt.log)
+			}
+			if s.Up != tt.up || s.Flapping != tt.flapping {
+				t.Errorf("up %v, flapping %v; want %v, %v", s.Up, s.Flapping, tt.up, tt.flapping)
+			}
+			if len(s.Recent) > FlapWindow || !strings.HasSuffix(tt.results, s.Recent) {
+				t.Errorf("Recent %q after %q", s.Recent, tt.results)
+			}
+		})
+	}
+}
+
+func TestStateStreak(t *testing.T) {
+	s := State{Up: true}
+	m := Monitor{DownAfter: 3, UpAfter: 1}
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		s.Update(m, Result{Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	if s.Streak != 2 || !s.StreakStart.Equal(start) || !s.Up {
+		t.Errorf("after two failures %+v", s)
+	}
+	c := s.Update(m, Result{Time: start.Add(2 * time.Minute)})
+	if !c.Changed || !c.Since.Equal(start) || s.Up || s.Streak != 0 || !s.StreakStart.IsZero() {
+		t.Errorf("after three failures %+v, %+v", c, s)
+	}
+	s.Update(m, Result{Time: start.Add(3 * time.Minute)})
+	if s.Streak != 0 || !s.StreakStart.IsZero() {
+		t.Errorf("a result that agrees with the state leaves a streak: %+v", s)
+	}
+}
+
+func TestFlips(t *testing.T) {
+	for recent, want := range map[string]int{
+		"":       0,
+		"+":      0,
+		"++++":   0,
+		"++--":   1,
+		"+-+":    2,
+		"-+-+-+": 5,
+	} {
+		s := State{Recent: recent}
+		if got := s.Flips(); got != want {
+			t.Errorf("Flips of %q = %d, want %d", recent, got, want)
+		}
+	}
+}
+
+func TestChangeNotify(t *testing.T) {
+	tests := []struct {
+		c    Change
+		want bool
+	}{
+		{Change{}, false},
+		{Change{Changed: true}, true},
+		{Change{Changed: true, Flapping: true}, false},
+		{Change{Flapping: true}, false},
+		{Change{Changed: true, Flapping: true, FlapStarted: true}, true},
+		{Change{FlapEnded: true}, true},
+		{Change{Changed: true, FlapEnded: true}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Notify(); got != tt.want {
+			t.Errorf("%+v Notify = %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,955 @@
+package checks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service is a check as the server keeps it: its settings, its state and
+// how it was last checked. Every chat that watches a target shares one
+// Service, directly or through the teams it is shared with.
+type Service struct {
+	Monitor
+	State
+	LastChecked time.Time
+	LastLatency time.Duration // of the last successful check
+	LastError   string        // of the last failed check
+	Watchers    int
+	Teams       []string
+}
+
+type checkJSON struct {
+	ID              int        `json:"id"`
+	Type            string     `json:"type"`
+	Target          string     `json:"target"`
+	IntervalSeconds int        `json:"interval_seconds"`
+	TimeoutSeconds  int        `json:"timeout_seconds"`
+	Retries         int        `json:"retries"`
+	DownAfter       int        `json:"down_after"`
+	UpAfter         int        `json:"up_after"`
+	Options         Options    `json:"options"`
+	Up              bool       `json:"up"`
+	Flapping        bool       `json:"flapping"`
+	Recent          string     `json:"recent"`
+	LastChecked     *time.Time `json:"last_checked,omitempty"`
+	LastLatencyMs   *float64   `json:"last_latency_ms,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	Watchers        int        `json:"watchers"`
+	Teams           []string   `json:"teams"`
+}
+
+// MarshalJSON gives durations in seconds and the latency in
+// milliseconds, like Report.
+func (c Service) MarshalJSON() ([]byte, error) {
+	j := checkJSON{
+		ID:              c.ID,
+		Type:            c.Type,
+		Target:          c.Target,
+		IntervalSeconds: int(c.Interval.Seconds()),
+		TimeoutSeconds:  int(c.Timeout.Seconds()),
+		Retries:         c.Retries,
+		DownAfter:       c.DownAfter,
+		UpAfter:         c.UpAfter,
+		Options:         c.Options,
+		Up:              c.Up,
+		Flapping:        c.Flapping,
+		Recent:          c.Recent,
+		LastError:       c.LastError,
+		Watchers:        c.Watchers,
+		Teams:           c.Teams,
+	}
+	if j.Teams == nil {
+		j.Teams = []string{}
+	}
+	if !c.LastChecked.IsZero() {
+		j.LastChecked = &c.LastChecked
+	}
+	if c.LastLatency > 0 {
+		ms := float64(c.LastLatency.Microseconds()) / 1000
+		j.LastLatencyMs = &ms
+	}
+	return json.Marshal(j)
+}
+
+func (c *Service) UnmarshalJSON(data []byte) error {
+	var j checkJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*c = Service{
+		Monitor: Monitor{
+			ID:        j.ID,
+			Type:      j.Type,
+			Target:    j.Target,
+			Interval:  time.Duration(j.IntervalSeconds) * time.Second,
+			Timeout:   time.Duration(j.TimeoutSeconds) * time.Second,
+			Retries:   j.Retries,
+			DownAfter: j.DownAfter,
+			UpAfter:   j.UpAfter,
+			Options:   j.Options,
+		},
+		State:     State{Up: j.Up, Flapping: j.Flapping, Recent: j.Recent},
+		LastError: j.LastError,
+		Watchers:  j.Watchers,
+		Teams:     j.Teams,
+	}
+	if j.LastChecked != nil {
+		c.LastChecked = *j.LastChecked
+	}
+	if j.LastLatencyMs != nil {
+		c.LastLatency = time.Duration(*j.LastLatencyMs * float64(time.Millisecond))
+	}
+	return nil
+}
+
+type resultJSON struct {
+	CheckID   int       `json:"check_id"`
+	Time      time.Time `json:"time"`
+	Up        bool      `json:"up"`
+	LatencyMs float64   `json:"latency_ms"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (r Result) MarshalJSON() ([]byte, error) {
+	j := resultJSON{r.MonitorID, r.Time, r.Up, float64(r.Latency.Microseconds()) / 1000, r.Attempts, ""}
+	if r.Err != nil {
+		j.Error = r.Err.Error()
+	}
+	return json.Marshal(j)
+}
+
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var j resultJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*r = Result{MonitorID: j.CheckID, Time: j.Time, Up: j.Up, Latency: time.Duration(j.LatencyMs * float64(time.Millisecond)), Attempts: j.Attempts}
+	if j.Error != "" {
+		r.Err = errors.New(j.Error)
+	}
+	return nil
+}
+
+// Team is a named group of chats that see the same checks.
+type Team struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	Checks  int    `json:"checks"`
+	// Member is whether the chat that asked is in the team.
+	Member bool `json:"member"`
+}
+
+// Errors of Store lookups.
+var (
+	ErrNotFound  = errors.New("no such check")
+	ErrAmbiguous = errors.New("several checks have that target, use the #ID")
+	ErrNoTeam    = errors.New("no such team")
+	ErrNotMember = errors.New("not a member of the team")
+	ErrNotShared = errors.New("not shared with the team")
+	ErrTeamName  = errors.New("team names are up to 32 lowercase letters, digits, - and _")
+)
+
+// Store keeps checks, their results and outages, and who watches them in
+// the server's database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open creates the tables the store needs.
+func Open(db *sql.DB) (*Store, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS checks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_by INTEGER NOT NULL DEFAULT 0,
+		type TEXT NOT NULL DEFAULT 'tcp',
+		target TEXT NOT NULL,
+		interval_seconds INTEGER NOT NULL DEFAULT 30,
+		timeout_seconds INTEGER NOT NULL DEFAULT 5,
+		retries INTEGER NOT NULL DEFAULT 1,
+		down_after INTEGER NOT NULL DEFAULT 2,
+		up_after INTEGER NOT NULL DEFAULT 1,
+		options TEXT NOT NULL DEFAULT '{}',
+		up BOOLEAN NOT NULL DEFAULT 1,
+		flapping BOOLEAN NOT NULL DEFAULT 0,
+		streak INTEGER NOT NULL DEFAULT 0,
+		streak_start INTEGER,
+		recent TEXT NOT NULL DEFAULT '',
+		last_checked INTEGER,
+		last_latency_ms REAL,
+		last_error TEXT NOT NULL DEFAULT '',
+		UNIQUE(type, target)
+	);
+	CREATE TABLE IF NOT EXISTS check_watchers (
+		check_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		added_at INTEGER NOT NULL,
+		PRIMARY KEY (check_id, chat_id)
+	);
+	CREATE INDEX IF NOT EXISTS check_watchers_chat ON check_watchers(chat_id);
+	CREATE TABLE IF NOT EXISTS check_teams (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		created_by INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS check_team_members (
+		team_id INTEGER NOT NULL,
+		chat_id INTEGER NOT NULL,
+		PRIMARY KEY (team_id, chat_id)
+	);
+	CREATE TABLE IF NOT EXISTS check_team_checks (
+		team_id INTEGER NOT NULL,
+		check_id INTEGER NOT NULL,
+		PRIMARY KEY (team_id, check_id)
+	);
+	CREATE TABLE IF NOT EXISTS check_results (
+		check_id INTEGER NOT NULL,
+		checked_at INTEGER NOT NULL,
+		up BOOLEAN NOT NULL,
+		latency_ms REAL NOT NULL,
+		attempts INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS check_results_check_time ON check_results(check_id, checked_at);
+	CREATE TABLE IF NOT EXISTS check_outages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		check_id INTEGER NOT NULL,
+		started_at INTEGER NOT NULL,
+		ended_at INTEGER,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS check_outages_check_time ON check_outages(check_id, started_at);
+	CREATE TABLE IF NOT EXISTS check_chat_settings (
+		chat_id INTEGER PRIMARY KEY,
+		weekly_summary BOOLEAN NOT NULL DEFAULT 1
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+const checkColumns = `id, type, target, interval_seconds, timeout_seconds, retries, down_after, up_after, options,
+	up, flapping, streak, streak_start, recent, last_checked, last_latency_ms, last_error,
+	(SELECT COUNT(*) FROM check_watchers w WHERE w.check_id = checks.id)`
+
+// visibleTo is the condition for the checks a chat sees: the ones it
+// watches and the ones shared with its teams. It takes the chat ID twice.
+const visibleTo = `(id IN (SELECT check_id FROM check_watchers WHERE chat_id = ?)
+	OR id IN (SELECT tc.check_id FROM check_team_checks tc JOIN check_team_members mb ON mb.team_id = tc.team_id WHERE mb.chat_id = ?))`
+
+func scanCheck(row interface{ Scan(...interface{}) error }) (Service, error) {
+	var c Service
+	var interval, timeout int
+	var options string
+	var streakStart, lastChecked sql.NullInt64
+	var latency sql.NullFloat64
+	err := row.Scan(&c.ID, &c.Type, &c.Target, &interval, &timeout, &c.Retries, &c.DownAfter, &c.UpAfter, &options,
+		&c.Up, &c.Flapping, &c.Streak, &streakStart, &c.Recent, &lastChecked, &latency, &c.LastError, &c.Watchers)
+	if err != nil {
+		return c, err
+	}
+	c.Interval = time.Duration(interval) * time.Second
+	c.Timeout = time.Duration(timeout) * time.Second
+	if streakStart.Valid {
+		c.StreakStart = time.Unix(streakStart.Int64, 0)
+	}
+	if lastChecked.Valid {
+		c.LastChecked = time.Unix(lastChecked.Int64, 0)
+	}
+	if latency.Valid {
+		c.LastLatency = time.Duration(latency.Float64 * float64(time.Millisecond))
+	}
+	return c, json.Unmarshal([]byte(options), &c.Options)
+}
+
+// query returns the checks matching where, with their teams.
+func (s *Store) query(where string, args ...interface{}) ([]Service, error) {
+	rows, err := s.db.Query("SELECT "+checkColumns+" FROM checks WHERE "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Service{}
+	index := map[int]int{}
+	for rows.Next() {
+		c, err := scanCheck(rows)
+		if err != nil {
+			return nil, err
+		}
+		index[c.ID] = len(list)
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	teams, err := s.db.Query(`SELECT tc.check_id, t.name FROM check_teams t JOIN check_team_checks tc ON tc.team_id = t.id ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer teams.Close()
+	for teams.Next() {
+		var id int
+		var name string
+		if err := teams.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			list[i].Teams = append(list[i].Teams, name)
+		}
+	}
+	return list, teams.Err()
+}
+
+// List returns the checks chatID sees, or all of them when chatID is 0.
+func (s *Store) List(chatID int64) ([]Service, error) {
+	if chatID == 0 {
+		return s.query("1 = 1")
+	}
+	return s.query(visibleTo, chatID, chatID)
+}
+
+func (s *Store) Get(id int) (Service, error) {
+	list, err := s.query("id = ?", id)
+	if err != nil {
+		return Service{}, err
+	}
+	if len(list) == 0 {
+		return Service{}, ErrNotFound
+	}
+	return list[0], nil
+}
+
+// Find looks a check up by #ID or target, among the ones chatID sees or,
+// with visible false, all of them.
+func (s *Store) Find(chatID int64, ref string, visible bool) (Service, error) {
+	where := "target = ?"
+	args := []interface{}{ref}
+	if id, err := strconv.Atoi(strings.TrimPrefix(ref, "#")); err == nil {
+		where, args = "id = ?", []interface{}{id}
+	}
+	if visible {
+		where += " AND " + visibleTo
+		args = append(args, chatID, chatID)
+	}
+	list, err := s.query(where, args...)
+	switch {
+	case err != nil:
+		return Service{}, err
+	case len(list) == 0:
+		return Service{}, ErrNotFound
+	case len(list) > 1:
+		return Service{}, ErrAmbiguous
+	}
+	return list[0], nil
+}
+
+// Monitors returns every check's settings, for the Scheduler.
+func (s *Store) Monitors() ([]Monitor, error) {
+	list, err := s.query("1 = 1")
+	if err != nil {
+		return nil, err
+	}
+	monitors := make([]Monitor, len(list))
+	for i, c := range list {
+		monitors[i] = c.Monitor
+	}
+	return monitors, nil
+}
+
+// Add adds a check watched by chatID. If the target is already checked,
+// the chat watches that check instead and added is false.
+func (s *Store) Add(chatID int64, m Monitor) (c Service, added bool, err error) {
+	var id int
+	err = s.db.QueryRow("SELECT id FROM checks WHERE type = ? AND target = ?", m.Type, m.Target).Scan(&id)
+	if err == nil {
+		if err := s.Watch(chatID, id); err != nil {
+			return c, false, err
+		}
+		c, err = s.Get(id)
+		return c, false, err
+	}
+	if err != sql.ErrNoRows {
+		return c, false, err
+	}
+
+	options, err := json.Marshal(m.Options)
+	if err != nil {
+		return c, false, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return c, false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO checks (created_by, type, target, interval_seconds, timeout_seconds, retries, down_after, up_after, options)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		chatID, m.Type, m.Target, int(m.Interval.Seconds()), int(m.Timeout.Seconds()), m.Retries, m.DownAfter, m.UpAfter, string(options))
+	if err != nil {
+		return c, false, err
+	}
+	newID, _ := res.LastInsertId()
+	if chatID != 0 {
+		if _, err := tx.Exec("INSERT INTO check_watchers (check_id, chat_id, added_at) VALUES (?, ?, ?)", newID, chatID, time.Now().Unix()); err != nil {
+			return c, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c, false, err
+	}
+	c, err = s.Get(int(newID))
+	return c, true, err
+}
+
+// Update saves a check's settings. The state is left alone.
+func (s *Store) Update(m Monitor) error {
+	options, err := json.Marshal(m.Options)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE checks SET interval_seconds = ?, timeout_seconds = ?, retries = ?, down_after = ?, up_after = ?, options = ?
+		WHERE id = ?`, int(m.Interval.Seconds()), int(m.Timeout.Seconds()), m.Retries, m.DownAfter, m.UpAfter, string(options), m.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes a check with its watchers, history and outages.
+func (s *Store) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM checks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	for _, table := range []string{"check_watchers", "check_team_checks", "check_results", "check_outages"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE check_id = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Watch(chatID int64, id int) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO check_watchers (check_id, chat_id, added_at) VALUES (?, ?, ?)", id, chatID, time.Now().Unix())
+	return err
+}
+
+// Unwatch stops chatID watching a check, and deletes the check if that
+// leaves nobody watching it.
+func (s *Store) Unwatch(chatID int64, id int) (deleted bool, err error) {
+	if _, err := s.db.Exec("DELETE FROM check_watchers WHERE check_id = ? AND chat_id = ?", id, chatID); err != nil {
+		return false, err
+	}
+	return s.deleteIfUnwatched(id)
+}
+
+// deleteIfUnwatched deletes a check that no chat or team watches.
+func (s *Store) deleteIfUnwatched(id int) (bool, error) {
+	var watched bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM check_watchers WHERE check_id = ?)
+		OR EXISTS (SELECT 1 FROM check_team_checks WHERE check_id = ?)`, id, id).Scan(&watched)
+	if err != nil || watched {
+		return false, err
+	}
+	if err := s.Delete(id); err != nil && err != ErrNotFound {
+		return false, err
+	}
+	return true, nil
+}
+
+// Recipients returns the chats to tell about each check: its watchers
+// and the members of the teams it is shared with.
+func (s *Store) Recipients() (map[int][]int64, error) {
+	rows, err := s.db.Query(`SELECT check_id, chat_id FROM check_watchers
+		UNION SELECT tc.check_id, mb.chat_id FROM check_team_members mb JOIN check_team_checks tc ON tc.team_id = mb.team_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chats := map[int][]int64{}
+	for rows.Next() {
+		var id int
+		var chatID int64
+		if err := rows.Scan(&id, &chatID); err != nil {
+			return nil, err
+		}
+		chats[id] = append(chats[id], chatID)
+	}
+	return chats, rows.Err()
+}
+
+// ValidTeamName allows short names that are easy to type in a command.
+func ValidTeamName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) teamID(name string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM check_teams WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoTeam
+	}
+	return id, err
+}
+
+// Share adds a check to a team, creating the team with chatID as its
+// first member if there is none of that name.
+func (s *Store) Share(chatID int64, id int, team string) error {
+	if !ValidTeamName(team) {
+		return ErrTeamName
+	}
+	_, err := s.db.Exec("INSERT OR IGNORE INTO check_teams (name, created_by, created_at) VALUES (?, ?, ?)", team, chatID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	teamID, err := s.teamID(team)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("INSERT OR IGNORE INTO check_team_members (team_id, chat_id) VALUES (?, ?)", teamID, chatID); err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT OR IGNORE INTO check_team_checks (team_id, check_id) VALUES (?, ?)", teamID, id)
+	return err
+}
+
+// Unshare takes a check out of a team, and deletes it if nobody else
+// watches it.
+func (s *Store) Unshare(id int, team string) (deleted bool, err error) {
+	teamID, err := s.teamID(team)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.Exec("DELETE FROM check_team_checks WHERE team_id = ? AND check_id = ?", teamID, id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, ErrNotShared
+	}
+	return s.deleteIfUnwatched(id)
+}
+
+// JoinTeam adds chatID to a team and returns how many checks it has.
+func (s *Store) JoinTeam(chatID int64, team string) (int, error) {
+	id, err := s.teamID(team)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec("INSERT OR IGNORE INTO check_team_members (team_id, chat_id) VALUES (?, ?)", id, chatID); err != nil {
+		return 0, err
+	}
+	var n int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM check_team_checks WHERE team_id = ?", id).Scan(&n)
+	return n, err
+}
+
+// LeaveTeam takes chatID out of a team. The last member leaving deletes
+// the team, and with it the checks nobody else watches.
+func (s *Store) LeaveTeam(chatID int64, team string) error {
+	id, err := s.teamID(team)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec("DELETE FROM check_team_members WHERE team_id = ? AND chat_id = ?", id, chatID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotMember
+	}
+	var members int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM check_team_members WHERE team_id = ?", id).Scan(&members); err != nil || members > 0 {
+		return err
+	}
+
+	var checks []int
+	rows, err := s.db.Query("SELECT check_id FROM check_team_checks WHERE team_id = ?", id)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c int
+		if rows.Scan(&c) == nil {
+			checks = append(checks, c)
+		}
+	}
+	rows.Close()
+	if _, err := s.db.Exec("DELETE FROM check_team_checks WHERE team_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM check_teams WHERE id = ?", id); err != nil {
+		return err
+	}
+	for _, c := range checks {
+		if _, err := s.deleteIfUnwatched(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Teams lists every team, marking the ones chatID is in.
+func (s *Store) Teams(chatID int64) ([]Team, error) {
+	rows, err := s.db.Query(`SELECT t.name,
+		(SELECT COUNT(*) FROM check_team_members WHERE team_id = t.id),
+		(SELECT COUNT(*) FROM check_team_checks WHERE team_id = t.id),
+		EXISTS (SELECT 1 FROM check_team_members WHERE team_id = t.id AND chat_id = ?)
+		FROM check_teams t ORDER BY t.name`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	teams := []Team{}
+	for rows.Next() {
+		var t Team
+		if err := rows.Scan(&t.Name, &t.Members, &t.Checks, &t.Member); err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+	return teams, rows.Err()
+}
+
+// Record stores a check result, updates the check's state and keeps the
+// outage log. The Scheduler never checks one monitor twice at once, so
+// results for a check come in one at a time.
+func (s *Store) Record(r Result) (Change, error) {
+	c, err := s.Get(r.MonitorID)
+	if err != nil {
+		// ErrNotFound: the check was deleted while it was being run.
+		return Change{}, err
+	}
+	latency := float64(r.Latency.Microseconds()) / 1000
+	errText := ""
+	if r.Err != nil {
+		errText = r.Err.Error()
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Change{}, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO check_results (check_id, checked_at, up, latency_ms, attempts, error) VALUES (?, ?, ?, ?, ?, ?)`,
+		c.ID, r.Time.Unix(), r.Up, latency, r.Attempts, errText)
+	if err != nil {
+		return Change{}, err
+	}
+
+	change := c.State.Update(c.Monitor, r)
+	var streakStart interface{}
+	if !c.StreakStart.IsZero() {
+		streakStart = c.StreakStart.Unix()
+	}
+	if r.Up {
+		_, err = tx.Exec(`UPDATE checks SET last_checked = ?, last_latency_ms = ? WHERE id = ?`, r.Time.Unix(), latency, c.ID)
+	} else {
+		_, err = tx.Exec(`UPDATE checks SET last_checked = ?, last_error = ? WHERE id = ?`, r.Time.Unix(), errText, c.ID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE checks SET up = ?, flapping = ?, streak = ?, streak_start = ?, recent = ? WHERE id = ?`,
+			c.Up, c.Flapping, c.Streak, streakStart, c.Recent, c.ID)
+	}
+	if err != nil {
+		return Change{}, err
+	}
+
+	if change.Changed {
+		if c.Up {
+			_, err = tx.Exec(`UPDATE check_outages SET ended_at = ? WHERE check_id = ? AND ended_at IS NULL`, change.Since.Unix(), c.ID)
+		} else {
+			_, err = tx.Exec(`INSERT INTO check_outages (check_id, started_at, error) VALUES (?, ?, ?)`, c.ID, change.Since.Unix(), errText)
+		}
+		if err != nil {
+			return Change{}, err
+		}
+	}
+	return change, tx.Commit()
+}
+
+// Results returns a check's latest results, newest first.
+func (s *Store) Results(id, limit int) ([]Result, error) {
+	rows, err := s.db.Query(`SELECT checked_at, up, latency_ms, attempts, error FROM check_results
+		WHERE check_id = ? ORDER BY checked_at DESC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []Result{}
+	for rows.Next() {
+		var at int64
+		var latency float64
+		var errText string
+		r := Result{MonitorID: id}
+		if err := rows.Scan(&at, &r.Up, &latency, &r.Attempts, &errText); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(at, 0)
+		r.Latency = time.Duration(latency * float64(time.Millisecond))
+		if errText != "" {
+			r.Err = errors.New(errText)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Outages returns the latest outages, newest first, of one check, of the
+// checks chatID sees, or of all checks when both are 0.
+func (s *Store) Outages(chatID int64, id, limit int) ([]Outage, error) {
+	query := `SELECT o.check_id, c.target, o.started_at, o.ended_at, o.error FROM check_outages o JOIN checks c ON c.id = o.check_id WHERE 1 = 1`
+	var args []interface{}
+	if chatID != 0 {
+		query += ` AND o.check_id IN (SELECT id FROM checks WHERE ` + visibleTo + `)`
+		args = append(args, chatID, chatID)
+	}
+	if id != 0 {
+		query += " AND o.check_id = ?"
+		args = append(args, id)
+	}
+	rows, err := s.db.Query(query+" ORDER BY o.started_at DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	outages := []Outage{}
+	for rows.Next() {
+		var o Outage
+		var started int64
+		var ended sql.NullInt64
+		if err := rows.Scan(&o.CheckID, &o.Target, &started, &ended, &o.Error); err != nil {
+			return nil, err
+		}
+		o.Start = time.Unix(started, 0)
+		if ended.Valid {
+			o.End = time.Unix(ended.Int64, 0)
+		}
+		outages = append(outages, o)
+	}
+	return outages, rows.Err()
+}
+
+// Report builds a check's uptime report for the period up to to.
+func (s *Store) Report(id int, to time.Time, period time.Duration) (Report, error) {
+	from := to.Add(-period)
+	rows, err := s.db.Query(`SELECT checked_at, up, latency_ms FROM check_results
+		WHERE check_id = ? AND checked_at >= ? AND checked_at < ?`, id, from.Unix(), to.Unix())
+	if err != nil {
+		return Report{}, err
+	}
+	var samples []Sample
+	for rows.Next() {
+		var at int64
+		var smp Sample
+		var latency float64
+		if err := rows.Scan(&at, &smp.Up, &latency); err != nil {
+			rows.Close()
+			return Report{}, err
+		}
+		smp.Time = time.Unix(at, 0)
+		smp.Latency = time.Duration(latency * float64(time.Millisecond))
+		samples = append(samples, smp)
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`SELECT started_at, ended_at, error FROM check_outages
+		WHERE check_id = ? AND started_at < ? AND (ended_at IS NULL OR ended_at > ?)`, id, to.Unix(), from.Unix())
+	if err != nil {
+		return Report{}, err
+	}
+	defer rows.Close()
+	var outages []Outage
+	for rows.Next() {
+		var started int64
+		var ended sql.NullInt64
+		o := Outage{CheckID: id}
+		if err := rows.Scan(&started, &ended, &o.Error); err != nil {
+			return Report{}, err
+		}
+		o.Start = time.Unix(started, 0)
+		if ended.Valid {
+			o.End = time.Unix(ended.Int64, 0)
+		}
+		outages = append(outages, o)
+	}
+	return Summarize(from, to, samples, outages), rows.Err()
+}
+
+// SetSummary turns a chat's weekly uptime summary on or off.
+func (s *Store) SetSummary(chatID int64, on bool) error {
+	_, err := s.db.Exec(`INSERT INTO check_chat_settings (chat_id, weekly_summary) VALUES (?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET weekly_summary = excluded.weekly_summary`, chatID, on)
+	return err
+}
+
+// SummaryChats returns the chats that watch something and did not turn
+// the weekly summary off.
+func (s *Store) SummaryChats() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT chat_id FROM check_watchers UNION SELECT chat_id FROM check_team_members
+		EXCEPT SELECT chat_id FROM check_chat_settings WHERE NOT weekly_summary`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chats := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		chats = append(chats, id)
+	}
+	return chats, rows.Err()
+}
+
+// Prune deletes results and finished outages from before cutoff.
+func (s *Store) Prune(cutoff time.Time) error {
+	if _, err := s.db.Exec("DELETE FROM check_results WHERE checked_at < ?", cutoff.Unix()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM check_outages WHERE ended_at < ?", cutoff.Unix())
+	return err
+}
+
+// Import copies the monitors, watchers, teams, results and outages of
+// the standalone monitor bot's database at path, keeping their IDs. It
+// does nothing if the store already has checks, so it is safe to leave
+// configured. Databases of every monitor bot release can be imported:
+// settings an older one lacks take their defaults, and the owner of a
+// monitor from before shared monitors becomes its first watcher.
+func (s *Store) Import(ctx context.Context, path string) (imported int, err error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM checks").Scan(&n); err != nil || n > 0 {
+		return 0, err
+	}
+	// ATTACH is per connection and not allowed in a transaction, so the
+	// import holds on to one connection.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS old", path); err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE old")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	columns, err := oldColumns(ctx, tx, "monitors")
+	if err != nil {
+		return 0, fmt.Errorf("importing %s: %v", path, err)
+	}
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("importing %s: no monitors table", path)
+	}
+	tables := map[string]bool{}
+	for _, table := range []string{"watchers", "teams", "team_members", "team_monitors", "check_results", "outages", "chat_settings"} {
+		cols, err := oldColumns(ctx, tx, table)
+		if err != nil {
+			return 0, fmt.Errorf("importing %s: %v", path, err)
+		}
+		tables[table] = len(cols) > 0
+	}
+
+	into := []string{"id", "created_by", "target", "up"}
+	from := []string{"id", "created_by", "target", "COALESCE(up, 1)"}
+	if !columns["created_by"] {
+		from[1] = "COALESCE(user_id, 0)"
+	}
+	for _, col := range importedColumns {
+		if columns[col] {
+			into = append(into, col)
+			from = append(from, col)
+		}
+	}
+	stmts := []string{
+		`INSERT INTO checks (` + strings.Join(into, ", ") + `)
+			SELECT ` + strings.Join(from, ", ") + ` FROM old.monitors WHERE target IS NOT NULL`,
+	}
+	switch {
+	case tables["watchers"]:
+		stmts = append(stmts, `INSERT INTO check_watchers (check_id, chat_id, added_at) SELECT monitor_id, chat_id, added_at FROM old.watchers`)
+	case columns["user_id"]:
+		stmts = append(stmts, `INSERT OR IGNORE INTO check_watchers (check_id, chat_id, added_at)
+			SELECT id, user_id, strftime('%s', 'now') FROM old.monitors WHERE user_id IS NOT NULL AND target IS NOT NULL`)
+	}
+	for _, t := range []struct{ table, stmt string }{
+		{"teams", `INSERT INTO check_teams (id, name, created_by, created_at) SELECT id, name, created_by, created_at FROM old.teams`},
+		{"team_members", `INSERT INTO check_team_members (team_id, chat_id) SELECT team_id, chat_id FROM old.team_members`},
+		{"team_monitors", `INSERT INTO check_team_checks (team_id, check_id) SELECT team_id, monitor_id FROM old.team_monitors`},
+		{"check_results", `INSERT INTO check_results (check_id, checked_at, up, latency_ms, attempts, error)
+			SELECT monitor_id, checked_at, up, latency_ms, attempts, error FROM old.check_results`},
+		{"outages", `INSERT INTO check_outages (check_id, started_at, ended_at, error) SELECT monitor_id, started_at, ended_at, error FROM old.outages`},
+		{"chat_settings", `INSERT INTO check_chat_settings (chat_id, weekly_summary) SELECT chat_id, weekly_summary FROM old.chat_settings`},
+	} {
+		if tables[t.table] {
+			stmts = append(stmts, t.stmt)
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return 0, fmt.Errorf("importing %s: %v", path, err)
+		}
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM checks").Scan(&imported); err != nil {
+		return 0, err
+	}
+	return imported, tx.Commit()
+}
+
+// importedColumns are the monitors columns that Import copies when the
+// old database has them. The first monitor bot had none of them.
+var importedColumns = []string{
+	"type", "interval_seconds", "timeout_seconds", "retries", "down_after", "up_after", "options",
+	"flapping", "streak", "streak_start", "recent", "last_checked", "last_latency_ms",
+}
+
+// oldColumns returns the names of the columns of table in the attached
+// database, none if it has no such table.
+func oldColumns(ctx context.Context, tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?, 'old')", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,269 @@
+package checks
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "gpu_inventory.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// monitorsDB writes a monitor bot database with the given schema and
+// rows and returns its path.
+func monitorsDB(t *testing.T, stmts ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "monitors.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return path
+}
+
+// The schema of the first monitor bot: every target once, owned by the
+// chat in user_id.
+const baselineSchema = `CREATE TABLE monitors (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	target TEXT UNIQUE,
+	up BOOLEAN DEFAULT 1
+)`
+
+// The schema before shared monitors, with the settings, results and
+// outages added on top of the first one.
+const ownedSchema = `CREATE TABLE monitors (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	target TEXT UNIQUE,
+	up BOOLEAN DEFAULT 1,
+	type TEXT NOT NULL DEFAULT 'tcp',
+	interval_seconds INTEGER NOT NULL DEFAULT 30,
+	timeout_seconds INTEGER NOT NULL DEFAULT 5,
+	retries INTEGER NOT NULL DEFAULT 1,
+	last_checked INTEGER,
+	last_latency_ms REAL,
+	options TEXT NOT NULL DEFAULT '{}',
+	down_after INTEGER NOT NULL DEFAULT 2,
+	up_after INTEGER NOT NULL DEFAULT 1,
+	flapping BOOLEAN NOT NULL DEFAULT 0,
+	streak INTEGER NOT NULL DEFAULT 0,
+	streak_start INTEGER,
+	recent TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE check_results (
+	monitor_id INTEGER NOT NULL,
+	checked_at INTEGER NOT NULL,
+	up BOOLEAN NOT NULL,
+	latency_ms REAL NOT NULL,
+	attempts INTEGER NOT NULL,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE outages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	monitor_id INTEGER NOT NULL,
+	started_at INTEGER NOT NULL,
+	ended_at INTEGER,
+	error TEXT NOT NULL DEFAULT ''
+)`
+
+// The schema of the last standalone monitor bot, with shared monitors,
+// watchers, teams and summary settings.
+const sharedSchema = `CREATE TABLE monitors (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_by INTEGER NOT NULL DEFAULT 0,
+	target TEXT NOT NULL,
+	up BOOLEAN DEFAULT 1,
+	type TEXT NOT NULL DEFAULT 'tcp',
+	interval_seconds INTEGER NOT NULL DEFAULT 30,
+	timeout_seconds INTEGER NOT NULL DEFAULT 5,
+	retries INTEGER NOT NULL DEFAULT 1,
+	last_checked INTEGER,
+	last_latency_ms REAL,
+	options TEXT NOT NULL DEFAULT '{}',
+	down_after INTEGER NOT NULL DEFAULT 2,
+	up_after INTEGER NOT NULL DEFAULT 1,
+	flapping BOOLEAN NOT NULL DEFAULT 0,
+	streak INTEGER NOT NULL DEFAULT 0,
+	streak_start INTEGER,
+	recent TEXT NOT NULL DEFAULT '',
+	UNIQUE(type, target)
+);
+CREATE TABLE watchers (monitor_id INTEGER NOT NULL, chat_id INTEGER NOT NULL, added_at INTEGER NOT NULL, PRIMARY KEY (monitor_id, chat_id));
+CREATE TABLE teams (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, created_by INTEGER NOT NULL, created_at INTEGER NOT NULL);
+CREATE TABLE team_members (team_id INTEGER NOT NULL, chat_id INTEGER NOT NULL, PRIMARY KEY (team_id, chat_id));
+CREATE TABLE team_monitors (team_id INTEGER NOT NULL, monitor_id INTEGER NOT NULL, PRIMARY KEY (team_id, monitor_id));
+CREATE TABLE check_results (
+	monitor_id INTEGER NOT NULL,
+	checked_at INTEGER NOT NULL,
+	up BOOLEAN NOT NULL,
+	latency_ms REAL NOT NULL,
+	attempts INTEGER NOT NULL,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE outages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	monitor_id INTEGER NOT NULL,
+	started_at INTEGER NOT NULL,
+	ended_at INTEGER,
+	error TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE chat_settings (chat_id INTEGER PRIMARY KEY, weekly_summary BOOLEAN NOT NULL DEFAULT 1);
+CREATE TABLE bot_state (key TEXT PRIMARY KEY, value TEXT NOT NULL)`
+
+// targets lists the targets of the checks chatID sees.
+func targets(t *testing.T, s *Store, chatID int64) string {
+	t.Helper()
+	list, err := s.List(chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, c := range list {
+		out = append(out, c.Target)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestImportBaseline(t *testing.T) {
+	path := monitorsDB(t, baselineSchema,
+		`INSERT INTO monitors (id, user_id, target, up) VALUES
+			(3, 100, '10.0.0.1:22', 1),
+			(5, 200, '10.0.0.2:443', 0),
+			(8, NULL, '10.0.0.3:80', NULL)`)
+	s := openStore(t)
+	n, err := s.Import(context.Background(), path)
+	if err != nil || n != 3 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+
+	c, err := s.Get(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != TCP || c.Target != "10.0.0.2:443" || c.Up || c.Interval != DefaultInterval ||
+		c.Timeout != DefaultTimeout || c.Retries != DefaultRetries || c.DownAfter != DefaultDownAfter {
+		t.Errorf("imported %+v", c)
+	}
+	if c, _ := s.Get(8); !c.Up {
+		t.Error("a monitor without a state is down")
+	}
+	// The owners watch their monitors.
+	if got := targets(t, s, 100); got != "10.0.0.1:22" {
+		t.Errorf("chat 100 sees %q", got)
+	}
+	if got := targets(t, s, 200); got != "10.0.0.2:443" {
+		t.Errorf("chat 200 sees %q", got)
+	}
+}
+
+func TestImportOwned(t *testing.T) {
+	path := monitorsDB(t, ownedSchema,
+		`INSERT INTO monitors (id, user_id, target, up, type, interval_seconds, retries, options, down_after, last_checked, last_latency_ms) VALUES
+			(1, 100, 'https://example.org', 1, 'http', 60, 3, '{"status":200}', 3, 1760862600, 12.5),
+			(2, 200, '10.0.0.2:443', 0, 'tcp', 30, 1, '{}', 2, 1760862600, NULL)`,
+		`INSERT INTO check_results VALUES (1, 1760862600, 1, 12.5, 1, ''), (2, 1760862600, 0, 5000, 2, 'i/o timeout')`,
+		`INSERT INTO outages (monitor_id, started_at, ended_at, error) VALUES (2, 1760860000, NULL, 'i/o timeout')`)
+	s := openStore(t)
+	n, err := s.Import(context.Background(), path)
+	if err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+
+	c, err := s.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != HTTP || c.Interval != time.Minute || c.Retries != 3 || c.Status != 200 || c.DownAfter != 3 ||
+		!c.LastChecked.Equal(time.Unix(1760862600, 0)) || c.LastLatency != 12500*time.Microsecond {
+		t.Errorf("imported %+v", c)
+	}
+	if got := targets(t, s, 100); got != "https://example.org" {
+		t.Errorf("chat 100 sees %q", got)
+	}
+	results, err := s.Results(2, 10)
+	if err != nil || len(results) != 1 || results[0].Err == nil || results[0].Err.Error() != "i/o timeout" {
+		t.Errorf("results %+v, %v", results, err)
+	}
+	outages, err := s.Outages(200, 0, 10)
+	if err != nil || len(outages) != 1 || outages[0].CheckID != 2 || !outages[0].End.IsZero() {
+		t.Errorf("outages %+v, %v", outages, err)
+	}
+}
+
+func TestImportShared(t *testing.T) {
+	path := monitorsDB(t, sharedSchema,
+		`INSERT INTO monitors (id, created_by, target, up, type) VALUES
+			(1, 100, '10.0.0.1:22', 1, 'tcp'),
+			(2, 100, '10.0.0.1:22', 1, 'tls')`,
+		`INSERT INTO watchers VALUES (1, 100, 1760000000), (1, 200, 1760000000), (2, 300, 1760000000)`,
+		`INSERT INTO teams VALUES (4, 'infra', 100, 1760000000)`,
+		`INSERT INTO team_members VALUES (4, 400)`,
+		`INSERT INTO team_monitors VALUES (4, 2)`,
+		`INSERT INTO check_results VALUES (1, 1760862600, 1, 0.8, 1, '')`,
+		`INSERT INTO chat_settings VALUES (200, 0)`)
+	s := openStore(t)
+	n, err := s.Import(context.Background(), path)
+	if err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+
+	for chat, want := range map[int64]string{100: "10.0.0.1:22", 200: "10.0.0.1:22", 300: "10.0.0.1:22", 400: "10.0.0.1:22"} {
+		if got := targets(t, s, chat); got != want {
+			t.Errorf("chat %d sees %q, want %q", chat, got, want)
+		}
+	}
+	if c, err := s.Get(2); err != nil || c.Type != TLS || len(c.Teams) != 1 || c.Teams[0] != "infra" {
+		t.Errorf("check 2 is %+v, %v", c, err)
+	}
+	if results, err := s.Results(1, 10); err != nil || len(results) != 1 {
+		t.Errorf("results %+v, %v", results, err)
+	}
+	chats, err := s.SummaryChats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chat := range chats {
+		if chat == 200 {
+			t.Error("chat 200 turned weekly summaries off")
+		}
+	}
+}
+
+func TestImportOnce(t *testing.T) {
+	path := monitorsDB(t, baselineSchema, `INSERT INTO monitors (user_id, target) VALUES (100, '10.0.0.1:22')`)
+	s := openStore(t)
+	if n, err := s.Import(context.Background(), path); err != nil || n != 1 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+	if n, err := s.Import(context.Background(), path); err != nil || n != 0 {
+		t.Errorf("second Import = %d, %v", n, err)
+	}
+
+	empty := monitorsDB(t, `CREATE TABLE bot_state (key TEXT PRIMARY KEY, value TEXT NOT NULL)`)
+	if _, err := openStore(t).Import(context.Background(), empty); err == nil || !strings.Contains(err.Error(), "no monitors table") {
+		t.Errorf("Import of a database without monitors: %v", err)
+	}
+}
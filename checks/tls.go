@@ -0,0 +1,40 @@
+package checks
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// checkTLS does a TLS handshake with the target and fails once its
+// certificate expires within WarnDays, so there is time to renew it.
+func checkTLS(ctx context.Context, m Monitor) (time.Duration, error) {
+	host, _, err := net.SplitHostPort(m.Target)
+	if err != nil {
+		return 0, err
+	}
+	d := tls.Dialer{Config: &tls.Config{ServerName: host, InsecureSkipVerify: m.Insecure}}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", m.Target)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return latency, fmt.Errorf("no certificate")
+	}
+	notAfter := certs[0].NotAfter
+	left := time.Until(notAfter)
+	switch {
+	case left <= 0:
+		return latency, fmt.Errorf("certificate expired on %s", notAfter.UTC().Format("2006-01-02"))
+	case left < time.Duration(m.WarnDays)*24*time.Hour:
+		return latency, fmt.Errorf("certificate expires in %d days, on %s", int(left.Hours()/24), notAfter.UTC().Format("2006-01-02"))
+	}
+	return latency, nil
+}
@@ -0,0 +1,95 @@
+package checks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tlsServer serves TLS on 127.0.0.1 with a self-signed certificate valid
+// until notAfter.
+func tlsServer(t *testing.T, notAfter time.Time) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func TestCheckTLS(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	expires := func(d time.Duration) string { return now.Add(d).UTC().Format("2006-01-02") }
+	tests := []struct {
+		name     string
+		left     time.Duration
+		warnDays int
+		err      string // empty if the check should pass
+	}{
+		{"valid", 60 * day, 14, ""},
+		{"just outside the warning", 15 * day, 14, ""},
+		{"expires soon", 5*day + time.Hour, 14, "certificate expires in 5 days, on " + expires(5*day+time.Hour)},
+		{"expires today", time.Hour, 14, "certificate expires in 0 days, on " + expires(time.Hour)},
+		{"custom warning", 20 * day, 30, "certificate expires in 19 days"},
+		{"expired", -2 * day, 14, "certificate expired on " + expires(-2*day)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Monitor{
+				Type:    TLS,
+				Target:  tlsServer(t, now.Add(tt.left)),
+				Timeout: 5 * time.Second,
+				Options: Options{WarnDays: tt.warnDays, Insecure: true},
+			}
+			_, err := Check(context.Background(), m)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Check: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("Check: %v, want %s...", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckTLSVerify(t *testing.T) {
+	m := Monitor{
+		Type:    TLS,
+		Target:  tlsServer(t, time.Now().Add(60*24*time.Hour)),
+		Timeout: 5 * time.Second,
+		Options: Options{WarnDays: 14},
+	}
+	if _, err := Check(context.Background(), m); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Check of a self-signed certificate: %v", err)
+	}
+}
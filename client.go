@@ -1,42 +1,201 @@
+// client.go is a Nagios/Icinga compatible check plugin for the collector:
+//
+//	go build -o check_gpumon client.go
+//	check_gpumon -server http://gpumon:1101 -host node-01 -gpu-temp-warn 80 -gpu-temp-crit 85
+//
+// It prints one status line with perfdata, one line per problem, and
+// exits 0/1/2/3 for OK/WARNING/CRITICAL/UNKNOWN. Thresholds use the
+// plugin range syntax: "90" alerts above 90, "10:" below 10, "@10:20"
+// inside 10..20, "~:" means no lower bound. An empty range turns the
+// threshold off.
 package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"gpu-monitor/report"
+)
+
+const (
+	stateOK = iota
+	stateWarning
+	stateCritical
+	stateUnknown
 )
 
-type GPUReport struct {
-	Index         int     `json:"index"`
-	Name          string  `json:"name"`
-	TemperatureC  int     `json:"temperature_c"`
-	ProcessCount  int     `json:"process_count"`
+var stateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// threshold is a plugin range. A value alerts when it lies outside
+// [start, end], or inside it for "@" ranges.
+type threshold struct {
+	text       string
+	start, end float64
+	inside     bool
+}
+
+func (t *threshold) String() string {
+	if t == nil {
+		return ""
+	}
+	return t.text
+}
+
+func (t *threshold) Set(s string) error {
+	orig := s
+	r := threshold{text: s, start: 0, end: math.Inf(1)}
+	if strings.HasPrefix(s, "@") {
+		r.inside = true
+		s = s[1:]
+	}
+	lo, hi, hasColon := strings.Cut(s, ":")
+	if !hasColon {
+		lo, hi = "0", lo
+	}
+	var err error
+	switch lo {
+	case "~":
+		r.start = math.Inf(-1)
+	case "":
+		r.start = 0
+	default:
+		if r.start, err = strconv.ParseFloat(lo, 64); err != nil {
+			return fmt.Errorf("bad range %q", orig)
+		}
+	}
+	if hi != "" {
+		if r.end, err = strconv.ParseFloat(hi, 64); err != nil {
+			return fmt.Errorf("bad range %q", orig)
+		}
+	}
+	if r.start > r.end {
+		return fmt.Errorf("bad range %q: start is above end", orig)
+	}
+	*t = r
+	return nil
 }
 
-type HostReport struct {
-	Hostname        string  `json:"hostname"`
-	CPUUsagePercent float64 `json:"cpu_usage_percent"`
-	MemoryUsedMB    int     `json:"memory_used_mb"`
-	MemoryTotalMB   int     `json:"memory_total_mb"`
-	DiskUsed        string  `json:"disk_used"`  // e.g., "40G"
-	DiskTotal       string  `json:"disk_total"` // e.g., "100G"
+func (t *threshold) alerts(v float64) bool {
+	if t.text == "" {
+		return false
+	}
+	outside := v < t.start || v > t.end
+	return outside != t.inside
+}
+
+// metricThresholds is a warning/critical pair for one metric, set from
+// flags named NAME-warn and NAME-crit.
+type metricThresholds struct {
+	warn, crit threshold
+}
+
+func newMetric(name, usage, warn, crit string) *metricThresholds {
+	m := &metricThresholds{}
+	if warn != "" {
+		m.warn.Set(warn)
+	}
+	if crit != "" {
+		m.crit.Set(crit)
+	}
+	flag.Var(&m.warn, name+"-warn", "warning range for "+usage)
+	flag.Var(&m.crit, name+"-crit", "critical range for "+usage)
+	return m
+}
+
+func (m *metricThresholds) state(v float64) int {
+	switch {
+	case m.crit.alerts(v):
+		return stateCritical
+	case m.warn.alerts(v):
+		return stateWarning
+	}
+	return stateOK
+}
+
+var (
+	serverURL = flag.String("server", "http://192.168.0.1:1101", "collector server URL")
+	timeout   = flag.Duration("timeout", 10*time.Second, "timeout for the API requests")
+	hostName  = flag.String("host", "", "only check this host")
+	gpuSel    = flag.String("gpu", "", "only check this GPU: index on the selected host, UUID or full name")
+	selector  = flag.String("selector", "", "only check hosts matching this label selector")
+	check     = flag.String("check", "all", "what to check: gpu, host or all")
+	idleState = flag.String("idle", "warning", "state for GPUs without processes: ok, warning or critical")
+
+	// Temperatures are whole degrees, so "89" is critical from 90°C on,
+	// where the server's gpu_temperature alert fires.
+	gpuTemp    = newMetric("gpu-temp", "GPU temperature in °C", "85", "89")
+	gpuUtil    = newMetric("gpu-util", "GPU utilization in %", "", "")
+	gpuMem     = newMetric("gpu-mem", "GPU memory used in %", "", "")
+	gpuPower   = newMetric("gpu-power", "GPU power draw in W", "", "")
+	hostCPU    = newMetric("cpu", "host CPU usage in %", "80", "90")
+	hostMemory = newMetric("mem", "host memory used in %", "80", "90")
+	hostDisk   = newMetric("disk", "host disk used in %", "80", "90")
+	age        = newMetric("age", "seconds since the last report", "300", "900")
+)
+
+// result collects the worst state, problem lines and perfdata.
+type result struct {
+	state    int
+	problems []string
+	perfdata []string
+}
+
+func (r *result) add(state int, format string, args ...interface{}) {
+	if state == stateOK {
+		return
+	}
+	if state > r.state {
+		r.state = state
+	}
+	r.problems = append(r.problems, stateNames[state]+": "+fmt.Sprintf(format, args...))
 }
 
-func fetchJSON(url string, target interface{}) error {
-	resp, err := http.Get(url)
+// metric checks v against m and records it as perfdata.
+func (r *result) metric(label string, v float64, uom string, m *metricThresholds, min, max string) {
+	r.add(m.state(v), "%s is %s%s", label, formatValue(v), uom)
+	r.perfdata = append(r.perfdata, fmt.Sprintf("'%s'=%s%s;%s;%s;%s;%s",
+		label, formatValue(v), uom, m.warn.text, m.crit.text, min, max))
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func unknown(format string, args ...interface{}) {
+	fmt.Printf("GPUMON UNKNOWN - "+format+"\n", args...)
+	os.Exit(stateUnknown)
+}
+
+func fetchJSON(path string, target interface{}) error {
+	client := &http.Client{Timeout: *timeout}
+	u := *serverURL + path
+	if *selector != "" {
+		u += "?" + url.Values{"selector": {*selector}}.Encode()
+	}
+	resp, err := client.Get(u)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	return json.Unmarshal(body, target)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
 }
 
 func parseGB(s string) float64 {
 	// Very basic: assumes "XXG" format, doesn't handle TiB/MiB etc.
+	if s == "" {
+		return 0
+	}
 	val, err := strconv.ParseFloat(s[:len(s)-1], 64)
 	if err != nil {
 		return 0
@@ -44,74 +203,121 @@ func parseGB(s string) float64 {
 	return val
 }
 
-func checkGPUHealth(gpus []GPUReport) bool {
-	allHealthy := true
+// ageSeconds returns how long ago a report was sent. A time that does
+// not parse leaves nothing to check the age against, which is UNKNOWN.
+func ageSeconds(label, updatedAt string, now time.Time) float64 {
+	t, err := time.Parse(time.RFC3339, updatedAt)
+	if err != nil {
+		unknown("%s: bad report time %q", label, updatedAt)
+	}
+	return math.Round(now.Sub(t).Seconds())
+}
+
+func selectGPU(gpu report.GPUReport) bool {
+	if *hostName != "" && gpu.Host() != *hostName {
+		return false
+	}
+	if *gpuSel == "" {
+		return true
+	}
+	return *gpuSel == gpu.Name || *gpuSel == gpu.UUID || *gpuSel == strconv.Itoa(gpu.Index)
+}
+
+func checkGPUs(r *result, gpus []report.GPUReport, idle int, now time.Time) int {
+	checked := 0
 	for _, gpu := range gpus {
+		if !selectGPU(gpu) {
+			continue
+		}
+		checked++
+		prefix := fmt.Sprintf("%s:gpu%d", gpu.Host(), gpu.Index)
+
 		if gpu.ProcessCount < 1 {
-			fmt.Printf("[GPU] ❌ GPU %s has no active processes\n", gpu.Name)
-			allHealthy = false
+			r.add(idle, "%s has no active processes", prefix)
 		}
-		if gpu.TemperatureC >= 90 {
-			fmt.Printf("[GPU] 🔥 GPU %s is too hot (%d°C)\n", gpu.Name, gpu.TemperatureC)
-			allHealthy = false
+		r.metric(prefix+"_temp", float64(gpu.TemperatureC), "C", gpuTemp, "", "")
+		r.metric(prefix+"_util", float64(gpu.UtilizationGpuPercent), "%", gpuUtil, "0", "100")
+		memPercent := 0.0
+		if gpu.MemoryTotalMiB > 0 {
+			memPercent = math.Round(float64(gpu.MemoryUsedMiB)/float64(gpu.MemoryTotalMiB)*1000) / 10
 		}
+		r.metric(prefix+"_mem", memPercent, "%", gpuMem, "0", "100")
+		r.metric(prefix+"_power", gpu.PowerWatt, "W", gpuPower, "0", "")
+		r.metric(prefix+"_age", ageSeconds(prefix, gpu.UpdatedAt, now), "s", age, "0", "")
 	}
-	if allHealthy {
-		fmt.Println("[GPU] ✅ All GPUs are healthy")
-	}
-	return allHealthy
+	return checked
 }
 
-func checkHostHealth(hosts []HostReport) bool {
-	allHealthy := true
+func checkHosts(r *result, hosts []report.HostReport, now time.Time) int {
+	checked := 0
 	for _, host := range hosts {
-		memPercent := float64(host.MemoryUsedMB) / float64(host.MemoryTotalMB) * 100
-		diskUsed := parseGB(host.DiskUsed)
-		diskTotal := parseGB(host.DiskTotal)
-		diskPercent := (diskUsed / diskTotal) * 100
-
-		if host.CPUUsagePercent >= 90 {
-			fmt.Printf("[Host %s] ⚠️ CPU usage too high: %.1f%%\n", host.Hostname, host.CPUUsagePercent)
-			allHealthy = false
+		if *hostName != "" && host.Hostname != *hostName {
+			continue
 		}
-		if memPercent >= 90 {
-			fmt.Printf("[Host %s] ⚠️ Memory usage too high: %.1f%%\n", host.Hostname, memPercent)
-			allHealthy = false
+		checked++
+
+		r.metric(host.Hostname+":cpu", math.Round(host.CPUUsagePercent*10)/10, "%", hostCPU, "", "")
+		if host.MemoryTotalMB > 0 {
+			memPercent := float64(host.MemoryUsedMB) / float64(host.MemoryTotalMB) * 100
+			r.metric(host.Hostname+":mem", math.Round(memPercent*10)/10, "%", hostMemory, "0", "100")
 		}
-		if diskPercent >= 90 {
-			fmt.Printf("[Host %s] ⚠️ Disk usage too high: %.1f%%\n", host.Hostname, diskPercent)
-			allHealthy = false
+		if diskTotal := parseGB(host.DiskTotal); diskTotal > 0 {
+			diskPercent := parseGB(host.DiskUsed) / diskTotal * 100
+			r.metric(host.Hostname+":disk", math.Round(diskPercent*10)/10, "%", hostDisk, "0", "100")
 		}
+		r.metric(host.Hostname+":age", ageSeconds(host.Hostname, host.UpdatedAt, now), "s", age, "0", "")
 	}
-	if allHealthy {
-		fmt.Println("[Host] ✅ All hosts are healthy")
-	}
-	return allHealthy
+	return checked
 }
 
 func main() {
-	gpus := []GPUReport{}
-	err := fetchJSON("http://192.168.0.1:1101/gpu/list", &gpus)
-	if err != nil {
-		fmt.Println("❌ System has issues")
-		log.Fatal("Failed to fetch GPU list:", err)
+	// Bad usage must be UNKNOWN, not the exit status 2 (CRITICAL) the flag
+	// package would use.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		os.Exit(stateUnknown)
 	}
 
-	hosts := []HostReport{}
-	err = fetchJSON("http://192.168.0.1:1101/host/list", &hosts)
-	if err != nil {
-		fmt.Println("❌ System has issues")
-		log.Fatal("Failed to fetch host metrics:", err)
+	idle := map[string]int{"ok": stateOK, "warning": stateWarning, "critical": stateCritical}
+	idleResult, ok := idle[*idleState]
+	if !ok {
+		unknown("-idle must be ok, warning or critical")
+	}
+	if *check != "all" && *check != "gpu" && *check != "host" {
+		unknown("-check must be gpu, host or all")
 	}
 
-	gpuOk := checkGPUHealth(gpus)
-	hostOk := checkHostHealth(hosts)
+	now := time.Now()
+	var r result
+	var gpusChecked, hostsChecked int
 
-	if gpuOk && hostOk {
-		fmt.Println("✅ System is healthy")
-	} else {
-		fmt.Println("❌ System has issues")
+	if *check != "host" {
+		gpus := []report.GPUReport{}
+		if err := fetchJSON("/gpu/list", &gpus); err != nil {
+			unknown("failed to fetch GPU list: %v", err)
+		}
+		gpusChecked = checkGPUs(&r, gpus, idleResult, now)
+	}
+	// A specific GPU is a GPU check; the host metrics would only add noise.
+	if *check != "gpu" && *gpuSel == "" {
+		hosts := []report.HostReport{}
+		if err := fetchJSON("/host/list", &hosts); err != nil {
+			unknown("failed to fetch host metrics: %v", err)
+		}
+		hostsChecked = checkHosts(&r, hosts, now)
 	}
-}
 
+	if gpusChecked == 0 && hostsChecked == 0 {
+		unknown("no hosts or GPUs match the selection")
+	}
 
+	summary := fmt.Sprintf("%d GPUs, %d hosts checked", gpusChecked, hostsChecked)
+	if len(r.problems) > 0 {
+		summary = fmt.Sprintf("%d problem(s), %s", len(r.problems), summary)
+	}
+	fmt.Printf("GPUMON %s - %s | %s\n", stateNames[r.state], summary, strings.Join(r.perfdata, " "))
+	for _, p := range r.problems {
+		fmt.Println(p)
+	}
+	os.Exit(r.state)
+}
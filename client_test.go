@@ -0,0 +1,197 @@
+// The repository root holds several programs, so its tests are run one
+// program at a time:
+//
+//	go test client.go client_test.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"gpu-monitor/report"
+)
+
+// pluginArgsEnv makes the test binary run the plugin with these
+// arguments, one per line, so that tests can see its exit status.
+const pluginArgsEnv = "CHECK_GPUMON_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(pluginArgsEnv); ok {
+		os.Args = append([]string{"check_gpumon"}, strings.Split(args, "\n")...)
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+func TestThresholdSet(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		in         string
+		start, end float64
+		inside     bool
+		err        string // empty if the range is valid
+	}{
+		{"90", 0, 90, false, ""},
+		{"10:", 10, inf, false, ""},
+		{"10:20", 10, 20, false, ""},
+		{"~:20", math.Inf(-1), 20, false, ""},
+		{"~:", math.Inf(-1), inf, false, ""},
+		{":20", 0, 20, false, ""},
+		{"@10:20", 10, 20, true, ""},
+		{"@90", 0, 90, true, ""},
+		{"-5:5", -5, 5, false, ""},
+		{"1.5", 0, 1.5, false, ""},
+		{"", 0, inf, false, ""},
+		{"20:10", 0, 0, false, `bad range "20:10": start is above end`},
+		{"-1", 0, 0, false, `bad range "-1": start is above end`},
+		{"abc", 0, 0, false, `bad range "abc"`},
+		{"10:abc", 0, 0, false, `bad range "10:abc"`},
+		{"@", 0, inf, true, ""},
+	}
+	for _, tt := range tests {
+		var th threshold
+		err := th.Set(tt.in)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Set(%q): %v", tt.in, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("Set(%q): %v, want %s", tt.in, err, tt.err)
+		case tt.err == "" && (th.start != tt.start || th.end != tt.end || th.inside != tt.inside || th.text != tt.in):
+			t.Errorf("Set(%q) = %+v", tt.in, th)
+		}
+	}
+}
+
+func TestThresholdAlerts(t *testing.T) {
+	tests := []struct {
+		in      string
+		alerts  []float64
+		quiet   []float64
+		comment string
+	}{
+		{"90", []float64{-1, 90.5, 91}, []float64{0, 89, 90}, "outside 0..90"},
+		{"89", []float64{90}, []float64{89}, "the default critical GPU temperature"},
+		{"10:", []float64{9.9, -3}, []float64{10, 1000}, "below 10"},
+		{"~:20", []float64{21}, []float64{-100, 20}, "above 20, no lower bound"},
+		{"@10:20", []float64{10, 15, 20}, []float64{9, 21}, "inside 10..20"},
+		{"", nil, []float64{-1e9, 0, 1e9}, "an empty range never alerts"},
+	}
+	for _, tt := range tests {
+		var th threshold
+		if err := th.Set(tt.in); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range tt.alerts {
+			if !th.alerts(v) {
+				t.Errorf("%q (%s) does not alert at %v", tt.in, tt.comment, v)
+			}
+		}
+		for _, v := range tt.quiet {
+			if th.alerts(v) {
+				t.Errorf("%q (%s) alerts at %v", tt.in, tt.comment, v)
+			}
+		}
+	}
+}
+
+func TestMetricState(t *testing.T) {
+	var m metricThresholds
+	m.warn.Set("80")
+	m.crit.Set("90")
+	for v, want := range map[float64]int{50: stateOK, 80: stateOK, 81: stateWarning, 90: stateWarning, 91: stateCritical} {
+		if got := m.state(v); got != want {
+			t.Errorf("state(%v) = %s, want %s", v, stateNames[got], stateNames[want])
+		}
+	}
+}
+
+// collector serves the GPU and host lists the plugin fetches.
+func collector(t *testing.T, gpus []report.GPUReport, hosts []report.HostReport) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gpu/list":
+			json.NewEncoder(w).Encode(gpus)
+		case "/host/list":
+			json.NewEncoder(w).Encode(hosts)
+		default:
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// plugin runs the plugin and returns its exit status and output.
+func plugin(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), pluginArgsEnv+"="+strings.Join(args, "\n"))
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		return exit.ExitCode(), string(out)
+	case err != nil:
+		t.Fatal(err)
+	}
+	return 0, string(out)
+}
+
+func TestExitStatus(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	gpu := func(temp, processes int, updatedAt string) report.GPUReport {
+		return report.GPUReport{
+			Name: "0@node-01@NVIDIA H100", TemperatureC: temp, ProcessCount: processes,
+			MemoryUsedMiB: 1024, MemoryTotalMiB: 81920, UpdatedAt: updatedAt,
+		}
+	}
+	host := report.HostReport{
+		Hostname: "node-01", CPUUsagePercent: 12.5, MemoryUsedMB: 1000, MemoryTotalMB: 10000,
+		DiskUsed: "10G", DiskTotal: "100G", UpdatedAt: now,
+	}
+	healthy := collector(t, []report.GPUReport{gpu(50, 1, now)}, []report.HostReport{host})
+
+	tests := []struct {
+		name   string
+		server string
+		args   []string
+		status int
+		output string // the start of the status line
+	}{
+		{"ok", healthy, nil, stateOK, "GPUMON OK - 1 GPUs, 1 hosts checked | 'node-01:gpu0_temp'=50C;85;89;;"},
+		{"warning", collector(t, []report.GPUReport{gpu(86, 1, now)}, nil), nil, stateWarning,
+			"GPUMON WARNING - 1 problem(s), 1 GPUs, 0 hosts checked"},
+		{"idle", collector(t, []report.GPUReport{gpu(50, 0, now)}, nil), nil, stateWarning, "GPUMON WARNING - 1 problem(s)"},
+		{"idle is ok", collector(t, []report.GPUReport{gpu(50, 0, now)}, nil), []string{"-idle", "ok"}, stateOK, "GPUMON OK"},
+		{"critical at 90°C", collector(t, []report.GPUReport{gpu(90, 1, now)}, nil), nil, stateCritical,
+			"GPUMON CRITICAL - 1 problem(s)"},
+		{"custom threshold", healthy, []string{"-gpu-temp-crit", "40"}, stateCritical, "GPUMON CRITICAL"},
+		{"threshold off", collector(t, []report.GPUReport{gpu(95, 1, now)}, nil), []string{"-gpu-temp-warn", "", "-gpu-temp-crit", ""},
+			stateOK, "GPUMON OK"},
+		{"stale", collector(t, []report.GPUReport{gpu(50, 1, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))}, nil), nil,
+			stateCritical, "GPUMON CRITICAL"},
+		{"bad report time", collector(t, []report.GPUReport{gpu(50, 1, "yesterday")}, nil), nil, stateUnknown,
+			`GPUMON UNKNOWN - node-01:gpu0: bad report time "yesterday"`},
+		{"no match", healthy, []string{"-host", "node-02"}, stateUnknown, "GPUMON UNKNOWN - no hosts or GPUs match the selection"},
+		{"server down", collector(t, nil, nil) + "/down", nil, stateUnknown, "GPUMON UNKNOWN - failed to fetch GPU list: /gpu/list: 503"},
+		{"bad range", healthy, []string{"-gpu-temp-crit", "20:10"}, stateUnknown, ""},
+		{"bad flag", healthy, []string{"-idle", "sometimes"}, stateUnknown, "GPUMON UNKNOWN - -idle must be ok, warning or critical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, out := plugin(t, append([]string{"-server", tt.server}, tt.args...)...)
+			if status != tt.status || !strings.HasPrefix(out, tt.output) {
+				t.Errorf("exit %d, output\n%s\nwant exit %d, output %s...", status, out, tt.status, tt.output)
+			}
+		})
+	}
+}
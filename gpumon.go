@@ -0,0 +1,718 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"gpu-monitor/api"
+	"gpu-monitor/output"
+	"gpu-monitor/report"
+	"gpu-monitor/telegram"
+	"gpu-monitor/top"
+)
+
+const usage = `Usage: gpumon COMMAND [flags]
+
+Commands:
+  gpus        GPUs and their current metrics
+  hosts       host CPU, memory and disk usage
+  hardware    hardware inventory per host
+  health      overall health; exits 1 when there are alerts
+  alerts      active alerts
+  processes   processes running on each GPU
+  history     GPU or host metrics over time
+  top         full-screen dashboard that refreshes live
+  pair        create a code that links a Telegram chat to the bot
+  pairings    pairing codes and the chats that used them
+  chatid      Telegram chats that recently messaged the bot
+  incident    open or update an incident on the status page
+  incidents   status page incidents and their latest update
+  checks      service checks and whether they are up
+
+Flags for every command:
+  -server URL       collector server (default $GPUMON_SERVER or http://localhost:1101)
+  -token TOKEN      bearer token (default $GPUMON_TOKEN)
+  -output FORMAT    table, json, yaml or csv (default table)
+  -columns A,B,C    columns to show, or "all"
+  -sort COLUMN      sort by a column, prefix with - for descending
+  -no-headers       omit the header line in table and csv output
+  -selector SEL     only hosts matching a label selector, e.g. cluster=training
+
+Run "gpumon COMMAND -h" for the command's own flags and columns.
+`
+
+// command builds a table from the API. Columns lists what is shown by
+// default; the table itself may carry more.
+type command struct {
+	columns []string
+	flags   func(fs *flag.FlagSet)
+	run     func(c *api.Client, selector string) (*output.Table, error)
+}
+
+var (
+	historyKind  string
+	historyName  string
+	historyHost  string
+	historySince time.Duration
+	historyStep  time.Duration
+	severity     string
+	botToken     string
+)
+
+var commands = map[string]command{
+	"gpus": {
+		columns: []string{"host", "index", "model", "util", "temp", "mem_used", "mem_total", "power", "procs"},
+		run:     gpus,
+	},
+	"hosts": {
+		columns: []string{"hostname", "cpu", "mem_used", "mem_total", "disk_used", "disk_total", "updated"},
+		run:     hosts,
+	},
+	"hardware": {
+		columns: []string{"hostname", "distro", "kernel", "uptime"},
+		run:     hardware,
+	},
+	"health": {
+		columns: []string{"status", "alerts", "critical", "warning"},
+		run:     health,
+	},
+	"alerts": {
+		columns: []string{"severity", "rule", "host", "gpu", "message"},
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&severity, "severity", "", "only alerts of this severity: warning or critical")
+		},
+		run: alertList,
+	},
+	"processes": {
+		columns: []string{"host", "gpu", "instance", "process"},
+		run:     processes,
+	},
+	"history": {
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&historyKind, "kind", "gpu", "gpu or host history")
+			fs.StringVar(&historyName, "gpu", "", "only this GPU (full name)")
+			fs.StringVar(&historyHost, "host", "", "only this host")
+			fs.DurationVar(&historySince, "since", time.Hour, "how far back to go")
+			fs.DurationVar(&historyStep, "step", 0, "average samples over buckets of this size")
+		},
+		run: history,
+	},
+	"pairings": {
+		columns: []string{"id", "role", "subscriptions", "note", "expires", "used", "chat"},
+		run:     pairings,
+	},
+	"incidents": {
+		columns: []string{"id", "status", "title", "components", "updated", "latest"},
+		run:     incidents,
+	},
+	"checks": {
+		columns: []string{"id", "type", "target", "status", "latency", "checked", "error"},
+		run:     checkList,
+	},
+	"chatid": {
+		columns: []string{"chat_id", "type", "title", "from", "last_seen", "text"},
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&botToken, "bot-token", os.Getenv("TELEGRAM_BOT_TOKEN"), "Telegram bot token")
+		},
+		run: chatIDs,
+	},
+}
+
+// subscribeFlags collects repeated -subscribe SEVERITY[:SELECTOR] flags.
+type subscribeFlags []report.PairingSubscription
+
+func (f *subscribeFlags) String() string { return "" }
+
+func (f *subscribeFlags) Set(v string) error {
+	sev, sel, _ := strings.Cut(v, ":")
+	*f = append(*f, report.PairingSubscription{Severity: sev, Selector: sel})
+	return nil
+}
+
+// runPair creates a pairing code and prints how to use it.
+func runPair(args []string) {
+	fs := flag.NewFlagSet("gpumon pair", flag.ExitOnError)
+	server := fs.String("server", envOr("GPUMON_SERVER", "http://localhost:1101"), "collector server URL")
+	token := fs.String("token", os.Getenv("GPUMON_TOKEN"), "admin token")
+	role := fs.String("role", "viewer", "role the chat gets: viewer, operator or admin")
+	ttl := fs.Duration("ttl", 24*time.Hour, "how long the code is valid, at most 168h")
+	note := fs.String("note", "", "who the code is for, shown by gpumon pairings")
+	var subs subscribeFlags
+	fs.Var(&subs, "subscribe", "subscribe the chat to alerts, as SEVERITY or SEVERITY:SELECTOR, e.g. critical:env=prod (repeatable)")
+	fs.Parse(args)
+
+	code, err := api.New(*server, *token).CreatePairingCode(report.PairingRequest{
+		Role:          *role,
+		Subscriptions: subs,
+		Note:          *note,
+		TTL:           ttl.String(),
+	})
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Pairing code %s for the %s role, valid until %s.\n", code.Code, code.Role, code.ExpiresAt)
+	for _, sub := range code.Subscriptions {
+		scope := "all hosts"
+		if sub.Selector != "" {
+			scope = sub.Selector
+		}
+		fmt.Printf("Subscribes to %s alerts and up on %s.\n", sub.Severity, scope)
+	}
+	fmt.Printf("\nSend this to the bot from the chat to link, once:\n  /pair %s\n", code.Code)
+}
+
+// runIncident opens an incident, or with -id posts an update to one.
+func runIncident(args []string) {
+	fs := flag.NewFlagSet("gpumon incident", flag.ExitOnError)
+	server := fs.String("server", envOr("GPUMON_SERVER", "http://localhost:1101"), "collector server URL")
+	token := fs.String("token", os.Getenv("GPUMON_TOKEN"), "admin token")
+	id := fs.Int64("id", 0, "update this incident instead of opening one")
+	title := fs.String("title", "", "title of a new incident")
+	status := fs.String("status", "", "investigating, identified, monitoring or resolved (default investigating for a new incident)")
+	message := fs.String("message", "", "what is going on, shown on the status page")
+	components := fs.String("components", "", "comma-separated status page components the incident affects")
+	fs.Parse(args)
+
+	c := api.New(*server, *token)
+	var inc report.Incident
+	var err error
+	if *id != 0 {
+		if *status == "" {
+			fatal(errors.New("-status is required with -id"))
+		}
+		inc, err = c.UpdateIncident(report.IncidentUpdateRequest{ID: *id, Status: *status, Message: *message})
+	} else {
+		req := report.IncidentRequest{Title: *title, Status: *status, Message: *message}
+		for _, name := range strings.Split(*components, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				req.Components = append(req.Components, name)
+			}
+		}
+		inc, err = c.CreateIncident(req)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("Incident %d %q is %s.\n", inc.ID, inc.Title, inc.Status)
+}
+
+// runTop starts the dashboard. It takes the connection flags but none of
+// the output ones, since it draws the screen itself.
+func runTop(args []string) {
+	fs := flag.NewFlagSet("gpumon top", flag.ExitOnError)
+	server := fs.String("server", envOr("GPUMON_SERVER", "http://localhost:1101"), "collector server URL")
+	token := fs.String("token", os.Getenv("GPUMON_TOKEN"), "bearer token")
+	selector := fs.String("selector", "", "label selector, e.g. cluster=training,env!=staging")
+	filter := fs.String("filter", "", "only GPUs whose name, host or label values contain this")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	fs.Parse(args)
+	if *interval < 500*time.Millisecond {
+		fatal(fmt.Errorf("-interval must be at least 500ms"))
+	}
+
+	err := top.Run(api.New(*server, *token), top.Options{
+		Interval: *interval,
+		Selector: *selector,
+		Filter:   *filter,
+	})
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	switch name {
+	case "top":
+		runTop(os.Args[2:])
+		return
+	case "pair":
+		runPair(os.Args[2:])
+		return
+	case "incident":
+		runIncident(os.Args[2:])
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("gpumon "+name, flag.ExitOnError)
+	server := fs.String("server", envOr("GPUMON_SERVER", "http://localhost:1101"), "collector server URL")
+	token := fs.String("token", os.Getenv("GPUMON_TOKEN"), "bearer token")
+	format := fs.String("output", "table", "output format: "+strings.Join(output.Formats, ", "))
+	columns := fs.String("columns", "", "comma-separated columns to show, or \"all\"")
+	sortBy := fs.String("sort", "", "column to sort by, prefix with - for descending")
+	noHeaders := fs.Bool("no-headers", false, "omit the header line")
+	selector := fs.String("selector", "", "label selector, e.g. cluster=training,env!=staging")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(os.Args[2:])
+
+	table, err := cmd.run(api.New(*server, *token), *selector)
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && *token == "" {
+		fatal(fmt.Errorf("%v (%s needs the admin token: set -token or $GPUMON_TOKEN)", err, name))
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	switch {
+	case *columns == "all":
+	case *columns != "":
+		err = table.Select(strings.Split(*columns, ","))
+	case cmd.columns != nil:
+		err = table.Select(cmd.columns)
+	}
+	if err == nil && *sortBy != "" {
+		err = table.Sort(*sortBy)
+	}
+	if err == nil {
+		err = output.Write(os.Stdout, table, *format, !*noHeaders)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	if name == "health" && table.Rows[0]["status"] != "ok" {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "gpumon:", err)
+	os.Exit(2)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// splitName splits a "slot@host@model" GPU name.
+func splitName(name string) (host, model string) {
+	parts := strings.SplitN(name, "@", 3)
+	if len(parts) != 3 {
+		return "", name
+	}
+	return parts[1], parts[2]
+}
+
+func gpus(c *api.Client, selector string) (*output.Table, error) {
+	list, err := c.GPUs(selector)
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{
+		"name", "host", "index", "model", "vendor", "uuid", "bus_id", "util", "temp", "fan",
+		"mem_used", "mem_total", "power", "power_limit", "procs", "processes", "mig",
+		"throttle", "xid", "labels", "updated",
+	}}
+	for _, g := range list {
+		host, model := splitName(g.Name)
+		t.Rows = append(t.Rows, output.Row{
+			"name":        g.Name,
+			"host":        host,
+			"index":       g.Index,
+			"model":       model,
+			"vendor":      g.Vendor,
+			"uuid":        g.UUID,
+			"bus_id":      g.PCIBusID,
+			"util":        g.UtilizationGpuPercent,
+			"temp":        g.TemperatureC,
+			"fan":         g.FanPercent,
+			"mem_used":    g.MemoryUsedMiB,
+			"mem_total":   g.MemoryTotalMiB,
+			"power":       g.PowerWatt,
+			"power_limit": g.PowerLimitWatt,
+			"procs":       g.ProcessCount,
+			"processes":   g.ProcessNames,
+			"mig":         g.MIGMode,
+			"throttle":    g.ThrottleReasons,
+			"xid":         g.XIDErrors,
+			"labels":      g.Labels,
+			"updated":     g.UpdatedAt,
+		})
+	}
+	return t, nil
+}
+
+func hosts(c *api.Client, selector string) (*output.Table, error) {
+	list, err := c.Hosts(selector)
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{
+		"hostname", "cpu", "mem_used", "mem_total", "disk_used", "disk_total", "labels", "updated",
+	}}
+	for _, h := range list {
+		t.Rows = append(t.Rows, output.Row{
+			"hostname":   h.Hostname,
+			"cpu":        h.CPUUsagePercent,
+			"mem_used":   h.MemoryUsedMB,
+			"mem_total":  h.MemoryTotalMB,
+			"disk_used":  h.DiskUsed,
+			"disk_total": h.DiskTotal,
+			"labels":     h.Labels,
+			"updated":    h.UpdatedAt,
+		})
+	}
+	return t, nil
+}
+
+func hardware(c *api.Client, selector string) (*output.Table, error) {
+	list, err := c.Hardware()
+	if err != nil {
+		return nil, err
+	}
+	// /hardware/list has no selector support; filter on the hosts the
+	// selector matches instead.
+	var keep map[string]bool
+	if selector != "" {
+		matched, err := c.Hosts(selector)
+		if err != nil {
+			return nil, err
+		}
+		keep = map[string]bool{}
+		for _, h := range matched {
+			keep[h.Hostname] = true
+		}
+	}
+
+	t := &output.Table{Columns: []string{
+		"hostname", "uptime", "kernel", "distro", "cpu", "memory", "storage", "pci", "usb",
+	}}
+	for _, hw := range list {
+		if keep != nil && !keep[hw.Hostname] {
+			continue
+		}
+		t.Rows = append(t.Rows, output.Row{
+			"hostname": hw.Hostname,
+			"uptime":   hw.Uptime,
+			"kernel":   hw.Kernel,
+			"distro":   hw.Distro,
+			"cpu":      hw.CPU,
+			"memory":   hw.Memory,
+			"storage":  hw.Storage,
+			"pci":      hw.PCI,
+			"usb":      hw.USB,
+		})
+	}
+	return t, nil
+}
+
+func health(c *api.Client, selector string) (*output.Table, error) {
+	h, err := c.Health(selector)
+	if err != nil {
+		return nil, err
+	}
+	status := "ok"
+	if !h.Healthy {
+		status = "unhealthy"
+	}
+	counts := map[string]int{}
+	for _, a := range h.Alerts {
+		counts[string(a.Severity)]++
+	}
+	return &output.Table{
+		Columns: []string{"status", "alerts", "critical", "warning"},
+		Rows: []output.Row{{
+			"status":   status,
+			"alerts":   len(h.Alerts),
+			"critical": counts["critical"],
+			"warning":  counts["warning"],
+		}},
+	}, nil
+}
+
+func alertList(c *api.Client, selector string) (*output.Table, error) {
+	h, err := c.Health(selector)
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{"severity", "rule", "host", "gpu", "message"}}
+	for _, a := range h.Alerts {
+		if severity != "" && string(a.Severity) != severity {
+			continue
+		}
+		t.Rows = append(t.Rows, output.Row{
+			"severity": string(a.Severity),
+			"rule":     a.Rule,
+			"host":     a.Host,
+			"gpu":      a.GPU,
+			"message":  a.Message,
+		})
+	}
+	return t, nil
+}
+
+// processes lists one row per process name, including the ones running
+// in MIG instances.
+func processes(c *api.Client, selector string) (*output.Table, error) {
+	list, err := c.GPUs(selector)
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{"host", "gpu", "index", "model", "instance", "process"}}
+	add := func(g report.GPUReport, instance, names string) {
+		host, model := splitName(g.Name)
+		for _, p := range strings.Split(names, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			t.Rows = append(t.Rows, output.Row{
+				"host":     host,
+				"gpu":      g.Name,
+				"index":    g.Index,
+				"model":    model,
+				"instance": instance,
+				"process":  p,
+			})
+		}
+	}
+	for _, g := range list {
+		if len(g.MIGInstances) == 0 {
+			add(g, "", g.ProcessNames)
+		}
+		for _, mig := range g.MIGInstances {
+			add(g, fmt.Sprintf("%s GI %d/CI %d", mig.Profile, mig.GPUInstanceID, mig.ComputeInstanceID), mig.ProcessNames)
+		}
+	}
+	sort.SliceStable(t.Rows, func(i, j int) bool {
+		return t.Rows[i]["gpu"].(string) < t.Rows[j]["gpu"].(string)
+	})
+	return t, nil
+}
+
+func history(c *api.Client, selector string) (*output.Table, error) {
+	q := api.HistoryQuery{
+		Name:     historyName,
+		Host:     historyHost,
+		Selector: selector,
+		Since:    historySince,
+		Step:     historyStep,
+	}
+
+	switch historyKind {
+	case "gpu":
+		list, err := c.GPUHistory(q)
+		if err != nil {
+			return nil, err
+		}
+		t := &output.Table{Columns: []string{"name", "time", "util", "temp", "mem_used", "power"}}
+		for _, h := range list {
+			for _, s := range h.Samples {
+				t.Rows = append(t.Rows, output.Row{
+					"name":     h.Name,
+					"time":     s.Time,
+					"util":     s.UtilizationGpuPercent,
+					"temp":     s.TemperatureC,
+					"mem_used": s.MemoryUsedMiB,
+					"power":    s.PowerWatt,
+				})
+			}
+		}
+		return t, nil
+	case "host":
+		list, err := c.HostHistory(q)
+		if err != nil {
+			return nil, err
+		}
+		t := &output.Table{Columns: []string{"hostname", "time", "cpu", "mem_used"}}
+		for _, h := range list {
+			for _, s := range h.Samples {
+				t.Rows = append(t.Rows, output.Row{
+					"hostname": h.Hostname,
+					"time":     s.Time,
+					"cpu":      s.CPUUsagePercent,
+					"mem_used": s.MemoryUsedMB,
+				})
+			}
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("-kind must be gpu or host")
+}
+
+func pairings(c *api.Client, selector string) (*output.Table, error) {
+	codes, err := c.PairingCodes()
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{
+		"id", "role", "subscriptions", "note", "created", "expires", "used", "chat", "user",
+	}}
+	for _, pc := range codes {
+		var subs []string
+		for _, s := range pc.Subscriptions {
+			subs = append(subs, strings.TrimSuffix(s.Severity+":"+s.Selector, ":"))
+		}
+		row := output.Row{
+			"id":            pc.ID,
+			"role":          pc.Role,
+			"subscriptions": strings.Join(subs, " "),
+			"note":          pc.Note,
+			"created":       pc.CreatedAt,
+			"expires":       pc.ExpiresAt,
+			"used":          pc.UsedAt,
+			"chat":          "",
+			"user":          "",
+		}
+		if pc.ChatID != 0 {
+			row["chat"], row["user"] = pc.ChatID, pc.UserID
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+func incidents(c *api.Client, selector string) (*output.Table, error) {
+	list, err := c.Incidents()
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{
+		"id", "status", "title", "components", "created", "updated", "resolved", "latest",
+	}}
+	for _, inc := range list {
+		row := output.Row{
+			"id":         inc.ID,
+			"status":     inc.Status,
+			"title":      inc.Title,
+			"components": strings.Join(inc.Components, ","),
+			"created":    inc.CreatedAt,
+			"updated":    inc.UpdatedAt,
+			"resolved":   inc.ResolvedAt,
+			"latest":     "",
+		}
+		if len(inc.Updates) > 0 {
+			row["latest"] = inc.Updates[0].Message
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+func checkList(c *api.Client, selector string) (*output.Table, error) {
+	list, err := c.Checks()
+	if err != nil {
+		return nil, err
+	}
+	t := &output.Table{Columns: []string{
+		"id", "type", "target", "status", "interval", "latency", "checked", "error", "watchers", "teams",
+	}}
+	for _, s := range list {
+		status := "up"
+		switch {
+		case s.Flapping:
+			status = "flapping"
+		case !s.Up:
+			status = "down"
+		}
+		row := output.Row{
+			"id":       s.ID,
+			"type":     s.Type,
+			"target":   s.Target,
+			"status":   status,
+			"interval": s.Interval.String(),
+			"latency":  "",
+			"checked":  "",
+			"error":    s.LastError,
+			"watchers": s.Watchers,
+			"teams":    strings.Join(s.Teams, ","),
+		}
+		if s.Up && s.LastLatency > 0 {
+			row["latency"] = s.LastLatency.Round(time.Microsecond).String()
+		}
+		if !s.LastChecked.IsZero() {
+			row["checked"] = s.LastChecked.UTC().Format(time.RFC3339)
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+// chatIDs lists the chats in the bot's pending updates, which Telegram
+// keeps for 24 hours. It does not confirm them, so the bot still gets
+// them when it next starts.
+func chatIDs(_ *api.Client, _ string) (*output.Table, error) {
+	if botToken == "" {
+		return nil, errors.New("set TELEGRAM_BOT_TOKEN or -bot-token")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var updates []tgbotapi.Update
+	err := telegram.Call(ctx, botToken, "getUpdates", map[string]int{"limit": 100}, &updates)
+	var apiErr *telegram.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return nil, errors.New("the bot is running and fetching updates itself; stop it first, or send /whoami to it in the chat")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &output.Table{Columns: []string{"chat_id", "type", "title", "from", "from_id", "last_seen", "text"}}
+	index := map[int64]int{}
+	for _, u := range updates {
+		m := u.Message
+		switch {
+		case m != nil:
+		case u.EditedMessage != nil:
+			m = u.EditedMessage
+		case u.ChannelPost != nil:
+			m = u.ChannelPost
+		case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+			m = u.CallbackQuery.Message
+			m.From = u.CallbackQuery.From
+		default:
+			continue
+		}
+		title := m.Chat.Title
+		if title == "" {
+			title = strings.TrimSpace(m.Chat.FirstName + " " + m.Chat.LastName)
+		}
+		row := output.Row{
+			"chat_id":   m.Chat.ID,
+			"type":      m.Chat.Type,
+			"title":     title,
+			"from":      "",
+			"from_id":   "",
+			"last_seen": time.Unix(int64(m.Date), 0).UTC().Format(time.RFC3339),
+			"text":      m.Text,
+		}
+		if m.From != nil {
+			row["from"] = m.From.String()
+			row["from_id"] = m.From.ID
+		}
+		// Later updates replace earlier ones from the same chat.
+		if i, ok := index[m.Chat.ID]; ok {
+			t.Rows[i] = row
+			continue
+		}
+		index[m.Chat.ID] = len(t.Rows)
+		t.Rows = append(t.Rows, row)
+	}
+	if len(t.Rows) == 0 {
+		fmt.Fprintln(os.Stderr, "No recent messages. Send the bot a message, or mention it in the group, then run this again.")
+	}
+	return t, nil
+}
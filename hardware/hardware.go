@@ -0,0 +1,273 @@
+// Package hardware parses the raw command output that hardware reports
+// carry (lscpu, free, lsblk, lspci -mm, lsusb, ip -j addr, df) into
+// structured details for display.
+package hardware
+
+import (
+	"bufio"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gpu-monitor/report"
+)
+
+type Details struct {
+	Hostname string
+	Distro   string
+	Kernel   string
+	Uptime   string
+
+	CPUModel string
+	Sockets  int
+	Cores    int // physical cores across all sockets
+	Threads  int
+
+	MemoryTotal string
+	MemoryUsed  string
+
+	Disks       []Disk
+	Filesystems []Filesystem
+	PCI         []PCIDevice
+	USB         []string
+	Interfaces  []Interface
+}
+
+type Disk struct {
+	Name   string
+	Size   string
+	Mounts []string // mount points of the disk and its partitions
+}
+
+type Filesystem struct {
+	Source     string
+	Type       string
+	Size       string
+	Used       string
+	Available  string
+	UsePercent string
+	Mount      string
+}
+
+type PCIDevice struct {
+	Slot   string
+	Class  string
+	Vendor string
+	Device string
+}
+
+// GPU reports whether the device is a display or 3D controller.
+func (d PCIDevice) GPU() bool {
+	return strings.HasPrefix(d.Class, "VGA") || strings.HasPrefix(d.Class, "3D") || strings.HasPrefix(d.Class, "Display")
+}
+
+// Network reports whether the device is a network adapter, Ethernet or
+// InfiniBand.
+func (d PCIDevice) Network() bool {
+	return strings.Contains(d.Class, "Ethernet") || strings.Contains(d.Class, "Network") || strings.Contains(d.Class, "Infiniband")
+}
+
+type Interface struct {
+	Name      string
+	State     string
+	MAC       string
+	Addresses []string // CIDR notation
+}
+
+// Parse extracts what it can from a report. Fields whose command output
+// is missing or malformed are left empty.
+func Parse(hr report.HardwareReport) Details {
+	d := Details{
+		Hostname:   hr.Hostname,
+		Distro:     distro(hr.Distro),
+		Kernel:     strings.TrimSpace(hr.Kernel),
+		Uptime:     strings.TrimPrefix(strings.TrimSpace(hr.Uptime), "up "),
+		Disks:      disks(hr.Disk),
+		PCI:        pciDevices(hr.PCI),
+		Interfaces: interfaces(hr.Network),
+	}
+	d.CPUModel, d.Sockets, d.Cores, d.Threads = cpu(hr.CPU)
+	d.MemoryTotal, d.MemoryUsed = memory(hr.Memory)
+	d.Filesystems = filesystems(hr.Storage)
+	for _, line := range lines(hr.USB) {
+		// "Bus 001 Device 002: ID 8087:0024 Intel Corp. Hub"
+		if _, desc, ok := strings.Cut(line, ": ID "); ok {
+			if _, name, ok := strings.Cut(desc, " "); ok {
+				line = name
+			}
+		}
+		d.USB = append(d.USB, line)
+	}
+	return d
+}
+
+func lines(s string) []string {
+	var out []string
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// distro takes PRETTY_NAME from /etc/os-release, or the whole text if it
+// is already a single line.
+func distro(osRelease string) string {
+	for _, line := range lines(osRelease) {
+		if v, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+			return strings.Trim(v, `"`)
+		}
+	}
+	if osRelease = strings.TrimSpace(osRelease); !strings.Contains(osRelease, "\n") {
+		return osRelease
+	}
+	return ""
+}
+
+// cpu reads lscpu's "Key: value" lines.
+func cpu(lscpu string) (model string, sockets, cores, threads int) {
+	fields := map[string]string{}
+	for _, line := range lines(lscpu) {
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	atoi := func(k string) int {
+		n, _ := strconv.Atoi(fields[k])
+		return n
+	}
+	model = fields["Model name"]
+	sockets = atoi("Socket(s)")
+	threads = atoi("CPU(s)")
+	cores = atoi("Core(s) per socket") * max(sockets, 1)
+	return
+}
+
+// memory reads the "Mem:" row of free's output.
+func memory(free string) (total, used string) {
+	for _, line := range lines(free) {
+		if f := strings.Fields(line); len(f) >= 3 && f[0] == "Mem:" {
+			return f[1], f[2]
+		}
+	}
+	return "", ""
+}
+
+// disks reads lsblk -J output and keeps whole disks, with the mount
+// points of their partitions.
+func disks(raw json.RawMessage) []Disk {
+	type device struct {
+		Name       string  `json:"name"`
+		Size       string  `json:"size"`
+		Type       string  `json:"type"`
+		Mountpoint *string `json:"mountpoint"`
+		// util-linux 2.37 and later list every mount point.
+		Mountpoints []*string `json:"mountpoints"`
+		Children    []device  `json:"children"`
+	}
+	var lsblk struct {
+		BlockDevices []device `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(raw, &lsblk); err != nil {
+		return nil
+	}
+	var mounts func(d device) []string
+	mounts = func(d device) []string {
+		var m []string
+		for _, p := range append(d.Mountpoints, d.Mountpoint) {
+			if p != nil && *p != "" {
+				m = append(m, *p)
+			}
+		}
+		for _, c := range d.Children {
+			m = append(m, mounts(c)...)
+		}
+		return m
+	}
+	var out []Disk
+	for _, d := range lsblk.BlockDevices {
+		if d.Type != "disk" {
+			continue
+		}
+		out = append(out, Disk{Name: d.Name, Size: d.Size, Mounts: mounts(d)})
+	}
+	return out
+}
+
+// filesystems reads df output with the columns source, fstype, size,
+// used, avail, pcent and target.
+func filesystems(df string) []Filesystem {
+	var out []Filesystem
+	for i, line := range lines(df) {
+		f := strings.Fields(line)
+		if i == 0 || len(f) < 7 {
+			continue
+		}
+		out = append(out, Filesystem{
+			Source: f[0], Type: f[1], Size: f[2], Used: f[3], Available: f[4], UsePercent: f[5],
+			Mount: strings.Join(f[6:], " "),
+		})
+	}
+	return out
+}
+
+// pciDevices reads lspci -mm, where each line is a slot followed by
+// quoted class, vendor and device names and some options.
+func pciDevices(lspci string) []PCIDevice {
+	var out []PCIDevice
+	for _, line := range lines(lspci) {
+		slot, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		var quoted []string
+		for {
+			start := strings.IndexByte(rest, '"')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(rest[start+1:], '"')
+			if end < 0 {
+				break
+			}
+			quoted = append(quoted, rest[start+1:start+1+end])
+			rest = rest[start+end+2:]
+		}
+		if len(quoted) < 3 {
+			continue
+		}
+		out = append(out, PCIDevice{Slot: slot, Class: quoted[0], Vendor: quoted[1], Device: quoted[2]})
+	}
+	return out
+}
+
+// interfaces reads ip -j addr output, skipping the loopback.
+func interfaces(raw json.RawMessage) []Interface {
+	var links []struct {
+		Name     string `json:"ifname"`
+		State    string `json:"operstate"`
+		MAC      string `json:"address"`
+		LinkType string `json:"link_type"`
+		Addrs    []struct {
+			Local     string `json:"local"`
+			PrefixLen int    `json:"prefixlen"`
+		} `json:"addr_info"`
+	}
+	if err := json.Unmarshal(raw, &links); err != nil {
+		return nil
+	}
+	var out []Interface
+	for _, l := range links {
+		if l.LinkType == "loopback" {
+			continue
+		}
+		iface := Interface{Name: l.Name, State: l.State, MAC: l.MAC}
+		for _, a := range l.Addrs {
+			iface.Addresses = append(iface.Addresses, a.Local+"/"+strconv.Itoa(a.PrefixLen))
+		}
+		out = append(out, iface)
+	}
+	return out
+}
@@ -0,0 +1,133 @@
+// Package labels implements the key/value labels attached to hosts and the
+// selectors used to filter by them. Selectors follow the Kubernetes
+// equality syntax: a comma-separated list of requirements that must all
+// hold, e.g. "cluster=training,env!=staging,gpu,!drained".
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Equals    Operator = "="
+	NotEquals Operator = "!="
+	Exists    Operator = "exists"
+	NotExists Operator = "!exists"
+)
+
+type Requirement struct {
+	Key   string
+	Op    Operator
+	Value string
+}
+
+// Selector matches a label set when every requirement holds. The empty
+// selector matches everything.
+type Selector []Requirement
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,61}[A-Za-z0-9])?$`)
+
+// ValidKey reports whether k can be used as a label key.
+func ValidKey(k string) bool {
+	return keyPattern.MatchString(k)
+}
+
+// ValidValue reports whether v can be used as a label value. Values may
+// be empty but cannot contain the selector separators.
+func ValidValue(v string) bool {
+	return len(v) <= 63 && !strings.ContainsAny(v, ",=!")
+}
+
+// Parse parses a selector. Whitespace around requirements is ignored.
+func Parse(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var r Requirement
+		switch {
+		case strings.Contains(part, "!="):
+			k, v, _ := strings.Cut(part, "!=")
+			r = Requirement{Key: strings.TrimSpace(k), Op: NotEquals, Value: strings.TrimSpace(v)}
+		case strings.Contains(part, "="):
+			k, v, _ := strings.Cut(strings.Replace(part, "==", "=", 1), "=")
+			r = Requirement{Key: strings.TrimSpace(k), Op: Equals, Value: strings.TrimSpace(v)}
+		case strings.HasPrefix(part, "!"):
+			r = Requirement{Key: strings.TrimSpace(part[1:]), Op: NotExists}
+		default:
+			r = Requirement{Key: part, Op: Exists}
+		}
+
+		if !ValidKey(r.Key) {
+			return nil, fmt.Errorf("invalid label key %q", r.Key)
+		}
+		if !ValidValue(r.Value) {
+			return nil, fmt.Errorf("invalid label value %q", r.Value)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches reports whether set satisfies every requirement.
+func (s Selector) Matches(set map[string]string) bool {
+	for _, r := range s {
+		v, ok := set[r.Key]
+		switch r.Op {
+		case Equals:
+			if !ok || v != r.Value {
+				return false
+			}
+		case NotEquals:
+			if ok && v == r.Value {
+				return false
+			}
+		case Exists:
+			if !ok {
+				return false
+			}
+		case NotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		switch r.Op {
+		case Exists:
+			parts[i] = r.Key
+		case NotExists:
+			parts[i] = "!" + r.Key
+		default:
+			parts[i] = r.Key + string(r.Op) + r.Value
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Format renders a label set as "k1=v1,k2=v2" with keys sorted.
+func Format(set map[string]string) string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + set[k]
+	}
+	return strings.Join(parts, ",")
+}
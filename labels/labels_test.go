@@ -0,0 +1,144 @@
+package labels
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // the parsed selector as a string
+		err  string // empty if the selector is valid
+	}{
+		{"", "", ""},
+		{" , ,", "", ""},
+		{"cluster=training", "cluster=training", ""},
+		{"cluster==training", "cluster=training", ""},
+		{"env!=staging", "env!=staging", ""},
+		{"gpu", "gpu", ""},
+		{"!drained", "!drained", ""},
+		{"cluster=training,env!=staging,gpu,!drained", "cluster=training,env!=staging,gpu,!drained", ""},
+		{"  cluster = training ,  env != staging , gpu , ! drained ", "cluster=training,env!=staging,gpu,!drained", ""},
+		{"env=", "env=", ""},
+		{"env==", "env=", ""},
+		{"nvidia.com/gpu.product=H100", "nvidia.com/gpu.product=H100", ""},
+		{"bad key=prod", "", `invalid label key "bad key"`},
+		{"=prod", "", `invalid label key ""`},
+		{"!", "", `invalid label key ""`},
+		{"-env=prod", "", `invalid label key "-env"`},
+		{"env.=prod", "", `invalid label key "env."`},
+		{"!env=prod", "", `invalid label key "!env"`},
+		{strings.Repeat("k", 64), "", `invalid label key "` + strings.Repeat("k", 64) + `"`},
+		{"env===prod", "", `invalid label value "=prod"`},
+		{"env=a!b", "", `invalid label value "a!b"`},
+		{"env=" + strings.Repeat("v", 64), "", `invalid label value "` + strings.Repeat("v", 64) + `"`},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.in)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Parse(%q): %v", tt.in, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("Parse(%q): %v, want %s", tt.in, err, tt.err)
+		case tt.err == "" && sel.String() != tt.want:
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, sel.String(), tt.want)
+		}
+		// The string form parses back to the same selector.
+		if err == nil {
+			if again, err := Parse(sel.String()); err != nil || again.String() != sel.String() {
+				t.Errorf("Parse(%q) = %q, %v", sel.String(), again, err)
+			}
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	node := map[string]string{"cluster": "training", "env": "prod", "gpu": "h100", "note": ""}
+	tests := []struct {
+		sel  string
+		set  map[string]string
+		want bool
+	}{
+		{"", node, true},
+		{"", nil, true},
+		{"cluster=training", node, true},
+		{"cluster=inference", node, false},
+		{"rack=a1", node, false},
+		{"note=", node, true},
+		{"env!=staging", node, true},
+		{"env!=prod", node, false},
+		// A missing key is not equal to anything.
+		{"rack!=a1", node, true},
+		{"gpu", node, true},
+		{"note", node, true},
+		{"drained", node, false},
+		{"!drained", node, true},
+		{"!gpu", node, false},
+		{"cluster=training,env!=staging,gpu,!drained", node, true},
+		{"cluster=training,drained", node, false},
+		{"cluster=training", nil, false},
+		{"!drained", nil, true},
+	}
+	for _, tt := range tests {
+		sel, err := Parse(tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Matches(tt.set); got != tt.want {
+			t.Errorf("%q matches %v = %v, want %v", tt.sel, tt.set, got, tt.want)
+		}
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	for k, want := range map[string]bool{
+		"a":                     true,
+		"cluster":               true,
+		"Cluster-2":             true,
+		"nvidia.com/gpu_count":  true,
+		strings.Repeat("k", 63): true,
+		"":                      false,
+		strings.Repeat("k", 64): false,
+		"-cluster":              false,
+		"cluster_":              false,
+		"two words":             false,
+		"env=prod":              false,
+		"!env":                  false,
+		"env,rack":              false,
+	} {
+		if got := ValidKey(k); got != want {
+			t.Errorf("ValidKey(%q) = %v, want %v", k, got, want)
+		}
+	}
+}
+
+func TestValidValue(t *testing.T) {
+	for v, want := range map[string]bool{
+		"":                      true,
+		"prod":                  true,
+		"two words":             true,
+		"H100 80GB":             true,
+		strings.Repeat("v", 63): true,
+		strings.Repeat("v", 64): false,
+		"a,b":                   false,
+		"a=b":                   false,
+		"a!b":                   false,
+	} {
+		if got := ValidValue(v); got != want {
+			t.Errorf("ValidValue(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for want, set := range map[string]map[string]string{
+		"":                              nil,
+		"env=prod":                      {"env": "prod"},
+		"cluster=training,env=,rack=a1": {"rack": "a1", "env": "", "cluster": "training"},
+	} {
+		if got := Format(set); got != want {
+			t.Errorf("Format(%v) = %q, want %q", set, got, want)
+		}
+	}
+}
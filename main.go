@@ -1,55 +1,71 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"time"
 	"io"
-		"fmt"
+	"strconv"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
-)
 
-type GPUReport struct {
-	Index                  int    `json:"index"`
-	Name                   string `json:"name"`
-	FanPercent             int    `json:"fan_percent"`
-	TemperatureC           int    `json:"temperature_c"`
-	PowerWatt              float64 `json:"power_watt"`           // <-- updated
-	MemoryUsedMiB          int    `json:"memory_used_mib"`
-	MemoryTotalMiB         int    `json:"memory_total_mib"`
-	UtilizationGpuPercent  int    `json:"utilization_gpu_percent"`
-	ProcessCount           int    `json:"process_count"`
-	ProcessNames           string `json:"process_names"`
-	UpdatedAt              string  `json:"updated_at"` // ISO string
-}
-
-type HostReport struct {
-	Hostname         string  `json:"hostname"`
-	CPUUsagePercent  float64 `json:"cpu_usage_percent"`
-	MemoryUsedMB     int     `json:"memory_used_mb"`
-	MemoryTotalMB    int     `json:"memory_total_mb"`
-	DiskUsed         string  `json:"disk_used"`
-	DiskTotal        string  `json:"disk_total"`
-	UpdatedAt        string  `json:"updated_at"`
-}
-
-type HardwareReport struct {
-	Hostname string          `json:"hostname"`
-	Uptime   string          `json:"uptime"`
-	Kernel   string          `json:"kernel"`
-	Distro   string          `json:"distro"`
-	CPU      string          `json:"cpu"`
-	Memory   string          `json:"memory"`
-	Disk     json.RawMessage `json:"disk"`
-	PCI      string          `json:"pci"`
-	USB      string          `json:"usb"`
-	Network  json.RawMessage `json:"network"`
-	Storage  string          `json:"storage"`
-}
+	"gpu-monitor/alerts"
+	"gpu-monitor/botauth"
+	"gpu-monitor/checks"
+	"gpu-monitor/labels"
+	"gpu-monitor/notifier"
+	"gpu-monitor/release"
+	"gpu-monitor/report"
+	"gpu-monitor/status"
+)
 
+const (
+	releasesDir   = "./releases"
+	adminTokenEnv = "GPUMON_ADMIN_TOKEN"
+	// When set, reports are only accepted from enrolled hosts. Otherwise
+	// hosts that never enrolled (mon.sh) may still report unauthenticated.
+	requireEnrollmentEnv = "GPUMON_REQUIRE_ENROLLMENT"
+	historyRetention     = 7 * 24 * time.Hour
+	// Agents report an XID only in the scan after it was logged, so the
+	// server keeps every XID a GPU reported for xidWindow and the
+	// gpu_xid alert fires until the last one ages out.
+	xidWindow = 24 * time.Hour
+	// How often the alert rules run, and how often an unacknowledged
+	// critical alert is repeated to its subscribers.
+	alertInterval    = 30 * time.Second
+	reminderInterval = time.Hour
+	// Path of the notifier config that sends alerts to Slack, email and
+	// other channels besides the bot's subscriptions.
+	notifyConfigEnv = "GPUMON_NOTIFY_CONFIG"
+	notifyTimeout   = time.Minute
+	// The public status page: the component config, how often component
+	// states are sampled for the uptime bars, and how long resolved
+	// incidents stay on the page.
+	statusConfigEnv      = "GPUMON_STATUS_CONFIG"
+	statusSampleInterval = time.Minute
+	resolvedIncidentAge  = 7 * 24 * time.Hour
+	// Service checks: how many run at once, the monitors.db of the old
+	// standalone monitor bot to take checks over from, and how long
+	// results and outages are kept, which is the longest period an
+	// uptime report covers.
+	checkWorkersEnv    = "GPUMON_CHECK_WORKERS"
+	importMonitorsEnv  = "GPUMON_IMPORT_MONITORS"
+	checkRetention     = 30 * 24 * time.Hour
+	defaultCheckPeriod = 7 * 24 * time.Hour
+)
 
 func main() {
 	db, err := sql.Open("sqlite3", "./gpu_inventory.db")
@@ -107,32 +123,435 @@ if err != nil {
 		log.Fatal(err)
 	}
 
+	// Extended telemetry reported by the Go agent. Added as a migration so
+	// existing gpu_inventory.db files pick the columns up.
+	err = addColumns(db, "gpu_inventory", []string{
+		"uuid TEXT",
+		"pci_bus_id TEXT",
+		"ecc_volatile_corrected INTEGER DEFAULT 0",
+		"ecc_volatile_uncorrected INTEGER DEFAULT 0",
+		"ecc_aggregate_corrected INTEGER DEFAULT 0",
+		"ecc_aggregate_uncorrected INTEGER DEFAULT 0",
+		"retired_pages_sbe INTEGER DEFAULT 0",
+		"retired_pages_dbe INTEGER DEFAULT 0",
+		"retired_pages_pending BOOLEAN DEFAULT 0",
+		"xid_errors TEXT DEFAULT ''", // no longer written, see gpu_xids
+		"throttle_reasons TEXT DEFAULT ''",
+		"clock_sm_mhz INTEGER DEFAULT 0",
+		"clock_mem_mhz INTEGER DEFAULT 0",
+		"power_limit_watt REAL DEFAULT 0",
+		"pcie_gen INTEGER DEFAULT 0",
+		"pcie_gen_max INTEGER DEFAULT 0",
+		"pcie_width INTEGER DEFAULT 0",
+		"pcie_width_max INTEGER DEFAULT 0",
+		"pcie_replay_count INTEGER DEFAULT 0",
+		"mig_mode BOOLEAN DEFAULT 0",
+		"virtualization_mode TEXT DEFAULT ''",
+		"vendor TEXT DEFAULT 'nvidia'",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// MIG instances of partitioned GPUs, replaced wholesale on every report
+	// since instances can be destroyed and recreated with other profiles.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS gpu_mig_instances (
+		gpu_name TEXT,
+		gpu_instance_id INTEGER,
+		compute_instance_id INTEGER,
+		profile TEXT,
+		uuid TEXT,
+		compute_slices INTEGER,
+		sm_count INTEGER,
+		memory_used_mib INTEGER,
+		memory_total_mib INTEGER,
+		process_count INTEGER,
+		process_names TEXT,
+		updated_at DATETIME,
+		PRIMARY KEY(gpu_name, gpu_instance_id, compute_instance_id)
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS agent_heartbeats (
+		hostname TEXT PRIMARY KEY,
+		version TEXT,
+		os TEXT,
+		arch TEXT,
+		started_at TEXT,
+		updated_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Staged agent rollouts: a version is offered to the given percentage
+	// of hosts, see release.Bucket.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS agent_rollouts (
+		version TEXT PRIMARY KEY,
+		percent INTEGER,
+		updated_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Hosts enrolled with `gpumon-agent enroll`. Only a hash of the
+	// per-host credential is stored.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS hosts (
+		hostname TEXT PRIMARY KEY,
+		owner TEXT DEFAULT '',
+		rack TEXT DEFAULT '',
+		status TEXT,
+		credential_hash TEXT UNIQUE,
+		enrolled_at DATETIME,
+		reviewed_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// One-time join tokens minted by an admin. Owner, rack and labels are
+	// copied to the host that redeems the token. A token with a hostname
+	// only enrolls that host, and only such a token re-enrolls a host that
+	// is already enrolled.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS join_tokens (
+		token_hash TEXT PRIMARY KEY,
+		owner TEXT DEFAULT '',
+		rack TEXT DEFAULT '',
+		labels TEXT DEFAULT '{}',
+		auto_approve BOOLEAN DEFAULT 0,
+		created_at DATETIME,
+		expires_at DATETIME,
+		used_at DATETIME,
+		used_by TEXT,
+		hostname TEXT DEFAULT ''
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := addColumns(db, "join_tokens", []string{"hostname TEXT DEFAULT ''"}); err != nil {
+		log.Fatal(err)
+	}
+
+	// Host labels, for enrolled and unenrolled hosts alike. Labels set
+	// through /admin/labels (source 'admin') win over the ones from the
+	// agent config, which the agent replaces on every heartbeat.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS host_labels (
+		hostname TEXT,
+		key TEXT,
+		value TEXT,
+		source TEXT,
+		PRIMARY KEY(hostname, key)
+	)`)
+	if err == nil {
+		err = migrateHostLabels(db)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Samples of every report for the history endpoints, kept for
+	// historyRetention. Times are unix seconds.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS gpu_history (
+		name TEXT,
+		recorded_at INTEGER,
+		temperature_c INTEGER,
+		utilization_gpu_percent INTEGER,
+		memory_used_mib INTEGER,
+		power_watt REAL
+	)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE INDEX IF NOT EXISTS gpu_history_name_time ON gpu_history(name, recorded_at)`)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The XIDs each GPU reported, with when it last reported them. Times
+	// are unix seconds; rows older than xidWindow are pruned.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS gpu_xids (
+		gpu_name TEXT,
+		code INTEGER,
+		reported_at INTEGER,
+		PRIMARY KEY(gpu_name, code)
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS host_history (
+		hostname TEXT,
+		recorded_at INTEGER,
+		cpu_usage_percent REAL,
+		memory_used_mb INTEGER
+	)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE INDEX IF NOT EXISTS host_history_name_time ON host_history(hostname, recorded_at)`)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Alerts raised by the rule evaluator, from first seen until resolved.
+	// next_reminder_at is when subscribers are reminded of an alert nobody
+	// acknowledged; snoozing pushes it back.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS alert_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		fingerprint TEXT,
+		rule TEXT,
+		severity TEXT,
+		hostname TEXT,
+		gpu TEXT,
+		message TEXT,
+		started_at DATETIME,
+		resolved_at DATETIME,
+		acked_by TEXT DEFAULT '',
+		acked_at DATETIME,
+		snoozed_until DATETIME,
+		next_reminder_at DATETIME
+	)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS alert_events_open ON alert_events(fingerprint) WHERE resolved_at IS NULL`)
+	}
+	if err == nil {
+		// check_id is set on alerts about a service check, whose hostname
+		// is then the check's target.
+		err = addColumns(db, "alert_events", []string{"check_id INTEGER DEFAULT 0"})
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Per-chat alert subscriptions and mutes, managed through the bot, and
+	// the notifications queued for it to deliver.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS alert_subscriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		selector TEXT DEFAULT '',
+		min_severity TEXT,
+		created_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS alert_mutes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		kind TEXT,
+		value TEXT,
+		until DATETIME,
+		created_by TEXT DEFAULT ''
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS alert_notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chat_id INTEGER,
+		event_id INTEGER,
+		kind TEXT,
+		created_at DATETIME,
+		sent_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS pairing_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code_hash TEXT UNIQUE,
+		role TEXT,
+		subscriptions TEXT DEFAULT '[]',
+		note TEXT DEFAULT '',
+		created_at DATETIME,
+		expires_at DATETIME,
+		used_at DATETIME,
+		chat_id INTEGER,
+		user_id INTEGER
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Samples of the status page components by day, for the uptime bars.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS status_daily (
+		component TEXT,
+		day TEXT,
+		samples INTEGER DEFAULT 0,
+		degraded INTEGER DEFAULT 0,
+		outage INTEGER DEFAULT 0,
+		PRIMARY KEY(component, day)
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Incidents posted by admins on the status page. components is a JSON
+	// list of component names.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS status_incidents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT,
+		status TEXT,
+		components TEXT DEFAULT '[]',
+		created_at DATETIME,
+		updated_at DATETIME,
+		resolved_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS status_incident_updates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		incident_id INTEGER,
+		status TEXT,
+		message TEXT,
+		created_at DATETIME
+	)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var router *notifier.Router
+	if path := os.Getenv(notifyConfigEnv); path != "" {
+		if router, err = notifier.Load(path); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Sending alerts to channels %s", strings.Join(router.Channels(), ", "))
+	}
+
+	// Service checks: TCP, HTTP, TLS and DNS probes of the services the
+	// GPUs serve, shared by the chats that watch them.
+	store, err := checks.Open(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if path := os.Getenv(importMonitorsEnv); path != "" {
+		n, err := store.Import(context.Background(), path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			log.Printf("Imported %d checks from %s", n, path)
+		}
+	}
+	workers := checks.DefaultWorkers
+	if v := os.Getenv(checkWorkersEnv); v != "" {
+		if workers, err = strconv.Atoi(v); err != nil || workers < 1 {
+			log.Fatalf("%s must be a positive number", checkWorkersEnv)
+		}
+	}
+	// A check going up or down runs the alert rules right away rather
+	// than at the next alertInterval.
+	alertsDue := make(chan struct{}, 1)
+	scheduler := &checks.Scheduler{
+		Workers: workers,
+		OnResult: func(r checks.Result) {
+			change, err := store.Record(r)
+			if err != nil {
+				if err != checks.ErrNotFound {
+					log.Println("Saving check result failed:", err)
+				}
+				return
+			}
+			if change.Notify() {
+				select {
+				case alertsDue <- struct{}{}:
+				default:
+				}
+			}
+		},
+	}
+	reloadChecks := func() {
+		monitors, err := store.Monitors()
+		if err != nil {
+			log.Println("Loading checks failed:", err)
+			return
+		}
+		scheduler.Set(monitors)
+	}
+	reloadChecks()
+	go scheduler.Run(context.Background())
+
+	statusConfig := status.DefaultConfig()
+	if path := os.Getenv(statusConfigEnv); path != "" {
+		if statusConfig, err = status.Load(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	go func() {
+		for {
+			if err := evaluateAlerts(db, store, router, time.Now()); err != nil {
+				log.Println("Evaluating alerts failed:", err)
+			}
+			select {
+			case <-alertsDue:
+			case <-time.After(alertInterval):
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			if err := sampleStatus(db, store, statusConfig, time.Now()); err != nil {
+				log.Println("Sampling status failed:", err)
+			}
+			time.Sleep(statusSampleInterval)
+		}
+	}()
+
+	go func() {
+		for {
+			cutoff := time.Now().Add(-historyRetention).Unix()
+			if _, err := db.Exec(`DELETE FROM gpu_history WHERE recorded_at < ?`, cutoff); err != nil {
+				log.Println("Pruning GPU history failed:", err)
+			}
+			if _, err := db.Exec(`DELETE FROM host_history WHERE recorded_at < ?`, cutoff); err != nil {
+				log.Println("Pruning host history failed:", err)
+			}
+			if _, err := db.Exec(`DELETE FROM gpu_xids WHERE reported_at < ?`, time.Now().Add(-xidWindow).Unix()); err != nil {
+				log.Println("Pruning XIDs failed:", err)
+			}
+			before := time.Now().UTC().Add(-historyRetention)
+			if _, err := db.Exec(`DELETE FROM alert_notifications WHERE created_at < ?`, before); err != nil {
+				log.Println("Pruning alert notifications failed:", err)
+			}
+			if _, err := db.Exec(`DELETE FROM alert_events WHERE resolved_at < ?`, before); err != nil {
+				log.Println("Pruning alert events failed:", err)
+			}
+			if _, err := db.Exec(`DELETE FROM alert_mutes WHERE until < ?`, time.Now().UTC()); err != nil {
+				log.Println("Pruning alert mutes failed:", err)
+			}
+			if _, err := db.Exec(`DELETE FROM pairing_codes WHERE expires_at < ?`, before); err != nil {
+				log.Println("Pruning pairing codes failed:", err)
+			}
+			oldest := time.Now().UTC().AddDate(0, 0, -status.HistoryDays).Format("2006-01-02")
+			if _, err := db.Exec(`DELETE FROM status_daily WHERE day < ?`, oldest); err != nil {
+				log.Println("Pruning status history failed:", err)
+			}
+			if err := store.Prune(time.Now().Add(-checkRetention)); err != nil {
+				log.Println("Pruning check history failed:", err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
+	http.Handle("/agent/releases/", http.StripPrefix("/agent/releases/", http.FileServer(http.Dir(releasesDir))))
 	http.HandleFunc("/gpu/list", func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query(`SELECT index_id, name, fan_percent, temperature_c, power_watt,
-		memory_used_mib, memory_total_mib, utilization_gpu_percent,
-		process_count, process_names, updated_at FROM gpu_inventory`)
+		sel, ok := selectorParam(w, r)
+		if !ok {
+			return
+		}
+		gpus, err := queryGPUs(db)
+		if err == nil {
+			gpus, err = filterGPUs(db, gpus, sel)
+		}
 		if err != nil {
 			http.Error(w, "DB error", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var gpus []GPUReport
-		for rows.Next() {
-			var gpu GPUReport
-			var updatedAt time.Time
-			err := rows.Scan(&gpu.Index, &gpu.Name, &gpu.FanPercent, &gpu.TemperatureC,
-			&gpu.PowerWatt, &gpu.MemoryUsedMiB, &gpu.MemoryTotalMiB,
-			&gpu.UtilizationGpuPercent, &gpu.ProcessCount, &gpu.ProcessNames, &updatedAt)
-			gpu.UpdatedAt = updatedAt.Format(time.RFC3339)
-			if err != nil {
-				http.Error(w, "Scan error", http.StatusInternalServerError)
-				return
-			}
-			gpus = append(gpus, gpu)
-		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(gpus)
@@ -151,14 +570,17 @@ http.HandleFunc("/hardware/report", func(w http.ResponseWriter, r *http.Request)
 	}
 
 
-	var report HardwareReport
-	if err := json.Unmarshal(body, &report); err != nil {
+	var hw report.HardwareReport
+	if err := json.Unmarshal(body, &hw); err != nil {
 		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !authorizeHost(w, r, db, hw.Hostname) {
+		return
+	}
 
 	// Optional: Print parsed report
-	log.Printf("Received hardware report from host: %s", report.Hostname)
+	log.Printf("Received hardware report from host: %s", hw.Hostname)
 
 	stmt, err := db.Prepare(`INSERT INTO hardware_reports
 	(hostname, uptime, kernel, distro, cpu, memory, disk_json, pci, usb, network_json, storage, updated_at)
@@ -182,17 +604,17 @@ http.HandleFunc("/hardware/report", func(w http.ResponseWriter, r *http.Request)
 	defer stmt.Close()
 
 	_, err = stmt.Exec(
-		report.Hostname,
-		report.Uptime,
-		report.Kernel,
-		report.Distro,
-		report.CPU,
-		report.Memory,
-		string(report.Disk),
-		report.PCI,
-		report.USB,
-		string(report.Network),
-		report.Storage,
+		hw.Hostname,
+		hw.Uptime,
+		hw.Kernel,
+		hw.Distro,
+		hw.CPU,
+		hw.Memory,
+		string(hw.Disk),
+		hw.PCI,
+		hw.USB,
+		string(hw.Network),
+		hw.Storage,
 		time.Now(),
 	)
 	if err != nil {
@@ -213,9 +635,9 @@ http.HandleFunc("/hardware/list", func(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var reports []HardwareReport
+	var reports []report.HardwareReport
 	for rows.Next() {
-		var hr HardwareReport
+		var hr report.HardwareReport
 		var updatedAt time.Time
 		var diskJSON, networkJSON string
 
@@ -249,11 +671,14 @@ http.HandleFunc("/host/report", func(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("Received host body:", string(body))
 
-	var report HostReport
-	if err := json.Unmarshal(body, &report); err != nil {
+	var host report.HostReport
+	if err := json.Unmarshal(body, &host); err != nil {
 		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !authorizeHost(w, r, db, host.Hostname) {
+		return
+	}
 
 	stmt, err := db.Prepare(`INSERT INTO host_metrics
 		(hostname, cpu_usage_percent, memory_used_mb, memory_total_mb, disk_used, disk_total, updated_at)
@@ -271,7 +696,11 @@ http.HandleFunc("/host/report", func(w http.ResponseWriter, r *http.Request) {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(report.Hostname, report.CPUUsagePercent, report.MemoryUsedMB, report.MemoryTotalMB, report.DiskUsed, report.DiskTotal, time.Now())
+	_, err = stmt.Exec(host.Hostname, host.CPUUsagePercent, host.MemoryUsedMB, host.MemoryTotalMB, host.DiskUsed, host.DiskTotal, time.Now())
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO host_history (hostname, recorded_at, cpu_usage_percent, memory_used_mb) VALUES (?, ?, ?, ?)`,
+			host.Hostname, time.Now().Unix(), host.CPUUsagePercent, host.MemoryUsedMB)
+	}
 	if err != nil {
 		http.Error(w, "Insert error", http.StatusInternalServerError)
 		return
@@ -283,25 +712,18 @@ http.HandleFunc("/host/report", func(w http.ResponseWriter, r *http.Request) {
 
 
 http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.Query(`SELECT hostname, cpu_usage_percent, memory_used_mb, memory_total_mb, disk_used, disk_total, updated_at FROM host_metrics`)
+	sel, ok := selectorParam(w, r)
+	if !ok {
+		return
+	}
+	hosts, err := queryHosts(db)
+	if err == nil {
+		hosts, err = filterHosts(db, hosts, sel)
+	}
 	if err != nil {
 		http.Error(w, "DB error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var hosts []HostReport
-	for rows.Next() {
-		var h HostReport
-		var updatedAt time.Time
-		err := rows.Scan(&h.Hostname, &h.CPUUsagePercent, &h.MemoryUsedMB, &h.MemoryTotalMB, &h.DiskUsed, &h.DiskTotal, &updatedAt)
-		h.UpdatedAt = updatedAt.Format(time.RFC3339)
-		if err != nil {
-			http.Error(w, "Scan error", http.StatusInternalServerError)
-			return
-		}
-		hosts = append(hosts, h)
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(hosts)
@@ -321,13 +743,22 @@ http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Println("Received body:", string(body))
 
-		var gpus []GPUReport
+		var gpus []report.GPUReport
 		err = json.Unmarshal(body, &gpus)
 		if err != nil {
 			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 			log.Println("JSON decode error:", err)
 			return
 		}
+		checked := map[string]bool{}
+		for _, gpu := range gpus {
+			if host := gpu.Host(); !checked[host] {
+				if !authorizeHost(w, r, db, host) {
+					return
+				}
+				checked[host] = true
+			}
+		}
 
 		tx, err := db.Begin()
 		if err != nil {
@@ -337,8 +768,13 @@ http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
 
 		stmt, err := tx.Prepare(`INSERT INTO gpu_inventory
 		(index_id, name, fan_percent, temperature_c, power_watt, memory_used_mib,
-		memory_total_mib, utilization_gpu_percent, process_count, process_names, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		memory_total_mib, utilization_gpu_percent, process_count, process_names, updated_at,
+		uuid, pci_bus_id, ecc_volatile_corrected, ecc_volatile_uncorrected,
+		ecc_aggregate_corrected, ecc_aggregate_uncorrected, retired_pages_sbe,
+		retired_pages_dbe, retired_pages_pending, throttle_reasons,
+		clock_sm_mhz, clock_mem_mhz, power_limit_watt, pcie_gen, pcie_gen_max,
+		pcie_width, pcie_width_max, pcie_replay_count, mig_mode, virtualization_mode, vendor)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(name) DO UPDATE SET
 		name=excluded.name,
 		fan_percent=excluded.fan_percent,
@@ -349,7 +785,28 @@ http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
 		utilization_gpu_percent=excluded.utilization_gpu_percent,
 		process_count=excluded.process_count,
 		process_names=excluded.process_names,
-		updated_at=excluded.updated_at;
+		updated_at=excluded.updated_at,
+		uuid=excluded.uuid,
+		pci_bus_id=excluded.pci_bus_id,
+		ecc_volatile_corrected=excluded.ecc_volatile_corrected,
+		ecc_volatile_uncorrected=excluded.ecc_volatile_uncorrected,
+		ecc_aggregate_corrected=excluded.ecc_aggregate_corrected,
+		ecc_aggregate_uncorrected=excluded.ecc_aggregate_uncorrected,
+		retired_pages_sbe=excluded.retired_pages_sbe,
+		retired_pages_dbe=excluded.retired_pages_dbe,
+		retired_pages_pending=excluded.retired_pages_pending,
+		throttle_reasons=excluded.throttle_reasons,
+		clock_sm_mhz=excluded.clock_sm_mhz,
+		clock_mem_mhz=excluded.clock_mem_mhz,
+		power_limit_watt=excluded.power_limit_watt,
+		pcie_gen=excluded.pcie_gen,
+		pcie_gen_max=excluded.pcie_gen_max,
+		pcie_width=excluded.pcie_width,
+		pcie_width_max=excluded.pcie_width_max,
+		pcie_replay_count=excluded.pcie_replay_count,
+		mig_mode=excluded.mig_mode,
+		virtualization_mode=excluded.virtualization_mode,
+		vendor=excluded.vendor;
 		`)
 		if err != nil {
 			http.Error(w, "DB prepare error", http.StatusInternalServerError)
@@ -358,6 +815,10 @@ http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
 		defer stmt.Close()
 
 		for _, gpu := range gpus {
+			// Older mon.sh versions predate AMD support and send no vendor.
+			if gpu.Vendor == "" {
+				gpu.Vendor = report.VendorNVIDIA
+			}
 			_, err := stmt.Exec(
 				gpu.Index,
 				gpu.Name,
@@ -370,7 +831,40 @@ http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
 				gpu.ProcessCount,
 				gpu.ProcessNames,
 				time.Now(),
+				gpu.UUID,
+				gpu.PCIBusID,
+				gpu.ECCVolatileCorrected,
+				gpu.ECCVolatileUncorrected,
+				gpu.ECCAggregateCorrected,
+				gpu.ECCAggregateUncorrected,
+				gpu.RetiredPagesSingleBit,
+				gpu.RetiredPagesDoubleBit,
+				gpu.RetiredPagesPending,
+				strings.Join(gpu.ThrottleReasons, ","),
+				gpu.ClockSMMHz,
+				gpu.ClockMemMHz,
+				gpu.PowerLimitWatt,
+				gpu.PCIeGen,
+				gpu.PCIeGenMax,
+				gpu.PCIeWidth,
+				gpu.PCIeWidthMax,
+				gpu.PCIeReplayCount,
+				gpu.MIGMode,
+				gpu.VirtualizationMode,
+				gpu.Vendor,
 			)
+			if err == nil {
+				err = saveMIGInstances(tx, gpu)
+			}
+			if err == nil {
+				err = saveXIDs(tx, gpu, time.Now())
+			}
+			if err == nil {
+				_, err = tx.Exec(`INSERT INTO gpu_history
+				(name, recorded_at, temperature_c, utilization_gpu_percent, memory_used_mib, power_watt)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+					gpu.Name, time.Now().Unix(), gpu.TemperatureC, gpu.UtilizationGpuPercent, gpu.MemoryUsedMiB, gpu.PowerWatt)
+			}
 			if err != nil {
 				tx.Rollback()
 				http.Error(w, "DB insert error", http.StatusInternalServerError)
@@ -384,80 +878,54 @@ http.HandleFunc("/host/list", func(w http.ResponseWriter, r *http.Request) {
 	})
 
 http.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
-	var issues []string
-	cutoff := time.Now().Add(-5 * time.Minute)
-
-	// Check GPUs
-	rows, err := db.Query(`SELECT name, temperature_c, process_count, updated_at FROM gpu_inventory`)
+	sel, ok := selectorParam(w, r)
+	if !ok {
+		return
+	}
+	gpus, err := queryGPUs(db)
+	if err == nil {
+		gpus, err = filterGPUs(db, gpus, sel)
+	}
 	if err != nil {
 		http.Error(w, "Failed to query GPUs", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var name string
-		var temp, count int
-		var updatedAt time.Time
-
-		if err := rows.Scan(&name, &temp, &count, &updatedAt); err != nil {
-			http.Error(w, "Failed to scan GPU data", http.StatusInternalServerError)
-			return
-		}
-
-		if updatedAt.Before(cutoff) {
-			issues = append(issues, fmt.Sprintf("GPU %s data is stale (last update: %s)", name, updatedAt.Format(time.RFC3339)))
-		}
-		if count < 1 {
-			issues = append(issues, fmt.Sprintf("GPU %s has no running processes", name))
-		}
-		if temp >= 90 {
-			issues = append(issues, fmt.Sprintf("GPU %s temperature is high (%d°C)", name, temp))
-		}
+	hosts, err := queryHosts(db)
+	if err == nil {
+		hosts, err = filterHosts(db, hosts, sel)
 	}
-
-	// Check Host Metrics
-	hostRows, err := db.Query(`SELECT hostname, cpu_usage_percent, memory_used_mb, memory_total_mb, disk_used, disk_total, updated_at FROM host_metrics`)
 	if err != nil {
 		http.Error(w, "Failed to query host metrics", http.StatusInternalServerError)
 		return
 	}
-	defer hostRows.Close()
 
-	for hostRows.Next() {
-		var hostname, diskUsedStr, diskTotalStr string
-		var cpuPercent float64
-		var memUsed, memTotal int
-		var updatedAt time.Time
-
-		if err := hostRows.Scan(&hostname, &cpuPercent, &memUsed, &memTotal, &diskUsedStr, &diskTotalStr, &updatedAt); err != nil {
-			http.Error(w, "Failed to scan host data", http.StatusInternalServerError)
+	now := time.Now()
+	var found []alerts.Alert
+	for _, gpu := range gpus {
+		found = append(found, alerts.CheckGPU(gpu, now)...)
+	}
+	for _, host := range hosts {
+		found = append(found, alerts.CheckHost(host, now)...)
+	}
+	// Service checks have no labels, so a selector leaves them out.
+	if len(sel) == 0 {
+		services, err := store.List(0)
+		if err != nil {
+			http.Error(w, "Failed to query checks", http.StatusInternalServerError)
 			return
 		}
-
-		if updatedAt.Before(cutoff) {
-			issues = append(issues, fmt.Sprintf("Host %s data is stale (last update: %s)", hostname, updatedAt.Format(time.RFC3339)))
-		}
-
-		memUsage := float64(memUsed) / float64(memTotal) * 100
-
-		var diskUsed, diskTotal int64
-		fmt.Sscanf(diskUsedStr, "%d", &diskUsed)
-		fmt.Sscanf(diskTotalStr, "%d", &diskTotal)
-		var diskUsage float64
-		if diskTotal > 0 {
-			diskUsage = float64(diskUsed) / float64(diskTotal) * 100
+		for _, c := range services {
+			found = append(found, alerts.CheckService(c)...)
 		}
+	}
 
-		if cpuPercent > 90 {
-			issues = append(issues, fmt.Sprintf("Host %s CPU usage is high (%.1f%%)", hostname, cpuPercent))
-		}
-		if memUsage > 90 {
-			issues = append(issues, fmt.Sprintf("Host %s memory usage is high (%.1f%%)", hostname, memUsage))
-		}
-		if diskUsage > 90 {
-			issues = append(issues, fmt.Sprintf("Host %s disk usage is high (%.1f%%)", hostname, diskUsage))
+	admin := isAdmin(r)
+	var issues []string
+	for i, a := range found {
+		if !admin {
+			found[i] = redactCheck(a)
 		}
+		issues = append(issues, found[i].Message)
 	}
 
 	if len(issues) == 0 {
@@ -469,13 +937,2796 @@ http.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status": "unhealthy",
 			"issues": issues,
+			"alerts": found,
 		})
 	}
 })
 
 
 
-	log.Println("Listening on :1101...")
-	log.Fatal(http.ListenAndServe(":1101", nil))
-}
+http.HandleFunc("/agent/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	var hb report.Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorizeHost(w, r, db, hb.Hostname) {
+		return
+	}
+
+	_, err := db.Exec(`INSERT INTO agent_heartbeats (hostname, version, os, arch, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hostname) DO UPDATE SET
+			version=excluded.version,
+			os=excluded.os,
+			arch=excluded.arch,
+			started_at=excluded.started_at,
+			updated_at=excluded.updated_at`,
+		hb.Hostname, hb.Version, hb.OS, hb.Arch, hb.StartedAt, time.Now())
+	if err == nil && hb.Labels != nil {
+		if err = validateLabels(hb.Labels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var tx *sql.Tx
+		if tx, err = db.Begin(); err == nil {
+			if err = setAgentLabels(tx, hb.Hostname, hb.Labels); err == nil {
+				err = tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, "Insert error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+})
+
+http.HandleFunc("/agent/list", func(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT hostname, version, os, arch, started_at, updated_at FROM agent_heartbeats`)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var agents []report.Heartbeat
+	for rows.Next() {
+		var hb report.Heartbeat
+		var updatedAt time.Time
+		if err := rows.Scan(&hb.Hostname, &hb.Version, &hb.OS, &hb.Arch, &hb.StartedAt, &updatedAt); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		hb.UpdatedAt = updatedAt.Format(time.RFC3339)
+		agents = append(agents, hb)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agents)
+})
+
+// Agents poll this with their hostname and version. The response is the
+// signed manifest of the release the host should run, or 204 when it is
+// up to date. The agent verifies the manifest itself; the server is not
+// trusted with the signing key.
+http.HandleFunc("/agent/update", func(w http.ResponseWriter, r *http.Request) {
+	hostname := r.URL.Query().Get("hostname")
+	current := r.URL.Query().Get("version")
+	if hostname == "" {
+		http.Error(w, "hostname is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT version, percent FROM agent_rollouts`)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	var target string
+	for rows.Next() {
+		var version string
+		var percent int
+		if err := rows.Scan(&version, &percent); err != nil {
+			rows.Close()
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		// The newest rollout that includes this host wins.
+		if release.Bucket(hostname, version) < percent && (target == "" || release.Compare(version, target) > 0) {
+			target = version
+		}
+	}
+	rows.Close()
+
+	if target == "" || target == current {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(releasesDir, target, release.ManifestFile))
+	if err != nil {
+		http.Error(w, "Release not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(manifest)
+})
+
+http.HandleFunc("/admin/rollout", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var rollout struct {
+			Version string `json:"version"`
+			Percent int    `json:"percent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&rollout); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rollout.Percent < 0 || rollout.Percent > 100 {
+			http.Error(w, "percent must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		if rollout.Version == "" || filepath.Base(rollout.Version) != rollout.Version || strings.HasPrefix(rollout.Version, ".") {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(releasesDir, rollout.Version, release.ManifestFile)); err != nil {
+			http.Error(w, "No such release: "+rollout.Version, http.StatusBadRequest)
+			return
+		}
+
+		_, err := db.Exec(`INSERT INTO agent_rollouts (version, percent, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT(version) DO UPDATE SET percent=excluded.percent, updated_at=excluded.updated_at`,
+			rollout.Version, rollout.Percent, time.Now())
+		if err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Rollout of agent %s set to %d%%", rollout.Version, rollout.Percent)
+	}
+
+	rows, err := db.Query(`SELECT version, percent, updated_at FROM agent_rollouts ORDER BY updated_at DESC`)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rollouts := []map[string]interface{}{}
+	for rows.Next() {
+		var version string
+		var percent int
+		var updatedAt time.Time
+		if err := rows.Scan(&version, &percent, &updatedAt); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		rollouts = append(rollouts, map[string]interface{}{
+			"version":    version,
+			"percent":    percent,
+			"updated_at": updatedAt.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rollouts)
+})
+
+// Agents exchange a one-time join token for their own credential here.
+http.HandleFunc("/agent/enroll", func(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req report.EnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Hostname == "" || strings.Contains(req.Hostname, "@") {
+		http.Error(w, "token and a valid hostname are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateLabels(req.Labels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Claiming the token is the transaction's first write, so two hosts
+	// racing with one token cannot both get it. Expiry is compared by
+	// SQLite, which reads the stored time's UTC offset.
+	now := time.Now()
+	res, err := tx.Exec(`UPDATE join_tokens SET used_at = ?, used_by = ?
+		WHERE token_hash = ? AND used_at IS NULL AND julianday(expires_at) > julianday(?)`,
+		now, req.Hostname, hashSecret(req.Token), now)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		http.Error(w, "Invalid or expired join token", http.StatusForbidden)
+		return
+	}
+	var owner, rack, tokenLabelsJSON, tokenHost string
+	var autoApprove bool
+	err = tx.QueryRow(`SELECT owner, rack, labels, auto_approve, COALESCE(hostname, '') FROM join_tokens WHERE token_hash = ?`,
+		hashSecret(req.Token)).Scan(&owner, &rack, &tokenLabelsJSON, &autoApprove, &tokenHost)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if tokenHost != "" && tokenHost != req.Hostname {
+		http.Error(w, "Join token is for another host", http.StatusForbidden)
+		return
+	}
+	// Anyone holding a join token could otherwise take over an enrolled
+	// host's name and lock the host itself out.
+	var enrolled bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM hosts WHERE hostname = ?)`, req.Hostname).Scan(&enrolled); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if enrolled && tokenHost == "" {
+		http.Error(w, "Host is already enrolled: re-enrolling it needs a join token minted for "+req.Hostname, http.StatusConflict)
+		return
+	}
+	var tokenLabels map[string]string
+	json.Unmarshal([]byte(tokenLabelsJSON), &tokenLabels)
+
+	status := report.HostPending
+	if autoApprove {
+		status = report.HostApproved
+	}
+	credential, err := newSecret("gpumon_host_")
+	if err != nil {
+		http.Error(w, "Failed to generate credential", http.StatusInternalServerError)
+		return
+	}
+
+	// Re-enrolling (e.g. after a reinstall) with a token for the host
+	// replaces the old credential.
+	_, err = tx.Exec(`INSERT INTO hosts (hostname, owner, rack, status, credential_hash, enrolled_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hostname) DO UPDATE SET
+			owner=excluded.owner,
+			rack=excluded.rack,
+			status=excluded.status,
+			credential_hash=excluded.credential_hash,
+			enrolled_at=excluded.enrolled_at,
+			reviewed_at=NULL`,
+		req.Hostname, owner, rack, status, hashSecret(credential), now)
+	// Labels set on the token by the admin win over the ones the host
+	// asks for.
+	if err == nil {
+		err = setAdminLabels(tx, req.Hostname, tokenLabels, nil)
+	}
+	if err == nil {
+		err = setAgentLabels(tx, req.Hostname, req.Labels)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		http.Error(w, "Insert error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Host %s enrolled (%s, agent %s %s/%s)", req.Hostname, status, req.Version, req.OS, req.Arch)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report.EnrollResponse{
+		Hostname:   req.Hostname,
+		Credential: credential,
+		Status:     status,
+	})
+})
+
+http.HandleFunc("/admin/join-tokens", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Owner       string            `json:"owner"`
+		Rack        string            `json:"rack"`
+		Labels      map[string]string `json:"labels"`
+		TTL         string            `json:"ttl"` // Go duration, default 1h
+		AutoApprove bool              `json:"auto_approve"`
+		Hostname    string            `json:"hostname"` // the only host the token enrolls
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl := time.Hour
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > 7*24*time.Hour {
+			http.Error(w, "ttl must be a duration of at most 168h", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Labels == nil {
+		req.Labels = map[string]string{}
+	}
+	labelsJSON, _ := json.Marshal(req.Labels)
+
+	token, err := newSecret("gpumon_join_")
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	_, err = db.Exec(`INSERT INTO join_tokens (token_hash, owner, rack, labels, auto_approve, created_at, expires_at, hostname)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		hashSecret(token), req.Owner, req.Rack, string(labelsJSON), req.AutoApprove, now, now.Add(ttl), req.Hostname)
+	if err != nil {
+		http.Error(w, "Insert error", http.StatusInternalServerError)
+		return
+	}
+
+	// The token itself is only ever shown here.
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": now.Add(ttl).Format(time.RFC3339),
+	})
+})
+
+http.HandleFunc("/admin/hosts", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	hosts, err := queryEnrolledHosts(db, r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hosts)
+})
+
+reviewHost := func(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Hostname string `json:"hostname"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := db.Exec(`UPDATE hosts SET status = ?, reviewed_at = ? WHERE hostname = ?`, status, time.Now(), req.Hostname)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "No such host: "+req.Hostname, http.StatusNotFound)
+			return
+		}
+		log.Printf("Host %s %s", req.Hostname, status)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+http.HandleFunc("/admin/hosts/approve", reviewHost(report.HostApproved))
+http.HandleFunc("/admin/hosts/reject", reviewHost(report.HostRejected))
+
+http.HandleFunc("/gpu/history", func(w http.ResponseWriter, r *http.Request) {
+	sel, ok := selectorParam(w, r)
+	if !ok {
+		return
+	}
+	since, step, ok := historyParams(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	host := r.URL.Query().Get("host")
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	// Samples are averaged over step-second buckets.
+	rows, err := db.Query(`SELECT name, (recorded_at / ?) * ? AS t, AVG(temperature_c),
+		AVG(utilization_gpu_percent), AVG(memory_used_mib), AVG(power_watt)
+		FROM gpu_history WHERE recorded_at >= ? AND (? = '' OR name = ?)
+		GROUP BY name, t ORDER BY name, t`,
+		step, step, since.Unix(), name, name)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []report.GPUHistory{}
+	for rows.Next() {
+		var gpuName string
+		var t int64
+		var temp, util, mem, power float64
+		if err := rows.Scan(&gpuName, &t, &temp, &util, &mem, &power); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		gpuHost := report.GPUReport{Name: gpuName}.Host()
+		if (host != "" && gpuHost != host) || !sel.Matches(hostLabels[gpuHost]) {
+			continue
+		}
+		if len(history) == 0 || history[len(history)-1].Name != gpuName {
+			history = append(history, report.GPUHistory{Name: gpuName})
+		}
+		h := &history[len(history)-1]
+		h.Samples = append(h.Samples, report.GPUSample{
+			Time:                  time.Unix(t, 0).UTC().Format(time.RFC3339),
+			TemperatureC:          int(temp + 0.5),
+			UtilizationGpuPercent: int(util + 0.5),
+			MemoryUsedMiB:         int(mem + 0.5),
+			PowerWatt:             power,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+})
+
+http.HandleFunc("/host/history", func(w http.ResponseWriter, r *http.Request) {
+	sel, ok := selectorParam(w, r)
+	if !ok {
+		return
+	}
+	since, step, ok := historyParams(w, r)
+	if !ok {
+		return
+	}
+	hostname := r.URL.Query().Get("hostname")
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`SELECT hostname, (recorded_at / ?) * ? AS t, AVG(cpu_usage_percent), AVG(memory_used_mb)
+		FROM host_history WHERE recorded_at >= ? AND (? = '' OR hostname = ?)
+		GROUP BY hostname, t ORDER BY hostname, t`,
+		step, step, since.Unix(), hostname, hostname)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []report.HostHistory{}
+	for rows.Next() {
+		var name string
+		var t int64
+		var cpu, mem float64
+		if err := rows.Scan(&name, &t, &cpu, &mem); err != nil {
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		if !sel.Matches(hostLabels[name]) {
+			continue
+		}
+		if len(history) == 0 || history[len(history)-1].Hostname != name {
+			history = append(history, report.HostHistory{Hostname: name})
+		}
+		h := &history[len(history)-1]
+		h.Samples = append(h.Samples, report.HostSample{
+			Time:            time.Unix(t, 0).UTC().Format(time.RFC3339),
+			CPUUsagePercent: cpu,
+			MemoryUsedMB:    int(mem + 0.5),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+})
+
+// Aggregates per value of the label given in ?by=, e.g. /group/summary?by=cluster.
+http.HandleFunc("/group/summary", func(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if !labels.ValidKey(by) {
+		http.Error(w, "by must be a label key", http.StatusBadRequest)
+		return
+	}
+	sel, ok := selectorParam(w, r)
+	if !ok {
+		return
+	}
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	gpus, err := queryGPUs(db)
+	if err == nil {
+		gpus, err = filterGPUs(db, gpus, sel)
+	}
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	hosts, err := queryHosts(db)
+	if err == nil {
+		hosts, err = filterHosts(db, hosts, sel)
+	}
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeGroups(by, hostLabels, gpus, hosts, time.Now()))
+})
+
+// Labels of every host, keyed by hostname.
+http.HandleFunc("/labels", func(w http.ResponseWriter, r *http.Request) {
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hostLabels)
+})
+
+http.HandleFunc("/admin/labels", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Hostname string            `json:"hostname"`
+		Set      map[string]string `json:"set"`
+		Remove   []string          `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Hostname == "" {
+		http.Error(w, "hostname is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateLabels(req.Set); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if err := setAdminLabels(tx, req.Hostname, req.Set, req.Remove); err != nil {
+		tx.Rollback()
+		http.Error(w, "Insert error", http.StatusInternalServerError)
+		return
+	}
+	tx.Commit()
+
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hostLabels[req.Hostname])
+})
+
+http.HandleFunc("/alerts/active", func(w http.ResponseWriter, r *http.Request) {
+	sel, ok := selectorParam(w, r)
+	if !ok {
+		return
+	}
+	events, err := queryEvents(db, "resolved_at IS NULL")
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	admin := isAdmin(r)
+	matched := []alerts.Event{}
+	for _, ev := range events {
+		if sel.Matches(hostLabels[ev.Host]) {
+			if !admin {
+				ev.Alert = redactCheck(ev.Alert)
+			}
+			matched = append(matched, ev)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched)
+})
+
+http.HandleFunc("/admin/alerts/ack", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int64  `json:"id"`
+		By string `json:"by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`UPDATE alert_events SET acked_by = ?, acked_at = ?, next_reminder_at = NULL
+		WHERE id = ? AND resolved_at IS NULL`, req.By, time.Now().UTC(), req.ID)
+	if err != nil {
+		http.Error(w, "Update error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "No such active alert", http.StatusNotFound)
+		return
+	}
+	writeEvent(w, db, req.ID)
+})
+
+http.HandleFunc("/admin/alerts/snooze", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID  int64  `json:"id"`
+		For string `json:"for"`
+		By  string `json:"by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(req.For)
+	if err != nil || d <= 0 || d > historyRetention {
+		http.Error(w, "for must be a duration of at most "+historyRetention.String(), http.StatusBadRequest)
+		return
+	}
+
+	until := time.Now().UTC().Add(d)
+	res, err := db.Exec(`UPDATE alert_events SET snoozed_until = ?, next_reminder_at = ?
+		WHERE id = ? AND resolved_at IS NULL`, until, until, req.ID)
+	if err != nil {
+		http.Error(w, "Update error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "No such active alert", http.StatusNotFound)
+		return
+	}
+	log.Printf("Alert %d snoozed until %s by %s", req.ID, until.Format(time.RFC3339), req.By)
+	writeEvent(w, db, req.ID)
+})
+
+http.HandleFunc("/admin/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+		subs, err := querySubscriptions(db, chatID)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subs)
+	case http.MethodPost:
+		var sub alerts.Subscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sub.ChatID == 0 {
+			http.Error(w, "chat_id is required", http.StatusBadRequest)
+			return
+		}
+		if sub.MinSeverity == "" {
+			sub.MinSeverity = alerts.Warning
+		}
+		if _, err := alerts.ParseSeverity(string(sub.MinSeverity)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sel, err := labels.Parse(sub.Selector)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sub.Selector = sel.String()
+
+		now := time.Now().UTC()
+		res, err := db.Exec(`INSERT INTO alert_subscriptions (chat_id, selector, min_severity, created_at) VALUES (?, ?, ?, ?)`,
+			sub.ChatID, sub.Selector, sub.MinSeverity, now)
+		if err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+		sub.ID, _ = res.LastInsertId()
+		sub.CreatedAt = now.Format(time.RFC3339)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sub)
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+})
+
+http.HandleFunc("/admin/subscriptions/delete", deleteForChat(db, "alert_subscriptions"))
+
+http.HandleFunc("/admin/mutes", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+		mutes, err := queryMutes(db, chatID, time.Now())
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mutes)
+	case http.MethodPost:
+		var req struct {
+			alerts.Mute
+			For string `json:"for"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		m := req.Mute
+		if m.ChatID == 0 || m.Value == "" {
+			http.Error(w, "chat_id and value are required", http.StatusBadRequest)
+			return
+		}
+		if m.Kind != alerts.MuteHost && m.Kind != alerts.MuteGPU && m.Kind != alerts.MuteRule {
+			http.Error(w, "kind must be host, gpu or rule", http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(req.For)
+		if err != nil || d <= 0 || d > 30*24*time.Hour {
+			http.Error(w, "for must be a duration of at most 720h", http.StatusBadRequest)
+			return
+		}
+
+		until := time.Now().UTC().Add(d)
+		res, err := db.Exec(`INSERT INTO alert_mutes (chat_id, kind, value, until, created_by) VALUES (?, ?, ?, ?, ?)`,
+			m.ChatID, m.Kind, m.Value, until, m.CreatedBy)
+		if err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+		m.ID, _ = res.LastInsertId()
+		m.Until = until.Format(time.RFC3339)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+})
+
+http.HandleFunc("/admin/mutes/delete", deleteForChat(db, "alert_mutes"))
+
+http.HandleFunc("/admin/notifications", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "checks" && source != "hosts" {
+		http.Error(w, "source must be checks or hosts", http.StatusBadRequest)
+		return
+	}
+	pending, err := queryPendingNotifications(db, source)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+})
+
+http.HandleFunc("/admin/notifications/sent", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	for _, id := range req.IDs {
+		if _, err := tx.Exec(`UPDATE alert_notifications SET sent_at = ? WHERE id = ?`, now, id); err != nil {
+			tx.Rollback()
+			http.Error(w, "Update error", http.StatusInternalServerError)
+			return
+		}
+	}
+	tx.Commit()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+})
+
+http.HandleFunc("/admin/notify/test", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Channel string `json:"channel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if router == nil {
+		http.Error(w, "No notification channels, set "+notifyConfigEnv, http.StatusNotFound)
+		return
+	}
+	known := false
+	for _, name := range router.Channels() {
+		known = known || name == req.Channel
+	}
+	if !known {
+		http.Error(w, "No channel "+req.Channel, http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), notifyTimeout)
+	defer cancel()
+	msg := notifier.Message{Title: "Test notification", Text: "Sent from gpumon to check channel " + req.Channel, Time: time.Now().UTC()}
+	if err := router.Send(ctx, req.Channel, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+})
+
+http.HandleFunc("/admin/pairing-codes", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		codes, err := queryPairingCodes(db, "1 = 1")
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(codes)
+	case http.MethodPost:
+		var req report.PairingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = botauth.Viewer.String()
+		}
+		role, err := botauth.ParseRole(req.Role)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl := 24 * time.Hour
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d <= 0 || d > 7*24*time.Hour {
+				http.Error(w, "ttl must be a duration of at most 168h", http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		subs := []report.PairingSubscription{}
+		for _, sub := range req.Subscriptions {
+			if sub.Severity == "" {
+				sub.Severity = string(alerts.Warning)
+			}
+			sev, err := alerts.ParseSeverity(sub.Severity)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sel, err := labels.Parse(sub.Selector)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			subs = append(subs, report.PairingSubscription{Selector: sel.String(), Severity: string(sev)})
+		}
+		subsJSON, _ := json.Marshal(subs)
+
+		code, err := newPairingCode()
+		if err != nil {
+			http.Error(w, "Failed to generate code", http.StatusInternalServerError)
+			return
+		}
+		now := time.Now().UTC()
+		res, err := db.Exec(`INSERT INTO pairing_codes (code_hash, role, subscriptions, note, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			hashSecret(normalizePairingCode(code)), role.String(), string(subsJSON), req.Note, now, now.Add(ttl))
+		if err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+
+		// Like join tokens, the code itself is only ever shown here.
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report.PairingCode{
+			ID:            id,
+			Code:          code,
+			Role:          role.String(),
+			Subscriptions: subs,
+			Note:          req.Note,
+			CreatedAt:     now.Format(time.RFC3339),
+			ExpiresAt:     now.Add(ttl).Format(time.RFC3339),
+		})
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+})
+
+// The bot redeems a code for the chat it was sent in: the code is used up
+// and the chat subscribed, and the bot grants the role itself.
+http.HandleFunc("/admin/pairing-codes/redeem", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req report.PairingRedemption
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ChatID == 0 {
+		http.Error(w, "chat_id is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	hash := hashSecret(normalizePairingCode(req.Code))
+	// Claiming the code in the UPDATE makes a code good for one chat even
+	// if two redeem it at once.
+	res, err := tx.Exec(`UPDATE pairing_codes SET used_at = ?, chat_id = ?, user_id = ?
+		WHERE code_hash = ? AND used_at IS NULL AND expires_at > ?`, now, req.ChatID, req.UserID, hash, now)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invalid, used or expired pairing code", http.StatusForbidden)
+		return
+	}
+	codes, err := queryPairingCodes(tx, "code_hash = ?", hash)
+	if err != nil || len(codes) == 0 {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	for _, sub := range codes[0].Subscriptions {
+		if _, err := tx.Exec(`INSERT INTO alert_subscriptions (chat_id, selector, min_severity, created_at) VALUES (?, ?, ?, ?)`,
+			req.ChatID, sub.Selector, sub.Severity, now); err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Pairing code %d used by chat %d", codes[0].ID, req.ChatID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes[0])
+})
+
+// The check endpoints need the admin token, since targets and their
+// errors name internal hosts.
+http.HandleFunc("/checks", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	list, err := store.List(0)
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+})
+
+http.HandleFunc("/checks/results", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := store.Get(id); err != nil {
+		checkError(w, err)
+		return
+	}
+	results, err := store.Results(id, limitParam(r, 50))
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+})
+
+http.HandleFunc("/checks/outages", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	outages, err := store.Outages(0, id, limitParam(r, 50))
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outages)
+})
+
+// Uptime reports of one check (?id=) or all of them, over ?period=, e.g.
+// 30d.
+http.HandleFunc("/checks/uptime", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	period := defaultCheckPeriod
+	if p := r.URL.Query().Get("period"); p != "" {
+		d, err := checks.ParsePeriod(p)
+		if err != nil || d > checkRetention {
+			http.Error(w, fmt.Sprintf("period must be like 7d or 12h, at most %dd", int(checkRetention.Hours()/24)), http.StatusBadRequest)
+			return
+		}
+		period = d
+	}
+	var list []checks.Service
+	var err error
+	if id := r.URL.Query().Get("id"); id != "" {
+		var c checks.Service
+		n, _ := strconv.Atoi(id)
+		c, err = store.Get(n)
+		list = append(list, c)
+	} else {
+		list, err = store.List(0)
+	}
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	type uptime struct {
+		Check  checks.Service `json:"check"`
+		Report checks.Report  `json:"report"`
+	}
+	out := []uptime{}
+	now := time.Now()
+	for _, c := range list {
+		rep, err := store.Report(c.ID, now, period)
+		if err != nil {
+			checkError(w, err)
+			return
+		}
+		out = append(out, uptime{c, rep})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+})
+
+// The checks a chat watches (?chat_id=), or all of them. POST adds a
+// check, or makes the chat watch the existing check of the same target.
+http.HandleFunc("/admin/checks", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+		list, err := store.List(chatID)
+		if err != nil {
+			checkError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req report.CheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		m := checks.Monitor{Type: strings.ToLower(req.Type), Target: req.Target, Retries: checks.DefaultRetries}
+		if m.Type == "" {
+			m.Type = checks.TCP
+		}
+		if err := m.SetAll(req.Settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.Validate(); err != nil {
+			http.Error(w, "Invalid check: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, added, err := store.Add(req.ChatID, m)
+		if err != nil {
+			checkError(w, err)
+			return
+		}
+		if added {
+			reloadChecks()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"check": c, "added": added})
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+})
+
+// Looks a check up by ?ref=, a #ID or target, among the ones ?chat_id=
+// watches or, with ?visible=false, all of them.
+http.HandleFunc("/admin/checks/find", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	chatID, _ := strconv.ParseInt(q.Get("chat_id"), 10, 64)
+	c, err := store.Find(chatID, q.Get("ref"), chatID != 0 && q.Get("visible") != "false")
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+})
+
+http.HandleFunc("/admin/checks/update", func(w http.ResponseWriter, r *http.Request) {
+	req, ok := checkRequest(w, r)
+	if !ok {
+		return
+	}
+	c, err := store.Get(req.ID)
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	m := c.Monitor
+	if err := m.SetAll(req.Settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := m.Validate(); err != nil {
+		http.Error(w, "Invalid check: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := store.Update(m); err != nil {
+		checkError(w, err)
+		return
+	}
+	reloadChecks()
+	if c, err = store.Get(req.ID); err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+})
+
+// Deletes a check for everyone watching it.
+http.HandleFunc("/admin/checks/delete", func(w http.ResponseWriter, r *http.Request) {
+	req, ok := checkRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := store.Delete(req.ID); err != nil {
+		checkError(w, err)
+		return
+	}
+	reloadChecks()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+})
+
+// The chat watches a check (id) or joins a team (team).
+http.HandleFunc("/admin/checks/watch", func(w http.ResponseWriter, r *http.Request) {
+	req, ok := checkRequest(w, r)
+	if !ok {
+		return
+	}
+	n := 1
+	var err error
+	if req.Team != "" {
+		n, err = store.JoinTeam(req.ChatID, req.Team)
+	} else if _, err = store.Get(req.ID); err == nil {
+		err = store.Watch(req.ChatID, req.ID)
+	}
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"checks": n})
+})
+
+// The chat stops watching a check (id) or leaves a team (team). Checks
+// nobody watches any more are deleted.
+http.HandleFunc("/admin/checks/unwatch", func(w http.ResponseWriter, r *http.Request) {
+	req, ok := checkRequest(w, r)
+	if !ok {
+		return
+	}
+	var deleted bool
+	var err error
+	if req.Team != "" {
+		err = store.LeaveTeam(req.ChatID, req.Team)
+	} else {
+		deleted, err = store.Unwatch(req.ChatID, req.ID)
+	}
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	reloadChecks()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"deleted": deleted})
+})
+
+http.HandleFunc("/admin/checks/share", func(w http.ResponseWriter, r *http.Request) {
+	req, ok := checkRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, err := store.Get(req.ID); err != nil {
+		checkError(w, err)
+		return
+	}
+	if err := store.Share(req.ChatID, req.ID, req.Team); err != nil {
+		checkError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+})
+
+http.HandleFunc("/admin/checks/unshare", func(w http.ResponseWriter, r *http.Request) {
+	req, ok := checkRequest(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := store.Unshare(req.ID, req.Team)
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	if deleted {
+		reloadChecks()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"deleted": deleted})
+})
+
+http.HandleFunc("/admin/checks/teams", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+	teams, err := store.Teams(chatID)
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+})
+
+// The outages of the checks ?chat_id= watches, or of one of them (?id=).
+http.HandleFunc("/admin/checks/outages", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat_id"), 10, 64)
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	outages, err := store.Outages(chatID, id, limitParam(r, 50))
+	if err != nil {
+		checkError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outages)
+})
+
+// GET lists the chats that get the weekly uptime summary, POST turns it
+// on or off for one.
+http.HandleFunc("/admin/checks/summaries", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		chats, err := store.SummaryChats()
+		if err != nil {
+			checkError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chats)
+	case http.MethodPost:
+		var req struct {
+			ChatID int64 `json:"chat_id"`
+			On     bool  `json:"on"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ChatID == 0 {
+			http.Error(w, "chat_id is required", http.StatusBadRequest)
+			return
+		}
+		if err := store.SetSummary(req.ChatID, req.On); err != nil {
+			checkError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+})
+
+// The status page is public: it shows how many hosts are reporting, but
+// not which.
+http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+	page, err := statusPage(db, store, statusConfig, time.Now())
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := status.Render(&buf, page); err != nil {
+		log.Println("Rendering status page failed:", err)
+		http.Error(w, "Failed to render the status page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+})
+
+http.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+	page, err := statusPage(db, store, statusConfig, time.Now())
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+})
+
+http.HandleFunc("/admin/status/incidents", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		incidents, err := queryIncidents(db, "1 = 1")
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(incidents)
+	case http.MethodPost:
+		var req report.IncidentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Status == "" {
+			req.Status = status.Investigating
+		}
+		switch {
+		case strings.TrimSpace(req.Title) == "" || strings.TrimSpace(req.Message) == "":
+			http.Error(w, "title and message are required", http.StatusBadRequest)
+			return
+		case !status.ValidIncidentStatus(req.Status):
+			http.Error(w, "status must be one of "+strings.Join(status.IncidentStatuses, ", "), http.StatusBadRequest)
+			return
+		}
+		known := map[string]bool{}
+		for _, name := range statusConfig.Components() {
+			known[name] = true
+		}
+		for _, c := range req.Components {
+			if !known[c] {
+				http.Error(w, fmt.Sprintf("unknown component %q", c), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Components == nil {
+			req.Components = []string{}
+		}
+		components, _ := json.Marshal(req.Components)
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		now := time.Now().UTC()
+		var resolvedAt interface{}
+		if req.Status == status.Resolved {
+			resolvedAt = now
+		}
+		res, err := tx.Exec(`INSERT INTO status_incidents (title, status, components, created_at, updated_at, resolved_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			req.Title, req.Status, string(components), now, now, resolvedAt)
+		if err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+		id, _ := res.LastInsertId()
+		if _, err := tx.Exec(`INSERT INTO status_incident_updates (incident_id, status, message, created_at) VALUES (?, ?, ?, ?)`,
+			id, req.Status, req.Message, now); err != nil {
+			http.Error(w, "Insert error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Incident %d opened: %s", id, req.Title)
+		writeIncident(w, db, id)
+	default:
+		http.Error(w, "Only GET and POST allowed", http.StatusMethodNotAllowed)
+	}
+})
+
+http.HandleFunc("/admin/status/incidents/update", func(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req report.IncidentUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case strings.TrimSpace(req.Message) == "":
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	case !status.ValidIncidentStatus(req.Status):
+		http.Error(w, "status must be one of "+strings.Join(status.IncidentStatuses, ", "), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	var resolvedAt interface{}
+	if req.Status == status.Resolved {
+		resolvedAt = now
+	}
+	// Updates only go to open incidents; reopening one takes a new
+	// incident.
+	res, err := tx.Exec(`UPDATE status_incidents SET status = ?, updated_at = ?, resolved_at = ? WHERE id = ? AND resolved_at IS NULL`,
+		req.Status, now, resolvedAt, req.ID)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "No open incident with that id", http.StatusNotFound)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO status_incident_updates (incident_id, status, message, created_at) VALUES (?, ?, ?, ?)`,
+		req.ID, req.Status, req.Message, now); err != nil {
+		http.Error(w, "Insert error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Incident %d is %s", req.ID, req.Status)
+	writeIncident(w, db, req.ID)
+})
+
+	log.Println("Listening on :1101...")
+	log.Fatal(http.ListenAndServe(":1101", nil))
+}
+
+// evaluateStatus works out the status page components from the latest
+// host reports, the active alerts, the service checks and the open
+// incidents.
+func evaluateStatus(db *sql.DB, store *checks.Store, cfg status.Config, now time.Time) (status.Page, error) {
+	var in status.Inputs
+	hosts, err := queryHosts(db)
+	if err != nil {
+		return status.Page{}, err
+	}
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		return status.Page{}, err
+	}
+	for _, h := range hosts {
+		updatedAt, _ := time.Parse(time.RFC3339, h.UpdatedAt)
+		in.Hosts = append(in.Hosts, status.Host{Name: h.Hostname, Labels: hostLabels[h.Hostname], UpdatedAt: updatedAt})
+	}
+	if in.Alerts, err = queryEvents(db, "resolved_at IS NULL"); err != nil {
+		return status.Page{}, err
+	}
+	if in.Incidents, err = queryIncidents(db, "resolved_at IS NULL"); err != nil {
+		return status.Page{}, err
+	}
+	in.Monitors, in.MonitorErr = store.List(0)
+	return status.Evaluate(cfg, in, now), nil
+}
+
+// statusPage is the page as served: the current state, the uptime bars
+// and the open and recently resolved incidents.
+func statusPage(db *sql.DB, store *checks.Store, cfg status.Config, now time.Time) (status.Page, error) {
+	page, err := evaluateStatus(db, store, cfg, now)
+	if err != nil {
+		return page, err
+	}
+	oldest := now.UTC().AddDate(0, 0, -status.HistoryDays).Format("2006-01-02")
+	rows, err := db.Query(`SELECT component, day, samples, degraded, outage FROM status_daily WHERE day >= ?`, oldest)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	counts := map[string]map[string]status.DayCount{}
+	for rows.Next() {
+		var component, day string
+		var n status.DayCount
+		if err := rows.Scan(&component, &day, &n.Samples, &n.Degraded, &n.Outage); err != nil {
+			return page, err
+		}
+		if counts[component] == nil {
+			counts[component] = map[string]status.DayCount{}
+		}
+		counts[component][day] = n
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	page.AddHistory(counts, now)
+
+	page.Incidents, err = queryIncidents(db, "resolved_at IS NULL OR resolved_at > ?", now.UTC().Add(-resolvedIncidentAge))
+	return page, err
+}
+
+// sampleStatus counts the current state of every component towards
+// today's uptime bar. Components whose state is unknown, such as ones
+// without any checks yet, are not counted either way.
+func sampleStatus(db *sql.DB, store *checks.Store, cfg status.Config, now time.Time) error {
+	page, err := evaluateStatus(db, store, cfg, now)
+	if err != nil {
+		return err
+	}
+	day := now.UTC().Format("2006-01-02")
+	for _, g := range page.Groups {
+		for _, c := range g.Components {
+			if c.Status == status.Unknown {
+				continue
+			}
+			var degraded, outage int
+			switch c.Status {
+			case status.Degraded:
+				degraded = 1
+			case status.Outage:
+				outage = 1
+			}
+			_, err := db.Exec(`INSERT INTO status_daily (component, day, samples, degraded, outage) VALUES (?, ?, 1, ?, ?)
+				ON CONFLICT(component, day) DO UPDATE SET samples = samples + 1, degraded = degraded + excluded.degraded, outage = outage + excluded.outage`,
+				c.Name, day, degraded, outage)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queryIncidents lists incidents with their updates, open ones first and
+// then the most recent.
+func queryIncidents(db *sql.DB, where string, args ...interface{}) ([]report.Incident, error) {
+	rows, err := db.Query(`SELECT id, title, status, components, created_at, updated_at, resolved_at
+		FROM status_incidents WHERE `+where+` ORDER BY resolved_at IS NOT NULL, updated_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	incidents := []report.Incident{}
+	index := map[int64]int{}
+	for rows.Next() {
+		var inc report.Incident
+		var components string
+		var created, updated time.Time
+		var resolved sql.NullTime
+		if err := rows.Scan(&inc.ID, &inc.Title, &inc.Status, &components, &created, &updated, &resolved); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(components), &inc.Components)
+		inc.CreatedAt = created.Format(time.RFC3339)
+		inc.UpdatedAt = updated.Format(time.RFC3339)
+		if resolved.Valid {
+			inc.ResolvedAt = resolved.Time.Format(time.RFC3339)
+		}
+		inc.Updates = []report.IncidentUpdate{}
+		index[inc.ID] = len(incidents)
+		incidents = append(incidents, inc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(incidents) == 0 {
+		return incidents, nil
+	}
+
+	ids := make([]int, 0, len(index))
+	for id := range index {
+		ids = append(ids, int(id))
+	}
+	rows, err = db.Query(`SELECT incident_id, status, message, created_at FROM status_incident_updates
+		WHERE incident_id IN (` + joinInts(ids) + `) ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var u report.IncidentUpdate
+		var created time.Time
+		if err := rows.Scan(&id, &u.Status, &u.Message, &created); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = created.Format(time.RFC3339)
+		inc := &incidents[index[id]]
+		inc.Updates = append(inc.Updates, u)
+	}
+	return incidents, rows.Err()
+}
+
+// checkRequest decodes an admin POST about the checks of a chat.
+func checkRequest(w http.ResponseWriter, r *http.Request) (report.CheckRequest, bool) {
+	var req report.CheckRequest
+	if !requireAdmin(w, r) {
+		return req, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// checkError answers with the status for an error of the check store:
+// 404 for a check or team that isn't there, 409 for a target several
+// checks share.
+func checkError(w http.ResponseWriter, err error) {
+	switch err {
+	case checks.ErrNotFound, checks.ErrNoTeam, checks.ErrNotMember, checks.ErrNotShared:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case checks.ErrAmbiguous:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case checks.ErrTeamName:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Println("Check store error:", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+	}
+}
+
+// limitParam returns the ?limit= of a list, def if there is none, and at
+// most 1000.
+func limitParam(r *http.Request, def int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || n < 1 {
+		return def
+	}
+	if n > 1000 {
+		return 1000
+	}
+	return n
+}
+
+func writeIncident(w http.ResponseWriter, db *sql.DB, id int64) {
+	incidents, err := queryIncidents(db, "id = ?", id)
+	if err != nil || len(incidents) == 0 {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(incidents[0])
+}
+
+// evaluateAlerts runs the alert rules over the latest reports and the
+// service checks, records which alerts started and resolved, and queues
+// notifications for the chats subscribed to them or watching the check.
+// The same notifications go to the router's channels, if there is a
+// router.
+func evaluateAlerts(db *sql.DB, store *checks.Store, router *notifier.Router, now time.Time) error {
+	gpus, err := queryGPUs(db)
+	if err != nil {
+		return err
+	}
+	hosts, err := queryHosts(db)
+	if err != nil {
+		return err
+	}
+	var found []alerts.Alert
+	for _, gpu := range gpus {
+		found = append(found, alerts.CheckGPU(gpu, now)...)
+	}
+	for _, host := range hosts {
+		found = append(found, alerts.CheckHost(host, now)...)
+	}
+	services, err := store.List(0)
+	if err != nil {
+		return err
+	}
+	for _, c := range services {
+		found = append(found, alerts.CheckService(c)...)
+	}
+
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		return err
+	}
+	subs, err := querySubscriptions(db, 0)
+	if err != nil {
+		return err
+	}
+	watchers, err := store.Recipients()
+	if err != nil {
+		return err
+	}
+	mutes, err := queryMutes(db, 0, now)
+	if err != nil {
+		return err
+	}
+	open, err := queryEvents(db, "resolved_at IS NULL")
+	if err != nil {
+		return err
+	}
+	byFingerprint := map[string]alerts.Event{}
+	for _, ev := range open {
+		byFingerprint[ev.Fingerprint()] = ev
+	}
+	var reminders map[int64]bool
+	if reminders, err = dueReminders(db, now); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	var outgoing []notifier.Message
+	notify := func(ev alerts.Event, kind string) error {
+		if router != nil {
+			outgoing = append(outgoing, notifier.FromEvent(ev, kind, hostLabels[ev.Host], now))
+		}
+		return queueNotifications(tx, ev, kind, subs, watchers, mutes, hostLabels, now)
+	}
+	seen := map[string]bool{}
+	for _, a := range found {
+		fp := a.Fingerprint()
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+
+		ev, ok := byFingerprint[fp]
+		if !ok {
+			var next interface{}
+			if a.Severity == alerts.Critical {
+				next = now.Add(reminderInterval)
+			}
+			res, err := tx.Exec(`INSERT INTO alert_events (fingerprint, rule, severity, hostname, gpu, check_id, message, started_at, next_reminder_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, fp, a.Rule, a.Severity, a.Host, a.GPU, a.Check, a.Message, now, next)
+			if err != nil {
+				return err
+			}
+			ev = alerts.Event{Alert: a, StartedAt: now.Format(time.RFC3339)}
+			ev.ID, _ = res.LastInsertId()
+			if err := notify(ev, alerts.Firing); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Messages carry the current reading, so keep them fresh.
+		ev.Message = a.Message
+		if _, err := tx.Exec(`UPDATE alert_events SET message = ? WHERE id = ?`, a.Message, ev.ID); err != nil {
+			return err
+		}
+		if reminders[ev.ID] {
+			// Only critical alerts keep reminding; a warning that was
+			// snoozed comes back once.
+			var next interface{}
+			if ev.Severity == alerts.Critical {
+				next = now.Add(reminderInterval)
+			}
+			if _, err := tx.Exec(`UPDATE alert_events SET next_reminder_at = ? WHERE id = ?`, next, ev.ID); err != nil {
+				return err
+			}
+			if err := notify(ev, alerts.Reminder); err != nil {
+				return err
+			}
+		}
+	}
+
+	for fp, ev := range byFingerprint {
+		if seen[fp] {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE alert_events SET resolved_at = ?, next_reminder_at = NULL WHERE id = ?`, now, ev.ID); err != nil {
+			return err
+		}
+		ev.ResolvedAt = now.Format(time.RFC3339)
+		if err := notify(ev, alerts.Resolved); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	go dispatch(router, outgoing)
+	return nil
+}
+
+// dispatch sends alert messages to the notifier channels. Failures are
+// only logged: the bot's subscriptions are the delivery that is tracked.
+func dispatch(router *notifier.Router, msgs []notifier.Message) {
+	for _, m := range msgs {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		if err := router.Notify(ctx, m); err != nil {
+			log.Printf("Notifying %q failed: %v", m.Title, err)
+		}
+		cancel()
+	}
+}
+
+// dueReminders returns the open alerts whose reminder time has passed.
+func dueReminders(db *sql.DB, now time.Time) (map[int64]bool, error) {
+	rows, err := db.Query(`SELECT id FROM alert_events
+		WHERE resolved_at IS NULL AND next_reminder_at IS NOT NULL AND next_reminder_at <= ?`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	due := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		due[id] = true
+	}
+	return due, rows.Err()
+}
+
+// queueNotifications queues ev once for every chat with a subscription
+// that matches it, or for a check's alert every chat in watchers for the
+// check, unless a mute covers it.
+func queueNotifications(tx *sql.Tx, ev alerts.Event, kind string, subs []alerts.Subscription, watchers map[int][]int64, mutes []alerts.Mute, hostLabels map[string]map[string]string, now time.Time) error {
+	var chats []int64
+	if ev.Check != 0 {
+		chats = watchers[ev.Check]
+	} else {
+		for _, sub := range subs {
+			if ev.Severity.Rank() < sub.MinSeverity.Rank() {
+				continue
+			}
+			sel, err := labels.Parse(sub.Selector)
+			if err == nil && sel.Matches(hostLabels[ev.Host]) {
+				chats = append(chats, sub.ChatID)
+			}
+		}
+	}
+	queued := map[int64]bool{}
+	for _, chatID := range chats {
+		if queued[chatID] {
+			continue
+		}
+		muted := false
+		for _, m := range mutes {
+			if m.ChatID == chatID && m.Matches(ev.Alert) {
+				muted = true
+				break
+			}
+		}
+		if muted {
+			continue
+		}
+		queued[chatID] = true
+		if _, err := tx.Exec(`INSERT INTO alert_notifications (chat_id, event_id, kind, created_at) VALUES (?, ?, ?, ?)`,
+			chatID, ev.ID, kind, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const eventColumns = `id, rule, severity, hostname, gpu, check_id, message, started_at, resolved_at, acked_by, acked_at, snoozed_until`
+
+func scanEvent(scan func(...interface{}) error) (alerts.Event, error) {
+	var ev alerts.Event
+	var started time.Time
+	var resolved, acked, snoozed sql.NullTime
+	err := scan(&ev.ID, &ev.Rule, &ev.Severity, &ev.Host, &ev.GPU, &ev.Check, &ev.Message, &started, &resolved, &ev.AckedBy, &acked, &snoozed)
+	if err != nil {
+		return ev, err
+	}
+	format := func(t sql.NullTime) string {
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format(time.RFC3339)
+	}
+	ev.StartedAt = started.Format(time.RFC3339)
+	ev.ResolvedAt = format(resolved)
+	ev.AckedAt = format(acked)
+	ev.SnoozedUntil = format(snoozed)
+	return ev, nil
+}
+
+func queryEvents(db *sql.DB, where string, args ...interface{}) ([]alerts.Event, error) {
+	rows, err := db.Query(`SELECT `+eventColumns+` FROM alert_events WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []alerts.Event{}
+	for rows.Next() {
+		ev, err := scanEvent(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+func writeEvent(w http.ResponseWriter, db *sql.DB, id int64) {
+	events, err := queryEvents(db, "id = ?", id)
+	if err != nil || len(events) == 0 {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events[0])
+}
+
+// querySubscriptions returns a chat's subscriptions, or everyone's when
+// chatID is 0.
+func querySubscriptions(db *sql.DB, chatID int64) ([]alerts.Subscription, error) {
+	rows, err := db.Query(`SELECT id, chat_id, selector, min_severity, created_at FROM alert_subscriptions
+		WHERE ? = 0 OR chat_id = ? ORDER BY id`, chatID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subs := []alerts.Subscription{}
+	for rows.Next() {
+		var sub alerts.Subscription
+		var created time.Time
+		if err := rows.Scan(&sub.ID, &sub.ChatID, &sub.Selector, &sub.MinSeverity, &created); err != nil {
+			return nil, err
+		}
+		sub.CreatedAt = created.Format(time.RFC3339)
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// queryMutes returns the mutes still in force at now for a chat, or for
+// everyone when chatID is 0.
+func queryMutes(db *sql.DB, chatID int64, now time.Time) ([]alerts.Mute, error) {
+	rows, err := db.Query(`SELECT id, chat_id, kind, value, until, created_by FROM alert_mutes
+		WHERE until > ? AND (? = 0 OR chat_id = ?) ORDER BY id`, now.UTC(), chatID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mutes := []alerts.Mute{}
+	for rows.Next() {
+		var m alerts.Mute
+		var until time.Time
+		if err := rows.Scan(&m.ID, &m.ChatID, &m.Kind, &m.Value, &until, &m.CreatedBy); err != nil {
+			return nil, err
+		}
+		m.Until = until.Format(time.RFC3339)
+		mutes = append(mutes, m)
+	}
+	return mutes, rows.Err()
+}
+
+// queryPendingNotifications returns the undelivered notifications of the
+// service checks' alerts ("checks"), of the others ("hosts"), or of all.
+func queryPendingNotifications(db *sql.DB, source string) ([]alerts.Notification, error) {
+	where := ""
+	switch source {
+	case "checks":
+		where = " AND e.check_id > 0"
+	case "hosts":
+		where = " AND e.check_id = 0"
+	}
+	rows, err := db.Query(`SELECT n.id, n.chat_id, n.kind, ` + prefixColumns("e.", eventColumns) + `
+		FROM alert_notifications n JOIN alert_events e ON e.id = n.event_id
+		WHERE n.sent_at IS NULL` + where + ` ORDER BY n.id LIMIT 100`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pending := []alerts.Notification{}
+	for rows.Next() {
+		var n alerts.Notification
+		n.Event, err = scanEvent(func(dest ...interface{}) error {
+			return rows.Scan(append([]interface{}{&n.ID, &n.ChatID, &n.Kind}, dest...)...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, n)
+	}
+	return pending, rows.Err()
+}
+
+func prefixColumns(prefix, columns string) string {
+	parts := strings.Split(columns, ", ")
+	for i := range parts {
+		parts[i] = prefix + parts[i]
+	}
+	return strings.Join(parts, ", ")
+}
+
+// deleteForChat removes a row owned by a chat from table, or all of the
+// chat's rows when the id is 0.
+func deleteForChat(db *sql.DB, table string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ChatID int64 `json:"chat_id"`
+			ID     int64 `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ChatID == 0 {
+			http.Error(w, "chat_id is required", http.StatusBadRequest)
+			return
+		}
+
+		res, err := db.Exec(`DELETE FROM `+table+` WHERE chat_id = ? AND (? = 0 OR id = ?)`, req.ChatID, req.ID, req.ID)
+		if err != nil {
+			http.Error(w, "Delete error", http.StatusInternalServerError)
+			return
+		}
+		n, _ := res.RowsAffected()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"deleted": n})
+	}
+}
+
+// requireAdmin checks the bearer token against GPUMON_ADMIN_TOKEN. Admin
+// endpoints are disabled when the variable is not set.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether the request carries the admin token.
+func isAdmin(r *http.Request) bool {
+	token := os.Getenv(adminTokenEnv)
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// redactCheck replaces a service check alert's target and error with the
+// check's ID, for public endpoints: like /checks, they would otherwise
+// name internal hosts. Other alerts are returned as they are.
+func redactCheck(a alerts.Alert) alerts.Alert {
+	if a.Check == 0 {
+		return a
+	}
+	a.Host = fmt.Sprintf("check #%d", a.Check)
+	state := "is down"
+	if a.Rule == "check_flapping" {
+		state = "is flapping"
+	}
+	a.Message = fmt.Sprintf("check #%d %s", a.Check, state)
+	return a
+}
+
+// authorizeHost checks that a report for hostname may be accepted. An
+// enrolled host must present its credential and be approved; hosts that
+// never enrolled are let through unless GPUMON_REQUIRE_ENROLLMENT is set.
+func authorizeHost(w http.ResponseWriter, r *http.Request, db *sql.DB, hostname string) bool {
+	var status, credentialHash string
+	err := db.QueryRow(`SELECT status, credential_hash FROM hosts WHERE hostname = ?`, hostname).Scan(&status, &credentialHash)
+	if err == sql.ErrNoRows {
+		if os.Getenv(requireEnrollmentEnv) != "" {
+			http.Error(w, "Host is not enrolled: "+hostname, http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return false
+	}
+
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(hashSecret(given)), []byte(credentialHash)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if status != report.HostApproved {
+		http.Error(w, "Host enrollment is "+status, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// selectorParam parses the ?selector= label selector, answering 400 when
+// it is malformed.
+func selectorParam(w http.ResponseWriter, r *http.Request) (labels.Selector, bool) {
+	sel, err := labels.Parse(r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, "Invalid selector: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return sel, true
+}
+
+// historyParams parses ?since= (how far back, default 1h) and ?step= (the
+// bucket size samples are averaged over, default one sample per report).
+func historyParams(w http.ResponseWriter, r *http.Request) (time.Time, int64, bool) {
+	since, step := time.Hour, time.Second
+	for name, d := range map[string]*time.Duration{"since": &since, "step": &step} {
+		v := r.URL.Query().Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < time.Second {
+			http.Error(w, "Invalid "+name+": "+v, http.StatusBadRequest)
+			return time.Time{}, 0, false
+		}
+		*d = parsed
+	}
+	if since > historyRetention {
+		since = historyRetention
+	}
+	return time.Now().Add(-since), int64(step / time.Second), true
+}
+
+// queryLabels returns the labels of every host that has any.
+func queryLabels(db *sql.DB) (map[string]map[string]string, error) {
+	rows, err := db.Query(`SELECT hostname, key, value FROM host_labels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hostLabels := map[string]map[string]string{}
+	for rows.Next() {
+		var hostname, key, value string
+		if err := rows.Scan(&hostname, &key, &value); err != nil {
+			return nil, err
+		}
+		if hostLabels[hostname] == nil {
+			hostLabels[hostname] = map[string]string{}
+		}
+		hostLabels[hostname][key] = value
+	}
+	return hostLabels, rows.Err()
+}
+
+func validateLabels(set map[string]string) error {
+	for k, v := range set {
+		if !labels.ValidKey(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if !labels.ValidValue(v) {
+			return fmt.Errorf("invalid label value %q", v)
+		}
+	}
+	return nil
+}
+
+// setAgentLabels replaces the agent-provided labels of a host. Keys an
+// admin has set are left alone.
+func setAgentLabels(tx *sql.Tx, hostname string, set map[string]string) error {
+	if _, err := tx.Exec(`DELETE FROM host_labels WHERE hostname = ? AND source = 'agent'`, hostname); err != nil {
+		return err
+	}
+	for k, v := range set {
+		_, err := tx.Exec(`INSERT INTO host_labels (hostname, key, value, source) VALUES (?, ?, ?, 'agent')
+			ON CONFLICT(hostname, key) DO NOTHING`, hostname, k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAdminLabels sets and removes labels on behalf of an admin.
+func setAdminLabels(tx *sql.Tx, hostname string, set map[string]string, remove []string) error {
+	for _, k := range remove {
+		if _, err := tx.Exec(`DELETE FROM host_labels WHERE hostname = ? AND key = ?`, hostname, k); err != nil {
+			return err
+		}
+	}
+	for k, v := range set {
+		_, err := tx.Exec(`INSERT INTO host_labels (hostname, key, value, source) VALUES (?, ?, ?, 'admin')
+			ON CONFLICT(hostname, key) DO UPDATE SET value=excluded.value, source='admin'`, hostname, k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterGPUs attaches host labels to the GPUs and keeps the ones whose
+// host matches sel.
+func filterGPUs(db *sql.DB, gpus []report.GPUReport, sel labels.Selector) ([]report.GPUReport, error) {
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		return nil, err
+	}
+	matched := []report.GPUReport{}
+	for _, gpu := range gpus {
+		gpu.Labels = hostLabels[gpu.Host()]
+		if sel.Matches(gpu.Labels) {
+			matched = append(matched, gpu)
+		}
+	}
+	return matched, nil
+}
+
+// filterHosts attaches labels to the hosts and keeps the ones matching sel.
+func filterHosts(db *sql.DB, hosts []report.HostReport, sel labels.Selector) ([]report.HostReport, error) {
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		return nil, err
+	}
+	matched := []report.HostReport{}
+	for _, host := range hosts {
+		host.Labels = hostLabels[host.Hostname]
+		if sel.Matches(host.Labels) {
+			matched = append(matched, host)
+		}
+	}
+	return matched, nil
+}
+
+// summarizeGroups aggregates GPUs, hosts and their alerts per value of
+// the label by. Hosts without the label form a group with an empty value.
+func summarizeGroups(by string, hostLabels map[string]map[string]string, gpus []report.GPUReport, hosts []report.HostReport, now time.Time) []report.GroupSummary {
+	groups := map[string]*report.GroupSummary{}
+	seen := map[string]bool{}
+	group := func(hostname string) *report.GroupSummary {
+		value := hostLabels[hostname][by]
+		g := groups[value]
+		if g == nil {
+			g = &report.GroupSummary{Label: by, Value: value}
+			groups[value] = g
+		}
+		if !seen[hostname] {
+			seen[hostname] = true
+			g.Hosts++
+		}
+		return g
+	}
+
+	for _, host := range hosts {
+		g := group(host.Hostname)
+		g.Alerts += len(alerts.CheckHost(host, now))
+	}
+	for _, gpu := range gpus {
+		g := group(gpu.Host())
+		g.GPUs++
+		if gpu.ProcessCount > 0 {
+			g.BusyGPUs++
+		}
+		g.AvgUtilization += float64(gpu.UtilizationGpuPercent)
+		g.MemoryUsedMiB += gpu.MemoryUsedMiB
+		g.MemoryTotalMiB += gpu.MemoryTotalMiB
+		g.PowerWatt += gpu.PowerWatt
+		g.Alerts += len(alerts.CheckGPU(gpu, now))
+	}
+
+	summaries := []report.GroupSummary{}
+	for _, g := range groups {
+		if g.GPUs > 0 {
+			g.AvgUtilization /= float64(g.GPUs)
+		}
+		summaries = append(summaries, *g)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Value < summaries[j].Value })
+	return summaries
+}
+
+// newSecret returns a random token with the given prefix.
+func newSecret(prefix string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// pairingAlphabet leaves out characters that are easily mistaken for one
+// another, like 0 and O or 1 and I.
+const pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newPairingCode returns a code such as "K7QM-3XPA", short enough to type
+// on a phone.
+func newPairingCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 9)
+	for i, c := range b {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, pairingAlphabet[int(c)%len(pairingAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizePairingCode accepts codes typed in lower case or without the
+// dash.
+func normalizePairingCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// queryPairingCodes works on the database or inside a transaction.
+func queryPairingCodes(q interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}, where string, args ...interface{}) ([]report.PairingCode, error) {
+	rows, err := q.Query(`SELECT id, role, subscriptions, note, created_at, expires_at, used_at, chat_id, user_id
+		FROM pairing_codes WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	codes := []report.PairingCode{}
+	for rows.Next() {
+		var c report.PairingCode
+		var subs string
+		var created, expires time.Time
+		var used sql.NullTime
+		var chatID, userID sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Role, &subs, &c.Note, &created, &expires, &used, &chatID, &userID); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(subs), &c.Subscriptions)
+		c.CreatedAt = created.Format(time.RFC3339)
+		c.ExpiresAt = expires.Format(time.RFC3339)
+		if used.Valid {
+			c.UsedAt = used.Time.Format(time.RFC3339)
+		}
+		c.ChatID, c.UserID = chatID.Int64, userID.Int64
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// queryEnrolledHosts lists enrolled hosts, optionally only those with the
+// given status.
+func queryEnrolledHosts(db *sql.DB, status string) ([]report.HostInfo, error) {
+	hostLabels, err := queryLabels(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT hostname, owner, rack, status, enrolled_at FROM hosts
+		WHERE ? = '' OR status = ? ORDER BY hostname`, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hosts := []report.HostInfo{}
+	for rows.Next() {
+		var h report.HostInfo
+		var enrolledAt time.Time
+		if err := rows.Scan(&h.Hostname, &h.Owner, &h.Rack, &h.Status, &enrolledAt); err != nil {
+			return nil, err
+		}
+		h.Labels = hostLabels[h.Hostname]
+		h.EnrolledAt = enrolledAt.Format(time.RFC3339)
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+func queryGPUs(db *sql.DB) ([]report.GPUReport, error) {
+	rows, err := db.Query(`SELECT index_id, name, fan_percent, temperature_c, power_watt,
+		memory_used_mib, memory_total_mib, utilization_gpu_percent,
+		process_count, process_names, updated_at,
+		COALESCE(uuid, ''), COALESCE(pci_bus_id, ''), ecc_volatile_corrected,
+		ecc_volatile_uncorrected, ecc_aggregate_corrected, ecc_aggregate_uncorrected,
+		retired_pages_sbe, retired_pages_dbe, retired_pages_pending,
+		throttle_reasons, clock_sm_mhz, clock_mem_mhz, power_limit_watt, pcie_gen,
+		pcie_gen_max, pcie_width, pcie_width_max, pcie_replay_count, mig_mode,
+		virtualization_mode, vendor
+		FROM gpu_inventory`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gpus []report.GPUReport
+	for rows.Next() {
+		var gpu report.GPUReport
+		var updatedAt time.Time
+		var throttle string
+		err := rows.Scan(&gpu.Index, &gpu.Name, &gpu.FanPercent, &gpu.TemperatureC,
+			&gpu.PowerWatt, &gpu.MemoryUsedMiB, &gpu.MemoryTotalMiB,
+			&gpu.UtilizationGpuPercent, &gpu.ProcessCount, &gpu.ProcessNames, &updatedAt,
+			&gpu.UUID, &gpu.PCIBusID, &gpu.ECCVolatileCorrected,
+			&gpu.ECCVolatileUncorrected, &gpu.ECCAggregateCorrected, &gpu.ECCAggregateUncorrected,
+			&gpu.RetiredPagesSingleBit, &gpu.RetiredPagesDoubleBit, &gpu.RetiredPagesPending,
+			&throttle, &gpu.ClockSMMHz, &gpu.ClockMemMHz, &gpu.PowerLimitWatt, &gpu.PCIeGen,
+			&gpu.PCIeGenMax, &gpu.PCIeWidth, &gpu.PCIeWidthMax, &gpu.PCIeReplayCount, &gpu.MIGMode,
+			&gpu.VirtualizationMode, &gpu.Vendor)
+		if err != nil {
+			return nil, err
+		}
+		gpu.UpdatedAt = updatedAt.Format(time.RFC3339)
+		if throttle != "" {
+			gpu.ThrottleReasons = strings.Split(throttle, ",")
+		}
+		gpus = append(gpus, gpu)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	migs, err := queryMIGInstances(db)
+	if err != nil {
+		return nil, err
+	}
+	xids, err := queryXIDs(db, time.Now().Add(-xidWindow))
+	if err != nil {
+		return nil, err
+	}
+	for i := range gpus {
+		if gpus[i].MIGMode {
+			gpus[i].MIGInstances = migs[gpus[i].Name]
+		}
+		gpus[i].XIDErrors = xids[gpus[i].Name]
+	}
+	return gpus, nil
+}
+
+// saveXIDs records the XIDs in a report. A report without XIDs leaves the
+// earlier ones alone: they stay until they are older than xidWindow.
+func saveXIDs(tx *sql.Tx, gpu report.GPUReport, now time.Time) error {
+	for _, code := range gpu.XIDErrors {
+		_, err := tx.Exec(`INSERT INTO gpu_xids (gpu_name, code, reported_at) VALUES (?, ?, ?)
+			ON CONFLICT(gpu_name, code) DO UPDATE SET reported_at = excluded.reported_at`,
+			gpu.Name, code, now.Unix())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryXIDs returns the XIDs every GPU reported since the given time, by
+// GPU name and in ascending order.
+func queryXIDs(db *sql.DB, since time.Time) (map[string][]int, error) {
+	rows, err := db.Query(`SELECT gpu_name, code FROM gpu_xids WHERE reported_at >= ? ORDER BY gpu_name, code`, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	xids := map[string][]int{}
+	for rows.Next() {
+		var name string
+		var code int
+		if err := rows.Scan(&name, &code); err != nil {
+			return nil, err
+		}
+		xids[name] = append(xids[name], code)
+	}
+	return xids, rows.Err()
+}
+
+func saveMIGInstances(tx *sql.Tx, gpu report.GPUReport) error {
+	if _, err := tx.Exec(`DELETE FROM gpu_mig_instances WHERE gpu_name = ?`, gpu.Name); err != nil {
+		return err
+	}
+	for _, mig := range gpu.MIGInstances {
+		_, err := tx.Exec(`INSERT INTO gpu_mig_instances
+		(gpu_name, gpu_instance_id, compute_instance_id, profile, uuid, compute_slices,
+		sm_count, memory_used_mib, memory_total_mib, process_count, process_names, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			gpu.Name, mig.GPUInstanceID, mig.ComputeInstanceID, mig.Profile, mig.UUID,
+			mig.ComputeSlices, mig.SMCount, mig.MemoryUsedMiB, mig.MemoryTotalMiB,
+			mig.ProcessCount, mig.ProcessNames, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryMIGInstances returns the MIG instances keyed by parent GPU name.
+func queryMIGInstances(db *sql.DB) (map[string][]report.MIGInstance, error) {
+	rows, err := db.Query(`SELECT gpu_name, gpu_instance_id, compute_instance_id, profile,
+		uuid, compute_slices, sm_count, memory_used_mib, memory_total_mib,
+		process_count, process_names
+		FROM gpu_mig_instances ORDER BY gpu_name, gpu_instance_id, compute_instance_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migs := map[string][]report.MIGInstance{}
+	for rows.Next() {
+		var gpuName string
+		var mig report.MIGInstance
+		err := rows.Scan(&gpuName, &mig.GPUInstanceID, &mig.ComputeInstanceID, &mig.Profile,
+			&mig.UUID, &mig.ComputeSlices, &mig.SMCount, &mig.MemoryUsedMiB, &mig.MemoryTotalMiB,
+			&mig.ProcessCount, &mig.ProcessNames)
+		if err != nil {
+			return nil, err
+		}
+		migs[gpuName] = append(migs[gpuName], mig)
+	}
+	return migs, rows.Err()
+}
+
+func queryHosts(db *sql.DB) ([]report.HostReport, error) {
+	rows, err := db.Query(`SELECT hostname, cpu_usage_percent, memory_used_mb, memory_total_mb, disk_used, disk_total, updated_at FROM host_metrics`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []report.HostReport
+	for rows.Next() {
+		var h report.HostReport
+		var updatedAt time.Time
+		if err := rows.Scan(&h.Hostname, &h.CPUUsagePercent, &h.MemoryUsedMB, &h.MemoryTotalMB, &h.DiskUsed, &h.DiskTotal, &updatedAt); err != nil {
+			return nil, err
+		}
+		h.UpdatedAt = updatedAt.Format(time.RFC3339)
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// migrateHostLabels moves the labels hosts were enrolled with, which
+// used to be a JSON column of hosts, into host_labels as admin labels.
+// Labels already set there win. The column is dropped afterwards, so this
+// runs once, and host_labels is the only place host labels are kept:
+// join tokens hold theirs until enroll copies them there.
+func migrateHostLabels(db *sql.DB) error {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('hosts') WHERE name = 'labels'`).Scan(&n)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT hostname, labels FROM hosts`)
+	if err != nil {
+		return err
+	}
+	hostLabels := map[string]map[string]string{}
+	for rows.Next() {
+		var hostname string
+		var data sql.NullString
+		if err := rows.Scan(&hostname, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		var set map[string]string
+		if err := json.Unmarshal([]byte(data.String), &set); err != nil && data.String != "" {
+			log.Printf("Skipping the labels of host %s: %v", hostname, err)
+		}
+		hostLabels[hostname] = set
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for hostname, set := range hostLabels {
+		for k, v := range set {
+			if !labels.ValidKey(k) || !labels.ValidValue(v) {
+				log.Printf("Skipping invalid label %s=%s of host %s", k, v, hostname)
+				continue
+			}
+			_, err := tx.Exec(`INSERT OR IGNORE INTO host_labels (hostname, key, value, source) VALUES (?, ?, ?, 'admin')`,
+				hostname, k, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec(`ALTER TABLE hosts DROP COLUMN labels`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumns adds any of the given "name TYPE" column definitions that
+// table does not have yet.
+func addColumns(db *sql.DB, table string, columns []string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, col := range columns {
+		name := strings.Fields(col)[0]
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + col); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,555 @@
+// The repository root holds several programs, so its tests are run one
+// program at a time:
+//
+//	go test main.go main_test.go
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gpu-monitor/checks"
+	"gpu-monitor/report"
+	"gpu-monitor/sim"
+)
+
+const testServer = "http://localhost:1101"
+
+// TestMain runs the tests in a temporary directory, where the collector
+// started by startServer keeps its database.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gpumon-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err == nil {
+		err = os.Chdir(dir)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv(adminTokenEnv, "admin-token")
+	code := m.Run()
+	os.Chdir(wd)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var (
+	serverOnce sync.Once
+	serverSkip string // why the collector could not be started
+)
+
+// startServer runs the collector, once for all the tests, so it starts
+// with an empty database. It listens on the fixed port, and the tests
+// are skipped when that is taken.
+func startServer(t *testing.T) *sql.DB {
+	t.Helper()
+	serverOnce.Do(func() {
+		l, err := net.Listen("tcp", ":1101")
+		if err != nil {
+			serverSkip = fmt.Sprint("port 1101 is in use: ", err)
+			return
+		}
+		l.Close()
+
+		go main()
+		client := &http.Client{Timeout: time.Second}
+		for deadline := time.Now().Add(10 * time.Second); ; {
+			resp, err := client.Get(testServer + "/gpu/list")
+			if err == nil {
+				resp.Body.Close()
+				return
+			}
+			if time.Now().After(deadline) {
+				serverSkip = fmt.Sprint("server did not start: ", err)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	if serverSkip != "" {
+		t.Skip(serverSkip)
+	}
+
+	db, err := sql.Open("sqlite3", "./gpu_inventory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func getJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(testServer + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+// TestFleet posts a simulated fleet through the report endpoints the
+// agents use and runs the alert rules over what the server stored.
+func TestFleet(t *testing.T) {
+	db := startServer(t)
+
+	cfg := sim.DefaultConfig()
+	cfg.HostPrefix = "e2e-node"
+	cfg.ThermalRunaways = 1
+	fleet := sim.NewFleet(cfg)
+	client := &http.Client{Timeout: 10 * time.Second}
+	// A runaway GPU climbs at least 1.5°C a step from about 34°C, so it
+	// is past the alert threshold well within 50 steps.
+	for step := 0; step < 50; step++ {
+		if err := fleet.Post(client, testServer); err != nil {
+			t.Fatal(err)
+		}
+		fleet.Advance()
+	}
+
+	var gpus []report.GPUReport
+	getJSON(t, "/gpu/list", &gpus)
+	n := 0
+	for _, g := range gpus {
+		if strings.HasPrefix(g.Host(), cfg.HostPrefix+"-") {
+			n++
+		}
+	}
+	if want := cfg.Hosts * cfg.GPUsPerHost; n != want {
+		t.Fatalf("server lists %d GPUs of the fleet, want %d", n, want)
+	}
+	var hot string
+	for _, h := range fleet.Hosts {
+		for _, g := range h.GPUs {
+			if g.Fault == sim.ThermalRunaway {
+				hot = fleet.GPUReports(h)[g.Index].Name
+			}
+		}
+	}
+	if hot == "" {
+		t.Fatal("the fleet has no runaway GPU")
+	}
+
+	store, err := checks.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Twice: an alert that is still firing must not be opened again.
+	for i := 0; i < 2; i++ {
+		if err := evaluateAlerts(db, store, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT gpu FROM alert_events WHERE rule = 'gpu_temperature' AND resolved_at IS NULL`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var firing []string
+	for rows.Next() {
+		var gpu string
+		if err := rows.Scan(&gpu); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(gpu, "@"+cfg.HostPrefix+"-") {
+			firing = append(firing, gpu)
+		}
+	}
+	if len(firing) != 1 || firing[0] != hot {
+		t.Errorf("gpu_temperature fires for %q, want only %q", firing, hot)
+	}
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	getJSON(t, "/healthcheck", &health)
+	if health.Status != "unhealthy" {
+		t.Errorf("healthcheck status %q, want unhealthy", health.Status)
+	}
+
+}
+
+// TestXIDs reports an XID once and checks that gpu_xid keeps firing on
+// the reports after it until the XID is older than xidWindow.
+func TestXIDs(t *testing.T) {
+	db := startServer(t)
+	store, err := checks.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const name = "0@xid-node@NVIDIA H100"
+	gpu := report.GPUReport{Name: name, TemperatureC: 40, ProcessCount: 1}
+	firing := func(t *testing.T, xids ...int) string {
+		t.Helper()
+		gpu.XIDErrors = xids
+		if code, body := post(t, "/gpu/report", "", []report.GPUReport{gpu}); code != http.StatusOK {
+			t.Fatalf("POST /gpu/report: %d %s", code, body)
+		}
+		if err := evaluateAlerts(db, store, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		var severity, message string
+		err := db.QueryRow(`SELECT severity, message FROM alert_events
+			WHERE rule = 'gpu_xid' AND gpu = ? AND resolved_at IS NULL`, name).Scan(&severity, &message)
+		if err == sql.ErrNoRows {
+			return ""
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return severity + ": " + message
+	}
+
+	want := "critical: GPU " + name + " reported XID 79"
+	if got := firing(t, 79); got != want {
+		t.Fatalf("after XID 79: %q, want %q", got, want)
+	}
+	// The agent only sends an XID in the scan after it was logged.
+	for i := 0; i < 3; i++ {
+		if got := firing(t); got != want {
+			t.Fatalf("report %d without XIDs: %q, want %q", i+1, got, want)
+		}
+	}
+	var gpus []report.GPUReport
+	getJSON(t, "/gpu/list", &gpus)
+	for _, g := range gpus {
+		if g.Name == name && !reflect.DeepEqual(g.XIDErrors, []int{79}) {
+			t.Errorf("/gpu/list has XIDs %v, want [79]", g.XIDErrors)
+		}
+	}
+
+	if _, err := db.Exec(`UPDATE gpu_xids SET reported_at = ? WHERE gpu_name = ?`,
+		time.Now().Add(-xidWindow-time.Minute).Unix(), name); err != nil {
+		t.Fatal(err)
+	}
+	if got := firing(t, 13); got != "warning: GPU "+name+" reported XID 13" {
+		t.Errorf("after XID 79 aged out and XID 13: %q", got)
+	}
+}
+
+// TestMigrateHostLabels upgrades a database whose hosts were enrolled
+// with labels in a JSON column.
+func TestMigrateHostLabels(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/gpu_inventory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE hosts (hostname TEXT PRIMARY KEY, owner TEXT DEFAULT '', rack TEXT DEFAULT '',
+			labels TEXT DEFAULT '{}', status TEXT, credential_hash TEXT UNIQUE, enrolled_at DATETIME, reviewed_at DATETIME)`,
+		`CREATE TABLE host_labels (hostname TEXT, key TEXT, value TEXT, source TEXT, PRIMARY KEY(hostname, key))`,
+		`INSERT INTO hosts (hostname, labels) VALUES ('gpu-01', '{"cluster":"training","env":"prod"}'), ('gpu-02', '{}'), ('gpu-03', NULL)`,
+		`INSERT INTO host_labels VALUES ('gpu-01', 'env', 'staging', 'admin')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second run finds the column gone and does nothing.
+	for i := 0; i < 2; i++ {
+		if err := migrateHostLabels(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := queryLabels(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{"gpu-01": {"cluster": "training", "env": "staging"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("labels %v, want %v", got, want)
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('hosts') WHERE name = 'labels'`).Scan(&n)
+	if n != 0 {
+		t.Error("hosts still has the labels column")
+	}
+}
+
+// get fetches path, with the bearer token if not empty, and returns the
+// status and the response body.
+func get(t *testing.T, path, token string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, testServer+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// TestCheckEndpointsNeedAdmin checks that service check targets, which
+// name internal hosts, are only shown with the admin token.
+func TestCheckEndpointsNeedAdmin(t *testing.T) {
+	db := startServer(t)
+	const target = "127.0.0.1:1"
+	res, err := db.Exec(`INSERT INTO checks (type, target, up, last_error) VALUES ('tcp', ?, 0, ?)`,
+		target, "dial tcp "+target+": connect: connection refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	store, err := checks.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := evaluateAlerts(db, store, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/checks", "/checks/results?id=1", "/checks/outages", "/checks/uptime"} {
+		if code, _ := get(t, path, ""); code != http.StatusUnauthorized {
+			t.Errorf("GET %s without the admin token: %d", path, code)
+		}
+	}
+	if code, body := get(t, "/checks", "admin-token"); code != http.StatusOK || !strings.Contains(body, target) {
+		t.Errorf("GET /checks with the admin token: %d %s", code, body)
+	}
+
+	// The public endpoints tell the check is down, but not what it checks.
+	redacted := fmt.Sprintf("check #%d is down", id)
+	for _, path := range []string{"/healthcheck", "/alerts/active"} {
+		_, body := get(t, path, "")
+		if strings.Contains(body, target) || !strings.Contains(body, redacted) {
+			t.Errorf("GET %s without the admin token: %s", path, body)
+		}
+		_, body = get(t, path, "admin-token")
+		if !strings.Contains(body, target) {
+			t.Errorf("GET %s with the admin token lacks the target: %s", path, body)
+		}
+	}
+}
+
+// post sends v as JSON to path, with the bearer token if not empty, and
+// returns the status and the response body.
+func post(t *testing.T, path, token string, v interface{}) (int, []byte) {
+	t.Helper()
+	body, _ := json.Marshal(v)
+	req, err := http.NewRequest(http.MethodPost, testServer+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, out
+}
+
+// joinToken mints a join token with the given settings.
+func joinToken(t *testing.T, settings map[string]interface{}) string {
+	t.Helper()
+	code, body := post(t, "/admin/join-tokens", "admin-token", settings)
+	var res struct {
+		Token string `json:"token"`
+	}
+	if code != http.StatusOK || json.Unmarshal(body, &res) != nil {
+		t.Fatalf("minting a join token: %d %s", code, body)
+	}
+	return res.Token
+}
+
+// enrollHost redeems token for hostname and returns the status code and
+// the enrollment.
+func enrollHost(t *testing.T, token, hostname string) (int, report.EnrollResponse) {
+	t.Helper()
+	code, body := post(t, "/agent/enroll", "", report.EnrollRequest{Token: token, Hostname: hostname})
+	var res report.EnrollResponse
+	if code == http.StatusOK {
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return code, res
+}
+
+// hostReport posts a host report as hostname with credential and returns
+// the status code.
+func hostReport(t *testing.T, hostname, credential string) int {
+	t.Helper()
+	code, _ := post(t, "/host/report", credential, report.HostReport{Hostname: hostname})
+	return code
+}
+
+func TestEnroll(t *testing.T) {
+	db := startServer(t)
+
+	// A token is good for one host, pending review unless it approves.
+	token := joinToken(t, map[string]interface{}{"owner": "ml-team"})
+	code, first := enrollHost(t, token, "enroll-01")
+	if code != http.StatusOK || first.Status != report.HostPending || first.Credential == "" {
+		t.Fatalf("enroll: %d %+v", code, first)
+	}
+	if code, _ := enrollHost(t, token, "enroll-02"); code != http.StatusForbidden {
+		t.Errorf("reusing a token: %d, want 403", code)
+	}
+	if code := hostReport(t, "enroll-01", first.Credential); code != http.StatusForbidden {
+		t.Errorf("report from a pending host: %d, want 403", code)
+	}
+	if code, body := post(t, "/admin/hosts/approve", "admin-token", map[string]string{"hostname": "enroll-01"}); code != http.StatusOK {
+		t.Fatalf("approve: %d %s", code, body)
+	}
+	if code := hostReport(t, "enroll-01", first.Credential); code != http.StatusOK {
+		t.Errorf("report from an approved host: %d", code)
+	}
+
+	// Another token cannot take the enrolled host over.
+	auto := joinToken(t, map[string]interface{}{"auto_approve": true})
+	if code, _ := enrollHost(t, auto, "enroll-01"); code != http.StatusConflict {
+		t.Errorf("re-enrolling with an unbound token: %d, want 409", code)
+	}
+	if code := hostReport(t, "enroll-01", first.Credential); code != http.StatusOK {
+		t.Errorf("report after a refused takeover: %d", code)
+	}
+	// The refusal left the token unused.
+	if code, res := enrollHost(t, auto, "enroll-03"); code != http.StatusOK || res.Status != report.HostApproved {
+		t.Errorf("auto-approved enroll: %d %+v", code, res)
+	}
+
+	// A token minted for the host re-enrolls it, e.g. after a reinstall.
+	bound := joinToken(t, map[string]interface{}{"hostname": "enroll-01"})
+	if code, _ := enrollHost(t, bound, "enroll-04"); code != http.StatusForbidden {
+		t.Errorf("a token for another host: %d, want 403", code)
+	}
+	bound = joinToken(t, map[string]interface{}{"hostname": "enroll-01"})
+	code, second := enrollHost(t, bound, "enroll-01")
+	if code != http.StatusOK || second.Status != report.HostPending {
+		t.Fatalf("re-enroll: %d %+v", code, second)
+	}
+	if code := hostReport(t, "enroll-01", first.Credential); code != http.StatusUnauthorized {
+		t.Errorf("report with the replaced credential: %d, want 401", code)
+	}
+
+	// Expired tokens are refused.
+	expired := "gpumon_join_expired"
+	_, err := db.Exec(`INSERT INTO join_tokens (token_hash, created_at, expires_at) VALUES (?, ?, ?)`,
+		hashSecret(expired), time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := enrollHost(t, expired, "enroll-05"); code != http.StatusForbidden {
+		t.Errorf("expired token: %d, want 403", code)
+	}
+	if code, _ := enrollHost(t, "gpumon_join_unknown", "enroll-05"); code != http.StatusForbidden {
+		t.Errorf("unknown token: %d, want 403", code)
+	}
+}
+
+// Hosts racing to redeem one token: only one gets it.
+func TestEnrollRace(t *testing.T) {
+	startServer(t)
+	token := joinToken(t, nil)
+	codes := make(chan int, 8)
+	for i := 0; i < cap(codes); i++ {
+		go func(i int) {
+			body, _ := json.Marshal(report.EnrollRequest{Token: token, Hostname: fmt.Sprintf("race-%02d", i)})
+			resp, err := http.Post(testServer+"/agent/enroll", "application/json", bytes.NewReader(body))
+			if err != nil {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}(i)
+	}
+	enrolled := 0
+	for i := 0; i < cap(codes); i++ {
+		if <-codes == http.StatusOK {
+			enrolled++
+		}
+	}
+	if enrolled != 1 {
+		t.Errorf("%d hosts enrolled with one token", enrolled)
+	}
+}
+
+func TestAuthorizeHost(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/gpu_inventory.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE hosts (hostname TEXT PRIMARY KEY, owner TEXT DEFAULT '', rack TEXT DEFAULT '',
+			status TEXT, credential_hash TEXT UNIQUE, enrolled_at DATETIME, reviewed_at DATETIME)`,
+		`INSERT INTO hosts (hostname, status, credential_hash) VALUES
+			('approved', 'approved', '` + hashSecret("secret-a") + `'),
+			('pending', 'pending', '` + hashSecret("secret-p") + `'),
+			('rejected', 'rejected', '` + hashSecret("secret-r") + `')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		credential string
+		require    bool
+		code       int // 0 if authorized
+	}{
+		{"unenrolled", "legacy", "", false, 0},
+		{"unenrolled with enrollment required", "legacy", "", true, http.StatusUnauthorized},
+		{"approved", "approved", "secret-a", false, 0},
+		{"approved with enrollment required", "approved", "secret-a", true, 0},
+		{"wrong credential", "approved", "secret-p", false, http.StatusUnauthorized},
+		{"no credential", "approved", "", false, http.StatusUnauthorized},
+		{"pending", "pending", "secret-p", false, http.StatusForbidden},
+		{"rejected", "rejected", "secret-r", true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.require {
+				t.Setenv(requireEnrollmentEnv, "1")
+			} else {
+				t.Setenv(requireEnrollmentEnv, "")
+			}
+			r := httptest.NewRequest(http.MethodPost, "/host/report", nil)
+			if tt.credential != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.credential)
+			}
+			w := httptest.NewRecorder()
+			ok := authorizeHost(w, r, db, tt.host)
+			if ok != (tt.code == 0) || (!ok && w.Code != tt.code) {
+				t.Errorf("authorized %v, status %d, want %d", ok, w.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+
+	"gpu-monitor/labels"
+)
+
+// Slack posts to a Slack incoming webhook as a colored attachment.
+type Slack struct {
+	URL string
+}
+
+func (s Slack) Notify(ctx context.Context, m Message) error {
+	return postJSON(ctx, "POST", s.URL, slackPayload(m), nil)
+}
+
+// Mattermost incoming webhooks accept Slack's format.
+type Mattermost struct {
+	URL string
+	// Channel overrides the webhook's default channel when set.
+	Channel string
+}
+
+func (mm Mattermost) Notify(ctx context.Context, m Message) error {
+	p := slackPayload(m)
+	if mm.Channel != "" {
+		p["channel"] = mm.Channel
+	}
+	return postJSON(ctx, "POST", mm.URL, p, nil)
+}
+
+func slackPayload(m Message) map[string]interface{} {
+	attachment := map[string]interface{}{
+		"fallback": m.emoji() + " " + m.Title,
+		"color":    hexColor(m.color()),
+		"title":    m.emoji() + " " + m.Title,
+		"text":     m.Text,
+		"ts":       m.Time.Unix(),
+	}
+	if l := labels.Format(m.Labels); l != "" {
+		attachment["footer"] = l
+	}
+	return map[string]interface{}{"attachments": []interface{}{attachment}}
+}
+
+// Discord posts to a Discord webhook as an embed.
+type Discord struct {
+	URL string
+}
+
+func (d Discord) Notify(ctx context.Context, m Message) error {
+	embed := map[string]interface{}{
+		"title":       truncate(m.emoji()+" "+m.Title, 256),
+		"description": truncate(m.Text, 4096),
+		"color":       m.color(),
+		"timestamp":   m.Time.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	if l := labels.Format(m.Labels); l != "" {
+		embed["footer"] = map[string]string{"text": truncate(l, 2048)}
+	}
+	return postJSON(ctx, "POST", d.URL, map[string]interface{}{"embeds": []interface{}{embed}}, nil)
+}
+
+func hexColor(c int) string {
+	const digits = "0123456789abcdef"
+	b := []byte("#000000")
+	for i := 6; i > 0; i-- {
+		b[i] = digits[c&0xf]
+		c >>= 4
+	}
+	return string(b)
+}
+
+// truncate cuts s to n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
@@ -0,0 +1,130 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type slackBody struct {
+	Channel     string `json:"channel"`
+	Attachments []struct {
+		Fallback string `json:"fallback"`
+		Color    string `json:"color"`
+		Title    string `json:"title"`
+		Text     string `json:"text"`
+		TS       int64  `json:"ts"`
+		Footer   string `json:"footer"`
+	} `json:"attachments"`
+}
+
+func TestSlack(t *testing.T) {
+	rcv := newReceiver(t)
+	if err := (Slack{URL: rcv.URL + "/services/T0/B0/x"}).Notify(context.Background(), critical()); err != nil {
+		t.Fatal(err)
+	}
+	var body slackBody
+	r := rcv.only(t, &body)
+	if r.Method != http.MethodPost || r.Path != "/services/T0/B0/x" {
+		t.Errorf("%s %s", r.Method, r.Path)
+	}
+	if len(body.Attachments) != 1 {
+		t.Fatalf("%d attachments", len(body.Attachments))
+	}
+	a := body.Attachments[0]
+	if a.Title != "🚨 CRITICAL gpu_temperature on node-a" || a.Fallback != a.Title {
+		t.Errorf("title %q, fallback %q", a.Title, a.Fallback)
+	}
+	if a.Color != "#e01e5a" || a.TS != testTime.Unix() || a.Footer != "cluster=training,env=prod" {
+		t.Errorf("color %s ts %d footer %q", a.Color, a.TS, a.Footer)
+	}
+	if !strings.HasPrefix(a.Text, "GPU 1 on node-a is at 91°C\n") {
+		t.Errorf("text %q", a.Text)
+	}
+	if body.Channel != "" {
+		t.Errorf("channel %q", body.Channel)
+	}
+}
+
+func TestMattermost(t *testing.T) {
+	rcv := newReceiver(t)
+	m := critical()
+	m.Labels = nil
+	if err := (Mattermost{URL: rcv.URL + "/hooks/abc", Channel: "gpu-alerts"}).Notify(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	var body slackBody
+	rcv.only(t, &body)
+	if body.Channel != "gpu-alerts" || len(body.Attachments) != 1 || body.Attachments[0].Footer != "" {
+		t.Errorf("body %+v", body)
+	}
+}
+
+func TestDiscord(t *testing.T) {
+	rcv := newReceiver(t)
+	m := critical()
+	m.Text = strings.Repeat("x", 5000)
+	if err := (Discord{URL: rcv.URL + "/api/webhooks/1/abc"}).Notify(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Embeds []struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Color       int    `json:"color"`
+			Timestamp   string `json:"timestamp"`
+			Footer      struct {
+				Text string `json:"text"`
+			} `json:"footer"`
+		} `json:"embeds"`
+	}
+	rcv.only(t, &body)
+	if len(body.Embeds) != 1 {
+		t.Fatalf("%d embeds", len(body.Embeds))
+	}
+	e := body.Embeds[0]
+	if e.Title != "🚨 CRITICAL gpu_temperature on node-a" || e.Color != 0xe01e5a || e.Timestamp != "2026-10-19T08:30:00Z" || e.Footer.Text != "cluster=training,env=prod" {
+		t.Errorf("embed %+v", e)
+	}
+	// Discord rejects descriptions over 4096 characters.
+	if d := []rune(e.Description); len(d) != 4096 || d[4095] != '…' {
+		t.Errorf("description of %d runes", len(d))
+	}
+}
+
+func TestChatError(t *testing.T) {
+	rcv := newReceiver(t)
+	rcv.status = http.StatusBadRequest
+	err := (Slack{URL: rcv.URL}).Notify(context.Background(), critical())
+	if err == nil || err.Error() != "400 Bad Request: invalid_payload" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestHexColor(t *testing.T) {
+	for c, want := range map[int]string{0: "#000000", 0x2eb67d: "#2eb67d", 0xabc: "#000abc"} {
+		if got := hexColor(c); got != want {
+			t.Errorf("hexColor(%#x) = %s, want %s", c, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long here", 8, "too lon…"},
+		{"temp 91°C°C", 10, "temp 91°C…"},
+		{"cut at a space", 5, "cut…"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
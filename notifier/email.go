@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"gpu-monitor/labels"
+)
+
+// Email sends plain text mail through an SMTP server. The connection is
+// upgraded with STARTTLS when the server offers it, or is TLS from the
+// start when ImplicitTLS is set (usually port 465).
+type Email struct {
+	Server      string // host:port
+	Username    string // optional, for PLAIN auth
+	Password    string
+	From        string
+	To          []string
+	ImplicitTLS bool
+}
+
+// tlsRoots verifies the SMTP server's certificate; nil uses the system's
+// roots.
+var tlsRoots *x509.CertPool
+
+func (e Email) Notify(ctx context.Context, m Message) error {
+	host, _, err := net.SplitHostPort(e.Server)
+	if err != nil {
+		return fmt.Errorf("smtp server %q: %v", e.Server, err)
+	}
+	if len(e.To) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.Server)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+	if e.ImplicitTLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: host, RootCAs: tlsRoots})
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !e.ImplicitTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: host, RootCAs: tlsRoots}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+	// From may carry a display name, the envelope only takes the address.
+	from := e.From
+	if a, err := mail.ParseAddress(e.From); err == nil {
+		from = a.Address
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.message(m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (e Email) message(m Message) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := "gpumon"
+	if _, d, ok := strings.Cut(e.From, "@"); ok {
+		domain = strings.Trim(d, "> ")
+	}
+
+	var b strings.Builder
+	header := func(k, v string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	header("From", e.From)
+	header("To", strings.Join(e.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", "[gpumon] "+m.Title))
+	header("Date", m.Time.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+	if m.Severity != "" {
+		header("X-Gpumon-Severity", string(m.Severity))
+	}
+	b.WriteString("\r\n")
+
+	body := m.Text + "\n"
+	if m.Host != "" {
+		body += "\nHost: " + m.Host
+	}
+	if l := labels.Format(m.Labels); l != "" {
+		body += "\nLabels: " + l
+	}
+	// Mail wants CRLF line endings. Leading dots are escaped by c.Data.
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		b.WriteString(strings.TrimRight(line, "\r") + "\r\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,244 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the fake SMTP server saw of one delivery.
+type smtpSession struct {
+	TLS   bool // STARTTLS or implicit TLS before MAIL
+	Auth  string
+	From  string
+	To    []string
+	Data  string // as sent, before undoing dot-stuffing
+	Error error
+}
+
+// smtpServer accepts one connection on 127.0.0.1 and speaks enough SMTP
+// for Email: EHLO, STARTTLS, AUTH PLAIN, MAIL, RCPT, DATA and QUIT. It
+// offers STARTTLS unless implicit is set, in which case the connection is
+// TLS from the start.
+func smtpServer(t *testing.T, implicit bool) (addr string, session <-chan smtpSession) {
+	t.Helper()
+	// Borrow httptest's certificate, which is valid for 127.0.0.1.
+	hs := httptest.NewTLSServer(nil)
+	cert := hs.TLS.Certificates[0]
+	roots := x509.NewCertPool()
+	roots.AddCert(hs.Certificate())
+	hs.Close()
+	tlsRoots = roots
+	t.Cleanup(func() { tlsRoots = nil })
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	done := make(chan smtpSession, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- smtpSession{Error: err}
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		var s smtpSession
+		if implicit {
+			conn = tls.Server(conn, config)
+			s.TLS = true
+		}
+		s.Error = serveSMTP(conn, config, &s)
+		done <- s
+	}()
+	return l.Addr().String(), done
+}
+
+func serveSMTP(conn net.Conn, config *tls.Config, s *smtpSession) error {
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	reply := func(lines ...string) {
+		for _, l := range lines {
+			w.WriteString(l + "\r\n")
+		}
+		w.Flush()
+	}
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(line, "\r\n") {
+			return fmt.Errorf("command %q does not end in CRLF", line)
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSuffix(line, "\r\n"), " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			if s.TLS {
+				reply("250-localhost", "250 AUTH PLAIN")
+			} else {
+				reply("250-localhost", "250-STARTTLS", "250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tc := tls.Server(conn, config)
+			if err := tc.Handshake(); err != nil {
+				return err
+			}
+			conn, s.TLS = tc, true
+			r, w = bufio.NewReader(conn), bufio.NewWriter(conn)
+		case "AUTH":
+			mech, resp, _ := strings.Cut(arg, " ")
+			dec, err := base64.StdEncoding.DecodeString(resp)
+			if mech != "PLAIN" || err != nil {
+				reply("504 unrecognized authentication")
+				continue
+			}
+			s.Auth = string(dec)
+			reply("235 authenticated")
+		case "MAIL":
+			s.From = strings.TrimPrefix(arg, "FROM:")
+			reply("250 ok")
+		case "RCPT":
+			s.To = append(s.To, strings.TrimPrefix(arg, "TO:"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return err
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.Data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return nil
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	for _, implicit := range []bool{false, true} {
+		t.Run(fmt.Sprintf("implicit TLS %v", implicit), func(t *testing.T) {
+			addr, session := smtpServer(t, implicit)
+			e := Email{
+				Server:      addr,
+				Username:    "gpumon",
+				Password:    "hunter2",
+				From:        "GPU monitor <gpumon@example.org>",
+				To:          []string{"oncall@example.org", "ops@example.org"},
+				ImplicitTLS: implicit,
+			}
+			m := critical()
+			m.Text += "\n.hidden line\r\nlast"
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := e.Notify(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+
+			s := <-session
+			if s.Error != nil {
+				t.Fatal(s.Error)
+			}
+			if !s.TLS {
+				t.Error("mail was sent without TLS")
+			}
+			if s.Auth != "\x00gpumon\x00hunter2" {
+				t.Errorf("AUTH PLAIN %q", s.Auth)
+			}
+			if s.From != "<gpumon@example.org>" {
+				t.Errorf("MAIL FROM %s", s.From)
+			}
+			if want := []string{"<oncall@example.org>", "<ops@example.org>"}; strings.Join(s.To, " ") != strings.Join(want, " ") {
+				t.Errorf("RCPT TO %q, want %q", s.To, want)
+			}
+
+			// Every line ends in CRLF, and the line starting with a dot
+			// was stuffed.
+			for _, l := range strings.SplitAfter(s.Data, "\n") {
+				if l != "" && !strings.HasSuffix(l, "\r\n") {
+					t.Errorf("line %q does not end in CRLF", l)
+				}
+			}
+			if !strings.Contains(s.Data, "\r\n..hidden line\r\nlast\r\n") {
+				t.Errorf("body lines in %q", s.Data)
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(s.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil || subject != "[gpumon] CRITICAL gpu_temperature on node-a" {
+				t.Errorf("Subject %q: %v", subject, err)
+			}
+			date, err := msg.Header.Date()
+			if err != nil || !date.Equal(testTime) {
+				t.Errorf("Date %v: %v", date, err)
+			}
+			for k, want := range map[string]string{
+				"From":                      "GPU monitor <gpumon@example.org>",
+				"To":                        "oncall@example.org, ops@example.org",
+				"Mime-Version":              "1.0",
+				"Content-Type":              `text/plain; charset="utf-8"`,
+				"Content-Transfer-Encoding": "8bit",
+				"X-Gpumon-Severity":         "critical",
+			} {
+				if got := msg.Header.Get(k); got != want {
+					t.Errorf("%s: %q, want %q", k, got, want)
+				}
+			}
+			if id := msg.Header.Get("Message-Id"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.org>") {
+				t.Errorf("Message-ID %q", id)
+			}
+			if !strings.Contains(s.Data, "\r\nHost: node-a\r\nLabels: cluster=training,env=prod\r\n") {
+				t.Errorf("body %q lacks the host and labels", s.Data)
+			}
+		})
+	}
+}
+
+func TestEmailSubjectEncoding(t *testing.T) {
+	m := Message{Title: "GPU at 91°C", Text: "hot", Time: testTime}
+	data := string(Email{From: "gpumon@example.org", To: []string{"a@example.org"}}.message(m))
+	header, _, _ := strings.Cut(data, "\r\n\r\n")
+	if !strings.Contains(header, "Subject: =?utf-8?q?[gpumon]_GPU_at_91=C2=B0C?=\r\n") {
+		t.Errorf("header %q", header)
+	}
+	if strings.Contains(header, "X-Gpumon-Severity") {
+		t.Error("a message without a severity has X-Gpumon-Severity")
+	}
+}
+
+func TestEmailConfig(t *testing.T) {
+	ctx := context.Background()
+	if err := (Email{Server: "smtp.example.org", To: []string{"a@example.org"}}).Notify(ctx, critical()); err == nil {
+		t.Error("a server without a port was accepted")
+	}
+	if err := (Email{Server: "127.0.0.1:25"}).Notify(ctx, critical()); err == nil || err.Error() != "no recipients" {
+		t.Errorf("no recipients: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Matrix sends a message to a room as a user whose access token it has.
+// The user must already have joined the room.
+type Matrix struct {
+	Homeserver  string // e.g. https://matrix.example.org
+	AccessToken string
+	RoomID      string // e.g. !abc123:example.org
+}
+
+// txnCounter makes transaction IDs unique within the process; the
+// timestamp makes them unique across restarts.
+var txnCounter atomic.Int64
+
+func (mx Matrix) Notify(ctx context.Context, m Message) error {
+	txn := fmt.Sprintf("gpumon-%d-%d", time.Now().UnixNano(), txnCounter.Add(1))
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(mx.Homeserver, "/"), url.PathEscape(mx.RoomID), txn)
+	body := map[string]string{
+		"msgtype":        "m.text",
+		"body":           m.emoji() + " " + m.Title + "\n" + m.Text,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": "<b>" + html.EscapeString(m.emoji()+" "+m.Title) + "</b><br>" + strings.ReplaceAll(html.EscapeString(m.Text), "\n", "<br>"),
+	}
+	header := http.Header{"Authorization": {"Bearer " + mx.AccessToken}}
+	return postJSON(ctx, http.MethodPut, u, body, header)
+}
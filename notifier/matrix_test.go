@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMatrix(t *testing.T) {
+	rcv := newReceiver(t)
+	mx := Matrix{Homeserver: rcv.URL + "/", AccessToken: "syt_secret", RoomID: "!abc123:example.org"}
+	m := critical()
+	m.Text = "<b>hot</b> & loud\nsecond line"
+	if err := mx.Notify(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]string
+	r := rcv.only(t, &body)
+	if r.Method != http.MethodPut {
+		t.Errorf("method %s, want PUT", r.Method)
+	}
+	prefix := "/_matrix/client/v3/rooms/%21abc123:example.org/send/m.room.message/gpumon-"
+	if !strings.HasPrefix(r.Path, prefix) {
+		t.Errorf("path %s, want %s...", r.Path, prefix)
+	}
+	if auth := r.Header.Get("Authorization"); auth != "Bearer syt_secret" {
+		t.Errorf("Authorization %q", auth)
+	}
+	want := map[string]string{
+		"msgtype":        "m.text",
+		"body":           "🚨 CRITICAL gpu_temperature on node-a\n<b>hot</b> & loud\nsecond line",
+		"format":         "org.matrix.custom.html",
+		"formatted_body": "<b>🚨 CRITICAL gpu_temperature on node-a</b><br>&lt;b&gt;hot&lt;/b&gt; &amp; loud<br>second line",
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s = %q, want %q", k, body[k], v)
+		}
+	}
+}
+
+// Matrix drops a message whose transaction ID it has seen, so each send
+// needs a new one.
+func TestMatrixTransactionIDs(t *testing.T) {
+	rcv := newReceiver(t)
+	mx := Matrix{Homeserver: rcv.URL, AccessToken: "syt_secret", RoomID: "!abc123:example.org"}
+	for i := 0; i < 3; i++ {
+		if err := mx.Notify(context.Background(), critical()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, r := range rcv.requests {
+		if seen[r.Path] {
+			t.Errorf("%s sent twice", r.Path)
+		}
+		seen[r.Path] = true
+	}
+}
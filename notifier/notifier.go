@@ -0,0 +1,127 @@
+// Package notifier delivers alert messages to chat services, email and
+// webhooks. Each destination implements Notifier; a Router built from a
+// JSON config decides which channels a message goes to by its severity
+// and host labels.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"gpu-monitor/alerts"
+)
+
+// Message is what gets delivered. Severity and Kind are empty for plain
+// informational messages.
+type Message struct {
+	Title    string            `json:"title"`
+	Text     string            `json:"text"`
+	Severity alerts.Severity   `json:"severity,omitempty"`
+	Kind     string            `json:"kind,omitempty"` // alerts.Firing, Reminder or Resolved
+	Host     string            `json:"host,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Time     time.Time         `json:"time"`
+
+	// Event is set for alert notifications.
+	Event *alerts.Event `json:"event,omitempty"`
+}
+
+// FromEvent builds the message for an alert notification. hostLabels are
+// the labels of the alert's host, used for routing.
+func FromEvent(ev alerts.Event, kind string, hostLabels map[string]string, now time.Time) Message {
+	var title string
+	switch kind {
+	case alerts.Resolved:
+		title = fmt.Sprintf("RESOLVED %s on %s", ev.Rule, ev.Host)
+	case alerts.Reminder:
+		title = fmt.Sprintf("Still firing: %s %s on %s", strings.ToUpper(string(ev.Severity)), ev.Rule, ev.Host)
+	default:
+		title = fmt.Sprintf("%s %s on %s", strings.ToUpper(string(ev.Severity)), ev.Rule, ev.Host)
+	}
+	text := ev.Message + "\nSince " + ev.StartedAt
+	if ev.ResolvedAt != "" {
+		text += ", resolved " + ev.ResolvedAt
+	}
+	return Message{
+		Title:    title,
+		Text:     text,
+		Severity: ev.Severity,
+		Kind:     kind,
+		Host:     ev.Host,
+		Labels:   hostLabels,
+		Time:     now,
+		Event:    &ev,
+	}
+}
+
+// emoji marks a message's state in chat services.
+func (m Message) emoji() string {
+	switch {
+	case m.Kind == alerts.Resolved:
+		return "✅"
+	case m.Severity == alerts.Critical:
+		return "🚨"
+	case m.Severity == alerts.Warning:
+		return "⚠️"
+	}
+	return "ℹ️"
+}
+
+// color is the message's state as an RGB value for attachments and
+// embeds.
+func (m Message) color() int {
+	switch {
+	case m.Kind == alerts.Resolved:
+		return 0x2eb67d
+	case m.Severity == alerts.Critical:
+		return 0xe01e5a
+	case m.Severity == alerts.Warning:
+		return 0xecb22e
+	}
+	return 0x439fe0
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, m Message) error
+}
+
+// httpClient is used by the HTTP based notifiers. Requests also carry the
+// caller's context.
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// postJSON sends v as JSON and fails unless the reply is a 2xx.
+func postJSON(ctx context.Context, method, url string, v interface{}, header http.Header) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return send(ctx, method, url, body, header)
+}
+
+func send(ctx context.Context, method, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
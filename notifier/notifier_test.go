@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gpu-monitor/alerts"
+)
+
+// request is what a test server received.
+type request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// receiver is an HTTP server that records requests and answers them with
+// status.
+type receiver struct {
+	*httptest.Server
+	status int
+
+	mu       sync.Mutex
+	requests []request
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+	rcv := &receiver{status: http.StatusOK}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		rcv.requests = append(rcv.requests, request{r.Method, r.URL.EscapedPath(), r.Header, body})
+		status := rcv.status
+		rcv.mu.Unlock()
+		if status != http.StatusOK {
+			http.Error(w, "invalid_payload", status)
+		}
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+// only returns the one request received, decoding its JSON body into v.
+func (rcv *receiver) only(t *testing.T, v interface{}) request {
+	t.Helper()
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rcv.requests))
+	}
+	r := rcv.requests[0]
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("body %s: %v", r.Body, err)
+	}
+	return r
+}
+
+var testTime = time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+
+// critical is a firing alert on a labeled host.
+func critical() Message {
+	ev := alerts.Event{
+		ID:        12,
+		Alert:     alerts.Alert{Rule: "gpu_temperature", Severity: alerts.Critical, Host: "node-a", Message: "GPU 1 on node-a is at 91°C"},
+		StartedAt: "2026-10-19T08:20:00Z",
+	}
+	return FromEvent(ev, alerts.Firing, map[string]string{"cluster": "training", "env": "prod"}, testTime)
+}
+
+func TestFromEvent(t *testing.T) {
+	m := critical()
+	if m.Title != "CRITICAL gpu_temperature on node-a" || m.Text != "GPU 1 on node-a is at 91°C\nSince 2026-10-19T08:20:00Z" {
+		t.Errorf("firing: %q %q", m.Title, m.Text)
+	}
+	if m.emoji() != "🚨" || m.color() != 0xe01e5a {
+		t.Errorf("firing: emoji %s color %#x", m.emoji(), m.color())
+	}
+
+	ev := *m.Event
+	ev.ResolvedAt = "2026-10-19T08:29:00Z"
+	m = FromEvent(ev, alerts.Resolved, nil, testTime)
+	if m.Title != "RESOLVED gpu_temperature on node-a" || m.Text != "GPU 1 on node-a is at 91°C\nSince 2026-10-19T08:20:00Z, resolved 2026-10-19T08:29:00Z" {
+		t.Errorf("resolved: %q %q", m.Title, m.Text)
+	}
+	if m.emoji() != "✅" || m.color() != 0x2eb67d {
+		t.Errorf("resolved: emoji %s color %#x", m.emoji(), m.color())
+	}
+
+	if m := FromEvent(ev, alerts.Reminder, nil, testTime); m.Title != "Still firing: CRITICAL gpu_temperature on node-a" {
+		t.Errorf("reminder: %q", m.Title)
+	}
+}
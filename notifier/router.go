@@ -0,0 +1,239 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/labels"
+)
+
+// Config is the notification config file, e.g.
+//
+//	{
+//	  "channels": {
+//	    "ops": {"type": "slack", "url": "https://hooks.slack.com/services/..."},
+//	    "oncall": {"type": "email", "server": "smtp.example.org:587",
+//	      "username": "gpumon", "password": "${SMTP_PASSWORD}",
+//	      "from": "gpumon@example.org", "to": ["oncall@example.org"]}
+//	  },
+//	  "routes": [
+//	    {"severity": "critical", "selector": "env=prod", "channels": ["oncall"], "continue": true},
+//	    {"channels": ["ops"]}
+//	  ]
+//	}
+//
+// ${VAR} references are replaced from the environment, to keep secrets
+// out of the file.
+type Config struct {
+	Channels map[string]ChannelConfig `json:"channels"`
+	Routes   []Route                  `json:"routes"`
+}
+
+// ChannelConfig configures one destination. Which fields apply depends on
+// Type: slack, discord, mattermost, matrix, email, webhook or telegram.
+type ChannelConfig struct {
+	Type string `json:"type"`
+
+	// slack, discord, mattermost and webhook
+	URL     string            `json:"url,omitempty"`
+	Channel string            `json:"channel,omitempty"` // mattermost
+	Secret  string            `json:"secret,omitempty"`  // webhook
+	Headers map[string]string `json:"headers,omitempty"` // webhook
+
+	// matrix
+	Homeserver  string `json:"homeserver,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
+	RoomID      string `json:"room_id,omitempty"`
+
+	// email
+	Server      string   `json:"server,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	Password    string   `json:"password,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+	ImplicitTLS bool     `json:"implicit_tls,omitempty"`
+
+	// telegram
+	Token  string `json:"token,omitempty"`
+	ChatID int64  `json:"chat_id,omitempty"`
+}
+
+func (c ChannelConfig) notifier() (Notifier, error) {
+	require := func(fields ...string) error {
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i+1] == "" {
+				return fmt.Errorf("%s channel needs %s", c.Type, fields[i])
+			}
+		}
+		return nil
+	}
+	switch c.Type {
+	case "slack":
+		return Slack{URL: c.URL}, require("url", c.URL)
+	case "discord":
+		return Discord{URL: c.URL}, require("url", c.URL)
+	case "mattermost":
+		return Mattermost{URL: c.URL, Channel: c.Channel}, require("url", c.URL)
+	case "webhook":
+		return Webhook{URL: c.URL, Secret: c.Secret, Header: c.Headers}, require("url", c.URL)
+	case "matrix":
+		return Matrix{Homeserver: c.Homeserver, AccessToken: c.AccessToken, RoomID: c.RoomID},
+			require("homeserver", c.Homeserver, "access_token", c.AccessToken, "room_id", c.RoomID)
+	case "email":
+		if len(c.To) == 0 {
+			return nil, fmt.Errorf("email channel needs to")
+		}
+		return Email{Server: c.Server, Username: c.Username, Password: c.Password, From: c.From, To: c.To, ImplicitTLS: c.ImplicitTLS},
+			require("server", c.Server, "from", c.From)
+	case "telegram":
+		if c.ChatID == 0 {
+			return nil, fmt.Errorf("telegram channel needs chat_id")
+		}
+		return Telegram{Token: c.Token, ChatID: c.ChatID}, require("token", c.Token)
+	}
+	return nil, fmt.Errorf("unknown channel type %q", c.Type)
+}
+
+// Route sends messages of at least Severity from hosts matching Selector
+// to Channels. Routes are tried in order and the first match wins unless
+// it has Continue set. Empty Severity and Selector match everything,
+// including messages without a severity.
+type Route struct {
+	Severity alerts.Severity `json:"severity,omitempty"`
+	Selector string          `json:"selector,omitempty"`
+	Channels []string        `json:"channels"`
+	Continue bool            `json:"continue,omitempty"`
+
+	selector labels.Selector
+}
+
+func (r Route) matches(m Message) bool {
+	if r.Severity != "" && m.Severity.Rank() < r.Severity.Rank() {
+		return false
+	}
+	return r.selector.Matches(m.Labels)
+}
+
+// Router sends messages to the channels their route picks.
+type Router struct {
+	channels map[string]Notifier
+	routes   []Route
+}
+
+// Load reads a Config file and builds its Router.
+func Load(path string) (*Router, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	r, err := New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+func New(cfg Config) (*Router, error) {
+	r := &Router{channels: map[string]Notifier{}}
+	for name, c := range cfg.Channels {
+		n, err := c.notifier()
+		if err != nil {
+			return nil, fmt.Errorf("channel %q: %v", name, err)
+		}
+		r.channels[name] = n
+	}
+	for i, route := range cfg.Routes {
+		if route.Severity != "" {
+			if _, err := alerts.ParseSeverity(string(route.Severity)); err != nil {
+				return nil, fmt.Errorf("route %d: %v", i+1, err)
+			}
+		}
+		sel, err := labels.Parse(route.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", i+1, err)
+		}
+		route.selector = sel
+		for _, ch := range route.Channels {
+			if r.channels[ch] == nil {
+				return nil, fmt.Errorf("route %d: no channel %q", i+1, ch)
+			}
+		}
+		r.routes = append(r.routes, route)
+	}
+	return r, nil
+}
+
+// Add registers a channel, replacing one of the same name.
+func (r *Router) Add(name string, n Notifier) {
+	r.channels[name] = n
+}
+
+// Channels lists the configured channel names.
+func (r *Router) Channels() []string {
+	var names []string
+	for name := range r.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Route returns the channels a message goes to, each once.
+func (r *Router) Route(m Message) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, route := range r.routes {
+		if !route.matches(m) {
+			continue
+		}
+		for _, ch := range route.Channels {
+			if !seen[ch] {
+				seen[ch] = true
+				out = append(out, ch)
+			}
+		}
+		if !route.Continue {
+			break
+		}
+	}
+	return out
+}
+
+// Notify delivers a message to every channel its routes pick, in
+// parallel. The error names each channel that failed.
+func (r *Router) Notify(ctx context.Context, m Message) error {
+	names := r.Route(m)
+	errs := make([]error, len(names))
+	done := make(chan struct{})
+	for i, name := range names {
+		go func(i int, name string) {
+			defer func() { done <- struct{}{} }()
+			if err := r.channels[name].Notify(ctx, m); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", name, err)
+			}
+		}(i, name)
+	}
+	for range names {
+		<-done
+	}
+	return errors.Join(errs...)
+}
+
+// Send delivers a message to one channel by name, ignoring the routes,
+// e.g. to test a channel's configuration.
+func (r *Router) Send(ctx context.Context, name string, m Message) error {
+	n, ok := r.channels[name]
+	if !ok {
+		return fmt.Errorf("no channel %q", name)
+	}
+	return n.Notify(ctx, m)
+}
@@ -0,0 +1,179 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"gpu-monitor/alerts"
+)
+
+func testRouter(t *testing.T) *Router {
+	t.Helper()
+	hook := ChannelConfig{Type: "slack", URL: "https://hooks.example.org/x"}
+	r, err := New(Config{
+		Channels: map[string]ChannelConfig{"oncall": hook, "prod": hook, "training": hook, "ops": hook},
+		Routes: []Route{
+			{Severity: alerts.Critical, Selector: "env=prod", Channels: []string{"oncall"}, Continue: true},
+			{Selector: "env=prod", Channels: []string{"prod", "oncall"}},
+			{Severity: alerts.Warning, Selector: "cluster=training", Channels: []string{"training"}},
+			{Channels: []string{"ops"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRoute(t *testing.T) {
+	r := testRouter(t)
+	tests := []struct {
+		name     string
+		severity alerts.Severity
+		labels   map[string]string
+		want     []string
+	}{
+		{"critical in prod continues", alerts.Critical, map[string]string{"env": "prod"}, []string{"oncall", "prod"}},
+		{"warning in prod stops at the first match", alerts.Warning, map[string]string{"env": "prod"}, []string{"prod", "oncall"}},
+		{"info in prod", "", map[string]string{"env": "prod"}, []string{"prod", "oncall"}},
+		{"warning in training", alerts.Warning, map[string]string{"cluster": "training"}, []string{"training"}},
+		{"critical in training", alerts.Critical, map[string]string{"cluster": "training", "env": "staging"}, []string{"training"}},
+		{"info in training is below the route", "", map[string]string{"cluster": "training"}, []string{"ops"}},
+		{"unlabeled", alerts.Critical, nil, []string{"ops"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Route(Message{Severity: tt.severity, Labels: tt.labels})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteNothing(t *testing.T) {
+	r, err := New(Config{
+		Channels: map[string]ChannelConfig{"oncall": {Type: "discord", URL: "https://discord.example.org/x"}},
+		Routes:   []Route{{Severity: alerts.Critical, Channels: []string{"oncall"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Route(Message{Severity: alerts.Warning}); got != nil {
+		t.Errorf("Route = %q, want none", got)
+	}
+	if err := r.Notify(context.Background(), Message{Severity: alerts.Warning}); err != nil {
+		t.Errorf("Notify to no channels: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  string // empty if the config is valid
+	}{
+		{"every type", Config{Channels: map[string]ChannelConfig{
+			"slack":      {Type: "slack", URL: "https://hooks.slack.com/x"},
+			"discord":    {Type: "discord", URL: "https://discord.com/api/webhooks/x"},
+			"mattermost": {Type: "mattermost", URL: "https://chat.example.org/hooks/x", Channel: "alerts"},
+			"webhook":    {Type: "webhook", URL: "https://example.org/hook", Secret: "s"},
+			"matrix":     {Type: "matrix", Homeserver: "https://matrix.example.org", AccessToken: "t", RoomID: "!r:example.org"},
+			"email":      {Type: "email", Server: "smtp.example.org:587", From: "gpumon@example.org", To: []string{"a@example.org"}},
+			"telegram":   {Type: "telegram", Token: "123:abc", ChatID: -100},
+		}}, ""},
+		{"unknown type", Config{Channels: map[string]ChannelConfig{"x": {Type: "pager"}}}, `channel "x": unknown channel type "pager"`},
+		{"slack without url", Config{Channels: map[string]ChannelConfig{"x": {Type: "slack"}}}, `channel "x": slack channel needs url`},
+		{"matrix without room", Config{Channels: map[string]ChannelConfig{"x": {Type: "matrix", Homeserver: "h", AccessToken: "t"}}}, `channel "x": matrix channel needs room_id`},
+		{"email without to", Config{Channels: map[string]ChannelConfig{"x": {Type: "email", Server: "s:25", From: "f"}}}, `channel "x": email channel needs to`},
+		{"email without from", Config{Channels: map[string]ChannelConfig{"x": {Type: "email", Server: "s:25", To: []string{"a"}}}}, `channel "x": email channel needs from`},
+		{"telegram without chat", Config{Channels: map[string]ChannelConfig{"x": {Type: "telegram", Token: "t"}}}, `channel "x": telegram channel needs chat_id`},
+		{"bad severity", Config{Routes: []Route{{Severity: "urgent"}}}, "route 1: "},
+		{"bad selector", Config{Routes: []Route{{Selector: "bad key=prod"}}}, "route 1: "},
+		{"unknown channel", Config{
+			Channels: map[string]ChannelConfig{"ops": {Type: "slack", URL: "u"}},
+			Routes:   []Route{{Channels: []string{"ops"}}, {Channels: []string{"oncall"}}},
+		}, `route 2: no channel "oncall"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("New: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("New: %v, want %s...", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv("TEST_SLACK_URL", "https://hooks.slack.com/services/T0/B0/x")
+	path := filepath.Join(t.TempDir(), "notify.json")
+	config := `{
+		"channels": {"ops": {"type": "slack", "url": "${TEST_SLACK_URL}"}},
+		"routes": [{"channels": ["ops"]}]
+	}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := r.channels["ops"].(Slack); !ok || s.URL != "https://hooks.slack.com/services/T0/B0/x" {
+		t.Errorf("ops is %#v", r.channels["ops"])
+	}
+
+	os.WriteFile(path, []byte(`{"channels": {"ops": {"type": "slack"}}}`), 0600)
+	if _, err := Load(path); err == nil || !strings.HasPrefix(err.Error(), path+": ") {
+		t.Errorf("invalid config: %v", err)
+	}
+}
+
+// recorder is a Notifier that keeps what it was sent.
+type recorder struct {
+	mu   sync.Mutex
+	got  []Message
+	fail error
+}
+
+func (r *recorder) Notify(ctx context.Context, m Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, m)
+	return r.fail
+}
+
+func TestNotify(t *testing.T) {
+	r := testRouter(t)
+	oncall, prod, ops := &recorder{}, &recorder{fail: errors.New("410 Gone")}, &recorder{}
+	r.Add("oncall", oncall)
+	r.Add("prod", prod)
+	r.Add("ops", ops)
+
+	err := r.Notify(context.Background(), critical())
+	if err == nil || err.Error() != "prod: 410 Gone" {
+		t.Errorf("Notify = %v, want prod's error", err)
+	}
+	if len(oncall.got) != 1 || len(prod.got) != 1 || len(ops.got) != 0 {
+		t.Errorf("delivered to oncall %d, prod %d, ops %d times", len(oncall.got), len(prod.got), len(ops.got))
+	}
+
+	if err := r.Send(context.Background(), "ops", critical()); err != nil || len(ops.got) != 1 {
+		t.Errorf("Send to ops: %v", err)
+	}
+	if err := r.Send(context.Background(), "pager", critical()); err == nil {
+		t.Error("Send to an unknown channel worked")
+	}
+	if got, want := r.Channels(), []string{"oncall", "ops", "prod", "training"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Channels = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,23 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+
+	"gpu-monitor/telegram"
+)
+
+// Telegram sends to a chat through the Bot API, for setups that want
+// alerts in a fixed chat without running the bot.
+type Telegram struct {
+	Token  string
+	ChatID int64
+}
+
+func (t Telegram) Notify(ctx context.Context, m Message) error {
+	body := map[string]interface{}{
+		"chat_id": t.ChatID,
+		"text":    truncate(m.emoji()+" "+m.Title+"\n"+m.Text, 4096),
+	}
+	return postJSON(ctx, http.MethodPost, telegram.MethodURL(t.Token, "sendMessage"), body, nil)
+}
@@ -0,0 +1,77 @@
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of signed webhook requests.
+const (
+	SignatureHeader = "X-Gpumon-Signature"
+	TimestampHeader = "X-Gpumon-Timestamp"
+)
+
+// Webhook posts the Message as JSON. With a Secret, requests carry the
+// Unix time in TimestampHeader and "sha256=" followed by the hex HMAC of
+// "TIMESTAMP.BODY" in SignatureHeader, which receivers check with Verify.
+type Webhook struct {
+	URL    string
+	Secret string
+	// Header is added to every request, e.g. for an API key.
+	Header map[string]string
+}
+
+func (wh Webhook) Notify(ctx context.Context, m Message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	for k, v := range wh.Header {
+		header.Set(k, v)
+	}
+	if wh.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(TimestampHeader, ts)
+		header.Set(SignatureHeader, Sign(wh.Secret, ts, body))
+	}
+	return send(ctx, http.MethodPost, wh.URL, body, header)
+}
+
+// Sign returns the signature header value for a webhook body.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signed webhook request's headers against its body and
+// rejects timestamps further than maxAge from now, so captured requests
+// cannot be replayed later.
+func Verify(secret string, header http.Header, body []byte, maxAge time.Duration, now time.Time) error {
+	ts := header.Get(TimestampHeader)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s", TimestampHeader)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("timestamp is %s off", age.Round(time.Second))
+	}
+	want := Sign(secret, ts, body)
+	got := strings.TrimSpace(header.Get(SignatureHeader))
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
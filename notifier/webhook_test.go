@@ -0,0 +1,97 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"gpu-monitor/alerts"
+)
+
+func TestWebhook(t *testing.T) {
+	rcv := newReceiver(t)
+	wh := Webhook{URL: rcv.URL + "/hook", Secret: "s3cret", Header: map[string]string{"X-Api-Key": "key"}}
+	before := time.Now()
+	if err := wh.Notify(context.Background(), critical()); err != nil {
+		t.Fatal(err)
+	}
+
+	var m Message
+	r := rcv.only(t, &m)
+	if r.Method != http.MethodPost || r.Path != "/hook" || r.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("%s %s, headers %v", r.Method, r.Path, r.Header)
+	}
+	if m.Title != "CRITICAL gpu_temperature on node-a" || m.Severity != alerts.Critical || m.Kind != alerts.Firing ||
+		m.Host != "node-a" || m.Labels["env"] != "prod" || !m.Time.Equal(testTime) {
+		t.Errorf("message %+v", m)
+	}
+	if m.Event == nil || m.Event.ID != 12 || m.Event.Rule != "gpu_temperature" {
+		t.Errorf("event %+v", m.Event)
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil || ts < before.Unix() || ts > time.Now().Unix() {
+		t.Errorf("%s %q", TimestampHeader, r.Header.Get(TimestampHeader))
+	}
+	if err := Verify("s3cret", r.Header, r.Body, time.Minute, time.Now()); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	rcv := newReceiver(t)
+	if err := (Webhook{URL: rcv.URL}).Notify(context.Background(), critical()); err != nil {
+		t.Fatal(err)
+	}
+	var m Message
+	r := rcv.only(t, &m)
+	if r.Header.Get(SignatureHeader) != "" || r.Header.Get(TimestampHeader) != "" {
+		t.Errorf("unsigned webhook has headers %v", r.Header)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1760862600.{"a":1}' | openssl dgst -sha256 -hmac s3cret
+	want := "sha256=33e27a30ff50adf36ca82e02f0f213b766921a464199fbe9c513f3ff5d75228f"
+	if got := Sign("s3cret", "1760862600", []byte(`{"a":1}`)); got != want {
+		t.Errorf("Sign = %q, want %q", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1760862600, 0)
+	body := []byte(`{"title":"CRITICAL gpu_temperature on node-a"}`)
+	signed := func(ts int64, secret string) http.Header {
+		h := http.Header{}
+		s := strconv.FormatInt(ts, 10)
+		h.Set(TimestampHeader, s)
+		h.Set(SignatureHeader, Sign(secret, s, body))
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		ok     bool
+	}{
+		{"round trip", signed(now.Unix(), "s3cret"), body, true},
+		{"a little skew", signed(now.Unix()+30, "s3cret"), body, true},
+		{"stale", signed(now.Add(-10*time.Minute).Unix(), "s3cret"), body, false},
+		{"from the future", signed(now.Add(10*time.Minute).Unix(), "s3cret"), body, false},
+		{"wrong secret", signed(now.Unix(), "guess"), body, false},
+		{"changed body", signed(now.Unix(), "s3cret"), []byte(`{"title":"all good"}`), false},
+		{"no timestamp", http.Header{SignatureHeader: {Sign("s3cret", "", body)}}, body, false},
+		{"no signature", http.Header{TimestampHeader: {strconv.FormatInt(now.Unix(), 10)}}, body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify("s3cret", tt.header, tt.body, 5*time.Minute, now)
+			if (err == nil) != tt.ok {
+				t.Errorf("Verify = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
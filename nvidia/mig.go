@@ -0,0 +1,79 @@
+package nvidia
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gpu-monitor/report"
+)
+
+// MIGDevice is one "MIG ..." line of `nvidia-smi -L`.
+type MIGDevice struct {
+	GPUUUID string
+	Device  int
+	Profile string
+	UUID    string
+}
+
+var (
+	listGPULine = regexp.MustCompile(`^GPU \d+: .*\(UUID: ([^)]+)\)`)
+	listMIGLine = regexp.MustCompile(`^\s+MIG (\S+)\s+Device\s+(\d+): \(UUID: ([^)]+)\)`)
+)
+
+// ParseList reads the MIG devices from `nvidia-smi -L` output, with the
+// UUID of the GPU each belongs to.
+func ParseList(r io.Reader) []MIGDevice {
+	var devices []MIGDevice
+	gpu := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := listGPULine.FindStringSubmatch(line); m != nil {
+			gpu = m[1]
+			continue
+		}
+		if m := listMIGLine.FindStringSubmatch(line); m != nil && gpu != "" {
+			dev, _ := strconv.Atoi(m[2])
+			devices = append(devices, MIGDevice{GPUUUID: gpu, Device: dev, Profile: m[1], UUID: m[3]})
+		}
+	}
+	return devices
+}
+
+// ApplyMIGProfiles fills in profile, UUID and compute slices of the MIG
+// instances from the `nvidia-smi -L` listing. Devices are matched on the
+// GPU's UUID and the instance's MIG device index, so neither the order
+// of the listing nor gaps in it matter.
+func ApplyMIGProfiles(gpus []report.GPUReport, devices []MIGDevice) {
+	for i := range gpus {
+		for _, d := range devices {
+			if d.GPUUUID != gpus[i].UUID {
+				continue
+			}
+			for j := range gpus[i].MIGInstances {
+				mig := &gpus[i].MIGInstances[j]
+				if mig.Device != d.Device {
+					continue
+				}
+				mig.Profile = d.Profile
+				mig.UUID = d.UUID
+				mig.ComputeSlices = ComputeSlices(d.Profile)
+			}
+		}
+	}
+}
+
+// ComputeSlices returns the number of compute slices in a MIG profile
+// name: 3 for "3g.40gb", 1 for the compute instance profile "1c.3g.40gb".
+func ComputeSlices(profile string) int {
+	part := strings.SplitN(profile, ".", 2)[0]
+	part = strings.TrimRight(part, "cg")
+	n, err := strconv.Atoi(part)
+	if err != nil {
+		return 0
+	}
+	return n
+}
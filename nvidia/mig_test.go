@@ -0,0 +1,73 @@
+package nvidia
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gpu-monitor/report"
+)
+
+func TestApplyMIGProfiles(t *testing.T) {
+	gpus := parseFile(t, "smi-535-a100-mig.xml")
+	f, err := os.Open("testdata/smi-list-mig.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ApplyMIGProfiles(gpus, ParseList(f))
+
+	if gpus[0].MIGMode || len(gpus[0].MIGInstances) != 0 {
+		t.Errorf("GPU 0 is not partitioned, got MIG mode %v with %d instances", gpus[0].MIGMode, len(gpus[0].MIGInstances))
+	}
+	if !gpus[1].MIGMode {
+		t.Fatal("GPU 1 is in MIG mode")
+	}
+	// The XML lists device 2 before device 0 and device 1 was destroyed;
+	// the profiles still go to the right instances.
+	want := []report.MIGInstance{
+		{
+			Device:         2,
+			GPUInstanceID:  5,
+			Profile:        "2g.20gb",
+			UUID:           "MIG-8c2d9e4f-a1b3-5c6d-8e7f-0a1b2c3d4e5f",
+			ComputeSlices:  2,
+			SMCount:        28,
+			MemoryUsedMiB:  11,
+			MemoryTotalMiB: 19968,
+		},
+		{
+			Device:         0,
+			GPUInstanceID:  1,
+			Profile:        "3g.40gb",
+			UUID:           "MIG-1b7e5a1c-d4f2-5d7b-9a0e-2f7c1d3e4b5a",
+			ComputeSlices:  3,
+			SMCount:        42,
+			MemoryUsedMiB:  22417,
+			MemoryTotalMiB: 40192,
+			ProcessCount:   1,
+			ProcessNames:   "vllm",
+		},
+	}
+	if !reflect.DeepEqual(gpus[1].MIGInstances, want) {
+		t.Errorf("MIG instances:\ngot  %+v\nwant %+v", gpus[1].MIGInstances, want)
+	}
+}
+
+func TestComputeSlices(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    int
+	}{
+		{"3g.40gb", 3},
+		{"1g.10gb", 1},
+		{"1c.3g.40gb", 1},
+		{"7g.80gb", 7},
+		{"bogus", 0},
+	}
+	for _, tt := range tests {
+		if got := ComputeSlices(tt.profile); got != tt.want {
+			t.Errorf("ComputeSlices(%q) = %d, want %d", tt.profile, got, tt.want)
+		}
+	}
+}
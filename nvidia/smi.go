@@ -0,0 +1,325 @@
+// Package nvidia parses the output of `nvidia-smi -q -x` and the NVRM XID
+// messages the driver writes to the kernel log.
+package nvidia
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"gpu-monitor/report"
+)
+
+type smiLog struct {
+	DriverVersion string   `xml:"driver_version"`
+	GPUs          []smiGPU `xml:"gpu"`
+}
+
+type smiGPU struct {
+	ID          string `xml:"id,attr"`
+	ProductName string `xml:"product_name"`
+	UUID        string `xml:"uuid"`
+	FanSpeed    string `xml:"fan_speed"`
+
+	MIGMode struct {
+		Current string `xml:"current_mig"`
+	} `xml:"mig_mode"`
+	MIGDevices struct {
+		Devices []smiMIGDevice `xml:"mig_device"`
+	} `xml:"mig_devices"`
+	VirtualizationMode string `xml:"gpu_virtualization_mode>virtualization_mode"`
+
+	PCI struct {
+		BusID    string `xml:"pci_bus_id"`
+		LinkInfo struct {
+			Gen struct {
+				Max     string `xml:"max_link_gen"`
+				Current string `xml:"current_link_gen"`
+			} `xml:"pcie_gen"`
+			Width struct {
+				Max     string `xml:"max_link_width"`
+				Current string `xml:"current_link_width"`
+			} `xml:"link_widths"`
+		} `xml:"pci_gpu_link_info"`
+		ReplayCounter string `xml:"replay_counter"`
+	} `xml:"pci"`
+
+	// Drivers before 535 call this clocks_throttle_reasons, newer ones
+	// clocks_event_reasons. The child elements carry the same prefixes.
+	ThrottleReasons smiReasons `xml:"clocks_throttle_reasons"`
+	EventReasons    smiReasons `xml:"clocks_event_reasons"`
+
+	FBMemory struct {
+		Total string `xml:"total"`
+		Used  string `xml:"used"`
+	} `xml:"fb_memory_usage"`
+
+	Utilization struct {
+		GPU string `xml:"gpu_util"`
+	} `xml:"utilization"`
+
+	ECCErrors struct {
+		Volatile  smiECC `xml:"volatile"`
+		Aggregate smiECC `xml:"aggregate"`
+	} `xml:"ecc_errors"`
+
+	RetiredPages struct {
+		SingleBit struct {
+			Count string `xml:"retired_count"`
+		} `xml:"multiple_single_bit_retirement"`
+		DoubleBit struct {
+			Count string `xml:"retired_count"`
+		} `xml:"double_bit_retirement"`
+		PendingBlacklist  string `xml:"pending_blacklist"`
+		PendingRetirement string `xml:"pending_retirement"`
+	} `xml:"retired_pages"`
+
+	Temperature struct {
+		GPU string `xml:"gpu_temp"`
+	} `xml:"temperature"`
+
+	// power_readings was renamed to gpu_power_readings in 535.
+	PowerReadings    smiPower `xml:"power_readings"`
+	GPUPowerReadings smiPower `xml:"gpu_power_readings"`
+
+	Clocks struct {
+		SM  string `xml:"sm_clock"`
+		Mem string `xml:"mem_clock"`
+	} `xml:"clocks"`
+
+	Processes struct {
+		Process []smiProcess `xml:"process_info"`
+	} `xml:"processes"`
+}
+
+type smiMIGDevice struct {
+	Index             string `xml:"index"`
+	GPUInstanceID     string `xml:"gpu_instance_id"`
+	ComputeInstanceID string `xml:"compute_instance_id"`
+	SMCount           string `xml:"device_attributes>shared>multiprocessor_count"`
+	FBMemory          struct {
+		Total string `xml:"total"`
+		Used  string `xml:"used"`
+	} `xml:"fb_memory_usage"`
+}
+
+type smiReasons struct {
+	Reasons []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+type smiECC struct {
+	// Pre-Ampere layout.
+	SingleBit struct {
+		Total string `xml:"total"`
+	} `xml:"single_bit"`
+	DoubleBit struct {
+		Total string `xml:"total"`
+	} `xml:"double_bit"`
+
+	// Ampere and newer split by memory type instead.
+	SRAMCorrectable   string `xml:"sram_correctable"`
+	SRAMUncorrectable string `xml:"sram_uncorrectable"`
+	DRAMCorrectable   string `xml:"dram_correctable"`
+	DRAMUncorrectable string `xml:"dram_uncorrectable"`
+}
+
+type smiPower struct {
+	Draw         string `xml:"power_draw"`
+	AverageDraw  string `xml:"average_power_draw"`
+	InstantDraw  string `xml:"instant_power_draw"`
+	Limit        string `xml:"power_limit"`
+	CurrentLimit string `xml:"current_power_limit"`
+}
+
+type smiProcess struct {
+	GPUInstanceID     string `xml:"gpu_instance_id"`
+	ComputeInstanceID string `xml:"compute_instance_id"`
+	PID               string `xml:"pid"`
+	Type              string `xml:"type"`
+	Name              string `xml:"process_name"`
+	UsedMemory        string `xml:"used_memory"`
+}
+
+// Query runs `nvidia-smi -q -x` and parses its output. When a GPU is in
+// MIG mode, `nvidia-smi -L` is consulted as well for the instance profiles.
+func Query() ([]report.GPUReport, error) {
+	out, err := exec.Command("nvidia-smi", "-q", "-x").Output()
+	if err != nil {
+		return nil, err
+	}
+	gpus, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gpu := range gpus {
+		if !gpu.MIGMode {
+			continue
+		}
+		out, err := exec.Command("nvidia-smi", "-L").Output()
+		if err != nil {
+			return nil, err
+		}
+		ApplyMIGProfiles(gpus, ParseList(bytes.NewReader(out)))
+		break
+	}
+	return gpus, nil
+}
+
+// Parse reads an `nvidia-smi -q -x` document. The GPUs are listed in
+// nvidia-smi index order, which is what Index is set to; minor_number can
+// differ from it. The returned reports carry the bare product name;
+// callers prefix it with slot and hostname.
+func Parse(r io.Reader) ([]report.GPUReport, error) {
+	var log smiLog
+	dec := xml.NewDecoder(r)
+	// nvidia-smi references a DTD we have no use for.
+	dec.Strict = false
+	if err := dec.Decode(&log); err != nil {
+		return nil, err
+	}
+
+	gpus := make([]report.GPUReport, 0, len(log.GPUs))
+	for i, g := range log.GPUs {
+		gpu := report.GPUReport{
+			Index:                 i,
+			Name:                  strings.TrimSpace(g.ProductName),
+			Vendor:                report.VendorNVIDIA,
+			UUID:                  strings.TrimSpace(g.UUID),
+			PCIBusID:              report.NormalizeBusID(firstNonEmpty(g.PCI.BusID, g.ID)),
+			FanPercent:            int(number(g.FanSpeed)),
+			TemperatureC:          int(number(g.Temperature.GPU)),
+			MemoryUsedMiB:         int(number(g.FBMemory.Used)),
+			MemoryTotalMiB:        int(number(g.FBMemory.Total)),
+			UtilizationGpuPercent: int(number(g.Utilization.GPU)),
+			ClockSMMHz:            int(number(g.Clocks.SM)),
+			ClockMemMHz:           int(number(g.Clocks.Mem)),
+			PCIeGen:               int(number(g.PCI.LinkInfo.Gen.Current)),
+			PCIeGenMax:            int(number(g.PCI.LinkInfo.Gen.Max)),
+			PCIeWidth:             int(number(g.PCI.LinkInfo.Width.Current)),
+			PCIeWidthMax:          int(number(g.PCI.LinkInfo.Width.Max)),
+			PCIeReplayCount:       int64(number(g.PCI.ReplayCounter)),
+			RetiredPagesSingleBit: int(number(g.RetiredPages.SingleBit.Count)),
+			RetiredPagesDoubleBit: int(number(g.RetiredPages.DoubleBit.Count)),
+			RetiredPagesPending: isYes(g.RetiredPages.PendingBlacklist) ||
+				isYes(g.RetiredPages.PendingRetirement),
+			MIGMode:            strings.EqualFold(strings.TrimSpace(g.MIGMode.Current), "enabled"),
+			VirtualizationMode: strings.TrimSpace(g.VirtualizationMode),
+		}
+		if gpu.VirtualizationMode == "None" || gpu.VirtualizationMode == "N/A" {
+			gpu.VirtualizationMode = ""
+		}
+		power := g.GPUPowerReadings
+		if power == (smiPower{}) {
+			power = g.PowerReadings
+		}
+		gpu.PowerWatt = number(power.Draw)
+		if gpu.PowerWatt == 0 {
+			// 550 and newer only report averaged and instant draw.
+			gpu.PowerWatt = number(firstNonEmpty(power.AverageDraw, power.InstantDraw))
+		}
+		gpu.PowerLimitWatt = number(firstNonEmpty(power.CurrentLimit, power.Limit))
+
+		gpu.ECCVolatileCorrected, gpu.ECCVolatileUncorrected = g.ECCErrors.Volatile.counts()
+		gpu.ECCAggregateCorrected, gpu.ECCAggregateUncorrected = g.ECCErrors.Aggregate.counts()
+
+		gpu.ThrottleReasons = append(g.ThrottleReasons.active(), g.EventReasons.active()...)
+
+		for _, d := range g.MIGDevices.Devices {
+			gpu.MIGInstances = append(gpu.MIGInstances, report.MIGInstance{
+				Device:            int(number(d.Index)),
+				GPUInstanceID:     int(number(d.GPUInstanceID)),
+				ComputeInstanceID: int(number(d.ComputeInstanceID)),
+				SMCount:           int(number(d.SMCount)),
+				MemoryUsedMiB:     int(number(d.FBMemory.Used)),
+				MemoryTotalMiB:    int(number(d.FBMemory.Total)),
+			})
+		}
+
+		var names []string
+		migNames := map[[2]int][]string{}
+		for _, p := range g.Processes.Process {
+			name := strings.TrimSpace(p.Name)
+			if i := strings.LastIndex(name, "/"); i >= 0 {
+				name = name[i+1:]
+			}
+			names = append(names, name)
+			if gpu.MIGMode {
+				key := [2]int{int(number(p.GPUInstanceID)), int(number(p.ComputeInstanceID))}
+				migNames[key] = append(migNames[key], name)
+			}
+		}
+		gpu.ProcessCount = len(names)
+		gpu.ProcessNames = strings.Join(names, ", ")
+
+		for i := range gpu.MIGInstances {
+			mig := &gpu.MIGInstances[i]
+			procs := migNames[[2]int{mig.GPUInstanceID, mig.ComputeInstanceID}]
+			mig.ProcessCount = len(procs)
+			mig.ProcessNames = strings.Join(procs, ", ")
+		}
+
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+func (e smiECC) counts() (corrected, uncorrected int64) {
+	corrected = int64(number(e.SingleBit.Total) + number(e.SRAMCorrectable) + number(e.DRAMCorrectable))
+	uncorrected = int64(number(e.DoubleBit.Total) + number(e.SRAMUncorrectable) + number(e.DRAMUncorrectable))
+	return corrected, uncorrected
+}
+
+// active returns the short names of the reasons reported as "Active",
+// e.g. "hw_thermal_slowdown" or "sw_power_cap". The GPU idling is not a
+// throttle and is left out.
+func (r smiReasons) active() []string {
+	var reasons []string
+	for _, reason := range r.Reasons {
+		if strings.TrimSpace(reason.Value) != "Active" {
+			continue
+		}
+		name := reason.XMLName.Local
+		name = strings.TrimPrefix(name, "clocks_throttle_reason_")
+		name = strings.TrimPrefix(name, "clocks_event_reason_")
+		if name == "gpu_idle" {
+			continue
+		}
+		reasons = append(reasons, name)
+	}
+	return reasons
+}
+
+// number extracts the leading number from values such as "61.23 W",
+// "16x" or "34 C". "N/A" and friends yield 0.
+func number(s string) float64 {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && (s[end] == '.' || s[end] == '-' || (s[end] >= '0' && s[end] <= '9')) {
+		end++
+	}
+	v, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func isYes(s string) bool {
+	return strings.EqualFold(strings.TrimSpace(s), "yes")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
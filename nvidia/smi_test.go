@@ -0,0 +1,151 @@
+package nvidia
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gpu-monitor/report"
+)
+
+func parseFile(t *testing.T, name string) []report.GPUReport {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gpus, err := Parse(f)
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", name, err)
+	}
+	return gpus
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file string
+		want []report.GPUReport
+	}{
+		{
+			// Driver 535: gpu_power_readings, clocks_event_reasons and
+			// the SRAM/DRAM ECC layout. The minor numbers are 2 and 0,
+			// but the GPUs are nvidia-smi 0 and 1.
+			file: "smi-535-a100.xml",
+			want: []report.GPUReport{
+				{
+					Index:                   0,
+					Name:                    "NVIDIA A100-SXM4-80GB",
+					Vendor:                  report.VendorNVIDIA,
+					TemperatureC:            61,
+					PowerWatt:               348.71,
+					MemoryUsedMiB:           40536,
+					MemoryTotalMiB:          81920,
+					UtilizationGpuPercent:   97,
+					ProcessCount:            1,
+					ProcessNames:            "python3.10",
+					UUID:                    "GPU-5fb1c0a4-0a4f-8f2e-3a55-7c1fb0c5d8a1",
+					PCIBusID:                "07:00",
+					ECCVolatileCorrected:    5,
+					ECCAggregateCorrected:   19,
+					ECCAggregateUncorrected: 1,
+					ThrottleReasons:         []string{"sw_power_cap"},
+					ClockSMMHz:              1275,
+					ClockMemMHz:             1593,
+					PowerLimitWatt:          400,
+					PCIeGen:                 4,
+					PCIeGenMax:              4,
+					PCIeWidth:               8,
+					PCIeWidthMax:            16,
+					PCIeReplayCount:         3,
+					VirtualizationMode:      "Pass-Through",
+				},
+				{
+					Index:          1,
+					Name:           "NVIDIA A100-SXM4-80GB",
+					Vendor:         report.VendorNVIDIA,
+					TemperatureC:   32,
+					PowerWatt:      61.23,
+					MemoryUsedMiB:  4,
+					MemoryTotalMiB: 81920,
+					UUID:           "GPU-0e3b2a6c-61c1-52d8-7c4f-19e9a9d2f0b3",
+					PCIBusID:       "0f:00",
+					ClockSMMHz:     210,
+					ClockMemMHz:    1593,
+					PowerLimitWatt: 400,
+					PCIeGen:        4,
+					PCIeGenMax:     4,
+					PCIeWidth:      16,
+					PCIeWidthMax:   16,
+				},
+			},
+		},
+		{
+			// Driver 470: power_readings, clocks_throttle_reasons, the
+			// single/double bit ECC layout and retired pages.
+			file: "smi-470-v100.xml",
+			want: []report.GPUReport{
+				{
+					Index:                   0,
+					Name:                    "Tesla V100-PCIE-32GB",
+					Vendor:                  report.VendorNVIDIA,
+					TemperatureC:            84,
+					PowerWatt:               241.05,
+					MemoryUsedMiB:           30211,
+					MemoryTotalMiB:          32510,
+					UtilizationGpuPercent:   88,
+					ProcessCount:            2,
+					ProcessNames:            "python3, tritonserver",
+					UUID:                    "GPU-9a1d7a9e-4d9c-3c25-e41e-6b6bb1f0f3c2",
+					PCIBusID:                "3b:00",
+					ECCVolatileCorrected:    2,
+					ECCAggregateCorrected:   37,
+					ECCAggregateUncorrected: 2,
+					RetiredPagesSingleBit:   4,
+					RetiredPagesDoubleBit:   1,
+					RetiredPagesPending:     true,
+					ThrottleReasons:         []string{"hw_slowdown", "hw_thermal_slowdown"},
+					ClockSMMHz:              1245,
+					ClockMemMHz:             877,
+					PowerLimitWatt:          250,
+					PCIeGen:                 3,
+					PCIeGenMax:              3,
+					PCIeWidth:               16,
+					PCIeWidthMax:            16,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got := parseFile(t, tt.file)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d GPUs, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("GPU %d:\ngot  %+v\nwant %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"61.23 W", 61.23},
+		{"16x", 16},
+		{" 34 C ", 34},
+		{"N/A", 0},
+		{"[Not Supported]", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := number(tt.in); got != tt.want {
+			t.Errorf("number(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
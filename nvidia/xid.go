@@ -0,0 +1,66 @@
+package nvidia
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gpu-monitor/report"
+)
+
+// XIDEvent is one "NVRM: Xid" line from the kernel log.
+type XIDEvent struct {
+	BusID   string
+	Code    int
+	Message string
+}
+
+var xidLine = regexp.MustCompile(`NVRM: Xid \(PCI:([0-9A-Fa-f:.]+)\): (\d+),?\s*(.*)$`)
+
+// ParseXID scans kernel log output for XID errors.
+func ParseXID(r io.Reader) []XIDEvent {
+	var events []XIDEvent
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := xidLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		code, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		events = append(events, XIDEvent{
+			BusID:   report.NormalizeBusID(m[1]),
+			Code:    code,
+			Message: m[3],
+		})
+	}
+	return events
+}
+
+// RecentXID reads the kernel log written since the given time through
+// journalctl and returns the XID errors in it.
+func RecentXID(since time.Time) ([]XIDEvent, error) {
+	out, err := exec.Command("journalctl", "-k", "--no-pager", "-o", "cat",
+		"--since", since.Format("2006-01-02 15:04:05")).Output()
+	if err != nil {
+		return nil, err
+	}
+	return ParseXID(bytes.NewReader(out)), nil
+}
+
+// AttachXID adds the codes of events to the GPUs with a matching PCI bus id.
+func AttachXID(gpus []report.GPUReport, events []XIDEvent) {
+	for i := range gpus {
+		for _, ev := range events {
+			if ev.BusID == gpus[i].PCIBusID {
+				gpus[i].XIDErrors = append(gpus[i].XIDErrors, ev.Code)
+			}
+		}
+	}
+}
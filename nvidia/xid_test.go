@@ -0,0 +1,43 @@
+package nvidia
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseXID(t *testing.T) {
+	f, err := os.Open("testdata/journal-xid.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got := ParseXID(f)
+	want := []XIDEvent{
+		{BusID: "07:00", Code: 79, Message: "pid='<unknown>', name=<unknown>, GPU has fallen off the bus."},
+		{BusID: "0f:00", Code: 48, Message: "pid=48213, name=python3.10, An uncorrectable double bit error (DBE) has been detected on GPU in the framebuffer at partition 6, subpartition 0."},
+		{BusID: "0f:00", Code: 63, Message: "pid=48213, name=python3.10, Row Remapper: New row (0x00000000000010ff) marked for remapping, reset gpu to activate."},
+		{BusID: "3b:00", Code: 13, Message: "Graphics SM Warp Exception on (GPC 0, TPC 1, SM 0): Out Of Range Address"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseXID:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestAttachXID(t *testing.T) {
+	gpus := parseFile(t, "smi-535-a100.xml")
+	f, err := os.Open("testdata/journal-xid.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	AttachXID(gpus, ParseXID(f))
+	if got := gpus[0].XIDErrors; !reflect.DeepEqual(got, []int{79}) {
+		t.Errorf("GPU 0 XIDs = %v, want [79]", got)
+	}
+	if got := gpus[1].XIDErrors; !reflect.DeepEqual(got, []int{48, 63}) {
+		t.Errorf("GPU 1 XIDs = %v, want [48 63]", got)
+	}
+}
@@ -0,0 +1,306 @@
+// Package output renders rows of data as aligned tables, JSON, YAML or
+// CSV, with column selection and sorting, for the command-line tools.
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{"table", "json", "yaml", "csv"}
+
+type Row map[string]interface{}
+
+// Table is a list of rows with an ordered set of columns. Values are
+// strings, numbers, bools or nested maps and slices (JSON and YAML only).
+type Table struct {
+	Columns []string
+	Rows    []Row
+}
+
+// Select keeps only the given columns, in the given order.
+func (t *Table) Select(columns []string) error {
+	known := map[string]bool{}
+	for _, c := range t.Columns {
+		known[c] = true
+	}
+	for _, c := range columns {
+		if !known[c] {
+			return fmt.Errorf("unknown column %q, have %s", c, strings.Join(t.Columns, ", "))
+		}
+	}
+	t.Columns = columns
+	return nil
+}
+
+// Sort orders the rows by column, descending when it starts with "-".
+// Numbers compare numerically, everything else as text.
+func (t *Table) Sort(column string) error {
+	desc := strings.HasPrefix(column, "-")
+	column = strings.TrimPrefix(column, "-")
+	found := false
+	for _, c := range t.Columns {
+		found = found || c == column
+	}
+	if !found {
+		return fmt.Errorf("unknown sort column %q", column)
+	}
+
+	sort.SliceStable(t.Rows, func(i, j int) bool {
+		c := compare(t.Rows[i][column], t.Rows[j][column])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	return nil
+}
+
+func compare(a, b interface{}) int {
+	x, okA := number(a)
+	y, okB := number(b)
+	if okA && okB {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(format(a), format(b))
+}
+
+// Write renders the table in the given format.
+func Write(w io.Writer, t *Table, formatName string, headers bool) error {
+	switch formatName {
+	case "table":
+		return writeTable(w, t, headers)
+	case "json":
+		return writeJSON(w, t)
+	case "yaml":
+		return writeYAML(w, t)
+	case "csv":
+		return writeCSV(w, t, headers)
+	}
+	return fmt.Errorf("unknown output format %q, want one of %s", formatName, strings.Join(Formats, ", "))
+}
+
+func writeTable(w io.Writer, t *Table, headers bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if headers {
+		upper := make([]string, len(t.Columns))
+		for i, c := range t.Columns {
+			upper[i] = strings.ToUpper(c)
+		}
+		fmt.Fprintln(tw, strings.Join(upper, "\t"))
+	}
+	for _, row := range t.Rows {
+		cells := make([]string, len(t.Columns))
+		for i, c := range t.Columns {
+			cells[i] = format(row[c])
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, t *Table, headers bool) error {
+	cw := csv.NewWriter(w)
+	if headers {
+		cw.Write(t.Columns)
+	}
+	for _, row := range t.Rows {
+		cells := make([]string, len(t.Columns))
+		for i, c := range t.Columns {
+			cells[i] = format(row[c])
+		}
+		cw.Write(cells)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes an array of objects whose keys follow the column order.
+func writeJSON(w io.Writer, t *Table) error {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, row := range t.Rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('{')
+		for j, c := range t.Columns {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(c)
+			value, err := json.Marshal(row[c])
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(']')
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// writeYAML emits a YAML sequence of mappings with the keys in column
+// order. Values go through JSON first so nested values come out the same
+// as in the JSON output.
+func writeYAML(w io.Writer, t *Table) error {
+	if len(t.Rows) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	for _, row := range t.Rows {
+		for i, c := range t.Columns {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+			data, err := json.Marshal(row[c])
+			if err != nil {
+				return err
+			}
+			var v interface{}
+			json.Unmarshal(data, &v)
+			if err := writeYAMLValue(w, prefix+yamlKey(c)+":", v, "    "); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeYAMLValue(w io.Writer, key string, v interface{}, indent string) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			_, err := fmt.Fprintln(w, key, "{}")
+			return err
+		}
+		fmt.Fprintln(w, key)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeYAMLValue(w, indent+yamlKey(k)+":", v[k], indent+"  "); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		if len(v) == 0 {
+			_, err := fmt.Fprintln(w, key, "[]")
+			return err
+		}
+		fmt.Fprintln(w, key)
+		for _, item := range v {
+			if err := writeYAMLValue(w, indent+"-", item, indent+"  "); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := fmt.Fprintln(w, key, yamlScalar(v))
+	return err
+}
+
+func yamlKey(k string) string {
+	return yamlString(k)
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// yamlString quotes strings that YAML would read as something else.
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "", "null", "~", "true", "false", "yes", "no", "on", "off":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`\n\t") || strings.TrimSpace(s) != s || strings.HasPrefix(s, "-") || strings.HasPrefix(s, "?") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// format renders a cell for table and CSV output.
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		if v == math.Trunc(v) {
+			return strconv.FormatFloat(v, 'f', 0, 64)
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = k + "=" + v[k]
+		}
+		return strings.Join(parts, ",")
+	case []string:
+		return strings.Join(v, ",")
+	case []int:
+		parts := make([]string, len(v))
+		for i, n := range v {
+			parts[i] = strconv.Itoa(n)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,227 @@
+package output
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// fleet is a table with every kind of value the tools print: numbers,
+// labels, lists, nested maps and lists, and strings that need quoting.
+func fleet() *Table {
+	return &Table{
+		Columns: []string{"host", "gpu", "temp", "power", "labels", "xids", "note", "detail"},
+		Rows: []Row{
+			{
+				"host": "node-01", "gpu": 0, "temp": 72.0, "power": 301.5,
+				"labels": map[string]string{"env": "prod", "cluster": "training"},
+				"xids":   []int{79, 48},
+				"note":   "ok",
+				"detail": map[string]interface{}{
+					"mig": []interface{}{
+						map[string]interface{}{"profile": "1g.10gb", "uuid": "MIG-1"},
+						map[string]interface{}{"profile": "3g.40gb", "uuid": "MIG-2"},
+					},
+					"ecc":   map[string]interface{}{"volatile": 0, "aggregate": 12},
+					"flags": []string{"hw_slowdown"},
+				},
+			},
+			{
+				"host": `node-02, rack "b"`, "gpu": 1, "temp": 9.0, "power": 0.25,
+				"labels": map[string]string{},
+				"xids":   []int{},
+				"note":   "line one\nline two",
+				"detail": map[string]interface{}{"empty": map[string]interface{}{}, "none": []interface{}{}, "nil": nil},
+			},
+			{
+				"host": "node-03", "gpu": 2, "temp": 100.0, "power": nil,
+				"labels": map[string]string{"on": "yes"},
+				"note":   "-1",
+				"detail": map[string]interface{}{"key: value": "null", "list": []interface{}{[]interface{}{1, "two"}, true}},
+			},
+		},
+	}
+}
+
+// golden compares got with testdata/name, or rewrites it with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := "testdata/" + name
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs, got\n%s\nwant\n%s", path, got, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	for _, tt := range []struct {
+		format  string
+		headers bool
+		golden  string
+	}{
+		{"json", true, "fleet.json"},
+		{"yaml", true, "fleet.yaml"},
+		{"csv", true, "fleet.csv"},
+		{"csv", false, "fleet-no-headers.csv"},
+	} {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, fleet(), tt.format, tt.headers); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteSelected(t *testing.T) {
+	tbl := fleet()
+	if err := tbl.Select([]string{"note", "host"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Sort("-host"); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"json", "yaml", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, tbl, format, true); err != nil {
+				t.Fatal(err)
+			}
+			golden(t, "selected."+format, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	for format, want := range map[string]string{
+		"table": "HOST  GPU\n",
+		"json":  "[]\n",
+		"yaml":  "[]\n",
+		"csv":   "host,gpu\n",
+	} {
+		var buf bytes.Buffer
+		if err := Write(&buf, &Table{Columns: []string{"host", "gpu"}}, format, true); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != want {
+			t.Errorf("empty %s: %q, want %q", format, buf.String(), want)
+		}
+	}
+	err := Write(&bytes.Buffer{}, fleet(), "xml", true)
+	if err == nil || err.Error() != `unknown output format "xml", want one of table, json, yaml, csv` {
+		t.Errorf("Write xml: %v", err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tbl := fleet()
+	if err := tbl.Select([]string{"temp", "host"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tbl.Columns, ",") != "temp,host" {
+		t.Errorf("columns %v", tbl.Columns)
+	}
+	// Only the known columns can be selected, the rows keep their data.
+	err := tbl.Select([]string{"host", "gpu"})
+	if err == nil || err.Error() != `unknown column "gpu", have temp, host` {
+		t.Errorf("Select of a dropped column: %v", err)
+	}
+	if strings.Join(tbl.Columns, ",") != "temp,host" || tbl.Rows[0]["gpu"] != 0 {
+		t.Errorf("a failed Select changed the table: %v", tbl.Columns)
+	}
+}
+
+func TestSort(t *testing.T) {
+	hosts := func(tbl *Table) string {
+		var names []string
+		for _, r := range tbl.Rows {
+			names = append(names, strings.Fields(format(r["host"]))[0])
+		}
+		return strings.Join(names, " ")
+	}
+	tests := []struct {
+		column string
+		want   string
+		err    string
+	}{
+		// Numbers compare as numbers: 9 < 72 < 100.
+		{"temp", "node-02, node-01 node-03", ""},
+		{"-temp", "node-03 node-01 node-02,", ""},
+		{"gpu", "node-01 node-02, node-03", ""},
+		{"-gpu", "node-03 node-02, node-01", ""},
+		{"host", "node-01 node-02, node-03", ""},
+		{"-host", "node-03 node-02, node-01", ""},
+		// A missing value sorts as empty text.
+		{"power", "node-03 node-02, node-01", ""},
+		{"note", "node-03 node-02, node-01", ""},
+		{"rack", "", `unknown sort column "rack"`},
+		{"-rack", "", `unknown sort column "rack"`},
+	}
+	for _, tt := range tests {
+		tbl := fleet()
+		err := tbl.Sort(tt.column)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Sort(%q): %v, want %s", tt.column, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("Sort(%q): %v", tt.column, err)
+		case hosts(tbl) != tt.want:
+			t.Errorf("Sort(%q) = %s, want %s", tt.column, hosts(tbl), tt.want)
+		}
+	}
+
+	// Equal values keep their order.
+	tbl := &Table{Columns: []string{"name", "rack"}, Rows: []Row{
+		{"name": "c", "rack": "a"}, {"name": "a", "rack": "b"}, {"name": "b", "rack": "a"}, {"name": "d", "rack": "b"},
+	}}
+	if err := tbl.Sort("rack"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range tbl.Rows {
+		names = append(names, r["name"].(string))
+	}
+	if strings.Join(names, "") != "cbad" {
+		t.Errorf("stable sort gave %v", names)
+	}
+}
+
+func TestYAMLString(t *testing.T) {
+	for in, want := range map[string]string{
+		"node-01":    "node-01",
+		"two words":  "two words",
+		"":           `""`,
+		"null":       `"null"`,
+		"Yes":        `"Yes"`,
+		"off":        `"off"`,
+		"1.5":        `"1.5"`,
+		"1e3":        `"1e3"`,
+		"-x":         `"-x"`,
+		"?x":         `"?x"`,
+		"a: b":       `"a: b"`,
+		"#1":         `"#1"`,
+		" padded":    `" padded"`,
+		"line\nline": `"line\nline"`,
+		"ümlaut":     "ümlaut",
+	} {
+		if got := yamlString(in); got != want {
+			t.Errorf("yamlString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gpu-monitor/release"
+)
+
+const usage = `Usage:
+  release keygen -out NAME
+      Writes NAME.key (keep it off the server) and NAME.pub (installed on
+      every agent).
+  release sign -key NAME.key -version VERSION [-dir releases] OS/ARCH=BINARY...
+      Copies the agent binaries into DIR/VERSION and writes the signed
+      manifest next to them. Copy DIR/VERSION to the server's releases
+      directory to publish it.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "keygen":
+		keygen(os.Args[2:])
+	case "sign":
+		sign(os.Args[2:])
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
+
+func keygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "gpumon-release", "output file name without extension")
+	fs.Parse(args)
+
+	pub, priv, err := release.GenerateKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := release.WriteKey(*out+".key", priv, 0600); err != nil {
+		log.Fatal(err)
+	}
+	if err := release.WriteKey(*out+".pub", pub, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %s.key and %s.pub\n", *out, *out)
+}
+
+func sign(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPath := fs.String("key", "gpumon-release.key", "private signing key")
+	version := fs.String("version", "", "release version, e.g. 1.4.0")
+	dir := fs.String("dir", "releases", "releases directory")
+	fs.Parse(args)
+
+	if *version == "" || fs.NArg() == 0 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	key, err := release.ReadPrivateKey(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	outDir := filepath.Join(*dir, *version)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	manifest := release.Manifest{Version: *version, CreatedAt: time.Now().UTC()}
+	for _, arg := range fs.Args() {
+		platform, binary, ok := strings.Cut(arg, "=")
+		goos, goarch, ok2 := strings.Cut(platform, "/")
+		if !ok || !ok2 {
+			log.Fatalf("Bad artifact %q, want OS/ARCH=BINARY", arg)
+		}
+
+		name := fmt.Sprintf("gpumon-agent-%s-%s", goos, goarch)
+		if err := copyFile(binary, filepath.Join(outDir, name)); err != nil {
+			log.Fatal(err)
+		}
+		size, sum, err := release.HashFile(filepath.Join(outDir, name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, release.Artifact{
+			OS: goos, Arch: goarch, File: name, Size: size, SHA256: sum,
+		})
+	}
+
+	signed, err := release.Sign(manifest, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outDir, release.ManifestFile), data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Signed release %s with %d artifacts in %s\n", *version, len(manifest.Artifacts), outDir)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
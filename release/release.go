@@ -0,0 +1,220 @@
+// Package release describes signed agent releases. A release is a
+// directory holding the agent binaries and a manifest listing their
+// checksums; the manifest is signed with an ed25519 key that never leaves
+// the release machine, and agents only install binaries whose manifest
+// verifies against the public key they were installed with.
+package release
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestFile is the name of the signed manifest inside a release
+// directory.
+const ManifestFile = "manifest.json"
+
+type Manifest struct {
+	Version   string     `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+type Artifact struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	File   string `json:"file"` // relative to the release directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Signed is the manifest as stored and served. The signature covers the
+// compact JSON encoding of Manifest, so the file may be pretty-printed.
+type Signed struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature string          `json:"signature"` // base64
+}
+
+// Artifact returns the artifact built for the given platform.
+func (m Manifest) Artifact(goos, goarch string) (Artifact, bool) {
+	for _, a := range m.Artifacts {
+		if a.OS == goos && a.Arch == goarch {
+			return a, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// GenerateKey creates a new signing key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// Sign encodes and signs a manifest.
+func Sign(m Manifest, key ed25519.PrivateKey) (Signed, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Signed{}, err
+	}
+	return Signed{
+		Manifest:  data,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, nil
+}
+
+// Verify checks the signature and returns the decoded manifest.
+func Verify(s Signed, key ed25519.PublicKey) (Manifest, error) {
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("bad signature encoding: %w", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, s.Manifest); err != nil {
+		return Manifest{}, err
+	}
+	if !ed25519.Verify(key, compact.Bytes(), sig) {
+		return Manifest{}, errors.New("manifest signature does not verify")
+	}
+	var m Manifest
+	if err := json.Unmarshal(s.Manifest, &m); err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// WriteKey stores a key as base64 text.
+func WriteKey(path string, key []byte, perm os.FileMode) error {
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), perm)
+}
+
+// ReadPublicKey reads a public key written by WriteKey.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	key, err := readKey(path, ed25519.PublicKeySize)
+	return ed25519.PublicKey(key), err
+}
+
+// ReadPrivateKey reads a private key written by WriteKey.
+func ReadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readKey(path, ed25519.PrivateKeySize)
+	return ed25519.PrivateKey(key), err
+}
+
+func readKey(path string, size int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%s: key is %d bytes, want %d", path, len(key), size)
+	}
+	return key, nil
+}
+
+// HashFile returns the size and hex SHA-256 of a file.
+func HashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Compare compares dotted version strings numerically ("1.10.0" is newer
+// than "1.9.2"), ignoring a leading "v"; missing segments count as 0. A
+// prerelease ("1.2.0-rc.1") is older than its release, and prereleases
+// of one release compare segment by segment as in semantic versioning.
+// It returns -1, 0 or 1.
+func Compare(a, b string) int {
+	a, preA, _ := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	b, preB, _ := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(pb[i])
+		}
+		if x != y {
+			return cmp(x < y)
+		}
+	}
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return comparePrerelease(preA, preB)
+}
+
+// comparePrerelease orders the prerelease parts of two versions: numeric
+// segments numerically and before alphanumeric ones, the others as
+// strings, and a shorter list before a longer one it starts.
+func comparePrerelease(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, errX := strconv.Atoi(pa[i])
+		y, errY := strconv.Atoi(pb[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				return cmp(x < y)
+			}
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		case pa[i] != pb[i]:
+			return cmp(pa[i] < pb[i])
+		}
+	}
+	if len(pa) != len(pb) {
+		return cmp(len(pa) < len(pb))
+	}
+	return 0
+}
+
+// cmp returns -1 if less, 1 otherwise.
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// Bucket places a host in one of 100 rollout buckets for a version. A
+// host is part of a rollout at N percent when its bucket is below N; the
+// version is mixed in so that every rollout reaches a different first
+// batch of hosts.
+func Bucket(hostname, version string) int {
+	h := fnv.New32a()
+	h.Write([]byte(hostname + "\x00" + version))
+	return int(h.Sum32() % 100)
+}
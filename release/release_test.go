@@ -0,0 +1,136 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func manifest() Manifest {
+	return Manifest{
+		Version:   "1.4.0",
+		CreatedAt: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Artifacts: []Artifact{
+			{OS: "linux", Arch: "amd64", File: "gpumon-agent-linux-amd64", Size: 1024, SHA256: strings.Repeat("ab", 32)},
+		},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pub, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := manifest()
+	s, err := Sign(m, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, s.Manifest, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(s.Manifest, []byte(`"size":1024`), []byte(`"size":1025`), 1)
+	if bytes.Equal(tampered, s.Manifest) {
+		t.Fatal("manifest has no size to tamper with")
+	}
+
+	tests := []struct {
+		name string
+		s    Signed
+		key  []byte
+		err  string // empty if the manifest should verify
+	}{
+		{"signed", s, pub, ""},
+		{"pretty-printed", Signed{pretty.Bytes(), s.Signature}, pub, ""},
+		{"tampered", Signed{tampered, s.Signature}, pub, "manifest signature does not verify"},
+		{"another version", Signed{bytes.Replace(s.Manifest, []byte("1.4.0"), []byte("1.5.0"), 1), s.Signature}, pub,
+			"manifest signature does not verify"},
+		{"wrong key", s, other, "manifest signature does not verify"},
+		{"bad encoding", Signed{s.Manifest, "not base64!"}, pub, "bad signature encoding"},
+		{"no signature", Signed{s.Manifest, ""}, pub, "manifest signature does not verify"},
+		{"bad JSON", Signed{[]byte(`{"version":`), s.Signature}, pub, "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.s, tt.key)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Verify: %v", err)
+			case tt.err == "" && (got.Version != m.Version || !got.CreatedAt.Equal(m.CreatedAt) || len(got.Artifacts) != 1 || got.Artifacts[0] != m.Artifacts[0]):
+				t.Errorf("Verify = %+v, want %+v", got, m)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("Verify: %v, want %s...", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.4.0", "1.4.0", 0},
+		{"1.10.0", "1.9.2", 1},
+		{"1.9.2", "1.10.0", -1},
+		{"v1.4.0", "1.4.0", 0},
+		{"2.0.0", "v1.99.99", 1},
+		{"1.0", "1.0.0", 0},
+		{"1.2", "1.2.1", -1},
+		{"1.2.0.1", "1.2", 1},
+		{"1", "0.9", 1},
+		{"1.2.0-rc.1", "1.2.0", -1},
+		{"1.2.0", "1.2.0-rc.1", 1},
+		{"1.2.0-rc.1", "1.1.9", 1},
+		{"1.2.0-rc.1", "1.2.0-rc.2", -1},
+		{"1.2.0-rc.2", "1.2.0-rc.10", -1},
+		{"1.2.0-alpha", "1.2.0-beta", -1},
+		{"1.2.0-alpha", "1.2.0-alpha.1", -1},
+		{"1.2.0-1", "1.2.0-alpha", -1},
+		{"v1.2.0-rc.1", "1.2.0-rc.1", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	const hosts = 2000
+	var deciles [10]int
+	moved := 0
+	for i := 0; i < hosts; i++ {
+		host := fmt.Sprintf("node-%04d", i)
+		b := Bucket(host, "1.4.0")
+		if b < 0 || b >= 100 {
+			t.Fatalf("Bucket(%q) = %d", host, b)
+		}
+		if again := Bucket(host, "1.4.0"); again != b {
+			t.Fatalf("Bucket(%q) = %d, then %d", host, b, again)
+		}
+		deciles[b/10]++
+		if Bucket(host, "1.5.0") != b {
+			moved++
+		}
+	}
+	// Every tenth of the fleet gets roughly a tenth of the hosts.
+	for i, n := range deciles {
+		if n < hosts/10/2 || n > hosts/10*2 {
+			t.Errorf("buckets %d-%d hold %d of %d hosts", i*10, i*10+9, n, hosts)
+		}
+	}
+	// Another version starts with another batch of hosts.
+	if moved < hosts/2 {
+		t.Errorf("only %d of %d hosts change buckets for a new version", moved, hosts)
+	}
+}
@@ -0,0 +1,303 @@
+// Package report holds the JSON documents exchanged between the agents
+// and the collector server.
+package report
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	VendorNVIDIA = "nvidia"
+	VendorAMD    = "amd"
+)
+
+type GPUReport struct {
+	Index                 int     `json:"index"`
+	Name                  string  `json:"name"`
+	Vendor                string  `json:"vendor"` // VendorNVIDIA or VendorAMD
+	FanPercent            int     `json:"fan_percent"`
+	TemperatureC          int     `json:"temperature_c"`
+	PowerWatt             float64 `json:"power_watt"`
+	MemoryUsedMiB         int     `json:"memory_used_mib"`
+	MemoryTotalMiB        int     `json:"memory_total_mib"`
+	UtilizationGpuPercent int     `json:"utilization_gpu_percent"`
+	ProcessCount          int     `json:"process_count"`
+	ProcessNames          string  `json:"process_names"`
+	UpdatedAt             string  `json:"updated_at"` // ISO string
+
+	// Extended telemetry, only filled in by the Go agent. mon.sh leaves
+	// these at their zero values, as does rocm-smi for the NVIDIA-only
+	// ECC, XID, throttle and PCIe link fields.
+	UUID                    string   `json:"uuid,omitempty"`
+	PCIBusID                string   `json:"pci_bus_id,omitempty"`
+	ECCVolatileCorrected    int64    `json:"ecc_volatile_corrected"`
+	ECCVolatileUncorrected  int64    `json:"ecc_volatile_uncorrected"`
+	ECCAggregateCorrected   int64    `json:"ecc_aggregate_corrected"`
+	ECCAggregateUncorrected int64    `json:"ecc_aggregate_uncorrected"`
+	RetiredPagesSingleBit   int      `json:"retired_pages_sbe"`
+	RetiredPagesDoubleBit   int      `json:"retired_pages_dbe"`
+	RetiredPagesPending     bool     `json:"retired_pages_pending"`
+	XIDErrors               []int    `json:"xid_errors,omitempty"`
+	ThrottleReasons         []string `json:"throttle_reasons,omitempty"`
+	ClockSMMHz              int      `json:"clock_sm_mhz"`
+	ClockMemMHz             int      `json:"clock_mem_mhz"`
+	PowerLimitWatt          float64  `json:"power_limit_watt"`
+	PCIeGen                 int      `json:"pcie_gen"`
+	PCIeGenMax              int      `json:"pcie_gen_max"`
+	PCIeWidth               int      `json:"pcie_width"`
+	PCIeWidthMax            int      `json:"pcie_width_max"`
+	PCIeReplayCount         int64    `json:"pcie_replay_count"`
+
+	// MIGMode is set when the GPU is partitioned; its memory and
+	// processes are then broken down per instance in MIGInstances.
+	MIGMode            bool          `json:"mig_mode"`
+	MIGInstances       []MIGInstance `json:"mig_instances,omitempty"`
+	VirtualizationMode string        `json:"virtualization_mode,omitempty"`
+
+	// Labels of the GPU's host, filled in by the server.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// MIGInstance is one compute instance of a MIG-partitioned GPU.
+type MIGInstance struct {
+	GPUInstanceID     int    `json:"gpu_instance_id"`
+	ComputeInstanceID int    `json:"compute_instance_id"`
+	Profile           string `json:"profile"` // e.g. "3g.40gb"
+	UUID              string `json:"uuid,omitempty"`
+	ComputeSlices     int    `json:"compute_slices"`
+	SMCount           int    `json:"sm_count"`
+	MemoryUsedMiB     int    `json:"memory_used_mib"`
+	MemoryTotalMiB    int    `json:"memory_total_mib"`
+	ProcessCount      int    `json:"process_count"`
+	ProcessNames      string `json:"process_names"`
+
+	// Device is the index nvidia-smi lists the instance under. The agent
+	// matches it against `nvidia-smi -L`; it is not reported.
+	Device int `json:"-"`
+}
+
+type HostReport struct {
+	Hostname        string  `json:"hostname"`
+	CPUUsagePercent float64 `json:"cpu_usage_percent"`
+	MemoryUsedMB    int     `json:"memory_used_mb"`
+	MemoryTotalMB   int     `json:"memory_total_mb"`
+	DiskUsed        string  `json:"disk_used"`
+	DiskTotal       string  `json:"disk_total"`
+	UpdatedAt       string  `json:"updated_at"`
+
+	// Labels are filled in by the server.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type HardwareReport struct {
+	Hostname string          `json:"hostname"`
+	Uptime   string          `json:"uptime"`
+	Kernel   string          `json:"kernel"`
+	Distro   string          `json:"distro"`
+	CPU      string          `json:"cpu"`
+	Memory   string          `json:"memory"`
+	Disk     json.RawMessage `json:"disk"`
+	PCI      string          `json:"pci"`
+	USB      string          `json:"usb"`
+	Network  json.RawMessage `json:"network"`
+	Storage  string          `json:"storage"`
+}
+
+// NormalizeBusID turns the various PCI address spellings used by
+// nvidia-smi ("00000000:3B:00.0"), rocm-smi ("0000:3B:00.0") and the
+// kernel log ("0000:3b:00") into a common "3b:00" form.
+func NormalizeBusID(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if i := strings.LastIndex(id, "."); i >= 0 {
+		id = id[:i]
+	}
+	parts := strings.Split(id, ":")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	return strings.Join(parts, ":")
+}
+
+// Host returns the hostname part of a GPU name in the "slot@host@model"
+// form used by the agents, or "" if the name does not follow it.
+func (g GPUReport) Host() string {
+	parts := strings.SplitN(g.Name, "@", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Heartbeat is sent by the Go agent on every report cycle so the server
+// knows which agent version each host runs.
+type Heartbeat struct {
+	Hostname  string `json:"hostname"`
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	StartedAt string `json:"started_at"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+
+	// Labels from the agent config. Nil from agents that predate labels,
+	// which leaves the stored ones alone.
+	Labels map[string]string `json:"labels"`
+}
+
+// Host enrollment states.
+const (
+	HostPending  = "pending"
+	HostApproved = "approved"
+	HostRejected = "rejected"
+)
+
+// HostInfo is a host registered through `gpumon-agent enroll`.
+type HostInfo struct {
+	Hostname   string            `json:"hostname"`
+	Owner      string            `json:"owner"`
+	Rack       string            `json:"rack"`
+	Labels     map[string]string `json:"labels"`
+	Status     string            `json:"status"`
+	EnrolledAt string            `json:"enrolled_at"`
+}
+
+// EnrollRequest exchanges a one-time join token for a host credential.
+type EnrollRequest struct {
+	Token    string            `json:"token"`
+	Hostname string            `json:"hostname"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Version  string            `json:"version"`
+	OS       string            `json:"os"`
+	Arch     string            `json:"arch"`
+}
+
+type EnrollResponse struct {
+	Hostname   string `json:"hostname"`
+	Credential string `json:"credential"`
+	Status     string `json:"status"`
+}
+
+// PairingRequest asks for a one-time code that, sent to the bot as
+// /pair CODE, gives the chat a role and alert subscriptions.
+type PairingRequest struct {
+	Role          string                `json:"role"` // viewer, operator or admin
+	Subscriptions []PairingSubscription `json:"subscriptions,omitempty"`
+	Note          string                `json:"note,omitempty"`
+	TTL           string                `json:"ttl,omitempty"` // Go duration, default 24h
+}
+
+type PairingSubscription struct {
+	Selector string `json:"selector"`
+	Severity string `json:"severity"`
+}
+
+// PairingCode is a code as listed or redeemed. Code itself is only
+// filled in when the code is created.
+type PairingCode struct {
+	ID            int64                 `json:"id"`
+	Code          string                `json:"code,omitempty"`
+	Role          string                `json:"role"`
+	Subscriptions []PairingSubscription `json:"subscriptions"`
+	Note          string                `json:"note"`
+	CreatedAt     string                `json:"created_at"`
+	ExpiresAt     string                `json:"expires_at"`
+	UsedAt        string                `json:"used_at,omitempty"`
+	ChatID        int64                 `json:"chat_id,omitempty"`
+	UserID        int64                 `json:"user_id,omitempty"`
+}
+
+// PairingRedemption is sent by the bot when someone uses a code.
+type PairingRedemption struct {
+	Code   string `json:"code"`
+	ChatID int64  `json:"chat_id"`
+	UserID int64  `json:"user_id"`
+}
+
+// IncidentRequest opens an incident on the status page. Components
+// names the status page components it affects, if any.
+type IncidentRequest struct {
+	Title      string   `json:"title"`
+	Status     string   `json:"status"` // investigating, identified, monitoring or resolved
+	Message    string   `json:"message"`
+	Components []string `json:"components,omitempty"`
+}
+
+// IncidentUpdateRequest posts an update to an open incident. A status of
+// resolved closes it.
+type IncidentUpdateRequest struct {
+	ID      int64  `json:"id"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Incident is a note about an outage posted by an admin, with its updates
+// newest first.
+type Incident struct {
+	ID         int64            `json:"id"`
+	Title      string           `json:"title"`
+	Status     string           `json:"status"`
+	Components []string         `json:"components"`
+	CreatedAt  string           `json:"created_at"`
+	UpdatedAt  string           `json:"updated_at"`
+	ResolvedAt string           `json:"resolved_at,omitempty"`
+	Updates    []IncidentUpdate `json:"updates"`
+}
+
+type IncidentUpdate struct {
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CheckRequest asks for a change to the service checks on behalf of a
+// chat. Which fields count depends on the endpoint: Type, Target and
+// Settings add a check, ID and Settings change one, ID or Team picks what
+// to watch, and ID and Team what to share.
+type CheckRequest struct {
+	ChatID   int64    `json:"chat_id"`
+	ID       int      `json:"id,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Target   string   `json:"target,omitempty"`
+	Settings []string `json:"settings,omitempty"` // key=value, e.g. interval=1m
+	Team     string   `json:"team,omitempty"`
+}
+
+// GPUSample is one point of a GPU's history.
+type GPUSample struct {
+	Time                  string  `json:"time"`
+	TemperatureC          int     `json:"temperature_c"`
+	UtilizationGpuPercent int     `json:"utilization_gpu_percent"`
+	MemoryUsedMiB         int     `json:"memory_used_mib"`
+	PowerWatt             float64 `json:"power_watt"`
+}
+
+type GPUHistory struct {
+	Name    string      `json:"name"`
+	Samples []GPUSample `json:"samples"`
+}
+
+// HostSample is one point of a host's history.
+type HostSample struct {
+	Time            string  `json:"time"`
+	CPUUsagePercent float64 `json:"cpu_usage_percent"`
+	MemoryUsedMB    int     `json:"memory_used_mb"`
+}
+
+type HostHistory struct {
+	Hostname string       `json:"hostname"`
+	Samples  []HostSample `json:"samples"`
+}
+
+// GroupSummary aggregates the hosts sharing one value of a label.
+type GroupSummary struct {
+	Label          string  `json:"label"`
+	Value          string  `json:"value"` // empty for hosts without the label
+	Hosts          int     `json:"hosts"`
+	GPUs           int     `json:"gpus"`
+	BusyGPUs       int     `json:"busy_gpus"` // GPUs running at least one process
+	AvgUtilization float64 `json:"avg_utilization_percent"`
+	MemoryUsedMiB  int     `json:"memory_used_mib"`
+	MemoryTotalMiB int     `json:"memory_total_mib"`
+	PowerWatt      float64 `json:"power_watt"`
+	Alerts         int     `json:"alerts"`
+}
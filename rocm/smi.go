@@ -0,0 +1,201 @@
+// Package rocm parses the JSON output of AMD's rocm-smi into the same GPU
+// reports the NVIDIA agent produces.
+package rocm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gpu-monitor/report"
+)
+
+// queryArgs selects the fields Parse understands. Other fields are
+// ignored, so newer rocm-smi releases adding keys do no harm.
+var queryArgs = []string{
+	"--showproductname", "--showbus", "--showtemp", "--showfan",
+	"--showpower", "--showmaxpower", "--showuse", "--showmeminfo", "vram",
+	"--json",
+}
+
+// Query runs rocm-smi for the GPU metrics and the process list and parses
+// both.
+func Query() ([]report.GPUReport, error) {
+	out, err := exec.Command("rocm-smi", queryArgs...).Output()
+	if err != nil {
+		return nil, err
+	}
+	gpus, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+
+	// Process information is best effort: older releases do not support
+	// --showpidgpus and containers often cannot see other PIDs.
+	pids, err := exec.Command("rocm-smi", "--showpids", "--json").Output()
+	if err != nil {
+		return gpus, nil
+	}
+	pidGPUs, err := exec.Command("rocm-smi", "--showpidgpus", "--json").Output()
+	if err != nil {
+		return gpus, nil
+	}
+	procs, err := ParseProcesses(bytes.NewReader(pids), bytes.NewReader(pidGPUs))
+	if err != nil {
+		return gpus, nil
+	}
+	AttachProcesses(gpus, procs)
+	return gpus, nil
+}
+
+var cardKey = regexp.MustCompile(`^card(\d+)$`)
+
+// Parse reads `rocm-smi --json` output. The top-level object has one
+// "cardN" entry per GPU plus a "system" entry. The returned reports carry
+// the bare product name; callers prefix it with slot and hostname.
+func Parse(r io.Reader) ([]report.GPUReport, error) {
+	var doc map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var gpus []report.GPUReport
+	for key, raw := range doc {
+		m := cardKey.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		var card map[string]interface{}
+		if err := json.Unmarshal(raw, &card); err != nil {
+			return nil, err
+		}
+		index, _ := strconv.Atoi(m[1])
+		c := fields{}
+		for k, v := range card {
+			c[k] = fmt.Sprint(v)
+		}
+
+		gpu := report.GPUReport{
+			Index:                 index,
+			Vendor:                report.VendorAMD,
+			Name:                  c.first("card series", "card model", "card sku"),
+			PCIBusID:              report.NormalizeBusID(c.first("pci bus")),
+			FanPercent:            int(c.number("fan speed (%)")),
+			TemperatureC:          int(c.number("temperature (sensor edge) (c)", "temperature (sensor junction) (c)")),
+			PowerWatt:             c.number("average graphics package power (w)", "current socket graphics package power (w)"),
+			PowerLimitWatt:        c.number("max graphics package power (w)"),
+			UtilizationGpuPercent: int(c.number("gpu use (%)")),
+			MemoryUsedMiB:         int(c.number("vram total used memory (b)") / (1 << 20)),
+			MemoryTotalMiB:        int(c.number("vram total memory (b)") / (1 << 20)),
+		}
+		gpus = append(gpus, gpu)
+	}
+	sort.Slice(gpus, func(i, j int) bool { return gpus[i].Index < gpus[j].Index })
+	return gpus, nil
+}
+
+// Process is one entry of `rocm-smi --showpids`.
+type Process struct {
+	PID  int
+	Name string
+	GPUs []int
+}
+
+var pidKey = regexp.MustCompile(`^PID\s*(\d+)$`)
+var integers = regexp.MustCompile(`\d+`)
+
+// ParseProcesses combines `rocm-smi --showpids --json`, whose values read
+// "name, gpu count, vram, sdma, cu occupancy", with
+// `rocm-smi --showpidgpus --json`, whose values list the GPU indices.
+func ParseProcesses(pids, pidGPUs io.Reader) ([]Process, error) {
+	var names, gpus map[string]map[string]string
+	if err := json.NewDecoder(pids).Decode(&names); err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(pidGPUs).Decode(&gpus); err != nil {
+		return nil, err
+	}
+
+	var procs []Process
+	for key, value := range names["system"] {
+		m := pidKey.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		pid, _ := strconv.Atoi(m[1])
+		proc := Process{PID: pid, Name: strings.TrimSpace(strings.SplitN(value, ",", 2)[0])}
+		for k, v := range gpus["system"] {
+			if pm := pidKey.FindStringSubmatch(k); pm == nil || pm[1] != m[1] {
+				continue
+			}
+			for _, idx := range integers.FindAllString(v, -1) {
+				n, _ := strconv.Atoi(idx)
+				proc.GPUs = append(proc.GPUs, n)
+			}
+		}
+		procs = append(procs, proc)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	return procs, nil
+}
+
+// AttachProcesses fills in the process count and names of each GPU.
+func AttachProcesses(gpus []report.GPUReport, procs []Process) {
+	for i := range gpus {
+		var names []string
+		for _, p := range procs {
+			for _, idx := range p.GPUs {
+				if idx == gpus[i].Index {
+					names = append(names, p.Name)
+					break
+				}
+			}
+		}
+		gpus[i].ProcessCount = len(names)
+		gpus[i].ProcessNames = strings.Join(names, ", ")
+	}
+}
+
+// fields gives case-insensitive access to a card's entries; the key
+// capitalisation has changed between rocm-smi releases ("Card series" vs
+// "Card Series").
+type fields map[string]string
+
+func (f fields) lookup(key string) (string, bool) {
+	for k, v := range f {
+		if strings.EqualFold(k, key) {
+			return strings.TrimSpace(v), true
+		}
+	}
+	return "", false
+}
+
+func (f fields) first(keys ...string) string {
+	for _, key := range keys {
+		if v, ok := f.lookup(key); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// number returns the first of keys holding a parseable number. Values such
+// as "N/A" or "Unable to determine" are skipped.
+func (f fields) number(keys ...string) float64 {
+	for _, key := range keys {
+		v, ok := f.lookup(key)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
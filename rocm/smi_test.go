@@ -0,0 +1,135 @@
+package rocm
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gpu-monitor/report"
+)
+
+func open(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file string
+		want []report.GPUReport
+	}{
+		{
+			// ROCm 5.4: "Card series", edge temperature and average
+			// package power.
+			file: "smi-5.4-mi210.json",
+			want: []report.GPUReport{
+				{Index: 0, Name: "Instinct MI210", Vendor: report.VendorAMD, PCIBusID: "c3:00",
+					TemperatureC: 38, PowerWatt: 43, PowerLimitWatt: 300,
+					MemoryUsedMiB: 10, MemoryTotalMiB: 65520},
+				{Index: 1, Name: "Instinct MI210", Vendor: report.VendorAMD, PCIBusID: "83:00",
+					TemperatureC: 63, PowerWatt: 271, PowerLimitWatt: 300, UtilizationGpuPercent: 100,
+					MemoryUsedMiB: 50176, MemoryTotalMiB: 65520},
+			},
+		},
+		{
+			// ROCm 6.1 on MI300X: "Card Series", no edge sensor, socket
+			// power and no fan.
+			file: "smi-6.1-mi300x.json",
+			want: []report.GPUReport{
+				{Index: 0, Name: "AMD Instinct MI300X OAM", Vendor: report.VendorAMD, PCIBusID: "0c:00",
+					TemperatureC: 52, PowerWatt: 402, PowerLimitWatt: 750, UtilizationGpuPercent: 87,
+					MemoryUsedMiB: 155648, MemoryTotalMiB: 196592},
+				{Index: 1, Name: "AMD Instinct MI300X OAM", Vendor: report.VendorAMD, PCIBusID: "22:00",
+					TemperatureC: 39, PowerWatt: 138, PowerLimitWatt: 750,
+					MemoryUsedMiB: 283, MemoryTotalMiB: 196592},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := Parse(open(t, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse:\ngot  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	tests := []struct {
+		name          string
+		smi           string
+		pids, pidGPUs string
+		wantProcs     []Process
+		wantCounts    []int
+		wantNames     []string
+	}{
+		{
+			// "PID3471" keys and a bare GPU index.
+			name: "5.4",
+			smi:  "smi-5.4-mi210.json", pids: "pids-5.4.json", pidGPUs: "pidgpus-5.4.json",
+			wantProcs:  []Process{{PID: 3471, Name: "python3", GPUs: []int{1}}},
+			wantCounts: []int{0, 1},
+			wantNames:  []string{"", "python3"},
+		},
+		{
+			// "PID 20817" keys and a bracketed list of GPUs.
+			name: "6.1",
+			smi:  "smi-6.1-mi300x.json", pids: "pids-6.1.json", pidGPUs: "pidgpus-6.1.json",
+			wantProcs: []Process{
+				{PID: 20817, Name: "vllm", GPUs: []int{0, 1}},
+				{PID: 20944, Name: "rocm-bandwidth-test", GPUs: []int{0}},
+			},
+			wantCounts: []int{2, 1},
+			wantNames:  []string{"vllm, rocm-bandwidth-test", "vllm"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procs, err := ParseProcesses(open(t, tt.pids), open(t, tt.pidGPUs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(procs, tt.wantProcs) {
+				t.Errorf("ParseProcesses:\ngot  %+v\nwant %+v", procs, tt.wantProcs)
+			}
+
+			gpus, err := Parse(open(t, tt.smi))
+			if err != nil {
+				t.Fatal(err)
+			}
+			AttachProcesses(gpus, procs)
+			for i, g := range gpus {
+				if g.ProcessCount != tt.wantCounts[i] || g.ProcessNames != tt.wantNames[i] {
+					t.Errorf("GPU %d: %d processes %q, want %d %q", i, g.ProcessCount, g.ProcessNames, tt.wantCounts[i], tt.wantNames[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFieldsNumber(t *testing.T) {
+	f := fields{"GPU use (%)": "87", "Fan speed (%)": "Unable to determine", "Temperature (Sensor edge) (C)": "N/A"}
+	tests := []struct {
+		keys []string
+		want float64
+	}{
+		{[]string{"gpu use (%)"}, 87},
+		{[]string{"fan speed (%)"}, 0},
+		{[]string{"temperature (sensor edge) (c)", "gpu use (%)"}, 87},
+		{[]string{"missing"}, 0},
+	}
+	for _, tt := range tests {
+		if got := f.number(tt.keys...); got != tt.want {
+			t.Errorf("number(%q) = %v, want %v", tt.keys, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gpu-monitor/telegram"
 )
 
 const (
@@ -13,39 +21,186 @@ const (
 	chatIDEnv   = "TELEGRAM_CHAT_ID"
 )
 
+const usage = `Usage: send [flags] [MESSAGE...]
+
+Sends MESSAGE, or stdin if there is none, to one or more Telegram chats.
+With -template, stdin is JSON that fills in a Go text/template, e.g.
+
+  echo '{"host": "node1", "temp": 92}' |
+    send -parse-mode HTML -template-text '<b>{{escape .host}}</b> is at {{.temp}}°C'
+
+Templates can use escape (for the parse mode), upper, lower, join and
+json. Messages longer than Telegram's limit are split at line breaks.
+
+The bot token comes from ` + botTokenEnv + `, chats default to ` + chatIDEnv + `.
+
+Flags:
+`
+
 func main() {
-	// Get bot token and chat ID from environment variables
-	botToken := os.Getenv(botTokenEnv)
-	chatID := os.Getenv(chatIDEnv)
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	chats := fs.String("chat", os.Getenv(chatIDEnv), "comma-separated chat IDs or @channel names")
+	mode := fs.String("parse-mode", "", "Markdown, MarkdownV2 or HTML (default plain text)")
+	tmplFile := fs.String("template", "", "template file, filled in with JSON from stdin")
+	tmplText := fs.String("template-text", "", "template given inline instead of -template")
+	retries := fs.Int("retries", 5, "how often to retry a message on rate limits and server errors")
+	silent := fs.Bool("silent", false, "send without a notification sound")
+	noPreview := fs.Bool("no-preview", false, "disable link previews")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
 
-	if botToken == "" || chatID == "" {
-		fmt.Printf("Environment variables %s and %s must be set.\n", botTokenEnv, chatIDEnv)
-		return
+	token := os.Getenv(botTokenEnv)
+	if token == "" || *chats == "" {
+		fmt.Fprintf(os.Stderr, "%s and -chat or %s must be set.\n", botTokenEnv, chatIDEnv)
+		os.Exit(2)
+	}
+	parseMode, err := telegram.ParseMode(*mode)
+	if err != nil {
+		fatal(err)
 	}
 
-	// Read message from stdin
-	message, err := io.ReadAll(os.Stdin)
+	text, err := message(fs.Args(), parseMode, *tmplFile, *tmplText)
 	if err != nil {
-		fmt.Println("Error reading stdin:", err)
-		return
+		fatal(err)
+	}
+	if strings.TrimSpace(text) == "" {
+		fatal(errors.New("message is empty"))
+	}
+	parts := telegram.Split(text, telegram.MaxMessageLength)
+
+	failed := false
+	for _, chat := range strings.Split(*chats, ",") {
+		chat = strings.TrimSpace(chat)
+		if chat == "" {
+			continue
+		}
+		for i, part := range parts {
+			params := map[string]interface{}{
+				"chat_id":                  chatParam(chat),
+				"text":                     part,
+				"disable_notification":     *silent,
+				"disable_web_page_preview": *noPreview,
+			}
+			if parseMode != "" {
+				params["parse_mode"] = parseMode
+			}
+			if err := sendWithRetry(token, params, *retries); err != nil {
+				fmt.Fprintf(os.Stderr, "Sending to %s failed: %v\n", chat, err)
+				failed = true
+				break
+			}
+			if len(parts) > 1 {
+				fmt.Printf("Sent part %d/%d to %s\n", i+1, len(parts), chat)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
 	}
+	fmt.Println("Message sent successfully!")
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
 
-	// Prepare request
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", botToken)
-	body := []byte(fmt.Sprintf("chat_id=%s&text=%s", chatID, string(message)))
+// chatParam passes numeric chat IDs as numbers and @channel names as is.
+func chatParam(chat string) interface{} {
+	if id, err := strconv.ParseInt(chat, 10, 64); err == nil {
+		return id
+	}
+	return chat
+}
+
+// message returns the text to send: the arguments, stdin, or a template
+// filled in with the JSON on stdin.
+func message(args []string, parseMode, tmplFile, tmplText string) (string, error) {
+	if tmplFile == "" && tmplText == "" {
+		if len(args) > 0 {
+			return strings.Join(args, " "), nil
+		}
+		b, err := io.ReadAll(os.Stdin)
+		return string(b), err
+	}
 
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", bytes.NewBuffer(body))
+	if tmplFile != "" {
+		b, err := os.ReadFile(tmplFile)
+		if err != nil {
+			return "", err
+		}
+		tmplText = string(b)
+	}
+	funcs := template.FuncMap{
+		"escape": func(v interface{}) string { return telegram.Escape(parseMode, fmt.Sprint(v)) },
+		"upper":  func(v interface{}) string { return strings.ToUpper(fmt.Sprint(v)) },
+		"lower":  func(v interface{}) string { return strings.ToLower(fmt.Sprint(v)) },
+		"join": func(sep string, v []interface{}) string {
+			s := make([]string, len(v))
+			for i, e := range v {
+				s[i] = fmt.Sprint(e)
+			}
+			return strings.Join(s, sep)
+		},
+		"json": func(v interface{}) (string, error) {
+			b, err := json.MarshalIndent(v, "", "  ")
+			return string(b), err
+		},
+	}
+	tmpl, err := template.New("message").Funcs(funcs).Option("missingkey=error").Parse(tmplText)
 	if err != nil {
-		fmt.Println("Error sending message:", err)
-		return
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Telegram API error: %s\n", respBody)
-	} else {
-		fmt.Println("Message sent successfully!")
+	var data interface{}
+	dec := json.NewDecoder(os.Stdin)
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading template data from stdin: %v", err)
 	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
+// sleep waits between retries. Tests replace it to see the waits without
+// sitting through them.
+var sleep = time.Sleep
+
+// sendWithRetry sends one message, retrying rate limits after the delay
+// Telegram asks for and server or network errors with exponential
+// backoff. Other errors, such as a bad chat ID or broken markup, are
+// returned at once.
+func sendWithRetry(token string, params map[string]interface{}, retries int) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := telegram.Call(ctx, token, "sendMessage", params, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		var apiErr *telegram.APIError
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			return err
+		}
+		if attempt >= retries {
+			return err
+		}
+
+		wait := backoff
+		if apiErr != nil && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		fmt.Fprintf(os.Stderr, "%v, retrying in %s\n", err, wait)
+		sleep(wait)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// The repository root holds several programs, so its tests are run one
+// program at a time:
+//
+//	go test send.go send_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reply is one scripted Bot API reply: an HTTP status, with retry_after
+// seconds on 429.
+type reply struct {
+	status     int
+	retryAfter int
+}
+
+// botAPI serves sendMessage with the scripted replies in turn, then with
+// success, and counts the calls.
+func botAPI(t *testing.T, replies ...reply) *int {
+	t.Helper()
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottest-token/sendMessage" {
+			http.NotFound(w, r)
+			return
+		}
+		var params map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params["text"] != "GPU 3 is at 92°C" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		i := calls
+		calls++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if i >= len(replies) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": map[string]int{"message_id": 1}})
+			return
+		}
+		resp := map[string]interface{}{"ok": false, "error_code": replies[i].status, "description": http.StatusText(replies[i].status)}
+		if replies[i].retryAfter > 0 {
+			resp["parameters"] = map[string]int{"retry_after": replies[i].retryAfter}
+		}
+		w.WriteHeader(replies[i].status)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("TELEGRAM_API_URL", srv.URL)
+	return &calls
+}
+
+func TestSendWithRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies []reply
+		retries int
+		calls   int
+		waits   []time.Duration
+		err     string // empty if the message should go out
+	}{
+		{"sent", nil, 5, 1, nil, ""},
+		{"rate limited", []reply{{429, 7}}, 5, 2, []time.Duration{7 * time.Second}, ""},
+		{"rate limited again", []reply{{429, 3}, {429, 30}}, 5, 3, []time.Duration{3 * time.Second, 30 * time.Second}, ""},
+		{"server errors back off", []reply{{500, 0}, {502, 0}, {503, 0}}, 5, 4, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, ""},
+		{"rate limit after a server error", []reply{{500, 0}, {429, 5}, {500, 0}}, 5, 4, []time.Duration{time.Second, 5 * time.Second, 4 * time.Second}, ""},
+		{"bad request", []reply{{400, 0}}, 5, 1, nil, "telegram: 400 Bad Request"},
+		{"forbidden", []reply{{403, 0}}, 5, 1, nil, "telegram: 403 Forbidden"},
+		{"out of retries", []reply{{429, 1}, {429, 1}, {429, 1}}, 2, 3, []time.Duration{time.Second, time.Second},
+			"telegram: 429 Too Many Requests (retry after 1s)"},
+		{"no retries", []reply{{500, 0}}, 0, 1, nil, "telegram: 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := botAPI(t, tt.replies...)
+			var waits []time.Duration
+			sleep = func(d time.Duration) { waits = append(waits, d) }
+			defer func() { sleep = time.Sleep }()
+
+			err := sendWithRetry("test-token", map[string]interface{}{"chat_id": 42, "text": "GPU 3 is at 92°C"}, tt.retries)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("sendWithRetry: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("sendWithRetry: %v, want %s...", err, tt.err)
+			}
+			if *calls != tt.calls || !reflect.DeepEqual(waits, tt.waits) {
+				t.Errorf("%d calls, waits %v; want %d, %v", *calls, waits, tt.calls, tt.waits)
+			}
+		})
+	}
+}
+
+// TestSendWithRetryWaits runs one retry with the real clock.
+func TestSendWithRetryWaits(t *testing.T) {
+	calls := botAPI(t, reply{429, 1})
+	start := time.Now()
+	if err := sendWithRetry("test-token", map[string]interface{}{"chat_id": 42, "text": "GPU 3 is at 92°C"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); *calls != 2 || elapsed < time.Second {
+		t.Errorf("%d calls in %s, want 2 a second apart", *calls, elapsed)
+	}
+}
@@ -0,0 +1,328 @@
+// Package sim generates fake GPU fleets for working on the server,
+// dashboard and bots without GPU hardware. A Fleet produces the same
+// reports the agents send and can post them to a server through the real
+// report endpoints.
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"gpu-monitor/report"
+)
+
+type Config struct {
+	Hosts       int
+	GPUsPerHost int
+	HostPrefix  string // hosts are named HostPrefix-01, HostPrefix-02, ...
+	Model       string
+	MemoryMiB   int
+	Seed        int64
+
+	// Faults, each applied to that many randomly picked GPUs (or hosts
+	// for StaleHosts) when the fleet is created.
+	ThermalRunaways int
+	DeadFans        int
+	StaleHosts      int
+
+	// RampSteps is how many steps a job takes to reach full utilization.
+	RampSteps int
+	// Churn is the probability per step that a GPU's job ends, or that an
+	// idle GPU picks up a new one.
+	Churn float64
+	// StaleAfter is the step at which stale hosts stop reporting.
+	StaleAfter int
+}
+
+// DefaultConfig is a small healthy fleet.
+func DefaultConfig() Config {
+	return Config{
+		Hosts:       3,
+		GPUsPerHost: 4,
+		HostPrefix:  "sim-node",
+		Model:       "NVIDIA A100-SXM4-80GB",
+		MemoryMiB:   81920,
+		Seed:        1,
+		RampSteps:   10,
+		Churn:       0.05,
+		StaleAfter:  5,
+	}
+}
+
+// Fault is the scripted failure a simulated GPU or host goes through.
+type Fault string
+
+const (
+	NoFault        Fault = ""
+	ThermalRunaway Fault = "thermal-runaway"
+	DeadFan        Fault = "dead-fan"
+	Stale          Fault = "stale"
+)
+
+type Fleet struct {
+	Hosts []*Host
+	Step  int
+
+	cfg Config
+	rng *rand.Rand
+}
+
+type Host struct {
+	Name  string
+	Fault Fault
+	GPUs  []*GPU
+
+	memoryTotalMB int
+	diskTotalGB   int
+	diskUsedGB    float64
+}
+
+type GPU struct {
+	Index int
+	Fault Fault
+
+	// Job state: target is the utilization the running job settles at,
+	// rampStep how far into its ramp-up it is.
+	job      string
+	target   float64
+	rampStep int
+
+	util        float64
+	temperature float64
+	memoryUsed  float64
+}
+
+var jobNames = []string{"python", "torchrun", "python3", "deepspeed", "jupyter-kernel", "blender", "ollama"}
+
+// NewFleet builds a fleet and assigns the configured faults.
+func NewFleet(cfg Config) *Fleet {
+	f := &Fleet{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+	for h := 0; h < cfg.Hosts; h++ {
+		host := &Host{
+			Name:          fmt.Sprintf("%s-%02d", cfg.HostPrefix, h+1),
+			memoryTotalMB: 515000,
+			diskTotalGB:   1800,
+			diskUsedGB:    200 + f.rng.Float64()*800,
+		}
+		for g := 0; g < cfg.GPUsPerHost; g++ {
+			gpu := &GPU{Index: g, temperature: 32 + f.rng.Float64()*4}
+			// Start roughly half the fleet busy.
+			if f.rng.Float64() < 0.5 {
+				f.startJob(gpu)
+			}
+			host.GPUs = append(host.GPUs, gpu)
+		}
+		f.Hosts = append(f.Hosts, host)
+	}
+
+	// Stale hosts are taken from the end of the list and their GPUs are
+	// left out of the GPU faults, which would never be seen otherwise.
+	for i := 0; i < cfg.StaleHosts && i < len(f.Hosts); i++ {
+		f.Hosts[len(f.Hosts)-1-i].Fault = Stale
+	}
+
+	var gpus []*GPU
+	for _, h := range f.Hosts {
+		if h.Fault != Stale {
+			gpus = append(gpus, h.GPUs...)
+		}
+	}
+	f.rng.Shuffle(len(gpus), func(i, j int) { gpus[i], gpus[j] = gpus[j], gpus[i] })
+	for i := 0; i < cfg.ThermalRunaways && len(gpus) > 0; i++ {
+		gpus[0].Fault = ThermalRunaway
+		gpus = gpus[1:]
+	}
+	for i := 0; i < cfg.DeadFans && len(gpus) > 0; i++ {
+		gpus[0].Fault = DeadFan
+		gpus = gpus[1:]
+	}
+	return f
+}
+
+func (f *Fleet) startJob(gpu *GPU) {
+	gpu.job = jobNames[f.rng.Intn(len(jobNames))]
+	gpu.target = 60 + f.rng.Float64()*40
+	gpu.rampStep = 0
+}
+
+// Advance moves the simulation forward by one step.
+func (f *Fleet) Advance() {
+	f.Step++
+	for _, host := range f.Hosts {
+		host.diskUsedGB = math.Min(float64(host.diskTotalGB), host.diskUsedGB+f.rng.Float64()*0.5)
+		for _, gpu := range host.GPUs {
+			f.advanceGPU(gpu)
+		}
+	}
+}
+
+func (f *Fleet) advanceGPU(gpu *GPU) {
+	// Process churn.
+	if f.rng.Float64() < f.cfg.Churn {
+		if gpu.job == "" {
+			f.startJob(gpu)
+		} else {
+			gpu.job = ""
+			gpu.target = 0
+		}
+	}
+
+	// Utilization ramps linearly towards the job's target and drops
+	// straight to idle when the job ends.
+	if gpu.job != "" {
+		if gpu.rampStep < f.cfg.RampSteps {
+			gpu.rampStep++
+		}
+		progress := 1.0
+		if f.cfg.RampSteps > 0 {
+			progress = float64(gpu.rampStep) / float64(f.cfg.RampSteps)
+		}
+		gpu.util = gpu.target*progress + f.rng.NormFloat64()*3
+	} else {
+		gpu.util = f.rng.Float64() * 2
+	}
+	gpu.util = clamp(gpu.util, 0, 100)
+
+	memTarget := 0.0
+	if gpu.job != "" {
+		memTarget = float64(f.cfg.MemoryMiB) * gpu.target / 110
+	}
+	gpu.memoryUsed += (memTarget - gpu.memoryUsed) * 0.3
+
+	// Temperature relaxes towards an equilibrium set by the load. A dead
+	// fan or a runaway keeps pushing it up regardless.
+	equilibrium := 34 + gpu.util*0.45
+	switch gpu.Fault {
+	case ThermalRunaway:
+		gpu.temperature += 1.5 + f.rng.Float64()
+	case DeadFan:
+		gpu.temperature += (equilibrium + 25 - gpu.temperature) * 0.15
+	default:
+		gpu.temperature += (equilibrium - gpu.temperature) * 0.3
+	}
+	gpu.temperature = clamp(gpu.temperature+f.rng.NormFloat64()*0.5, 20, 105)
+}
+
+// Reporting reports whether host still sends reports at the current step.
+func (f *Fleet) Reporting(host *Host) bool {
+	return host.Fault != Stale || f.Step < f.cfg.StaleAfter
+}
+
+// GPUReports returns what the agent on host would send to /gpu/report.
+func (f *Fleet) GPUReports(host *Host) []report.GPUReport {
+	var reports []report.GPUReport
+	for _, gpu := range host.GPUs {
+		fan := clamp(30+(gpu.temperature-40)*1.5, 30, 100)
+		if gpu.Fault == DeadFan {
+			fan = 0
+		}
+		power := 60 + gpu.util*3.4
+		var throttle []string
+		if gpu.temperature >= 88 {
+			throttle = append(throttle, "hw_thermal_slowdown")
+		}
+		if power >= 395 {
+			throttle = append(throttle, "sw_power_cap")
+		}
+
+		r := report.GPUReport{
+			Index:                 gpu.Index,
+			Name:                  fmt.Sprintf("%d@%s@%s", gpu.Index, host.Name, f.cfg.Model),
+			Vendor:                report.VendorNVIDIA,
+			FanPercent:            int(fan),
+			TemperatureC:          int(gpu.temperature),
+			PowerWatt:             math.Round(power*100) / 100,
+			PowerLimitWatt:        400,
+			MemoryUsedMiB:         int(gpu.memoryUsed),
+			MemoryTotalMiB:        f.cfg.MemoryMiB,
+			UtilizationGpuPercent: int(gpu.util),
+			ThrottleReasons:       throttle,
+			ClockSMMHz:            210 + int(gpu.util*12),
+			ClockMemMHz:           1593,
+			PCIeGen:               4,
+			PCIeGenMax:            4,
+			PCIeWidth:             16,
+			PCIeWidthMax:          16,
+			UUID:                  fmt.Sprintf("GPU-sim-%s-%d", host.Name, gpu.Index),
+			PCIBusID:              fmt.Sprintf("%02x:00", 0x10+gpu.Index*0x10),
+		}
+		if gpu.job != "" {
+			r.ProcessCount = 1
+			r.ProcessNames = gpu.job
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// HostReport returns what the agent on host would send to /host/report.
+func (f *Fleet) HostReport(host *Host) report.HostReport {
+	var load float64
+	for _, gpu := range host.GPUs {
+		if gpu.job != "" {
+			load += 8 + gpu.util/10
+		}
+	}
+	load = clamp(load+f.rng.Float64()*2, 0, 100)
+
+	var jobs int
+	for _, gpu := range host.GPUs {
+		if gpu.job != "" {
+			jobs++
+		}
+	}
+	memUsed := 12000 + jobs*48000 + f.rng.Intn(2000)
+
+	return report.HostReport{
+		Hostname:        host.Name,
+		CPUUsagePercent: math.Round(load*100) / 100,
+		MemoryUsedMB:    memUsed,
+		MemoryTotalMB:   host.memoryTotalMB,
+		DiskUsed:        fmt.Sprintf("%dG", int(host.diskUsedGB)),
+		DiskTotal:       fmt.Sprintf("%dG", host.diskTotalGB),
+	}
+}
+
+// Post sends the current reports of every host that is still reporting to
+// the server at serverURL.
+func (f *Fleet) Post(client *http.Client, serverURL string) error {
+	for _, host := range f.Hosts {
+		if !f.Reporting(host) {
+			continue
+		}
+		if err := post(client, serverURL+"/gpu/report", f.GPUReports(host)); err != nil {
+			return err
+		}
+		if err := post(client, serverURL+"/host/report", f.HostReport(host)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func post(client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
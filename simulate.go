@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"gpu-monitor/sim"
+)
+
+func main() {
+	cfg := sim.DefaultConfig()
+
+	serverURL := flag.String("server", "http://localhost:1101", "collector server URL")
+	interval := flag.Duration("interval", 10*time.Second, "time between reports")
+	steps := flag.Int("steps", 0, "number of reports to send before exiting (0 runs forever)")
+	flag.IntVar(&cfg.Hosts, "hosts", cfg.Hosts, "number of simulated hosts")
+	flag.IntVar(&cfg.GPUsPerHost, "gpus", cfg.GPUsPerHost, "GPUs per host")
+	flag.StringVar(&cfg.HostPrefix, "prefix", cfg.HostPrefix, "hostname prefix")
+	flag.StringVar(&cfg.Model, "model", cfg.Model, "GPU model name")
+	flag.IntVar(&cfg.MemoryMiB, "memory", cfg.MemoryMiB, "GPU memory in MiB")
+	flag.Int64Var(&cfg.Seed, "seed", cfg.Seed, "random seed; the same seed replays the same traces")
+	flag.IntVar(&cfg.ThermalRunaways, "thermal-runaway", 0, "number of GPUs whose temperature climbs until they throttle")
+	flag.IntVar(&cfg.DeadFans, "dead-fan", 0, "number of GPUs with a dead fan")
+	flag.IntVar(&cfg.StaleHosts, "stale", 0, "number of hosts that stop reporting")
+	flag.IntVar(&cfg.StaleAfter, "stale-after", cfg.StaleAfter, "step at which stale hosts stop reporting")
+	flag.IntVar(&cfg.RampSteps, "ramp", cfg.RampSteps, "steps a new job takes to reach full utilization")
+	flag.Float64Var(&cfg.Churn, "churn", cfg.Churn, "probability per step that a job starts or stops on a GPU")
+	flag.Parse()
+
+	fleet := sim.NewFleet(cfg)
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	log.Printf("Simulating %d hosts with %d GPUs each against %s", cfg.Hosts, cfg.GPUsPerHost, *serverURL)
+	for {
+		if err := fleet.Post(client, *serverURL); err != nil {
+			log.Println("Report failed:", err)
+		}
+		if *steps > 0 && fleet.Step+1 >= *steps {
+			return
+		}
+		time.Sleep(*interval)
+		fleet.Advance()
+	}
+}
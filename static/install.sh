@@ -1,51 +1,47 @@
 #!/bin/bash
 
-set -e
-
-SCRIPT_PATH="/usr/local/bin/gpu_push.sh"
-SERVICE_PATH="/etc/systemd/system/gpu-push.service"
-MON_URL="http://192.168.0.1:1101/mon.sh"
-
-echo "[+] Writing $SCRIPT_PATH..."
-cat <<EOF > "$SCRIPT_PATH"
-#!/bin/bash
+# Installs the gpumon agent and enrolls the host with the server.
+#
+#   install.sh AGENT_BINARY RELEASE_PUBKEY SERVER_URL JOIN_TOKEN [KEY=VALUE...]
+#
+# Get a join token from the server admin (POST /admin/join-tokens). Extra
+# KEY=VALUE arguments become host labels.
+#
+# The binary and the release public key are copied from local paths on
+# purpose: they are the root of trust for every later self-update, so they
+# must not be fetched from the server the agent is going to trust.
 
-URL="$MON_URL"
+set -e
 
-while true; do
-    TMP="/tmp/mon.sh.\$\$"
-    curl -fsSL --max-time 5 "\$URL" -o "\$TMP" && \\
-    sudo -u nobody timeout --kill-after=200s 100s bash "\$TMP"
-    rm -f "\$TMP"
-    sleep 10
-done
-EOF
+AGENT_SRC="$1"
+PUBKEY_SRC="$2"
+SERVER_URL="$3"
+JOIN_TOKEN="$4"
+shift 4 2>/dev/null || true
 
-chmod +x "$SCRIPT_PATH"
+CONFIG_DIR="/etc/gpumon"
 
-echo "[+] Creating systemd service..."
-cat <<EOF > "$SERVICE_PATH"
-[Unit]
-Description=GPU Monitoring Push Script
-After=network.target
+if [[ -z "$AGENT_SRC" || -z "$PUBKEY_SRC" || -z "$SERVER_URL" || -z "$JOIN_TOKEN" ]]; then
+    echo "Usage: $0 AGENT_BINARY RELEASE_PUBKEY SERVER_URL JOIN_TOKEN [KEY=VALUE...]" >&2
+    exit 1
+fi
 
-[Service]
-ExecStart=$SCRIPT_PATH
-Restart=always
-RestartSec=120
-User=root
-Nice=10
+echo "[+] Removing the old gpu-push download loop..."
+systemctl disable --now gpu-push.service 2>/dev/null || true
+rm -f /etc/systemd/system/gpu-push.service /usr/local/bin/gpu_push.sh
 
-[Install]
-WantedBy=multi-user.target
-EOF
+echo "[+] Installing release key..."
+install -d -m 0755 "$CONFIG_DIR"
+install -m 0644 "$PUBKEY_SRC" "$CONFIG_DIR/release.pub"
 
-echo "[+] Enabling and starting service..."
-systemctl daemon-reexec
-systemctl daemon-reload
-systemctl disable --now gpu-push.service||true
-systemctl enable gpu-push.service
-systemctl start gpu-push.service
+LABEL_ARGS=()
+for label in "$@"; do
+    LABEL_ARGS+=(-label "$label")
+done
 
-echo "[✓] gpu_push installed and running!"
+echo "[+] Enrolling with $SERVER_URL..."
+chmod +x "$AGENT_SRC"
+"$AGENT_SRC" enroll -server "$SERVER_URL" -token "$JOIN_TOKEN" \
+    -release-key "$CONFIG_DIR/release.pub" "${LABEL_ARGS[@]}"
 
+echo "[✓] gpumon-agent $(/usr/local/bin/gpumon-agent -version) installed and running!"
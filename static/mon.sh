@@ -1,5 +1,12 @@
 #!/bin/bash
 
+# Nodes running the Go agent (agent.go) report GPU telemetry and host
+# metrics themselves; posting the nvidia-smi csv here as well would
+# overwrite the extended fields with zeros.
+if systemctl is-active --quiet gpumon-agent 2>/dev/null; then
+    exit 0
+fi
+
 # Get GPU and process info
 gpu_info=$(nvidia-smi --query-gpu=index,name,fan.speed,temperature.gpu,power.draw,memory.used,memory.total,utilization.gpu --format=csv,noheader,nounits)
 process_info=$(nvidia-smi)
@@ -57,6 +64,7 @@ while IFS=',' read -r index name fan temp power mem_used mem_total util; do
 
     gpu_json+=("{
         \"index\": $index,
+        \"vendor\": \"nvidia\",
 	\"name\": \"$index@$(cat /etc/hostname)@${name}\",
         \"fan_percent\": $fan,
         \"temperature_c\": $temp,
@@ -0,0 +1,110 @@
+package status
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Render writes the page as HTML. It refreshes itself every minute.
+func Render(w io.Writer, p Page) error {
+	return pageTemplate.Execute(w, p)
+}
+
+var pageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"headline": func(l Level) string {
+		switch l {
+		case Operational:
+			return "All systems operational"
+		case Degraded:
+			return "Some systems are degraded"
+		case Outage:
+			return "Major outage"
+		}
+		return "Status unknown"
+	},
+	// label capitalizes a Level or an incident status.
+	"label": func(s string) string {
+		if s == "" {
+			return "no data"
+		}
+		return strings.ToUpper(s[:1]) + s[1:]
+	},
+	"uptime": func(u *float64) string {
+		if u == nil {
+			return "No data yet"
+		}
+		return fmt.Sprintf("%.2f%% uptime", *u)
+	},
+	"daytitle": func(d Day) string {
+		if d.Uptime == nil {
+			return d.Date + ": no data"
+		}
+		return fmt.Sprintf("%s: %.2f%% uptime", d.Date, *d.Uptime)
+	},
+	"when": func(ts string) string {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return ts
+		}
+		return t.UTC().Format("Jan 2, 15:04 UTC")
+	},
+	"days": func() int { return HistoryDays },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; background: #f6f7f9; color: #1f2933; margin: 0; }
+main { max-width: 860px; margin: 0 auto; padding: 24px 16px 48px; }
+h1 { font-size: 1.6em; margin: 8px 0 20px; }
+h2 { font-size: 1.15em; margin: 32px 0 10px; }
+.banner { border-radius: 6px; color: #fff; font-size: 1.2em; font-weight: 600; padding: 16px 20px; }
+.card { background: #fff; border: 1px solid #e1e4e8; border-radius: 6px; margin-bottom: 12px; padding: 14px 18px; }
+.row { align-items: baseline; display: flex; justify-content: space-between; gap: 12px; }
+.name { font-weight: 600; }
+.desc, .detail, .meta { color: #616e7c; font-size: 0.9em; }
+.bars { display: flex; gap: 2px; height: 32px; margin: 10px 0 4px; }
+.bars span { border-radius: 2px; flex: 1; }
+.operational { background: #2fb35c; } .t-operational { color: #2fb35c; }
+.degraded { background: #f0b429; } .t-degraded { color: #c99a0b; }
+.outage { background: #e12d39; } .t-outage { color: #e12d39; }
+.unknown, .none { background: #cbd2d9; } .t-unknown { color: #7b8794; }
+.axis { color: #9aa5b1; display: flex; font-size: 0.8em; justify-content: space-between; }
+.update { border-left: 3px solid #e1e4e8; margin: 8px 0 0; padding-left: 10px; }
+footer { color: #9aa5b1; font-size: 0.85em; margin-top: 32px; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+<div class="banner {{.Status}}">{{headline .Status}}</div>
+
+{{if .Incidents}}<h2>Incidents</h2>
+{{range .Incidents}}<div class="card">
+<div class="row"><span class="name">{{.Title}}</span><span class="meta">{{label (print .Status)}}</span></div>
+{{if .Components}}<div class="meta">Affects {{range $i, $c := .Components}}{{if $i}}, {{end}}{{$c}}{{end}}</div>{{end}}
+{{range .Updates}}<div class="update"><b>{{label (print .Status)}}</b> — {{.Message}}<div class="meta">{{when .CreatedAt}}</div></div>
+{{end}}</div>
+{{end}}{{end}}
+
+{{range .Groups}}<h2>{{.Name}}</h2>
+{{range .Components}}<div class="card">
+<div class="row"><span class="name">{{.Name}}</span><span class="t-{{.Status}}">{{label (print .Status)}}</span></div>
+{{if .Description}}<div class="desc">{{.Description}}</div>{{end}}
+<div class="detail">{{.Detail}}</div>
+<div class="bars">{{range .Days}}<span class="{{if .Status}}{{.Status}}{{else}}none{{end}}" title="{{daytitle .}}"></span>{{end}}</div>
+<div class="axis"><span>{{days}} days ago</span><span>{{uptime .Uptime}}</span><span>Today</span></div>
+</div>
+{{end}}{{end}}
+
+<footer>Updated {{when .UpdatedAt}}</footer>
+</main>
+</body>
+</html>
+`))
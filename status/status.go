@@ -0,0 +1,440 @@
+// Package status builds the public status page: the state of configured
+// components, made of GPU hosts picked by a label selector or of the
+// server's service checks, with their daily uptime over the last
+// 90 days and the incidents admins post about them.
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/checks"
+	"gpu-monitor/labels"
+	"gpu-monitor/report"
+)
+
+// Level is the state of a component, a group or the whole page.
+type Level string
+
+const (
+	Operational Level = "operational"
+	Degraded    Level = "degraded"
+	Outage      Level = "outage"
+	// Unknown is for components with nothing to go on, such as a
+	// selector that matches no hosts or no service checks.
+	Unknown Level = "unknown"
+)
+
+func (l Level) rank() int {
+	switch l {
+	case Operational:
+		return 1
+	case Degraded:
+		return 2
+	case Outage:
+		return 3
+	}
+	return 0
+}
+
+// Worst returns the most severe of levels, Unknown only if all are.
+func Worst(levels ...Level) Level {
+	worst := Unknown
+	for _, l := range levels {
+		if l.rank() > worst.rank() {
+			worst = l
+		}
+	}
+	return worst
+}
+
+// Incident statuses.
+const (
+	Investigating = "investigating"
+	Identified    = "identified"
+	Monitoring    = "monitoring"
+	Resolved      = "resolved"
+)
+
+var IncidentStatuses = []string{Investigating, Identified, Monitoring, Resolved}
+
+func ValidIncidentStatus(s string) bool {
+	for _, st := range IncidentStatuses {
+		if s == st {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryDays is how many days of uptime the page shows.
+const HistoryDays = 90
+
+// Config is the status page config file, e.g.
+//
+//	{
+//	  "title": "Example AI status",
+//	  "groups": [
+//	    {"name": "Inference", "components": [
+//	      {"name": "Chat API", "monitors": ["https://api.example.org/health"]},
+//	      {"name": "Embeddings API", "monitors": ["#4", "#5"]}
+//	    ]},
+//	    {"name": "GPU nodes", "components": [
+//	      {"name": "Training cluster", "hosts": "cluster=training"},
+//	      {"name": "Inference nodes", "hosts": "role=inference", "outage_percent": 50}
+//	    ]}
+//	  ]
+//	}
+//
+// A component is either the service checks listed as "monitors", by
+// target or #ID, or "*" for all of them; or otherwise the hosts matching its label selector,
+// which may be empty to take every host.
+type Config struct {
+	Title  string        `json:"title"`
+	Groups []GroupConfig `json:"groups"`
+}
+
+type GroupConfig struct {
+	Name       string            `json:"name"`
+	Components []ComponentConfig `json:"components"`
+}
+
+type ComponentConfig struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Monitors    []string `json:"monitors,omitempty"`
+	Hosts       string   `json:"hosts,omitempty"`
+	// OutagePercent is the share of hosts that must stop reporting for a
+	// host component to count as down rather than degraded, 100 if unset.
+	OutagePercent int `json:"outage_percent,omitempty"`
+
+	selector labels.Selector
+}
+
+// DefaultConfig is used without a config file: every service check and
+// every host.
+func DefaultConfig() Config {
+	cfg := Config{
+		Title: "GPU cluster status",
+		Groups: []GroupConfig{
+			{
+				Name:       "Services",
+				Components: []ComponentConfig{{Name: "Monitored services", Monitors: []string{"*"}}},
+			},
+			{
+				Name:       "Infrastructure",
+				Components: []ComponentConfig{{Name: "GPU nodes"}},
+			},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		panic("status: invalid default config: " + err.Error())
+	}
+	return cfg
+}
+
+// Load reads a config file. ${VAR} references are replaced from the
+// environment.
+func Load(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate parses the selectors and checks that component names are
+// unique, since incidents and the uptime history refer to them by name.
+func (c *Config) Validate() error {
+	if c.Title == "" {
+		c.Title = "Status"
+	}
+	seen := map[string]bool{}
+	for g := range c.Groups {
+		group := &c.Groups[g]
+		if group.Name == "" {
+			return fmt.Errorf("group %d has no name", g+1)
+		}
+		for i := range group.Components {
+			comp := &group.Components[i]
+			if comp.Name == "" {
+				return fmt.Errorf("component %d of group %q has no name", i+1, group.Name)
+			}
+			if seen[comp.Name] {
+				return fmt.Errorf("component %q appears twice", comp.Name)
+			}
+			seen[comp.Name] = true
+			if len(comp.Monitors) > 0 && comp.Hosts != "" {
+				return fmt.Errorf("component %q has both monitors and hosts", comp.Name)
+			}
+			if comp.OutagePercent < 0 || comp.OutagePercent > 100 {
+				return fmt.Errorf("component %q: outage_percent must be between 1 and 100", comp.Name)
+			}
+			if comp.OutagePercent == 0 {
+				comp.OutagePercent = 100
+			}
+			sel, err := labels.Parse(comp.Hosts)
+			if err != nil {
+				return fmt.Errorf("component %q: %v", comp.Name, err)
+			}
+			comp.selector = sel
+		}
+	}
+	return nil
+}
+
+// Components lists the component names in page order.
+func (c Config) Components() []string {
+	var names []string
+	for _, g := range c.Groups {
+		for _, comp := range g.Components {
+			names = append(names, comp.Name)
+		}
+	}
+	return names
+}
+
+// Host is what the page needs to know about a reporting host.
+type Host struct {
+	Name      string
+	Labels    map[string]string
+	UpdatedAt time.Time
+}
+
+// Inputs are the states the components are evaluated from.
+type Inputs struct {
+	Hosts []Host
+	// Alerts are the active ones.
+	Alerts []alerts.Event
+	// Monitors are the service checks, left nil when MonitorErr says
+	// they could not be loaded.
+	Monitors   []checks.Service
+	MonitorErr error
+	// Incidents are the open ones.
+	Incidents []report.Incident
+}
+
+// Page is the status page, as served by /status.json.
+type Page struct {
+	Title     string            `json:"title"`
+	Status    Level             `json:"status"`
+	UpdatedAt string            `json:"updated_at"`
+	Groups    []Group           `json:"groups"`
+	Incidents []report.Incident `json:"incidents"`
+}
+
+type Group struct {
+	Name       string      `json:"name"`
+	Status     Level       `json:"status"`
+	Components []Component `json:"components"`
+}
+
+type Component struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Status      Level  `json:"status"`
+	Detail      string `json:"detail"`
+	// Uptime is over the days with samples in Days, nil if none have.
+	Uptime *float64 `json:"uptime_percent"`
+	Days   []Day    `json:"days"`
+}
+
+// Evaluate works out the state of every component. History is left to
+// AddHistory.
+func Evaluate(cfg Config, in Inputs, now time.Time) Page {
+	page := Page{Title: cfg.Title, UpdatedAt: now.UTC().Format(time.RFC3339)}
+	var levels []Level
+	for _, gc := range cfg.Groups {
+		g := Group{Name: gc.Name}
+		var groupLevels []Level
+		for _, cc := range gc.Components {
+			var c Component
+			if len(cc.Monitors) > 0 {
+				c = evaluateMonitors(cc, in)
+			} else {
+				c = evaluateHosts(cc, in, now)
+			}
+			c.Name, c.Description = cc.Name, cc.Description
+			for _, inc := range in.Incidents {
+				if affects(inc, cc.Name) {
+					c.Status = Worst(c.Status, Degraded)
+					c.Detail = strings.TrimPrefix(c.Detail+"; incident: "+inc.Title, "; ")
+				}
+			}
+			g.Components = append(g.Components, c)
+			groupLevels = append(groupLevels, c.Status)
+		}
+		g.Status = Worst(groupLevels...)
+		page.Groups = append(page.Groups, g)
+		levels = append(levels, groupLevels...)
+	}
+	page.Status = Worst(levels...)
+	return page
+}
+
+func affects(inc report.Incident, component string) bool {
+	for _, c := range inc.Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateHosts counts the matching hosts that stopped reporting and the
+// critical alerts on the rest. Host names stay off the page.
+func evaluateHosts(cc ComponentConfig, in Inputs, now time.Time) Component {
+	matched := map[string]bool{}
+	stale := 0
+	for _, h := range in.Hosts {
+		if !cc.selector.Matches(h.Labels) {
+			continue
+		}
+		matched[h.Name] = true
+		if h.UpdatedAt.Before(now.Add(-alerts.StaleAfter)) {
+			stale++
+		}
+	}
+	if len(matched) == 0 {
+		return Component{Status: Unknown, Detail: "No hosts"}
+	}
+	critical := 0
+	for _, ev := range in.Alerts {
+		// Stale hosts are counted from their reports above.
+		if matched[ev.Host] && ev.Severity == alerts.Critical && ev.Rule != "host_stale" {
+			critical++
+		}
+	}
+
+	total := len(matched)
+	c := Component{Status: Operational, Detail: fmt.Sprintf("%d %s reporting", total, plural(total, "host", "hosts"))}
+	if stale > 0 {
+		c.Status = Degraded
+		if stale*100 >= total*cc.OutagePercent {
+			c.Status = Outage
+		}
+		c.Detail = fmt.Sprintf("%d of %d hosts not reporting", stale, total)
+	}
+	if critical > 0 {
+		c.Status = Worst(c.Status, Degraded)
+		c.Detail += fmt.Sprintf(", %d critical %s", critical, plural(critical, "alert", "alerts"))
+	}
+	return c
+}
+
+func evaluateMonitors(cc ComponentConfig, in Inputs) Component {
+	if in.MonitorErr != nil {
+		return Component{Status: Unknown, Detail: "Monitor state unavailable"}
+	}
+	var matched []checks.Service
+	for _, m := range in.Monitors {
+		for _, ref := range cc.Monitors {
+			if ref == "*" || ref == m.Target || ref == fmt.Sprintf("#%d", m.ID) {
+				matched = append(matched, m)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return Component{Status: Unknown, Detail: "No monitors"}
+	}
+	down, flapping := 0, 0
+	for _, m := range matched {
+		switch {
+		case !m.Up:
+			down++
+		case m.Flapping:
+			flapping++
+		}
+	}
+
+	total := len(matched)
+	switch {
+	case down == total:
+		return Component{Status: Outage, Detail: fmt.Sprintf("%d of %d %s failing", down, total, plural(total, "check", "checks"))}
+	case down > 0:
+		return Component{Status: Degraded, Detail: fmt.Sprintf("%d of %d checks failing", down, total)}
+	case flapping > 0:
+		return Component{Status: Degraded, Detail: "Intermittent failures"}
+	}
+	return Component{Status: Operational, Detail: fmt.Sprintf("%d %s passing", total, plural(total, "check", "checks"))}
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// DayCount is how one component fared over a day, counted in the
+// samples taken of its state.
+type DayCount struct {
+	Samples  int
+	Degraded int
+	Outage   int
+}
+
+// Day is one bar of the uptime history.
+type Day struct {
+	Date string `json:"date"`
+	// Status is empty for days without samples.
+	Status Level    `json:"status,omitempty"`
+	Uptime *float64 `json:"uptime_percent"`
+}
+
+// AddHistory fills in the daily bars and the overall uptime of every
+// component from counts by component name and date (2006-01-02, UTC).
+// Degraded time counts as up.
+func (p *Page) AddHistory(counts map[string]map[string]DayCount, now time.Time) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	for g := range p.Groups {
+		for i := range p.Groups[g].Components {
+			c := &p.Groups[g].Components[i]
+			var total DayCount
+			c.Days = make([]Day, 0, HistoryDays)
+			for d := HistoryDays - 1; d >= 0; d-- {
+				date := today.AddDate(0, 0, -d).Format("2006-01-02")
+				day := Day{Date: date}
+				if n := counts[c.Name][date]; n.Samples > 0 {
+					day.Status, day.Uptime = dayStatus(n), uptime(n)
+					total.Samples += n.Samples
+					total.Outage += n.Outage
+				}
+				c.Days = append(c.Days, day)
+			}
+			if total.Samples > 0 {
+				c.Uptime = uptime(total)
+			}
+		}
+	}
+}
+
+// dayStatus colors a day's bar: red once the component was down for
+// more than 1% of it (about a quarter of an hour), yellow for shorter or
+// partial outages.
+func dayStatus(n DayCount) Level {
+	switch {
+	case n.Outage*100 > n.Samples:
+		return Outage
+	case n.Outage > 0 || n.Degraded > 0:
+		return Degraded
+	}
+	return Operational
+}
+
+func uptime(n DayCount) *float64 {
+	u := 100 * float64(n.Samples-n.Outage) / float64(n.Samples)
+	return &u
+}
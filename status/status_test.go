@@ -0,0 +1,334 @@
+package status
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/checks"
+	"gpu-monitor/report"
+)
+
+var now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+// config validates a config of one group with the given components.
+func config(t *testing.T, components ...ComponentConfig) Config {
+	t.Helper()
+	cfg := Config{Groups: []GroupConfig{{Name: "Cluster", Components: components}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []GroupConfig
+		err    string // empty if the config is valid
+	}{
+		{"valid", []GroupConfig{
+			{Name: "Services", Components: []ComponentConfig{{Name: "API", Monitors: []string{"*"}}}},
+			{Name: "Nodes", Components: []ComponentConfig{{Name: "Training", Hosts: "cluster=training"}}},
+		}, ""},
+		{"no groups", nil, ""},
+		{"group without a name", []GroupConfig{{Components: []ComponentConfig{{Name: "API"}}}}, "group 1 has no name"},
+		{"component without a name", []GroupConfig{{Name: "Services", Components: []ComponentConfig{{Name: "API"}, {}}}},
+			`component 2 of group "Services" has no name`},
+		{"duplicate in a group", []GroupConfig{{Name: "Nodes", Components: []ComponentConfig{{Name: "GPU nodes"}, {Name: "GPU nodes", Hosts: "rack=a"}}}},
+			`component "GPU nodes" appears twice`},
+		{"duplicate across groups", []GroupConfig{
+			{Name: "Services", Components: []ComponentConfig{{Name: "API", Monitors: []string{"#1"}}}},
+			{Name: "Nodes", Components: []ComponentConfig{{Name: "API", Hosts: "role=api"}}},
+		}, `component "API" appears twice`},
+		{"monitors and hosts", []GroupConfig{{Name: "Services", Components: []ComponentConfig{{Name: "API", Monitors: []string{"*"}, Hosts: "role=api"}}}},
+			`component "API" has both monitors and hosts`},
+		{"outage percent above 100", []GroupConfig{{Name: "Nodes", Components: []ComponentConfig{{Name: "GPU nodes", OutagePercent: 101}}}},
+			`component "GPU nodes": outage_percent must be between 1 and 100`},
+		{"negative outage percent", []GroupConfig{{Name: "Nodes", Components: []ComponentConfig{{Name: "GPU nodes", OutagePercent: -1}}}},
+			`component "GPU nodes": outage_percent must be between 1 and 100`},
+		{"bad selector", []GroupConfig{{Name: "Nodes", Components: []ComponentConfig{{Name: "GPU nodes", Hosts: "bad key=x"}}}},
+			`component "GPU nodes": `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Groups: tt.groups}
+			err := cfg.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Validate: %v", err)
+			case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+				t.Errorf("Validate: %v, want %s...", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	cfg := config(t, ComponentConfig{Name: "GPU nodes"}, ComponentConfig{Name: "Inference", OutagePercent: 50})
+	if cfg.Title != "Status" {
+		t.Errorf("title %q", cfg.Title)
+	}
+	if got := cfg.Groups[0].Components[0].OutagePercent; got != 100 {
+		t.Errorf("unset outage_percent is %d, want 100", got)
+	}
+	if got := cfg.Groups[0].Components[1].OutagePercent; got != 50 {
+		t.Errorf("outage_percent 50 became %d", got)
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if got, want := cfg.Components(), []string{"Monitored services", "GPU nodes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("components %q, want %q", got, want)
+	}
+	if cfg.Groups[0].Name != "Services" || cfg.Groups[1].Name != "Infrastructure" {
+		t.Errorf("groups %+v", cfg.Groups)
+	}
+}
+
+func hosts(reporting, stale int, labels map[string]string) []Host {
+	var hs []Host
+	for i := 0; i < reporting+stale; i++ {
+		updated := now.Add(-time.Minute)
+		if i >= reporting {
+			updated = now.Add(-alerts.StaleAfter - time.Minute)
+		}
+		hs = append(hs, Host{Name: string(rune('a'+i)) + "-node", Labels: labels, UpdatedAt: updated})
+	}
+	return hs
+}
+
+func critical(host, rule string) alerts.Event {
+	return alerts.Event{Alert: alerts.Alert{Rule: rule, Severity: alerts.Critical, Host: host}}
+}
+
+func TestEvaluateHosts(t *testing.T) {
+	training := map[string]string{"cluster": "training"}
+	tests := []struct {
+		name    string
+		percent int
+		hosts   []Host
+		alerts  []alerts.Event
+		status  Level
+		detail  string
+	}{
+		{"all reporting", 0, hosts(4, 0, training), nil, Operational, "4 hosts reporting"},
+		{"one host", 0, hosts(1, 0, training), nil, Operational, "1 host reporting"},
+		{"no hosts", 0, hosts(3, 0, map[string]string{"cluster": "inference"}), nil, Unknown, "No hosts"},
+		{"some stale", 0, hosts(1, 3, training), nil, Degraded, "3 of 4 hosts not reporting"},
+		{"all stale", 0, hosts(0, 4, training), nil, Outage, "4 of 4 hosts not reporting"},
+		{"below outage_percent", 50, hosts(3, 1, training), nil, Degraded, "1 of 4 hosts not reporting"},
+		{"at outage_percent", 50, hosts(2, 2, training), nil, Outage, "2 of 4 hosts not reporting"},
+		{"above outage_percent", 50, hosts(1, 3, training), nil, Outage, "3 of 4 hosts not reporting"},
+		{"critical alert", 0, hosts(2, 0, training), []alerts.Event{critical("a-node", "gpu_xid")},
+			Degraded, "2 hosts reporting, 1 critical alert"},
+		{"critical alerts on a stale host", 0, hosts(1, 1, training),
+			[]alerts.Event{critical("a-node", "gpu_xid"), critical("b-node", "gpu_temperature"), critical("b-node", "host_stale")},
+			Degraded, "1 of 2 hosts not reporting, 2 critical alerts"},
+		{"critical alert during an outage", 0, hosts(0, 2, training), []alerts.Event{critical("a-node", "gpu_xid")},
+			Outage, "2 of 2 hosts not reporting, 1 critical alert"},
+		{"warnings and other hosts", 0, hosts(2, 0, training), []alerts.Event{
+			{Alert: alerts.Alert{Rule: "gpu_idle", Severity: alerts.Warning, Host: "a-node"}},
+			critical("z-node", "gpu_xid"),
+		}, Operational, "2 hosts reporting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config(t, ComponentConfig{Name: "Training", Hosts: "cluster=training", OutagePercent: tt.percent})
+			c := evaluateHosts(cfg.Groups[0].Components[0], Inputs{Hosts: tt.hosts, Alerts: tt.alerts}, now)
+			if c.Status != tt.status || c.Detail != tt.detail {
+				t.Errorf("got %s %q, want %s %q", c.Status, c.Detail, tt.status, tt.detail)
+			}
+		})
+	}
+}
+
+func TestEvaluateMonitors(t *testing.T) {
+	service := func(id int, target string, up, flapping bool) checks.Service {
+		return checks.Service{Monitor: checks.Monitor{ID: id, Target: target}, State: checks.State{Up: up, Flapping: flapping}}
+	}
+	api := service(1, "https://api.example.org/health", true, false)
+	embed := service(2, "https://embed.example.org/health", true, false)
+	downAPI := service(1, "https://api.example.org/health", false, false)
+	downEmbed := service(2, "https://embed.example.org/health", false, false)
+	flapping := service(2, "https://embed.example.org/health", true, true)
+
+	tests := []struct {
+		name     string
+		refs     []string
+		monitors []checks.Service
+		err      error
+		status   Level
+		detail   string
+	}{
+		{"all passing", []string{"*"}, []checks.Service{api, embed}, nil, Operational, "2 checks passing"},
+		{"by target", []string{"https://api.example.org/health"}, []checks.Service{api, downEmbed}, nil, Operational, "1 check passing"},
+		{"by ID", []string{"#2"}, []checks.Service{api, downEmbed}, nil, Outage, "1 of 1 check failing"},
+		{"some failing", []string{"*"}, []checks.Service{downAPI, embed}, nil, Degraded, "1 of 2 checks failing"},
+		{"all failing", []string{"#1", "#2"}, []checks.Service{downAPI, downEmbed}, nil, Outage, "2 of 2 checks failing"},
+		{"flapping", []string{"*"}, []checks.Service{api, flapping}, nil, Degraded, "Intermittent failures"},
+		{"failing and flapping", []string{"*"}, []checks.Service{downAPI, flapping}, nil, Degraded, "1 of 2 checks failing"},
+		{"no match", []string{"#3"}, []checks.Service{api, embed}, nil, Unknown, "No monitors"},
+		{"unavailable", []string{"*"}, nil, errors.New("database is locked"), Unknown, "Monitor state unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config(t, ComponentConfig{Name: "API", Monitors: tt.refs})
+			c := evaluateMonitors(cfg.Groups[0].Components[0], Inputs{Monitors: tt.monitors, MonitorErr: tt.err})
+			if c.Status != tt.status || c.Detail != tt.detail {
+				t.Errorf("got %s %q, want %s %q", c.Status, c.Detail, tt.status, tt.detail)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	cfg := Config{Title: "Example status", Groups: []GroupConfig{
+		{Name: "Services", Components: []ComponentConfig{
+			{Name: "Chat API", Description: "The public API", Monitors: []string{"#1"}},
+			{Name: "Embeddings API", Monitors: []string{"#2"}},
+		}},
+		{Name: "GPU nodes", Components: []ComponentConfig{
+			{Name: "Training", Hosts: "cluster=training"},
+			{Name: "Inference", Hosts: "cluster=inference"},
+		}},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	in := Inputs{
+		Hosts: hosts(2, 0, map[string]string{"cluster": "training"}),
+		Monitors: []checks.Service{
+			{Monitor: checks.Monitor{ID: 1}, State: checks.State{Up: true}},
+			{Monitor: checks.Monitor{ID: 2}, State: checks.State{Up: false}},
+		},
+		Incidents: []report.Incident{
+			{Title: "Slow responses", Components: []string{"Chat API"}},
+			{Title: "Embeddings down", Components: []string{"Embeddings API"}},
+			{Title: "Rack maintenance", Components: []string{"Training", "Unknown component"}},
+		},
+	}
+
+	page := Evaluate(cfg, in, now)
+	if page.Title != "Example status" || page.UpdatedAt != "2026-10-19T12:00:00Z" {
+		t.Errorf("page %q updated %s", page.Title, page.UpdatedAt)
+	}
+	var got []string
+	for _, g := range page.Groups {
+		got = append(got, g.Name+": "+string(g.Status))
+		for _, c := range g.Components {
+			got = append(got, "  "+c.Name+": "+string(c.Status)+" ("+c.Detail+")")
+		}
+	}
+	want := []string{
+		"Services: outage",
+		// An incident degrades an operational component ...
+		"  Chat API: degraded (1 check passing; incident: Slow responses)",
+		// ... but does not make an outage look better.
+		"  Embeddings API: outage (1 of 1 check failing; incident: Embeddings down)",
+		"GPU nodes: degraded",
+		"  Training: degraded (2 hosts reporting; incident: Rack maintenance)",
+		"  Inference: unknown (No hosts)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("page\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if page.Status != Outage {
+		t.Errorf("page status %s, want the worst component's", page.Status)
+	}
+	if d := page.Groups[0].Components[0].Description; d != "The public API" {
+		t.Errorf("description %q", d)
+	}
+
+	// An incident on a component with nothing to go on still says so.
+	in.Incidents = []report.Incident{{Title: "Inference nodes moving", Components: []string{"Inference"}}}
+	c := Evaluate(cfg, in, now).Groups[1].Components[1]
+	if c.Status != Degraded || c.Detail != "No hosts; incident: Inference nodes moving" {
+		t.Errorf("Inference is %s %q", c.Status, c.Detail)
+	}
+}
+
+func TestWorst(t *testing.T) {
+	tests := []struct {
+		levels []Level
+		want   Level
+	}{
+		{nil, Unknown},
+		{[]Level{Unknown, Unknown}, Unknown},
+		{[]Level{Unknown, Operational}, Operational},
+		{[]Level{Operational, Degraded, Unknown}, Degraded},
+		{[]Level{Outage, Degraded}, Outage},
+	}
+	for _, tt := range tests {
+		if got := Worst(tt.levels...); got != tt.want {
+			t.Errorf("Worst(%v) = %s, want %s", tt.levels, got, tt.want)
+		}
+	}
+}
+
+func TestAddHistory(t *testing.T) {
+	page := Page{Groups: []Group{{Components: []Component{{Name: "Chat API"}, {Name: "GPU nodes"}}}}}
+	day := func(daysAgo int) string { return now.AddDate(0, 0, -daysAgo).Format("2006-01-02") }
+	page.AddHistory(map[string]map[string]DayCount{
+		"Chat API": {
+			day(HistoryDays):     {Samples: 1440, Outage: 1440}, // before the window
+			day(HistoryDays - 1): {Samples: 1440, Outage: 720},
+			day(10):              {Samples: 1440, Degraded: 100},
+			day(0):               {Samples: 720},
+		},
+	}, now)
+
+	c := page.Groups[0].Components[0]
+	if len(c.Days) != HistoryDays {
+		t.Fatalf("%d days, want %d", len(c.Days), HistoryDays)
+	}
+	oldest, today := c.Days[0], c.Days[HistoryDays-1]
+	if oldest.Date != day(HistoryDays-1) || oldest.Status != Outage || oldest.Uptime == nil || *oldest.Uptime != 50 {
+		t.Errorf("oldest day %+v", oldest)
+	}
+	if today.Date != day(0) || today.Status != Operational || today.Uptime == nil || *today.Uptime != 100 {
+		t.Errorf("today %+v", today)
+	}
+	if d := c.Days[HistoryDays-1-10]; d.Status != Degraded || *d.Uptime != 100 {
+		t.Errorf("degraded day %+v", d)
+	}
+	if d := c.Days[1]; d.Date != day(HistoryDays-2) || d.Status != "" || d.Uptime != nil {
+		t.Errorf("day without samples %+v", d)
+	}
+	// 720 minutes down out of 3600 sampled; the day before the window
+	// does not count.
+	if c.Uptime == nil || *c.Uptime != 80 {
+		t.Errorf("uptime %v, want 80", c.Uptime)
+	}
+
+	other := page.Groups[0].Components[1]
+	if len(other.Days) != HistoryDays || other.Uptime != nil {
+		t.Errorf("a component without samples has %d days and uptime %v", len(other.Days), other.Uptime)
+	}
+}
+
+func TestDayStatus(t *testing.T) {
+	tests := []struct {
+		n    DayCount
+		want Level
+	}{
+		{DayCount{Samples: 1440}, Operational},
+		{DayCount{Samples: 1440, Degraded: 1440}, Degraded},
+		{DayCount{Samples: 1440, Outage: 1}, Degraded},
+		// 1% of a day of minutes is 14.4 samples.
+		{DayCount{Samples: 1440, Outage: 14}, Degraded},
+		{DayCount{Samples: 1440, Outage: 15}, Outage},
+		{DayCount{Samples: 100, Outage: 1}, Degraded},
+		{DayCount{Samples: 100, Outage: 2}, Outage},
+		{DayCount{Samples: 1, Outage: 1}, Outage},
+	}
+	for _, tt := range tests {
+		if got := dayStatus(tt.n); got != tt.want {
+			t.Errorf("dayStatus(%+v) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
@@ -2,30 +2,62 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"net"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
-	"strconv"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	_ "github.com/mattn/go-sqlite3"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/api"
+	"gpu-monitor/botauth"
+	"gpu-monitor/checks"
+	"gpu-monitor/report"
+	"gpu-monitor/telegram"
 )
 
+// The checks themselves run in the collector server. The bot only keeps
+// its access grants and when it last sent the weekly summaries in
+// ./monitors.db.
 var db *sql.DB
 
-// A map for tracking last command times for rate limiting
-var userCommandTimes = make(map[int64]time.Time)
+// client talks to the server's check endpoints, which need the admin
+// token in GPUMON_TOKEN.
+var client = api.New(envOr("GPUMON_SERVER", "http://localhost:1101"), os.Getenv("GPUMON_TOKEN"))
+
+// historyRetention is how long the server keeps check results and
+// outages, and so the longest period /uptime reports on.
+const historyRetention = 30 * 24 * time.Hour
+
+// defaultPeriod is what /uptime and the weekly summary report on.
+const defaultPeriod = 7 * 24 * time.Hour
 
-type Monitor struct {
-	ID     int
-	UserID int64
-	Target string
-	Up     bool
+// notifyPollInterval is how often the server is asked for checks that
+// went up or down.
+const notifyPollInterval = 5 * time.Second
+
+var auth *botauth.Store
+
+// Anyone with access can look at monitors and watch them, changing them
+// takes an operator.
+var commandRoles = map[string]botauth.Role{
+	"add":     botauth.Operator,
+	"set":     botauth.Operator,
+	"delete":  botauth.Operator,
+	"share":   botauth.Operator,
+	"unshare": botauth.Operator,
 }
 
+// A map for tracking last command times for rate limiting
+var userCommandTimes = make(map[int64]time.Time)
+
 func main() {
 	token := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if token == "" {
@@ -41,7 +73,19 @@ func main() {
 
 	createTable()
 
-	bot, err := tgbotapi.NewBotAPI(token)
+	admins, err := botauth.ParseIDs(os.Getenv("TELEGRAM_ADMINS"))
+	if err != nil {
+		log.Fatal("TELEGRAM_ADMINS: ", err)
+	}
+	auth, err = botauth.Open(db, admins)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auth.Empty() {
+		log.Println("Nobody can use the bot: set TELEGRAM_ADMINS to your Telegram user ID")
+	}
+
+	bot, err := telegram.NewBot(token)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -51,7 +95,8 @@ func main() {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	go monitorServices(bot)
+	go deliverNotifications(bot)
+	go sendWeeklySummaries(bot)
 
 	updates, err := bot.GetUpdatesChan(u)
 	if err != nil {
@@ -59,250 +104,887 @@ func main() {
 	}
 
 	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+		handleUpdate(bot, update)
+	}
+}
 
-		userID := update.Message.Chat.ID
-		text := update.Message.Text
+// handleUpdate runs one command.
+func handleUpdate(bot telegram.Messenger, update tgbotapi.Update) {
+	if update.Message == nil {
+		return
+	}
 
-		// Rate limiting (1 command per minute per user)
-		if time.Since(userCommandTimes[userID]) < time.Second {
-			send(bot, userID, "⚠️ Please wait before issuing another command.")
-			continue
-		}
-		userCommandTimes[userID] = time.Now()
+	userID := update.Message.Chat.ID
+	text := update.Message.Text
 
-		// Only allow authorized users (change user IDs to your own)
-		allowedUserIDs := map[int64]bool{
-			123456789: true, // Replace with your allowed user IDs
-		}
+	// Rate limiting (1 command per minute per user)
+	if time.Since(userCommandTimes[userID]) < time.Second {
+		send(bot, userID, "⚠️ Please wait before issuing another command.")
+		return
+	}
+	userCommandTimes[userID] = time.Now()
 
-		if allowedUserIDs[userID] {
-			send(bot, userID, "❌ You are not authorized to use this bot.")
-			continue
-		}
+	caller := botauth.Caller{UserID: userID, ChatID: userID, ChatTitle: update.Message.Chat.Title}
+	if from := update.Message.From; from != nil {
+		caller.UserID = int64(from.ID)
+		caller.UserName = from.UserName
+	}
+	command := update.Message.Command()
+	if reply, ok := auth.Command(caller, command, update.Message.CommandArguments()); ok {
+		send(bot, userID, reply)
+		return
+	}
+	need, ok := commandRoles[command]
+	if !ok {
+		need = botauth.Viewer
+	}
+	if err := auth.Check(caller, need); err != nil {
+		send(bot, userID, err.Error())
+		return
+	}
 
-		// Start command
-		if text == "/start" {
-			msg := `🤖 *Welcome to MonitorBot!*
-This bot allows you to monitor the status of IP:Port addresses. You can add, delete, and list your monitors.
+	// Start command
+	if text == "/start" {
+		msg := `🤖 *Welcome to MonitorBot!*
+This bot allows you to monitor TCP ports, websites, TLS certificates and DNS names. You can add, delete, and list your monitors.
 
 Commands:
-- /add ip:port – ➕ Add a monitor
-- /delete ip:port – 🗑️ Delete monitor
+- /add host:port – ➕ Add a TCP monitor
+- /add http https://example.org – 🌐 Add a website
+- /set target interval=1m – ⚙️ Change how it is checked
+- /watch target – 👀 Watch a monitor someone else added
+- /share target team – 🤝 Share a monitor with a team
+- /delete target – 🗑️ Delete monitor
 - /list – 📋 Show all monitors
+- /history target – 📈 Recent checks and latency
+- /outages – 🧯 When monitors were down
+- /uptime target 30d – 📊 Uptime and SLA report
 - /help – ❓ Get help
 
 Start managing your monitors by using the /add command!`
 
-			message := tgbotapi.NewMessage(userID, msg)
-			message.ParseMode = "Markdown"
-			bot.Send(message)
-			continue
-		}
+		message := tgbotapi.NewMessage(userID, msg+"\n\n"+auth.Help(caller))
+		message.ParseMode = "Markdown"
+		bot.Send(message)
+		return
+	}
 
-		// Help command
-		if text == "/help" {
-			msg := `🤖 *MonitorBot Help*
-You can manage IP:Port monitors here.
+	// Help command
+	if text == "/help" {
+		msg := `🤖 *MonitorBot Help*
+You can manage monitors here.
 
 Commands:
-/add ip:port – ➕ Add a monitor
-/delete ip:port – 🗑️ Delete monitor
-/list – 📋 Show all monitors`
+/add TYPE TARGET key=value... – ➕ Add a monitor
+/set TARGET key=value... – ⚙️ Change its settings, for everyone watching it
+/delete TARGET – 🗑️ Stop watching it, it is deleted once nobody does
+/list [all] – 📋 Show your monitors, or everyone's
+/history TARGET – 📈 Recent checks and latency
+/outages [TARGET] – 🧯 When monitors were down
+/uptime [TARGET] [7d|30d] – 📊 Uptime, outages, MTTR and latency
+/summary [on|off] – 📬 This week's summary now, or turn the Monday one on or off
+
+Sharing:
+/watch TARGET – 👀 Get alerts for a monitor someone else added
+/unwatch TARGET – 🙈 Stop getting them
+/share TARGET TEAM – 🤝 Share a monitor with a team, created on first use
+/unshare TARGET TEAM – ✂️ Take it out of the team
+/watch team TEAM – 👥 Join a team to see and get alerts for all its monitors
+/unwatch team TEAM – 🚪 Leave the team
+/teams – 👥 List the teams
+
+Each target is checked once however many chats watch it. Wherever a command takes a TARGET you can also give the #ID from /list.
+
+Types:
+tcp host:port – connects (the default type)
+http https://host/path – status=200 contains="some text" latency=500ms insecure=true
+tls host:443 – fails warn=14 days before the certificate expires
+dns host.name – record=A expect=192.0.2.1,192.0.2.2 resolver=ip:port
+
+All types take interval=30s timeout=5s retries=1 down\_after=2 up\_after=1. A check fails once all its retries fail, and a monitor goes down after down\_after failed checks in a row and back up after up\_after good ones. While it keeps going up and down it is flapping, and you get one notice instead of a message per change.`
+
+		message := tgbotapi.NewMessage(userID, msg+"\n"+auth.Help(caller))
+		message.ParseMode = "Markdown"
+		bot.Send(message)
+	}
 
-			message := tgbotapi.NewMessage(userID, msg)
-			message.ParseMode = "Markdown"
-			bot.Send(message)
+	if strings.HasPrefix(text, "/add") {
+		args, err := splitArgs(text)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
 		}
+		req := report.CheckRequest{ChatID: userID, Type: checks.TCP}
+		if len(args) > 1 && isType(args[1]) {
+			req.Type = strings.ToLower(args[1])
+			args = args[1:]
+		}
+		if len(args) < 2 {
+			send(bot, userID, "⚠️ Usage: /add [tcp|http|tls|dns] TARGET [key=value...], see /help")
+			return
+		}
+		req.Target, req.Settings = args[1], args[2:]
+		c, added, err := client.AddCheck(req)
+		switch {
+		case err != nil:
+			send(bot, userID, errorText(err, "Failed to add monitor"))
+		case added:
+			send(bot, userID, fmt.Sprintf("✅ Monitor added! 📡 %s", describe(c.Monitor)))
+		default:
+			msg := fmt.Sprintf("👀 #%d is already checked for someone else, you are now watching it too: %s", c.ID, describe(c.Monitor))
+			if len(args) > 2 {
+				msg += fmt.Sprintf("\nYour settings were not applied, /set #%d changes them for everyone.", c.ID)
+			}
+			send(bot, userID, msg)
+		}
+	}
 
-		if strings.HasPrefix(text, "/add") {
-			args := strings.Fields(text)
-			if len(args) != 2 {
-				send(bot, userID, "⚠️ Usage: /add ip:port")
-				continue
+	if strings.HasPrefix(text, "/set") {
+		args, err := splitArgs(text)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
+		}
+		if len(args) < 3 {
+			send(bot, userID, "⚠️ Usage: /set TARGET key=value..., see /help")
+			return
+		}
+		mon, err := findMonitor(userID, args[1], true)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
+		}
+		c, err := client.UpdateCheck(report.CheckRequest{ChatID: userID, ID: mon.ID, Settings: args[2:]})
+		if err != nil {
+			send(bot, userID, errorText(err, "Failed to update monitor"))
+			return
+		}
+		send(bot, userID, "⚙️ Updated! "+describe(c.Monitor))
+	}
+
+	if strings.HasPrefix(text, "/history") {
+		args := strings.Fields(text)
+		if len(args) != 2 {
+			send(bot, userID, "⚠️ Usage: /history TARGET")
+			return
+		}
+		mon, err := findMonitor(userID, args[1], true)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
+		}
+		send(bot, userID, formatHistory(mon))
+	}
+
+	if strings.HasPrefix(text, "/outages") {
+		args := strings.Fields(text)
+		monitorID := 0
+		if len(args) > 1 {
+			mon, err := findMonitor(userID, args[1], true)
+			if err != nil {
+				send(bot, userID, "❌ "+err.Error())
+				return
 			}
-			target := args[1]
-			if !isValidIPPort(target) {
-				send(bot, userID, "❌ Invalid IP:Port format.")
-				continue
+			monitorID = mon.ID
+		}
+		send(bot, userID, formatOutages(userID, monitorID))
+	}
+
+	if strings.HasPrefix(text, "/delete") || strings.HasPrefix(text, "/unwatch") {
+		args := strings.Fields(text)
+		if len(args) == 3 && args[0] == "/unwatch" && args[1] == "team" {
+			_, err := client.Unwatch(report.CheckRequest{ChatID: userID, Team: args[2]})
+			switch {
+			case errorIs(err, checks.ErrNoTeam):
+				send(bot, userID, fmt.Sprintf("❌ There is no %s team, see /teams.", args[2]))
+			case errorIs(err, checks.ErrNotMember):
+				send(bot, userID, fmt.Sprintf("❌ You are not in the %s team.", args[2]))
+			case err != nil:
+				send(bot, userID, errorText(err, "Failed to leave the team"))
+			default:
+				send(bot, userID, fmt.Sprintf("🚪 You left the %s team.", args[2]))
 			}
-			if err := addMonitor(userID, target); err != nil {
-				send(bot, userID, "❌ Failed to add monitor or monitor already exists.")
-			} else {
-				send(bot, userID, "✅ Monitor added! 📡")
+			return
+		}
+		if len(args) != 2 {
+			send(bot, userID, "⚠️ Usage: "+args[0]+" TARGET")
+			return
+		}
+		mon, err := findMonitor(userID, args[1], true)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
+		}
+		deleted, err := client.Unwatch(report.CheckRequest{ChatID: userID, ID: mon.ID})
+		switch {
+		case err != nil:
+			send(bot, userID, errorText(err, "Failed to delete monitor"))
+		case deleted:
+			send(bot, userID, "🗑️ Monitor deleted!")
+		default:
+			msg := fmt.Sprintf("🙈 You no longer watch %s. It is still checked for others", mon.Target)
+			if len(mon.Teams) > 0 {
+				msg += " and the " + strings.Join(mon.Teams, ", ") + " team"
+			}
+			msg += "."
+			if _, err := findMonitor(userID, "#"+strconv.Itoa(mon.ID), true); err == nil {
+				msg += " You still get its alerts through your team, /unwatch team TEAM to stop."
 			}
+			send(bot, userID, msg)
 		}
+	}
 
-		if strings.HasPrefix(text, "/delete") {
-			args := strings.Fields(text)
-			if len(args) != 2 {
-				send(bot, userID, "⚠️ Usage: /delete ip:port")
-				continue
+	if strings.HasPrefix(text, "/watch") {
+		args := strings.Fields(text)
+		if len(args) == 3 && args[1] == "team" {
+			n, err := client.Watch(report.CheckRequest{ChatID: userID, Team: args[2]})
+			switch {
+			case errorIs(err, checks.ErrNoTeam):
+				send(bot, userID, fmt.Sprintf("❌ There is no %s team, see /teams.", args[2]))
+			case err != nil:
+				send(bot, userID, errorText(err, "Failed to join the team"))
+			default:
+				send(bot, userID, fmt.Sprintf("👥 You joined the %s team and now watch its %d monitors.", args[2], n))
 			}
-			target := args[1]
-			if !isValidIPPort(target) {
-				send(bot, userID, "❌ Invalid IP:Port format.")
-				continue
+			return
+		}
+		if len(args) != 2 {
+			send(bot, userID, "⚠️ Usage: /watch TARGET or /watch team TEAM")
+			return
+		}
+		mon, err := findMonitor(userID, args[1], false)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
+		}
+		if _, err := client.Watch(report.CheckRequest{ChatID: userID, ID: mon.ID}); err != nil {
+			send(bot, userID, errorText(err, "Failed to watch monitor"))
+			return
+		}
+		send(bot, userID, fmt.Sprintf("👀 You are now watching #%d: %s", mon.ID, describe(mon.Monitor)))
+	}
+
+	if strings.HasPrefix(text, "/share") || strings.HasPrefix(text, "/unshare") {
+		args := strings.Fields(text)
+		if len(args) != 3 {
+			send(bot, userID, "⚠️ Usage: "+args[0]+" TARGET TEAM")
+			return
+		}
+		mon, err := findMonitor(userID, args[1], true)
+		if err != nil {
+			send(bot, userID, "❌ "+err.Error())
+			return
+		}
+		team := args[2]
+		req := report.CheckRequest{ChatID: userID, ID: mon.ID, Team: team}
+		deleted := false
+		if args[0] == "/share" {
+			err = client.Share(req)
+		} else {
+			deleted, err = client.Unshare(req)
+		}
+		switch {
+		case errorIs(err, checks.ErrTeamName):
+			send(bot, userID, "❌ Team names are up to 32 lowercase letters, digits, - and _.")
+		case errorIs(err, checks.ErrNoTeam):
+			send(bot, userID, fmt.Sprintf("❌ There is no %s team, see /teams.", team))
+		case errorIs(err, checks.ErrNotShared):
+			send(bot, userID, fmt.Sprintf("❌ It isn't shared with %s.", team))
+		case err != nil:
+			send(bot, userID, errorText(err, "Failed to change the team"))
+		case args[0] == "/share":
+			send(bot, userID, fmt.Sprintf("🤝 %s is shared with the %s team. Others join it with /watch team %s", mon.Target, team, team))
+		case deleted:
+			send(bot, userID, fmt.Sprintf("✂️ %s is no longer shared with %s and was deleted, nobody else watched it.", mon.Target, team))
+		default:
+			send(bot, userID, fmt.Sprintf("✂️ %s is no longer shared with %s.", mon.Target, team))
+		}
+	}
+
+	if strings.HasPrefix(text, "/uptime") {
+		args := strings.Fields(text)[1:]
+		period := defaultPeriod
+		if len(args) > 0 {
+			if d, err := checks.ParsePeriod(args[len(args)-1]); err == nil {
+				period = d
+				args = args[:len(args)-1]
 			}
-			if err := deleteMonitor(userID, target); err != nil {
-				send(bot, userID, "❌ Monitor not found or failed to delete.")
+		}
+		if period > historyRetention {
+			send(bot, userID, fmt.Sprintf("❌ Results are kept for %d days, ask for a shorter period.", int(historyRetention.Hours()/24)))
+			return
+		}
+		switch len(args) {
+		case 0:
+			send(bot, userID, formatSummary(userID, period))
+		case 1:
+			mon, err := findMonitor(userID, args[0], true)
+			if err != nil {
+				send(bot, userID, "❌ "+err.Error())
+				return
+			}
+			reports, err := client.CheckUptime(mon.ID, period)
+			if err != nil || len(reports) == 0 {
+				log.Println("Uptime report failed:", err)
+				send(bot, userID, "❌ Could not build the report.")
+				return
+			}
+			send(bot, userID, formatReport(mon, reports[0].Report, period))
+		default:
+			send(bot, userID, "⚠️ Usage: /uptime [TARGET] [7d|30d]")
+		}
+	}
+
+	if strings.HasPrefix(text, "/summary") {
+		switch strings.TrimSpace(strings.TrimPrefix(text, "/summary")) {
+		case "":
+			send(bot, userID, formatSummary(userID, defaultPeriod))
+		case "on", "off":
+			on := strings.HasSuffix(text, "on")
+			if err := client.SetSummary(userID, on); err != nil {
+				log.Println("Saving the summary setting failed:", err)
+				send(bot, userID, "❌ Could not save the setting.")
+			} else if on {
+				send(bot, userID, "📬 You'll get an uptime summary every Monday.")
 			} else {
-				send(bot, userID, "🗑️ Monitor deleted!")
+				send(bot, userID, "📭 No more weekly summaries. /summary on brings them back.")
 			}
+		default:
+			send(bot, userID, "⚠️ Usage: /summary [on|off]")
 		}
+	}
 
-		if strings.HasPrefix(text, "/list") {
-			rows, err := db.Query("SELECT id, target, up FROM monitors WHERE user_id = ?", userID)
-			if err != nil {
-				send(bot, userID, "❌ Could not list monitors")
-				continue
+	if strings.HasPrefix(text, "/teams") {
+		send(bot, userID, formatTeams(userID))
+	}
+
+	if strings.HasPrefix(text, "/list") {
+		all := strings.TrimSpace(strings.TrimPrefix(text, "/list")) == "all"
+		var list []checks.Service
+		var err error
+		if all {
+			list, err = client.Checks()
+		} else {
+			list, err = client.ChatChecks(userID)
+		}
+		if err != nil {
+			log.Println("Listing monitors failed:", err)
+			send(bot, userID, "❌ Could not list monitors")
+			return
+		}
+
+		msg := "📋 *Your Monitors:*\n"
+		if all {
+			msg = "📋 *All Monitors:*\n"
+		}
+		for _, c := range list {
+			// Display the status
+			status := "🟢 *UP*"
+			if !c.Up {
+				status = "🔴 *DOWN*"
+			}
+			if c.Flapping {
+				status = "🟠 *FLAPPING*"
 			}
-			defer rows.Close()
-
-			msg := "📋 *Your Monitors:*\n"
-			for rows.Next() {
-				var id int
-				var target string
-				var up bool
-				rows.Scan(&id, &target, &up)
-
-				// Display the status
-				status := "🟢 *UP*"
-				if !up {
-					status = "🔴 *DOWN*"
-				}
 
-				msg += fmt.Sprintf("%s - %s\n", status, target)
+			msg += fmt.Sprintf("%s - #%d %s %s every %s", status, c.ID, c.Type, telegram.Escape(telegram.Markdown, c.Target), c.Interval)
+			if c.Up && !c.LastChecked.IsZero() {
+				msg += ", " + formatLatency(c.LastLatency)
+			}
+			if len(c.Teams) > 0 {
+				msg += ", 👥 " + telegram.Escape(telegram.Markdown, strings.Join(c.Teams, ", "))
 			}
-			message := tgbotapi.NewMessage(userID, msg)
-			message.ParseMode = "Markdown"
-			bot.Send(message)
+			if c.Watchers > 1 {
+				msg += fmt.Sprintf(", 👀 %d", c.Watchers)
+			}
+			msg += "\n"
 		}
+		message := tgbotapi.NewMessage(userID, msg)
+		message.ParseMode = "Markdown"
+		bot.Send(message)
+	}
 
-		if text == "/start" || text == "/help" {
-			msg := `🤖 *Welcome to MonitorBot!*
-You can manage IP:Port monitors here.
+	if text == "/start" || text == "/help" {
+		msg := `🤖 *Welcome to MonitorBot!*
+You can manage monitors here.
 
 Commands:
-/add ip:port – ➕ Add a monitor
-/delete ip:port – 🗑️ Delete monitor
-/list – 📋 Show all monitors`
-			message := tgbotapi.NewMessage(userID, msg)
-			message.ParseMode = "Markdown"
-			bot.Send(message)
-		}
+/add host:port – ➕ Add a TCP monitor
+/add http https://example.org – 🌐 Add a website
+/set target interval=1m – ⚙️ Change how it is checked
+/watch target – 👀 Watch a monitor someone else added
+/share target team – 🤝 Share a monitor with a team
+/delete target – 🗑️ Delete monitor
+/list – 📋 Show all monitors
+/history target – 📈 Recent checks and latency
+/outages – 🧯 When monitors were down
+/uptime target 30d – 📊 Uptime and SLA report`
+		message := tgbotapi.NewMessage(userID, msg)
+		message.ParseMode = "Markdown"
+		bot.Send(message)
 	}
 }
 
-func send(bot *tgbotapi.BotAPI, chatID int64, text string) {
+func send(bot telegram.Messenger, chatID int64, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	bot.Send(msg)
 }
 
 func createTable() {
-	query := `
-	CREATE TABLE IF NOT EXISTS monitors (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER,
-		target TEXT UNIQUE,
-		up BOOLEAN DEFAULT 1
-	);`
-	_, err := db.Exec(query)
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bot_state (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func addMonitor(userID int64, target string) error {
-	// Check if the user already has a monitor for the target
-	var existingTarget string
-	err := db.QueryRow("SELECT target FROM monitors WHERE user_id = ? AND target = ?", userID, target).Scan(&existingTarget)
-	if err == nil {
-		// Monitor already exists for this user
-		return fmt.Errorf("Monitor already exists")
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return def
+}
 
-	_, err = db.Exec("INSERT INTO monitors (user_id, target) VALUES (?, ?)", userID, target)
-	return err
+// errorIs reports whether the server answered with the message of one of
+// the checks store's errors.
+func errorIs(err error, target error) bool {
+	var e *api.Error
+	return errors.As(err, &e) && e.Message == target.Error()
 }
 
-func deleteMonitor(userID int64, target string) error {
-	// Check if the monitor exists and belongs to the user
-	result, err := db.Exec("DELETE FROM monitors WHERE user_id = ? AND target = ?", userID, target)
-	if err != nil {
-		return err
+// errorText is the reply to a failed request: the server's own words for
+// a bad request, such as an invalid setting, or else that what was asked
+// failed.
+func errorText(err error, failed string) string {
+	var e *api.Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusBadRequest {
+		return "❌ " + e.Message
 	}
+	log.Printf("%s: %v", failed, err)
+	return "❌ " + failed + "."
+}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		// No rows affected means the monitor doesn't exist or it wasn't owned by the user
-		return fmt.Errorf("Monitor not found or not owned by you")
+// findMonitor looks a monitor up by #ID or target, among the ones the
+// chat sees or, with visible false, all of them.
+func findMonitor(chatID int64, ref string, visible bool) (checks.Service, error) {
+	c, err := client.FindCheck(chatID, ref, visible)
+	var e *api.Error
+	switch {
+	case err == nil:
+		return c, nil
+	case errors.As(err, &e) && e.StatusCode == http.StatusNotFound:
+		if visible {
+			return c, fmt.Errorf("You don't watch %s, see /list.", ref)
+		}
+		return c, fmt.Errorf("Nobody monitors %s, see /list all.", ref)
+	case errors.As(err, &e) && e.StatusCode == http.StatusConflict:
+		return c, fmt.Errorf("Several monitors check %s, use the #ID from /list.", ref)
 	}
-	return nil
+	log.Println("Finding monitor failed:", err)
+	return c, fmt.Errorf("Could not reach the monitor server.")
 }
 
-func isValidIPPort(target string) bool {
-	host, port, err := net.SplitHostPort(target)
+// formatTeams lists every team, marking the ones chatID is in.
+func formatTeams(chatID int64) string {
+	teams, err := client.Teams(chatID)
 	if err != nil {
-		return false
+		log.Println("Listing teams failed:", err)
+		return "❌ Could not list teams"
 	}
-	if net.ParseIP(host) == nil {
-		return false
+	if len(teams) == 0 {
+		return "No teams yet. /share TARGET TEAM creates one."
 	}
-	p, err := strconv.Atoi(port)
-	if err != nil || p < 1 || p > 65535 {
-		return false
+	var b strings.Builder
+	b.WriteString("👥 Teams:\n")
+	for _, t := range teams {
+		mark := "▫️"
+		if t.Member {
+			mark = "✅"
+		}
+		fmt.Fprintf(&b, "%s %s: %d members, %d monitors\n", mark, t.Name, t.Members, t.Checks)
 	}
-	return true
+	b.WriteString("\n✅ marks yours. Join one with /watch team TEAM.")
+	return b.String()
 }
 
-func monitorServices(bot *tgbotapi.BotAPI) {
-	for {
-		rows, err := db.Query("SELECT id, user_id, target, up FROM monitors")
-		if err != nil {
-			log.Println("Query error:", err)
-			time.Sleep(30 * time.Second)
-			continue
+// splitArgs splits a command into words like strings.Fields, except that
+// double quotes keep spaces, as in contains="Welcome back".
+func splitArgs(text string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, c := range text {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unmatched quote")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+func isType(s string) bool {
+	for _, t := range checks.Types {
+		if strings.EqualFold(s, t) {
+			return true
 		}
+	}
+	return false
+}
 
-		var monitors []Monitor
-		for rows.Next() {
-			var m Monitor
-			rows.Scan(&m.ID, &m.UserID, &m.Target, &m.Up)
-			monitors = append(monitors, m)
+// describe sums up how a monitor is checked.
+func describe(m checks.Monitor) string {
+	s := fmt.Sprintf("%s %s every %s, timeout %s, %d retries, down after %d failed checks, up after %d",
+		m.Type, m.Target, m.Interval, m.Timeout, m.Retries, m.DownAfter, m.UpAfter)
+	switch m.Type {
+	case checks.HTTP:
+		if m.Status != 0 {
+			s += fmt.Sprintf(", status %d", m.Status)
+		}
+		if m.Contains != "" {
+			s += fmt.Sprintf(", body contains %q", m.Contains)
 		}
-		rows.Close()
+		if m.MaxLatency != 0 {
+			s += fmt.Sprintf(", at most %s", m.MaxLatency)
+		}
+	case checks.TLS:
+		s += fmt.Sprintf(", warns %d days before expiry", m.WarnDays)
+	case checks.DNS:
+		s += ", " + m.Record + " records"
+		if len(m.Expect) > 0 {
+			s += " including " + strings.Join(m.Expect, ", ")
+		}
+		if m.Resolver != "" {
+			s += " from " + m.Resolver
+		}
+	}
+	if m.Insecure {
+		s += ", certificate not verified"
+	}
+	return s + "."
+}
 
-		for _, m := range monitors {
-			host, port, err := net.SplitHostPort(m.Target)
+// deliverNotifications tells the chats watching a check when it goes
+// down or up or starts or stops flapping. The server raises these as
+// check_down and check_flapping alerts and queues them for the watchers
+// and team members.
+func deliverNotifications(bot telegram.Messenger) {
+	for {
+		pending, err := client.PendingNotifications(api.CheckNotifications)
+		if err != nil {
+			log.Println("Fetching notifications failed:", err)
+		}
+		var byID map[int]checks.Service
+		if len(pending) > 0 {
+			byID, err = checksByID()
 			if err != nil {
-				continue
+				log.Println("Loading monitors failed:", err)
+				pending = nil
 			}
-			conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), 5*time.Second)
-			isUp := err == nil
-			if conn != nil {
-				conn.Close()
+		}
+		// A check that stops flapping while down fires check_down in the
+		// same round; the flapping notice already says it is down.
+		type chatCheck struct {
+			chatID int64
+			check  int
+		}
+		flapEnded := map[chatCheck]bool{}
+		for _, n := range pending {
+			if n.Kind == alerts.Resolved && n.Event.Rule == "check_flapping" {
+				flapEnded[chatCheck{n.ChatID, n.Event.Check}] = true
 			}
+		}
 
-			if isUp != m.Up {
-				_, err := db.Exec("UPDATE monitors SET up = ? WHERE id = ?", isUp, m.ID)
-				if err != nil {
-					continue
-				}
-				status := "🔴 *DOWN*"
-				if isUp {
-					status = "🟢 *UP*"
-				}
-				msg := fmt.Sprintf("🔔 %s is now %s", m.Target, status)
-				message := tgbotapi.NewMessage(m.UserID, msg)
+		var sent []int64
+		for _, n := range pending {
+			sent = append(sent, n.ID)
+			c, ok := byID[n.Event.Check]
+			if !ok {
+				// Deleted since.
+				continue
+			}
+			if n.Kind == alerts.Firing && n.Event.Rule == "check_down" && flapEnded[chatCheck{n.ChatID, c.ID}] {
+				continue
+			}
+			if msg := formatNotification(n, c); msg != "" {
+				message := tgbotapi.NewMessage(n.ChatID, msg)
 				message.ParseMode = "Markdown"
-				bot.Send(message)
+				if _, err := bot.Send(message); err != nil {
+					log.Printf("Sending notification to chat %d failed: %v", n.ChatID, err)
+				}
 			}
 		}
-		time.Sleep(30 * time.Second)
+		if len(sent) > 0 {
+			if err := client.MarkSent(sent); err != nil {
+				log.Println("Marking notifications sent failed:", err)
+			}
+		}
+		time.Sleep(notifyPollInterval)
 	}
 }
 
+func checksByID() (map[int]checks.Service, error) {
+	list, err := client.Checks()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]checks.Service, len(list))
+	for _, c := range list {
+		byID[c.ID] = c
+	}
+	return byID, nil
+}
 
+// formatNotification words a check's alert, or returns "" for one not
+// worth a message: the hourly reminders of a check that stays down, and
+// check_down resolving because the check started flapping.
+func formatNotification(n alerts.Notification, c checks.Service) string {
+	target := telegram.Escape(telegram.Markdown, c.Target)
+	status := "🔴 *DOWN*"
+	if c.Up {
+		status = "🟢 *UP*"
+	}
+	ev := n.Event
+	switch {
+	case n.Kind == alerts.Reminder:
+		return ""
+	case ev.Rule == "check_flapping" && n.Kind == alerts.Firing:
+		return fmt.Sprintf("⚠️ %s is *flapping*: its state changed %d times in the last %d checks. I'll stay quiet until it settles.",
+			target, c.Flips(), len(c.Recent))
+	case ev.Rule == "check_flapping":
+		return fmt.Sprintf("🔔 %s stopped flapping and is %s", target, status)
+	case n.Kind == alerts.Resolved:
+		if c.Flapping {
+			return ""
+		}
+		msg := fmt.Sprintf("🔔 %s is now 🟢 *UP* (%s)", target, formatLatency(c.LastLatency))
+		started, err1 := time.Parse(time.RFC3339, ev.StartedAt)
+		resolved, err2 := time.Parse(time.RFC3339, ev.ResolvedAt)
+		if err1 == nil && err2 == nil {
+			msg += fmt.Sprintf(", it was down for %s", resolved.Sub(started).Round(time.Second))
+		}
+		return msg
+	}
+	msg := fmt.Sprintf("🔔 %s is now 🔴 *DOWN*", target)
+	if c.DownAfter > 1 {
+		msg += fmt.Sprintf(" after %d failed checks", c.DownAfter)
+	}
+	return msg + ": " + telegram.Escape(telegram.Markdown, c.LastError)
+}
+
+// historyLength is how many checks /history shows.
+const historyLength = 15
+
+// formatHistory lists a monitor's latest checks and its latency over the
+// stored history.
+func formatHistory(m checks.Service) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📈 %s\n", describe(m.Monitor))
+
+	reports, err := client.CheckUptime(m.ID, historyRetention)
+	if err != nil || len(reports) == 0 || reports[0].Report.Checks == 0 {
+		b.WriteString("No checks yet.")
+		return b.String()
+	}
+	r := reports[0].Report
+	fmt.Fprintf(&b, "%d checks, %d up", r.Checks, r.Checks-r.Failed)
+	if r.Checks > r.Failed {
+		fmt.Fprintf(&b, ", latency p50 %s, p95 %s", formatLatency(r.LatencyP50), formatLatency(r.LatencyP95))
+	}
+	b.WriteString("\n\n")
+
+	results, err := client.CheckResults(m.ID, historyLength)
+	if err != nil {
+		return b.String()
+	}
+	for _, r := range results {
+		when := r.Time.UTC().Format("01-02 15:04:05")
+		if r.Up {
+			fmt.Fprintf(&b, "🟢 %s %s", when, formatLatency(r.Latency))
+		} else {
+			fmt.Fprintf(&b, "🔴 %s %v", when, r.Err)
+		}
+		if r.Attempts > 1 {
+			fmt.Fprintf(&b, " (attempt %d)", r.Attempts)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// outageLength is how many outages /outages shows.
+const outageLength = 15
+
+// formatOutages lists the latest outages of the monitors chatID sees, or
+// of one monitor if monitorID is set.
+func formatOutages(chatID int64, monitorID int) string {
+	outages, err := client.ChatOutages(chatID, monitorID, outageLength)
+	if err != nil {
+		log.Println("Listing outages failed:", err)
+		return "❌ Could not list outages"
+	}
+	if len(outages) == 0 {
+		return "🎉 No outages recorded."
+	}
+
+	var b strings.Builder
+	b.WriteString("🧯 Outages:\n")
+	for _, o := range outages {
+		if !o.End.IsZero() {
+			fmt.Fprintf(&b, "\n✅ %s\n%s – %s (%s)", o.Target, o.Start.UTC().Format("01-02 15:04"), o.End.UTC().Format("01-02 15:04"), o.End.Sub(o.Start).Round(time.Second))
+		} else {
+			fmt.Fprintf(&b, "\n🔴 %s\ndown since %s (%s)", o.Target, o.Start.UTC().Format("01-02 15:04"), time.Since(o.Start).Round(time.Second))
+		}
+		if o.Error != "" {
+			b.WriteString("\n" + o.Error)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func formatPeriod(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	return d.String()
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.1f ms", float64(d.Microseconds())/1000)
+}
+
+// formatReport shows one monitor's uptime report.
+func formatReport(m checks.Service, r checks.Report, period time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 %s %s, last %s\n", m.Type, m.Target, formatPeriod(period))
+	if r.Checks == 0 && r.Outages == 0 {
+		b.WriteString("No checks in this period yet.")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Uptime: %.3f%%", r.Uptime)
+	if r.Downtime > 0 {
+		fmt.Fprintf(&b, " (down %s)", r.Downtime.Round(time.Second))
+	}
+	fmt.Fprintf(&b, "\nOutages: %d", r.Outages)
+	if r.MTTR > 0 {
+		fmt.Fprintf(&b, ", MTTR %s", r.MTTR.Round(time.Second))
+	}
+	fmt.Fprintf(&b, "\nChecks: %d, %d failed", r.Checks, r.Failed)
+	if r.Checks > r.Failed {
+		fmt.Fprintf(&b, "\nLatency: p50 %s, p95 %s", formatLatency(r.LatencyP50), formatLatency(r.LatencyP95))
+	}
+	return b.String()
+}
+
+// formatSummary shows the uptime of every monitor chatID sees, worst
+// first.
+func formatSummary(chatID int64, period time.Duration) string {
+	monitors, err := client.ChatChecks(chatID)
+	if err != nil {
+		log.Println("Listing monitors failed:", err)
+		return "❌ Could not list monitors"
+	}
+	if len(monitors) == 0 {
+		return "You don't watch any monitors yet, /add or /watch one."
+	}
+	reports, err := client.CheckUptime(0, period)
+	if err != nil {
+		log.Println("Uptime report failed:", err)
+		return "❌ Could not build the report."
+	}
+	visible := map[int]bool{}
+	for _, m := range monitors {
+		visible[m.ID] = true
+	}
+	var lines []api.CheckReport
+	for _, r := range reports {
+		if visible[r.Check.ID] {
+			lines = append(lines, r)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Report.Uptime < lines[j].Report.Uptime })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Uptime, last %s\n\n", formatPeriod(period))
+	for _, l := range lines {
+		icon := "🟢"
+		switch {
+		case l.Report.Uptime < 99:
+			icon = "🔴"
+		case l.Report.Uptime < 99.9:
+			icon = "🟠"
+		}
+		fmt.Fprintf(&b, "%s #%d %s: %.2f%%", icon, l.Check.ID, l.Check.Target, l.Report.Uptime)
+		if l.Report.Outages > 0 {
+			fmt.Fprintf(&b, ", %d outages, MTTR %s", l.Report.Outages, l.Report.MTTR.Round(time.Second))
+		}
+		if l.Report.Checks > l.Report.Failed {
+			fmt.Fprintf(&b, ", p95 %s", formatLatency(l.Report.LatencyP95))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// lastSummaryDue returns the latest Monday 09:00 UTC at or before now,
+// when the weekly summaries go out.
+func lastSummaryDue(now time.Time) time.Time {
+	now = now.UTC()
+	due := time.Date(now.Year(), now.Month(), now.Day(), 9, 0, 0, 0, time.UTC)
+	due = due.AddDate(0, 0, -((int(due.Weekday()) + 6) % 7))
+	if due.After(now) {
+		due = due.AddDate(0, 0, -7)
+	}
+	return due
+}
+
+// sendWeeklySummaries sends every chat that watches something its uptime
+// summary once a week, unless it turned them off. The time of the last
+// round is stored, so a restart neither skips nor repeats one.
+func sendWeeklySummaries(bot telegram.Messenger) {
+	for {
+		due := lastSummaryDue(time.Now())
+		var last string
+		err := db.QueryRow("SELECT value FROM bot_state WHERE key = 'weekly_summary'").Scan(&last)
+		if err == sql.ErrNoRows {
+			// The first start waits for the next Monday rather than
+			// sending a summary right away.
+			last = time.Now().UTC().Format(time.RFC3339)
+			db.Exec("INSERT INTO bot_state (key, value) VALUES ('weekly_summary', ?)", last)
+		}
+		if sent, err := time.Parse(time.RFC3339, last); err != nil || sent.Before(due) {
+			chats, err := client.SummaryChats()
+			if err != nil {
+				log.Println("Loading chats for the weekly summary failed:", err)
+			} else {
+				for _, chatID := range chats {
+					send(bot, chatID, "📬 Weekly summary\n"+formatSummary(chatID, defaultPeriod)+"\n/summary off stops these.")
+				}
+				_, err = db.Exec(`INSERT INTO bot_state (key, value) VALUES ('weekly_summary', ?)
+					ON CONFLICT(key) DO UPDATE SET value = excluded.value`, time.Now().UTC().Format(time.RFC3339))
+				if err != nil {
+					log.Println("Saving the weekly summary time failed:", err)
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
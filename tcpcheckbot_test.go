@@ -0,0 +1,391 @@
+// The repository root holds several programs, so its tests are run one
+// program at a time:
+//
+//	go test tcpcheckbot.go tcpcheckbot_test.go
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"gpu-monitor/api"
+	"gpu-monitor/botauth"
+	"gpu-monitor/checks"
+	"gpu-monitor/telegram/telegramtest"
+)
+
+const testToken = "test-token"
+
+// fakeAPI stands in for the collector server. It answers "METHOD /path"
+// with a canned reply, encoded as JSON, and records the requests. Every
+// check endpoint needs the bot's token like on the real server.
+type fakeAPI struct {
+	mu      sync.Mutex
+	replies map[string]interface{}
+	calls   []apiCall
+}
+
+type apiCall struct {
+	Route string // e.g. "POST /admin/checks"
+	Query url.Values
+	Body  map[string]interface{}
+}
+
+// apiStatus is a reply with an error status and the message of one of
+// the checks store's errors, like the server's http.Error replies.
+type apiStatus struct {
+	code int
+	msg  string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + r.URL.Path
+	call := apiCall{Route: route, Query: r.URL.Query()}
+	json.NewDecoder(r.Body).Decode(&call.Body)
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	reply, ok := f.replies[route]
+	f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if s, ok := reply.(apiStatus); ok {
+		http.Error(w, s.msg, s.code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (f *fakeAPI) reply(route string, v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[route] = v
+}
+
+// last returns the latest request to a route.
+func (f *fakeAPI) last(t *testing.T, route string) apiCall {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if f.calls[i].Route == route {
+			return f.calls[i]
+		}
+	}
+	t.Fatalf("no %s request", route)
+	return apiCall{}
+}
+
+// Users of the tests, by role.
+var (
+	admin    = tgbotapi.User{ID: 1, FirstName: "alice", UserName: "alice"}
+	operator = tgbotapi.User{ID: 2, FirstName: "bob", UserName: "bob"}
+	viewer   = tgbotapi.User{ID: 3, FirstName: "carol", UserName: "carol"}
+	stranger = tgbotapi.User{ID: 4, FirstName: "mallory", UserName: "mallory"}
+)
+
+type testBot struct {
+	srv    *telegramtest.Server
+	bot    *tgbotapi.BotAPI
+	api    *fakeAPI
+	offset int
+}
+
+// newTestBot points the bot at a fake Telegram and a fake collector with
+// one website check, and gives it a fresh monitors.db.
+func newTestBot(t *testing.T) *testBot {
+	t.Helper()
+	srv := telegramtest.NewServer()
+	t.Cleanup(srv.Close)
+	bot, err := srv.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeAPI{replies: checkReplies()}
+	hs := httptest.NewServer(fake)
+	t.Cleanup(hs.Close)
+	client = api.New(hs.URL, testToken)
+
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "monitors.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	createTable()
+	auth, err = botauth.Open(db, []int64{int64(admin.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, r := range map[int64]botauth.Role{int64(operator.ID): botauth.Operator, int64(viewer.ID): botauth.Viewer} {
+		if err := auth.Grant(id, "", r, int64(admin.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &testBot{srv: srv, bot: bot, api: fake}
+}
+
+// deliver fetches the queued updates and handles them like main does.
+func (b *testBot) deliver(t *testing.T) {
+	t.Helper()
+	updates, err := b.bot.GetUpdates(tgbotapi.UpdateConfig{Offset: b.offset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range updates {
+		b.offset = u.UpdateID + 1
+		handleUpdate(b.bot, u)
+	}
+}
+
+// command sends text in the user's private chat and returns what the bot
+// sent back. The rate limit is lifted first, as the tests do not wait.
+func (b *testBot) command(t *testing.T, from tgbotapi.User, text string) []telegramtest.Sent {
+	t.Helper()
+	userCommandTimes = map[int64]time.Time{}
+	b.srv.Reset()
+	b.srv.SendMessage(from, b.srv.PrivateChat(from.ID), text)
+	b.deliver(t)
+	return b.srv.Sent()
+}
+
+// website is the check the fake server knows.
+var website = checks.Service{
+	Monitor: checks.Monitor{
+		ID: 3, Type: checks.HTTP, Target: "https://example.org",
+		Interval: 30 * time.Second, Timeout: 5 * time.Second, Retries: 1, DownAfter: 2, UpAfter: 1,
+		Options: checks.Options{Status: 200},
+	},
+	State:       checks.State{Up: true, Recent: "++++"},
+	LastChecked: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+	LastLatency: 12 * time.Millisecond,
+	Watchers:    2,
+	Teams:       []string{"web"},
+}
+
+func checkReplies() map[string]interface{} {
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	other := website
+	other.ID, other.Target, other.Type, other.Options = 9, "db.internal:5432", checks.TCP, checks.Options{}
+	return map[string]interface{}{
+		"GET /checks":                  []checks.Service{website, other},
+		"GET /admin/checks":            []checks.Service{website},
+		"GET /admin/checks/find":       website,
+		"POST /admin/checks":           map[string]interface{}{"check": website, "added": true},
+		"POST /admin/checks/update":    website,
+		"POST /admin/checks/watch":     map[string]int{"checks": 4},
+		"POST /admin/checks/unwatch":   map[string]bool{"deleted": false},
+		"POST /admin/checks/share":     map[string]bool{},
+		"POST /admin/checks/unshare":   map[string]bool{"deleted": false},
+		"POST /admin/checks/summaries": map[string]bool{},
+		"GET /admin/checks/teams": []checks.Team{
+			{Name: "db", Members: 1, Checks: 1},
+			{Name: "web", Members: 3, Checks: 4, Member: true},
+		},
+		"GET /admin/checks/outages": []checks.Outage{
+			{CheckID: 3, Target: "https://example.org", Start: at.Add(-2 * time.Hour), End: at.Add(-2*time.Hour + 90*time.Second), Error: "status 502"},
+		},
+		"GET /checks/uptime": []api.CheckReport{
+			{Check: website, Report: checks.Report{Checks: 200, Failed: 3, Uptime: 99.5, Downtime: 90 * time.Second, Outages: 1, MTTR: 90 * time.Second, LatencyP50: 12 * time.Millisecond, LatencyP95: 40 * time.Millisecond}},
+			{Check: other, Report: checks.Report{Checks: 200, Uptime: 100}},
+		},
+		"GET /checks/results": []checks.Result{
+			{MonitorID: 3, Time: at, Up: true, Latency: 12 * time.Millisecond, Attempts: 1},
+			{MonitorID: 3, Time: at.Add(-30 * time.Second), Up: false, Attempts: 2, Err: errors.New("status 502")},
+		},
+	}
+}
+
+func TestCommands(t *testing.T) {
+	b := newTestBot(t)
+
+	tests := []struct {
+		name    string
+		from    tgbotapi.User
+		text    string
+		want    []string // in the first message sent
+		replies map[string]interface{}
+	}{
+		{name: "start", from: viewer, text: "/start", want: []string{"*Welcome to MonitorBot!*", "/whoami"}},
+		{name: "help", from: viewer, text: "/help", want: []string{"*MonitorBot Help*", "down\\_after=2"}},
+		{name: "whoami", from: viewer, text: "/whoami", want: []string{"Your ID is 3, role: viewer"}},
+		{name: "no access", from: stranger, text: "/list", want: []string{"You are not authorized to use this bot"}},
+
+		{name: "add needs operator", from: viewer, text: "/add example.org:443", want: []string{"needs the operator role, you have viewer"}},
+		{name: "add usage", from: operator, text: "/add http", want: []string{"Usage: /add [tcp|http|tls|dns] TARGET"}},
+		{name: "add unmatched quote", from: operator, text: `/add http https://example.org contains="Welcome`, want: []string{"❌ unmatched quote"}},
+		{name: "add", from: operator, text: `/add http https://example.org status=200 contains="Welcome back"`,
+			want: []string{"✅ Monitor added! 📡 http https://example.org every 30s, timeout 5s, 1 retries, down after 2 failed checks, up after 1, status 200."}},
+		{name: "add watched", from: operator, text: "/add http https://example.org interval=1m",
+			replies: map[string]interface{}{"POST /admin/checks": map[string]interface{}{"check": website, "added": false}},
+			want:    []string{"👀 #3 is already checked for someone else", "/set #3 changes them for everyone"}},
+		{name: "add bad setting", from: operator, text: "/add example.org:443 interval=soon",
+			replies: map[string]interface{}{"POST /admin/checks": apiStatus{http.StatusBadRequest, "interval must be a duration, e.g. 30s or 2m"}},
+			want:    []string{"❌ interval must be a duration, e.g. 30s or 2m"}},
+		{name: "add server down", from: operator, text: "/add example.org:443",
+			replies: map[string]interface{}{"POST /admin/checks": apiStatus{http.StatusInternalServerError, "database is locked"}},
+			want:    []string{"❌ Failed to add monitor."}},
+
+		{name: "set needs operator", from: viewer, text: "/set #3 interval=1m", want: []string{"needs the operator role"}},
+		{name: "set usage", from: operator, text: "/set #3", want: []string{"Usage: /set TARGET key=value"}},
+		{name: "set", from: operator, text: "/set #3 interval=30s", want: []string{"⚙️ Updated! http https://example.org every 30s"}},
+		{name: "set unknown", from: operator, text: "/set nope interval=1m",
+			replies: map[string]interface{}{"GET /admin/checks/find": apiStatus{http.StatusNotFound, "no such check"}},
+			want:    []string{"❌ You don't watch nope, see /list."}},
+		{name: "set ambiguous", from: operator, text: "/set example.org interval=1m",
+			replies: map[string]interface{}{"GET /admin/checks/find": apiStatus{http.StatusConflict, "several checks have that target, use the #ID"}},
+			want:    []string{"❌ Several monitors check example.org, use the #ID from /list."}},
+
+		{name: "history", from: viewer, text: "/history #3",
+			want: []string{"📈 http https://example.org", "200 checks, 197 up, latency p50 12.0 ms, p95 40.0 ms", "🟢 10-19 12:00:00 12.0 ms\n🔴 10-19 11:59:30 status 502 (attempt 2)"}},
+		{name: "history usage", from: viewer, text: "/history", want: []string{"Usage: /history TARGET"}},
+		{name: "outages", from: viewer, text: "/outages", want: []string{"🧯 Outages:\n\n✅ https://example.org\n10-19 10:00 – 10-19 10:01 (1m30s)\nstatus 502"}},
+		{name: "no outages", from: viewer, text: "/outages #3",
+			replies: map[string]interface{}{"GET /admin/checks/outages": []checks.Outage{}},
+			want:    []string{"🎉 No outages recorded."}},
+
+		{name: "delete needs operator", from: viewer, text: "/delete #3", want: []string{"needs the operator role"}},
+		{name: "delete", from: operator, text: "/delete #3",
+			replies: map[string]interface{}{"POST /admin/checks/unwatch": map[string]bool{"deleted": true}},
+			want:    []string{"🗑️ Monitor deleted!"}},
+		{name: "unwatch", from: viewer, text: "/unwatch #3",
+			want: []string{"🙈 You no longer watch https://example.org. It is still checked for others and the web team. You still get its alerts through your team"}},
+		{name: "unwatch team", from: viewer, text: "/unwatch team web", want: []string{"🚪 You left the web team."}},
+		{name: "unwatch unknown team", from: viewer, text: "/unwatch team nope",
+			replies: map[string]interface{}{"POST /admin/checks/unwatch": apiStatus{http.StatusNotFound, checks.ErrNoTeam.Error()}},
+			want:    []string{"❌ There is no nope team, see /teams."}},
+		{name: "unwatch other team", from: viewer, text: "/unwatch team db",
+			replies: map[string]interface{}{"POST /admin/checks/unwatch": apiStatus{http.StatusBadRequest, checks.ErrNotMember.Error()}},
+			want:    []string{"❌ You are not in the db team."}},
+		{name: "watch", from: viewer, text: "/watch #3", want: []string{"👀 You are now watching #3: http https://example.org"}},
+		{name: "watch unknown", from: viewer, text: "/watch nope",
+			replies: map[string]interface{}{"GET /admin/checks/find": apiStatus{http.StatusNotFound, "no such check"}},
+			want:    []string{"❌ Nobody monitors nope, see /list all."}},
+		{name: "watch team", from: viewer, text: "/watch team web", want: []string{"👥 You joined the web team and now watch its 4 monitors."}},
+		{name: "watch usage", from: viewer, text: "/watch", want: []string{"Usage: /watch TARGET or /watch team TEAM"}},
+
+		{name: "share needs operator", from: viewer, text: "/share #3 web", want: []string{"needs the operator role"}},
+		{name: "share", from: operator, text: "/share #3 web", want: []string{"🤝 https://example.org is shared with the web team. Others join it with /watch team web"}},
+		{name: "share bad team name", from: operator, text: "/share #3 Web!",
+			replies: map[string]interface{}{"POST /admin/checks/share": apiStatus{http.StatusBadRequest, checks.ErrTeamName.Error()}},
+			want:    []string{"❌ Team names are up to 32 lowercase letters"}},
+		{name: "unshare needs operator", from: viewer, text: "/unshare #3 web", want: []string{"needs the operator role"}},
+		{name: "unshare", from: operator, text: "/unshare #3 web", want: []string{"✂️ https://example.org is no longer shared with web."}},
+		{name: "unshare and delete", from: operator, text: "/unshare #3 web",
+			replies: map[string]interface{}{"POST /admin/checks/unshare": map[string]bool{"deleted": true}},
+			want:    []string{"and was deleted, nobody else watched it."}},
+		{name: "unshare not shared", from: operator, text: "/unshare #3 db",
+			replies: map[string]interface{}{"POST /admin/checks/unshare": apiStatus{http.StatusBadRequest, checks.ErrNotShared.Error()}},
+			want:    []string{"❌ It isn't shared with db."}},
+
+		{name: "uptime", from: viewer, text: "/uptime", want: []string{"📊 Uptime, last 7d\n\n🟠 #3 https://example.org: 99.50%, 1 outages, MTTR 1m30s, p95 40.0 ms\n"}},
+		{name: "uptime of a check", from: viewer, text: "/uptime #3 30d",
+			want: []string{"📊 http https://example.org, last 30d\nUptime: 99.500% (down 1m30s)\nOutages: 1, MTTR 1m30s\nChecks: 200, 3 failed\nLatency: p50 12.0 ms, p95 40.0 ms"}},
+		{name: "uptime too long", from: viewer, text: "/uptime #3 90d", want: []string{"Results are kept for 30 days"}},
+		{name: "summary", from: viewer, text: "/summary", want: []string{"📊 Uptime, last 7d"}},
+		{name: "summary on", from: viewer, text: "/summary on", want: []string{"📬 You'll get an uptime summary every Monday."}},
+		{name: "summary off", from: viewer, text: "/summary off", want: []string{"📭 No more weekly summaries."}},
+		{name: "summary usage", from: viewer, text: "/summary daily", want: []string{"Usage: /summary [on|off]"}},
+		{name: "teams", from: viewer, text: "/teams", want: []string{"👥 Teams:\n▫️ db: 1 members, 1 monitors\n✅ web: 3 members, 4 monitors"}},
+		{name: "list", from: viewer, text: "/list", want: []string{"📋 *Your Monitors:*\n🟢 *UP* - #3 http https://example.org every 30s, 12.0 ms, 👥 web, 👀 2\n"}},
+		{name: "list all", from: viewer, text: "/list all", want: []string{"📋 *All Monitors:*", "#9 tcp db.internal:5432"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for route, reply := range tt.replies {
+				b.api.reply(route, reply)
+			}
+			defer func() {
+				defaults := checkReplies()
+				for route := range tt.replies {
+					b.api.reply(route, defaults[route])
+				}
+			}()
+
+			sent := b.command(t, tt.from, tt.text)
+			if len(sent) == 0 {
+				t.Fatal("nothing sent")
+			}
+			if sent[0].ChatID != int64(tt.from.ID) {
+				t.Errorf("sent to chat %d, want %d", sent[0].ChatID, tt.from.ID)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(sent[0].Text, w) {
+					t.Errorf("reply %q does not contain %q", sent[0].Text, w)
+				}
+			}
+		})
+	}
+}
+
+func TestRequests(t *testing.T) {
+	b := newTestBot(t)
+
+	b.command(t, operator, `/add http https://example.org status=200 contains="Welcome back"`)
+	call := b.api.last(t, "POST /admin/checks")
+	if call.Body["type"] != checks.HTTP || call.Body["target"] != "https://example.org" || call.Body["chat_id"] != 2.0 {
+		t.Errorf("add sent %v", call.Body)
+	}
+	if got, want := call.Body["settings"], []interface{}{"status=200", "contains=Welcome back"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("add sent settings %q, want %q", got, want)
+	}
+
+	b.command(t, operator, "/add example.org:443")
+	if call := b.api.last(t, "POST /admin/checks"); call.Body["type"] != checks.TCP {
+		t.Errorf("add without a type sent %v", call.Body)
+	}
+
+	b.command(t, viewer, "/watch https://example.org")
+	if q := b.api.last(t, "GET /admin/checks/find").Query; q.Get("ref") != "https://example.org" || q.Get("visible") != "false" || q.Get("chat_id") != "3" {
+		t.Errorf("watch looked up %v", q)
+	}
+	if call := b.api.last(t, "POST /admin/checks/watch"); call.Body["id"] != 3.0 || call.Body["chat_id"] != 3.0 {
+		t.Errorf("watch sent %v", call.Body)
+	}
+
+	b.command(t, operator, "/share #3 web")
+	if call := b.api.last(t, "POST /admin/checks/share"); call.Body["id"] != 3.0 || call.Body["team"] != "web" {
+		t.Errorf("share sent %v", call.Body)
+	}
+
+	b.command(t, viewer, "/uptime #3 30d")
+	if q := b.api.last(t, "GET /checks/uptime").Query; q.Get("id") != "3" || q.Get("period") != "720h0m0s" {
+		t.Errorf("uptime asked for %v", q)
+	}
+
+	b.command(t, viewer, "/summary on")
+	if call := b.api.last(t, "POST /admin/checks/summaries"); call.Body["on"] != true || call.Body["chat_id"] != 3.0 {
+		t.Errorf("summary on sent %v", call.Body)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	b := newTestBot(t)
+	userCommandTimes = map[int64]time.Time{}
+	b.srv.SendMessage(viewer, b.srv.PrivateChat(viewer.ID), "/teams")
+	b.srv.SendMessage(viewer, b.srv.PrivateChat(viewer.ID), "/teams")
+	b.deliver(t)
+	sent := b.srv.Sent()
+	if len(sent) != 2 || !strings.Contains(sent[0].Text, "Teams:") || !strings.Contains(sent[1].Text, "Please wait") {
+		t.Errorf("two commands at once: sent %+v", sent)
+	}
+}
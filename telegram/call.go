@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// APIError is an error reply from the Bot API.
+type APIError struct {
+	Code        int
+	Description string
+	// RetryAfter is set on 429 Too Many Requests.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("telegram: %d %s (retry after %s)", e.Code, e.Description, e.RetryAfter)
+	}
+	return fmt.Sprintf("telegram: %d %s", e.Code, e.Description)
+}
+
+// Temporary reports whether the request may succeed if repeated: rate
+// limits and server errors.
+func (e *APIError) Temporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Call invokes a Bot API method with params sent as JSON, and decodes the
+// result into v unless v is nil. Replies that are not ok come back as an
+// *APIError.
+func Call(ctx context.Context, token, method string, params, v interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, MethodURL(token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &APIError{Code: resp.StatusCode, Description: resp.Status}
+		}
+		return fmt.Errorf("telegram: invalid reply: %v", err)
+	}
+	if !reply.OK {
+		code := reply.ErrorCode
+		if code == 0 {
+			code = resp.StatusCode
+		}
+		return &APIError{Code: code, Description: reply.Description, RetryAfter: time.Duration(reply.Parameters.RetryAfter) * time.Second}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, v)
+}
@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// MaxMessageLength is the most characters Telegram accepts in a message.
+const MaxMessageLength = 4096
+
+// Parse modes for the parse_mode parameter.
+const (
+	Markdown   = "Markdown"
+	MarkdownV2 = "MarkdownV2"
+	HTML       = "HTML"
+)
+
+// ParseMode returns the canonical spelling of a parse mode given in any
+// case. The empty string means plain text.
+func ParseMode(s string) (string, error) {
+	for _, mode := range []string{"", Markdown, MarkdownV2, HTML} {
+		if strings.EqualFold(s, mode) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown parse mode %q, want Markdown, MarkdownV2 or HTML", s)
+}
+
+var (
+	markdownEscaper   = strings.NewReplacer("_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`)
+	markdownV2Escaper = func() *strings.Replacer {
+		var pairs []string
+		for _, c := range "\\_*[]()~`>#+-=|{}.!" {
+			pairs = append(pairs, string(c), `\`+string(c))
+		}
+		return strings.NewReplacer(pairs...)
+	}()
+)
+
+// Escape makes s show literally in a message with the given parse mode.
+func Escape(mode, s string) string {
+	switch mode {
+	case Markdown:
+		return markdownEscaper.Replace(s)
+	case MarkdownV2:
+		return markdownV2Escaper.Replace(s)
+	case HTML:
+		return html.EscapeString(s)
+	}
+	return s
+}
+
+// Split cuts text into messages of at most limit characters, breaking at
+// the last blank line, then line, then space before the limit, and only
+// mid-word when there is none. Formatting that spans a break is not
+// repaired, so keep markup within paragraphs.
+func Split(text string, limit int) []string {
+	var parts []string
+	for {
+		r := []rune(text)
+		if len(r) <= limit {
+			if strings.TrimSpace(text) != "" {
+				parts = append(parts, text)
+			}
+			return parts
+		}
+		head := string(r[:limit])
+		cut := len(head)
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			// Don't settle for a break in the first half, it would make
+			// lots of short messages.
+			if i := strings.LastIndex(head, sep); i > len(head)/2 {
+				cut = i + len(sep)
+				break
+			}
+		}
+		// A run of blanks longer than the limit leaves nothing to send,
+		// and Telegram rejects empty messages.
+		if part := strings.TrimRight(text[:cut], "\n "); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+}
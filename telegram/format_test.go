@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", "all good", 10, []string{"all good"}},
+		{"exactly the limit", "0123456789", 10, []string{"0123456789"}},
+		{"empty", "", 10, nil},
+		{"only blanks", " \n\n  \n", 10, nil},
+		{"at a blank line", "aaaa bbb\n\nccc dd", 14, []string{"aaaa bbb", "ccc dd"}},
+		{"blank line before line", "aaaa\nbbbbbb\n\ncc\ndddd", 18, []string{"aaaa\nbbbbbb", "cc\ndddd"}},
+		{"at a line", "aaaa\nbbbbbb\ncc", 12, []string{"aaaa\nbbbbbb", "cc"}},
+		{"line before space", "aaaa bb\nccc ddd", 12, []string{"aaaa bb", "ccc ddd"}},
+		{"at a space", "aaaa bbbb cccc", 12, []string{"aaaa bbbb", "cccc"}},
+		{"mid-word", "abcdefghijklmnopqrstuvwxyz", 10, []string{"abcdefghij", "klmnopqrst", "uvwxyz"}},
+		{"not in the first half", "ab cdefghijklmnop", 10, []string{"ab cdefghi", "jklmnop"}},
+		{"multi-byte mid-word", strings.Repeat("ä", 12), 5, []string{"äääää", "äääää", "ää"}},
+		{"emoji", strings.Repeat("🔥", 7), 3, []string{"🔥🔥🔥", "🔥🔥🔥", "🔥"}},
+		{"multi-byte at a space", "日本語 日本語 日本語", 8, []string{"日本語 日本語", "日本語"}},
+		{"multi-byte at a line", "ÄÖÜ\näöü\nß", 8, []string{"ÄÖÜ\näöü", "ß"}},
+		{"leading newlines dropped", "aaaa bbbb\n\n\n\ncc", 10, []string{"aaaa bbbb", "cc"}},
+		// A run of spaces longer than the limit must not become an empty
+		// message.
+		{"run of spaces", "a" + strings.Repeat(" ", 25) + "b", 10, []string{"a", "      b"}},
+		{"leading run of spaces", strings.Repeat(" ", 25) + "end", 10, []string{"     end"}},
+		{"trailing run of spaces", "end" + strings.Repeat(" ", 25), 10, []string{"end"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			for _, part := range got {
+				if n := utf8.RuneCountInString(part); n > tt.limit || strings.TrimSpace(part) == "" || !utf8.ValidString(part) {
+					t.Errorf("part %q (%d characters)", part, n)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitLong(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 400; i++ {
+		b.WriteString("GPU node-")
+		b.WriteString(strings.Repeat("x", i%7))
+		b.WriteString(" is at 92°C ✓\n")
+		if i%10 == 9 {
+			b.WriteString(strings.Repeat(" ", 5000) + "\n\n")
+		}
+	}
+	text := b.String()
+	parts := Split(text, MaxMessageLength)
+	lines := 0
+	for _, part := range parts {
+		if n := utf8.RuneCountInString(part); n > MaxMessageLength || strings.TrimSpace(part) == "" {
+			t.Errorf("part of %d characters: %.40q...", n, part)
+		}
+		lines += strings.Count(part, "°C ✓")
+	}
+	if lines != 400 {
+		t.Errorf("parts hold %d of 400 lines", lines)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	const text = `node_01 *hot* [a](b) ~x~ ` + "`c`" + ` > #1 + -2 = {3} | 4. ! \ <b>&"'`
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"", text},
+		{Markdown, `node\_01 \*hot\* \[a](b) ~x~ ` + "\\`c\\`" + ` > #1 + -2 = {3} | 4. ! \ <b>&"'`},
+		{MarkdownV2, `node\_01 \*hot\* \[a\]\(b\) \~x\~ ` + "\\`c\\`" + ` \> \#1 \+ \-2 \= \{3\} \| 4\. \! \\ <b\>&"'`},
+		{HTML, `node_01 *hot* [a](b) ~x~ ` + "`c`" + ` &gt; #1 + -2 = {3} | 4. ! \ &lt;b&gt;&amp;&#34;&#39;`},
+	}
+	for _, tt := range tests {
+		if got := Escape(tt.mode, text); got != tt.want {
+			t.Errorf("Escape(%q)\n got %s\nwant %s", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]string{"": "", "markdown": Markdown, "MARKDOWNV2": MarkdownV2, "html": HTML} {
+		if got, err := ParseMode(in); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseMode("bbcode"); err == nil {
+		t.Error("ParseMode accepts bbcode")
+	}
+}
@@ -0,0 +1,76 @@
+// Package telegram is the seam between the bots and the Telegram Bot API.
+// The bots send through the Messenger interface rather than a concrete
+// *tgbotapi.BotAPI, and every program reaches the API at the base URL in
+// TELEGRAM_API_URL, so they can be pointed at a self-hosted Bot API server
+// or at the fake one in package telegramtest.
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// DefaultAPIURL is used when TELEGRAM_API_URL is not set.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// Messenger is what a bot needs to reply to updates. *tgbotapi.BotAPI
+// implements it.
+type Messenger interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+}
+
+// APIURL returns the Bot API base URL from TELEGRAM_API_URL, without a
+// trailing slash.
+func APIURL() string {
+	if u := os.Getenv("TELEGRAM_API_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return DefaultAPIURL
+}
+
+// MethodURL is the URL of a Bot API method, e.g. sendMessage, for programs
+// that call the API directly.
+func MethodURL(token, method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", APIURL(), token, method)
+}
+
+// NewBot connects to the Bot API at APIURL.
+func NewBot(token string) (*tgbotapi.BotAPI, error) {
+	return NewBotAt(APIURL(), token)
+}
+
+// NewBotAt connects to the Bot API at base. The library has the official
+// endpoint built in, so its requests are redirected by the HTTP client.
+func NewBotAt(base, token string) (*tgbotapi.BotAPI, error) {
+	if base == DefaultAPIURL {
+		return tgbotapi.NewBotAPI(token)
+	}
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Bot API URL %q", base)
+	}
+	client := &http.Client{Transport: rewrite{base: u, next: http.DefaultTransport}}
+	return tgbotapi.NewBotAPIWithClient(token, client)
+}
+
+// rewrite sends requests for the official API to another base URL,
+// keeping the "/botTOKEN/method" path.
+type rewrite struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t rewrite) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.base.Scheme
+	r.URL.Host = t.base.Host
+	r.URL.Path = strings.TrimRight(t.base.Path, "/") + r.URL.Path
+	r.Host = t.base.Host
+	return t.next.RoundTrip(r)
+}
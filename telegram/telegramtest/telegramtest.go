@@ -0,0 +1,482 @@
+// Package telegramtest is an in-process fake of the Telegram Bot API for
+// exercising the bots without Telegram. It serves the methods the bots
+// use, records what they send and lets the caller inject messages and
+// button presses as updates:
+//
+//	srv := telegramtest.NewServer()
+//	defer srv.Close()
+//	bot, _ := srv.Bot()
+//	go run(bot)
+//	srv.SendMessage(srv.User(42, "alice"), srv.PrivateChat(42), "/start")
+//	sent, err := srv.WaitSent(1, time.Second)
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"gpu-monitor/telegram"
+)
+
+// Token is accepted by the fake. Requests with any other token get a 401
+// like the real API gives for a revoked one.
+const Token = "123456:TEST"
+
+// Sent is a request a bot made that puts something in a chat or changes
+// a message: sendMessage, sendPhoto, sendDocument or editMessageText.
+type Sent struct {
+	Method    string
+	ChatID    int64
+	MessageID int // the new message's ID, or the edited one's
+	Text      string
+	ParseMode string
+	Markup    *tgbotapi.InlineKeyboardMarkup
+	File      []byte // the upload of sendPhoto and sendDocument
+	Params    url.Values
+}
+
+// Buttons returns the callback data of the message's inline keyboard,
+// row by row.
+func (s Sent) Buttons() [][]string {
+	if s.Markup == nil {
+		return nil
+	}
+	var rows [][]string
+	for _, row := range s.Markup.InlineKeyboard {
+		var data []string
+		for _, b := range row {
+			if b.CallbackData != nil {
+				data = append(data, *b.CallbackData)
+			}
+		}
+		rows = append(rows, data)
+	}
+	return rows
+}
+
+// Answer is an answerCallbackQuery request.
+type Answer struct {
+	CallbackQueryID string
+	Text            string
+	ShowAlert       bool
+}
+
+type Server struct {
+	URL string
+	Me  tgbotapi.User
+
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	changed     chan struct{} // closed and replaced whenever state changes
+	updates     []tgbotapi.Update
+	nextUpdate  int
+	nextMessage int
+	nextQuery   int
+	sent        []Sent
+	answers     []Answer
+	failures    map[string]int // method -> HTTP status to fail with once
+}
+
+// NewServer starts a fake Bot API server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		Me:          tgbotapi.User{ID: 123456, FirstName: "Test Bot", UserName: "test_bot", IsBot: true},
+		changed:     make(chan struct{}),
+		nextUpdate:  1,
+		nextMessage: 1,
+		failures:    map[string]int{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.srv.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Bot returns a client of the fake server logged in with Token.
+func (s *Server) Bot() (*tgbotapi.BotAPI, error) {
+	return telegram.NewBotAt(s.URL, Token)
+}
+
+// User returns a user to send messages as.
+func (s *Server) User(id int, username string) tgbotapi.User {
+	return tgbotapi.User{ID: id, FirstName: username, UserName: username}
+}
+
+// PrivateChat is the chat between the bot and a user.
+func (s *Server) PrivateChat(userID int) tgbotapi.Chat {
+	return tgbotapi.Chat{ID: int64(userID), Type: "private"}
+}
+
+// GroupChat is a group the bot is in. Telegram gives groups negative IDs.
+func (s *Server) GroupChat(id int64, title string) tgbotapi.Chat {
+	return tgbotapi.Chat{ID: id, Type: "group", Title: title}
+}
+
+// SendMessage queues a message from a user as an update for the bot's
+// next getUpdates. A leading "/command" is marked as a bot command.
+func (s *Server) SendMessage(from tgbotapi.User, chat tgbotapi.Chat, text string) tgbotapi.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg := s.message(&from, chat, text)
+	if strings.HasPrefix(text, "/") {
+		n := strings.IndexAny(text, " \n")
+		if n < 0 {
+			n = len(text)
+		}
+		msg.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: n}}
+	}
+	s.push(tgbotapi.Update{Message: msg})
+	return *msg
+}
+
+// PressButton queues a callback query as if from pressed an inline
+// button with the given data under a message the bot sent. It returns
+// the query ID, which the bot's answer will carry.
+func (s *Server) PressButton(from tgbotapi.User, on Sent, data string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextQuery++
+	id := strconv.Itoa(s.nextQuery)
+	msg := &tgbotapi.Message{
+		MessageID: on.MessageID,
+		From:      &s.Me,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: on.ChatID, Type: chatType(on.ChatID)},
+		Text:      on.Text,
+	}
+	s.push(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:           id,
+		From:         &from,
+		Message:      msg,
+		ChatInstance: strconv.FormatInt(on.ChatID, 10),
+		Data:         data,
+	}})
+	return id
+}
+
+// Fail makes the next call of a method fail with an HTTP status, e.g.
+// 429 to exercise retries.
+func (s *Server) Fail(method string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = status
+}
+
+// Sent returns everything the bots have sent so far.
+func (s *Server) Sent() []Sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sent(nil), s.sent...)
+}
+
+// Answers returns the callback query answers so far.
+func (s *Server) Answers() []Answer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Answer(nil), s.answers...)
+}
+
+// Pending reports how many injected updates the bot has not fetched yet.
+func (s *Server) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.updates)
+}
+
+// Reset forgets what has been sent and answered.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent, s.answers = nil, nil
+}
+
+// WaitSent waits until at least n messages have been sent and returns
+// all of them.
+func (s *Server) WaitSent(n int, timeout time.Duration) ([]Sent, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		sent, changed := append([]Sent(nil), s.sent...), s.changed
+		s.mu.Unlock()
+		if len(sent) >= n {
+			return sent, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return sent, fmt.Errorf("telegramtest: %d messages sent after %s, want %d", len(sent), timeout, n)
+		}
+	}
+}
+
+// WaitAnswers is WaitSent for callback query answers.
+func (s *Server) WaitAnswers(n int, timeout time.Duration) ([]Answer, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		answers, changed := append([]Answer(nil), s.answers...), s.changed
+		s.mu.Unlock()
+		if len(answers) >= n {
+			return answers, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return answers, fmt.Errorf("telegramtest: %d callback answers after %s, want %d", len(answers), timeout, n)
+		}
+	}
+}
+
+func chatType(id int64) string {
+	if id < 0 {
+		return "group"
+	}
+	return "private"
+}
+
+// message builds a message with the next ID. s.mu must be held.
+func (s *Server) message(from *tgbotapi.User, chat tgbotapi.Chat, text string) *tgbotapi.Message {
+	id := s.nextMessage
+	s.nextMessage++
+	return &tgbotapi.Message{MessageID: id, From: from, Date: int(time.Now().Unix()), Chat: &chat, Text: text}
+}
+
+// push queues an update. s.mu must be held.
+func (s *Server) push(u tgbotapi.Update) {
+	u.UpdateID = s.nextUpdate
+	s.nextUpdate++
+	s.updates = append(s.updates, u)
+	s.notify()
+}
+
+// notify wakes up long polls and waiters. s.mu must be held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+type response struct {
+	OK          bool        `json:"ok"`
+	Result      interface{} `json:"result,omitempty"`
+	ErrorCode   int         `json:"error_code,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+func reply(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{OK: true, Result: result})
+}
+
+func fail(w http.ResponseWriter, code int, description string) {
+	resp := response{ErrorCode: code, Description: description}
+	if code == http.StatusTooManyRequests {
+		resp.Parameters = map[string]int{"retry_after": 1}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serve handles "/botTOKEN/METHOD" with parameters as a query string, a
+// form, a multipart form or a JSON object, like the real API.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	token, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+	if !ok || !strings.HasPrefix(r.URL.Path, "/bot") {
+		fail(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if token != Token {
+		fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	params, file, err := readParams(r)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	status, failing := s.failures[method]
+	delete(s.failures, method)
+	s.mu.Unlock()
+	if failing {
+		fail(w, status, http.StatusText(status))
+		return
+	}
+
+	switch method {
+	case "getMe":
+		reply(w, s.Me)
+	case "getUpdates":
+		s.getUpdates(w, r, params)
+	case "sendMessage", "sendPhoto", "sendDocument", "editMessageText":
+		s.send(w, method, params, file)
+	case "answerCallbackQuery":
+		s.mu.Lock()
+		s.answers = append(s.answers, Answer{
+			CallbackQueryID: params.Get("callback_query_id"),
+			Text:            params.Get("text"),
+			ShowAlert:       params.Get("show_alert") == "true",
+		})
+		s.notify()
+		s.mu.Unlock()
+		reply(w, true)
+	case "deleteWebhook", "setMyCommands", "sendChatAction":
+		reply(w, true)
+	default:
+		fail(w, http.StatusNotFound, "Not Found: method "+method+" is not faked")
+	}
+}
+
+// getUpdates returns the queued updates after offset, waiting up to
+// the requested timeout for one to arrive.
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request, params url.Values) {
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	timeout, _ := strconv.Atoi(params.Get("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		s.mu.Lock()
+		// Like the real API, asking for an offset confirms everything
+		// before it.
+		for len(s.updates) > 0 && s.updates[0].UpdateID < offset {
+			s.updates = s.updates[1:]
+		}
+		updates, changed := append([]tgbotapi.Update{}, s.updates...), s.changed
+		s.mu.Unlock()
+		if len(updates) > 0 || timeout <= 0 {
+			reply(w, updates)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			reply(w, updates)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) send(w http.ResponseWriter, method string, params url.Values, file []byte) {
+	chatID, err := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "Bad Request: chat not found")
+		return
+	}
+	text := params.Get("text")
+	if method == "sendPhoto" || method == "sendDocument" {
+		text = params.Get("caption")
+		if file == nil {
+			fail(w, http.StatusBadRequest, "Bad Request: there is no file in the request")
+			return
+		}
+	} else if text == "" {
+		fail(w, http.StatusBadRequest, "Bad Request: message text is empty")
+		return
+	}
+	if len([]rune(text)) > 4096 {
+		fail(w, http.StatusBadRequest, "Bad Request: message is too long")
+		return
+	}
+	sent := Sent{Method: method, ChatID: chatID, Text: text, ParseMode: params.Get("parse_mode"), File: file, Params: params}
+	if markup := params.Get("reply_markup"); markup != "" {
+		var m tgbotapi.InlineKeyboardMarkup
+		if err := json.Unmarshal([]byte(markup), &m); err != nil {
+			fail(w, http.StatusBadRequest, "Bad Request: can't parse reply keyboard markup JSON object")
+			return
+		}
+		for _, row := range m.InlineKeyboard {
+			for _, b := range row {
+				if b.CallbackData != nil && len(*b.CallbackData) > 64 {
+					fail(w, http.StatusBadRequest, "Bad Request: BUTTON_DATA_INVALID")
+					return
+				}
+			}
+		}
+		sent.Markup = &m
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chat := tgbotapi.Chat{ID: chatID, Type: chatType(chatID)}
+	var msg *tgbotapi.Message
+	if method == "editMessageText" {
+		id, _ := strconv.Atoi(params.Get("message_id"))
+		msg = &tgbotapi.Message{MessageID: id, From: &s.Me, Date: int(time.Now().Unix()), Chat: &chat, Text: text}
+	} else {
+		msg = s.message(&s.Me, chat, text)
+	}
+	sent.MessageID = msg.MessageID
+	s.sent = append(s.sent, sent)
+	s.notify()
+	reply(w, msg)
+}
+
+// readParams collects the request's parameters and the first uploaded
+// file, if any.
+func readParams(r *http.Request) (url.Values, []byte, error) {
+	params := r.URL.Query()
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch ct {
+	case "application/json":
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, nil, err
+		}
+		for k, v := range body {
+			switch v := v.(type) {
+			case string:
+				params.Set(k, v)
+			case float64:
+				params.Set(k, strconv.FormatFloat(v, 'f', -1, 64))
+			case bool:
+				params.Set(k, strconv.FormatBool(v))
+			default:
+				b, _ := json.Marshal(v)
+				params.Set(k, string(b))
+			}
+		}
+		return params, nil, nil
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, nil, err
+		}
+		for k, v := range r.MultipartForm.Value {
+			params[k] = v
+		}
+		for _, files := range r.MultipartForm.File {
+			f, err := files[0].Open()
+			if err != nil {
+				return nil, nil, err
+			}
+			defer f.Close()
+			b, err := io.ReadAll(f)
+			return params, b, err
+		}
+		return params, nil, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, err
+	}
+	for k, v := range r.PostForm {
+		params[k] = v
+	}
+	return params, nil, nil
+}
@@ -0,0 +1,310 @@
+package top
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/labels"
+	"gpu-monitor/report"
+)
+
+const (
+	reset   = "\x1b[0m"
+	bold    = "\x1b[1m"
+	dim     = "\x1b[2m"
+	inverse = "\x1b[7m"
+	red     = "\x1b[31m"
+	yellow  = "\x1b[33m"
+	green   = "\x1b[32m"
+	cyan    = "\x1b[36m"
+)
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws the last width values scaled between lo and hi.
+func sparkline(values []float64, width int, lo, hi float64) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	var b strings.Builder
+	for i := len(values); i < width; i++ {
+		b.WriteByte(' ')
+	}
+	for _, v := range values {
+		i := int((v - lo) / (hi - lo) * float64(len(sparkChars)-1))
+		i = max(0, min(len(sparkChars)-1, i))
+		b.WriteRune(sparkChars[i])
+	}
+	return b.String()
+}
+
+// fit pads or cuts s to exactly width cells. It assumes s has no escape
+// sequences and no double-width characters.
+func fit(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		r := []rune(s)
+		if width <= 1 {
+			return string(r[:width])
+		}
+		return string(r[:width-1]) + "…"
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+func severityColor(list []alerts.Alert) string {
+	color := ""
+	for _, a := range list {
+		if a.Severity == alerts.Critical {
+			return red
+		}
+		color = yellow
+	}
+	return color
+}
+
+func (m *model) render(width, height int) string {
+	var lines []string
+	add := func(color, s string) {
+		s = fit(s, width)
+		if color != "" {
+			s = color + s + reset
+		}
+		lines = append(lines, s)
+	}
+
+	byGPU, byHost := m.gpuAlerts()
+	critical, warning := 0, 0
+	for _, a := range m.snap.health.Alerts {
+		if a.Severity == alerts.Critical {
+			critical++
+		} else {
+			warning++
+		}
+	}
+
+	// Header.
+	hosts := map[string]bool{}
+	for _, g := range m.snap.gpus {
+		hosts[g.Host()] = true
+	}
+	health, healthWidth := green+"healthy"+reset, len("healthy")
+	if critical > 0 || warning > 0 {
+		health = fmt.Sprintf("%s%d critical%s %s%d warning%s", red, critical, reset, yellow, warning, reset)
+		healthWidth = len(fmt.Sprintf("%d critical %d warning", critical, warning))
+	}
+	// The health summary always shows, the header gives way on narrow
+	// terminals so the line does not wrap.
+	header := fmt.Sprintf("gpumon top  %s  %d GPUs on %d hosts  ", m.server, len(m.snap.gpus), len(hosts))
+	if n := utf8.RuneCountInString(header); n+healthWidth > width {
+		header = fit(header, max(0, width-healthWidth-2)) + "  "
+	}
+	lines = append(lines, bold+header+reset+health+"\x1b[K")
+
+	status := fmt.Sprintf("sort: %s %s", sortKeys[m.sortKey], map[bool]string{true: "↓", false: "↑"}[m.reverse])
+	if m.filter != "" {
+		status += "  filter: " + m.filter
+	}
+	if m.selector != "" {
+		status += "  labels: " + m.selector
+	}
+	if m.onlyAlerts {
+		status += "  only GPUs with alerts"
+	}
+	if !m.snap.at.IsZero() {
+		status += "  updated " + m.snap.at.Format("15:04:05")
+	}
+	if m.snap.err != nil {
+		add(red, status+"  error: "+m.snap.err.Error())
+	} else {
+		add(dim, status)
+	}
+	add("", "")
+
+	body := height - len(lines) - 2
+	if m.detail != "" {
+		lines = append(lines, m.renderDetail(width, body, byGPU)...)
+	} else {
+		lines = append(lines, m.renderList(width, body, byGPU, byHost)...)
+	}
+
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	if m.prompt != nil {
+		add(cyan, m.prompt.label+": "+string(m.prompt.buf)+"█")
+	} else if m.detail != "" {
+		add(inverse, " esc back  q quit")
+	} else {
+		add(inverse, " ↑↓ move  enter details  s sort  r reverse  / filter  l labels  a alerts only  q quit")
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range lines[:height] {
+		b.WriteString(l)
+		b.WriteString("\x1b[K")
+		if i < height-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	return b.String()
+}
+
+func (m *model) renderList(width, height int, byGPU, byHost map[string][]alerts.Alert) []string {
+	spark := 10
+	if width >= 140 {
+		spark = 24
+	} else if width >= 120 {
+		spark = 16
+	}
+	cols := fmt.Sprintf("%-14s %3s %-24s %5s %-*s %5s %-*s %-19s %7s %5s",
+		"HOST", "GPU", "MODEL", "UTIL", spark, "", "TEMP", spark, "", "MEMORY", "POWER", "PROCS")
+	out := []string{bold + fit(cols, width) + reset}
+
+	rows := m.rows()
+	if m.cursor >= len(rows) {
+		m.cursor = max(0, len(rows)-1)
+	}
+
+	// Room for the alert summary under the table.
+	var alertLines []string
+	for host, list := range byHost {
+		for _, a := range list {
+			alertLines = append(alertLines, severityColor([]alerts.Alert{a})+fit("  "+host+": "+a.Message, width)+reset)
+		}
+	}
+	for _, g := range rows {
+		for _, a := range byGPU[g.Name] {
+			alertLines = append(alertLines, severityColor([]alerts.Alert{a})+fit("  "+a.Message, width)+reset)
+		}
+	}
+	maxAlerts := min(len(alertLines), max(0, height/3))
+	visible := height - 1
+	if maxAlerts > 0 {
+		visible -= maxAlerts + 2
+	}
+
+	// Scroll so the cursor stays on screen.
+	start := 0
+	if m.cursor >= visible {
+		start = m.cursor - visible + 1
+	}
+	for i := start; i < len(rows) && i < start+visible; i++ {
+		g := rows[i]
+		model := g.Name
+		if parts := strings.SplitN(g.Name, "@", 3); len(parts) == 3 {
+			model = parts[2]
+		}
+		if g.MIGMode {
+			model += " (MIG)"
+		}
+		line := fmt.Sprintf("%-14s %3d %-24s %4d%% %s %4d° %s %-19s %6.0fW %5d",
+			fit(g.Host(), 14), g.Index, fit(model, 24),
+			g.UtilizationGpuPercent, sparkline(m.utilHist[g.Name], spark, 0, 100),
+			g.TemperatureC, sparkline(m.tempHist[g.Name], spark, 20, 100),
+			fmt.Sprintf("%d/%d MiB", g.MemoryUsedMiB, g.MemoryTotalMiB),
+			g.PowerWatt, g.ProcessCount)
+		line = fit(line, width)
+
+		color := severityColor(byGPU[g.Name])
+		if i == m.cursor {
+			color += inverse
+		}
+		if color != "" {
+			line = color + line + reset
+		}
+		out = append(out, line)
+	}
+	if len(rows) == 0 {
+		out = append(out, dim+fit("  no GPUs match", width)+reset)
+	}
+
+	if maxAlerts > 0 {
+		for len(out) < visible+1 {
+			out = append(out, "")
+		}
+		out = append(out, "", bold+fit(fmt.Sprintf("ALERTS (%d)", len(alertLines)), width)+reset)
+		out = append(out, alertLines[:maxAlerts]...)
+	}
+	return out
+}
+
+func (m *model) renderDetail(width, height int, byGPU map[string][]alerts.Alert) []string {
+	var g *report.GPUReport
+	for i := range m.snap.gpus {
+		if m.snap.gpus[i].Name == m.detail {
+			g = &m.snap.gpus[i]
+		}
+	}
+	if g == nil {
+		return []string{red + fit("  "+m.detail+" is no longer reported", width) + reset}
+	}
+
+	var out []string
+	line := func(format string, args ...interface{}) {
+		out = append(out, fit(fmt.Sprintf(format, args...), width))
+	}
+	section := func(title string) {
+		out = append(out, "", bold+fit(title, width)+reset)
+	}
+
+	out = append(out, bold+fit(g.Name, width)+reset)
+	line("  vendor %s  uuid %s  bus %s  labels %s", g.Vendor, g.UUID, g.PCIBusID, labels.Format(g.Labels))
+	updated, _ := time.Parse(time.RFC3339, g.UpdatedAt)
+	line("  updated %s (%s ago)", g.UpdatedAt, time.Since(updated).Round(time.Second))
+	for _, h := range m.snap.hosts {
+		if h.Hostname == g.Host() {
+			line("  host %s  CPU %.0f%%  memory %d/%d MB  disk %s/%s", h.Hostname, h.CPUUsagePercent, h.MemoryUsedMB, h.MemoryTotalMB, h.DiskUsed, h.DiskTotal)
+		}
+	}
+
+	spark := max(10, width-24)
+	section("Utilization")
+	line("  %3d%%  %s", g.UtilizationGpuPercent, sparkline(m.utilHist[g.Name], spark, 0, 100))
+	section("Temperature")
+	line("  %3d°C %s", g.TemperatureC, sparkline(m.tempHist[g.Name], spark, 20, 100))
+
+	section("Details")
+	line("  fan %d%%  power %.0f/%.0f W  clocks SM %d MHz, mem %d MHz", g.FanPercent, g.PowerWatt, g.PowerLimitWatt, g.ClockSMMHz, g.ClockMemMHz)
+	line("  memory %d/%d MiB  PCIe gen %d/%d x%d/x%d, %d replays", g.MemoryUsedMiB, g.MemoryTotalMiB, g.PCIeGen, g.PCIeGenMax, g.PCIeWidth, g.PCIeWidthMax, g.PCIeReplayCount)
+	line("  ECC volatile %d corrected, %d uncorrected  retired pages %d SBE, %d DBE", g.ECCVolatileCorrected, g.ECCVolatileUncorrected, g.RetiredPagesSingleBit, g.RetiredPagesDoubleBit)
+	if len(g.ThrottleReasons) > 0 {
+		line("  throttled: %s", strings.Join(g.ThrottleReasons, ", "))
+	}
+	if len(g.XIDErrors) > 0 {
+		line("  XID errors: %v", g.XIDErrors)
+	}
+
+	if list := byGPU[g.Name]; len(list) > 0 {
+		section("Alerts")
+		for _, a := range list {
+			out = append(out, severityColor([]alerts.Alert{a})+fit("  "+string(a.Severity)+": "+a.Message, width)+reset)
+		}
+	}
+
+	section(fmt.Sprintf("Processes (%d)", g.ProcessCount))
+	if len(g.MIGInstances) == 0 {
+		for _, p := range strings.Split(g.ProcessNames, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				line("  %s", p)
+			}
+		}
+	}
+	for _, mig := range g.MIGInstances {
+		line("  %s GI %d/CI %d  %d/%d MiB", mig.Profile, mig.GPUInstanceID, mig.ComputeInstanceID, mig.MemoryUsedMiB, mig.MemoryTotalMiB)
+		for _, p := range strings.Split(mig.ProcessNames, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				line("    %s", p)
+			}
+		}
+	}
+
+	if len(out) > height {
+		out = out[:height]
+	}
+	return out
+}
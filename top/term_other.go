@@ -0,0 +1,15 @@
+//go:build !linux
+
+package top
+
+import "errors"
+
+type termState struct{}
+
+var errUnsupported = errors.New("gpumon top needs a Linux terminal")
+
+func makeRaw(fd int) (*termState, error) { return nil, errUnsupported }
+
+func restore(fd int, state *termState) error { return nil }
+
+func size(fd int) (int, int, error) { return 0, 0, errUnsupported }
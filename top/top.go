@@ -0,0 +1,395 @@
+// Package top is a full-screen terminal dashboard for the fleet, in the
+// spirit of top and nvidia-smi. It polls the server's list and health
+// endpoints, keeps a short history per GPU for sparklines and lets the
+// user sort, filter and drill into a GPU.
+package top
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/api"
+	"gpu-monitor/labels"
+	"gpu-monitor/report"
+)
+
+type Options struct {
+	Interval time.Duration
+	Selector string // label selector sent to the server
+	Filter   string // substring matched against GPU names
+}
+
+// historyLen is how many samples are kept per GPU for the sparklines.
+const historyLen = 120
+
+var sortKeys = []string{"host", "util", "temp", "mem", "power", "alerts"}
+
+type snapshot struct {
+	gpus   []report.GPUReport
+	hosts  []report.HostReport
+	health api.Health
+	err    error
+	at     time.Time
+}
+
+type model struct {
+	server   string
+	interval time.Duration
+	snap     snapshot
+
+	utilHist map[string][]float64
+	tempHist map[string][]float64
+
+	sortKey    int
+	reverse    bool
+	filter     string
+	selector   string
+	onlyAlerts bool
+
+	cursor int
+	detail string // name of the GPU being inspected, "" in the list view
+
+	// prompt is non-nil while the user edits the filter or selector.
+	prompt *prompt
+}
+
+type prompt struct {
+	label  string
+	target *string
+	buf    []rune
+}
+
+// Run takes over the terminal until the user quits.
+func Run(c *api.Client, opts Options) error {
+	fd := int(os.Stdin.Fd())
+	state, err := makeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("not a terminal: %w", err)
+	}
+	defer restore(fd, state)
+
+	out := bufio.NewWriter(os.Stdout)
+	// Alternate screen and hidden cursor, undone on the way out.
+	out.WriteString("\x1b[?1049h\x1b[?25l")
+	out.Flush()
+	defer func() {
+		out.WriteString("\x1b[?25h\x1b[?1049l")
+		out.Flush()
+	}()
+
+	m := &model{
+		server:   c.BaseURL,
+		interval: opts.Interval,
+		utilHist: map[string][]float64{},
+		tempHist: map[string][]float64{},
+		sortKey:  1,
+		reverse:  true,
+		filter:   opts.Filter,
+		selector: opts.Selector,
+	}
+	m.seed(c)
+
+	// Fetches run in the background so a slow server never blocks the
+	// keyboard. Each request carries the selector current at the time.
+	requests := make(chan string, 1)
+	snapshots := make(chan snapshot)
+	go func() {
+		for selector := range requests {
+			snapshots <- fetch(c, selector)
+		}
+	}()
+	requests <- m.selector
+
+	keys := make(chan string)
+	go readKeys(keys)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case s := <-snapshots:
+			m.apply(s)
+		case <-ticker.C:
+			select {
+			case requests <- m.selector:
+			default: // a fetch is still running
+			}
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			oldSelector := m.selector
+			if quit := m.handleKey(k); quit {
+				return nil
+			}
+			if m.selector != oldSelector {
+				m.utilHist = map[string][]float64{}
+				m.tempHist = map[string][]float64{}
+				select {
+				case requests <- m.selector:
+				default:
+				}
+			}
+		}
+
+		w, h, err := size(fd)
+		if err != nil || w < 40 || h < 10 {
+			w, h = 120, 40
+		}
+		out.WriteString(m.render(w, h))
+		out.Flush()
+	}
+}
+
+func fetch(c *api.Client, selector string) snapshot {
+	s := snapshot{at: time.Now()}
+	if s.gpus, s.err = c.GPUs(selector); s.err != nil {
+		return s
+	}
+	if s.hosts, s.err = c.Hosts(selector); s.err != nil {
+		return s
+	}
+	s.health, s.err = c.Health(selector)
+	return s
+}
+
+// seed fills the sparklines from the server's history so they are not
+// empty on start.
+func (m *model) seed(c *api.Client) {
+	history, err := c.GPUHistory(api.HistoryQuery{
+		Selector: m.selector,
+		Since:    m.interval * historyLen,
+		Step:     m.interval,
+	})
+	if err != nil {
+		return
+	}
+	for _, h := range history {
+		for _, s := range h.Samples {
+			m.utilHist[h.Name] = push(m.utilHist[h.Name], float64(s.UtilizationGpuPercent))
+			m.tempHist[h.Name] = push(m.tempHist[h.Name], float64(s.TemperatureC))
+		}
+	}
+}
+
+func (m *model) apply(s snapshot) {
+	if s.err != nil {
+		// Keep showing the last good data, with the error in the header.
+		m.snap.err = s.err
+		return
+	}
+	m.snap = s
+	for _, g := range s.gpus {
+		m.utilHist[g.Name] = push(m.utilHist[g.Name], float64(g.UtilizationGpuPercent))
+		m.tempHist[g.Name] = push(m.tempHist[g.Name], float64(g.TemperatureC))
+	}
+}
+
+func push(hist []float64, v float64) []float64 {
+	hist = append(hist, v)
+	if len(hist) > historyLen {
+		hist = hist[len(hist)-historyLen:]
+	}
+	return hist
+}
+
+// gpuAlerts returns the health check alerts keyed by GPU name, and the
+// host-level ones keyed by host.
+func (m *model) gpuAlerts() (map[string][]alerts.Alert, map[string][]alerts.Alert) {
+	byGPU := map[string][]alerts.Alert{}
+	byHost := map[string][]alerts.Alert{}
+	for _, a := range m.snap.health.Alerts {
+		if a.GPU != "" {
+			byGPU[a.GPU] = append(byGPU[a.GPU], a)
+		} else {
+			byHost[a.Host] = append(byHost[a.Host], a)
+		}
+	}
+	return byGPU, byHost
+}
+
+// rows returns the GPUs to show, filtered and sorted.
+func (m *model) rows() []report.GPUReport {
+	byGPU, _ := m.gpuAlerts()
+	var rows []report.GPUReport
+	for _, g := range m.snap.gpus {
+		if m.filter != "" && !matchesFilter(g, m.filter) {
+			continue
+		}
+		if m.onlyAlerts && len(byGPU[g.Name]) == 0 {
+			continue
+		}
+		rows = append(rows, g)
+	}
+
+	key := sortKeys[m.sortKey]
+	value := func(g report.GPUReport) float64 {
+		switch key {
+		case "util":
+			return float64(g.UtilizationGpuPercent)
+		case "temp":
+			return float64(g.TemperatureC)
+		case "mem":
+			return float64(g.MemoryUsedMiB)
+		case "power":
+			return g.PowerWatt
+		case "alerts":
+			return float64(len(byGPU[g.Name]))
+		}
+		return 0
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if key == "host" {
+			if a.Host() != b.Host() {
+				return (a.Host() < b.Host()) != m.reverse
+			}
+			return a.Index < b.Index
+		}
+		if value(a) != value(b) {
+			return (value(a) < value(b)) != m.reverse
+		}
+		return a.Name < b.Name
+	})
+	return rows
+}
+
+// matchesFilter reports whether the GPU's name, which includes its host,
+// or one of its label values contains filter.
+func matchesFilter(g report.GPUReport, filter string) bool {
+	filter = strings.ToLower(filter)
+	if strings.Contains(strings.ToLower(g.Name), filter) {
+		return true
+	}
+	for _, v := range g.Labels {
+		if strings.Contains(strings.ToLower(v), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleKey applies a key press and reports whether to quit.
+func (m *model) handleKey(k string) bool {
+	if p := m.prompt; p != nil {
+		switch k {
+		case "enter":
+			value := string(p.buf)
+			if p.target == &m.selector {
+				if _, err := labels.Parse(value); err != nil {
+					p.label = "Invalid selector, try again"
+					return false
+				}
+			}
+			*p.target = value
+			m.prompt = nil
+			m.cursor = 0
+		case "esc":
+			m.prompt = nil
+		case "backspace":
+			if len(p.buf) > 0 {
+				p.buf = p.buf[:len(p.buf)-1]
+			}
+		default:
+			if r := []rune(k); len(r) == 1 && r[0] >= ' ' {
+				p.buf = append(p.buf, r[0])
+			}
+		}
+		return false
+	}
+
+	rows := m.rows()
+	switch k {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(rows)-1 {
+			m.cursor++
+		}
+	case "pgup":
+		m.cursor = max(0, m.cursor-10)
+	case "pgdn":
+		m.cursor = max(0, min(len(rows)-1, m.cursor+10))
+	case "home", "g":
+		m.cursor = 0
+	case "end", "G":
+		m.cursor = max(0, len(rows)-1)
+	case "enter":
+		if m.detail == "" && m.cursor < len(rows) {
+			m.detail = rows[m.cursor].Name
+		}
+	case "esc", "backspace":
+		m.detail = ""
+	case "s":
+		m.sortKey = (m.sortKey + 1) % len(sortKeys)
+		// Numbers read best biggest first, hosts alphabetically.
+		m.reverse = sortKeys[m.sortKey] != "host"
+	case "r":
+		m.reverse = !m.reverse
+	case "a":
+		m.onlyAlerts = !m.onlyAlerts
+		m.cursor = 0
+	case "/":
+		m.prompt = &prompt{label: "Filter GPUs by host, name or label value", target: &m.filter, buf: []rune(m.filter)}
+	case "l":
+		m.prompt = &prompt{label: "Label selector", target: &m.selector, buf: []rune(m.selector)}
+	}
+	return false
+}
+
+// readKeys turns terminal input into key names: arrows and a few other
+// escape sequences by name, everything else as the character typed.
+func readKeys(keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		in := string(buf[:n])
+		for len(in) > 0 {
+			key, rest := nextKey(in)
+			keys <- key
+			in = rest
+		}
+	}
+}
+
+var escapes = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1bOA": "up", "\x1bOB": "down",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+}
+
+func nextKey(in string) (string, string) {
+	if in[0] == 0x1b {
+		for seq, name := range escapes {
+			if strings.HasPrefix(in, seq) {
+				return name, in[len(seq):]
+			}
+		}
+		return "esc", in[1:]
+	}
+	switch in[0] {
+	case '\r', '\n':
+		return "enter", in[1:]
+	case 0x7f, 0x08:
+		return "backspace", in[1:]
+	case 0x03:
+		return "ctrl-c", in[1:]
+	}
+	r := []rune(in)
+	return string(r[0]), string(r[1:])
+}
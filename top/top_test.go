@@ -0,0 +1,373 @@
+package top
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"gpu-monitor/alerts"
+	"gpu-monitor/api"
+	"gpu-monitor/report"
+)
+
+func gpu(name string, util, temp int, labels map[string]string) report.GPUReport {
+	g := report.GPUReport{Name: name, UtilizationGpuPercent: util, TemperatureC: temp, Labels: labels,
+		MemoryUsedMiB: util * 800, MemoryTotalMiB: 81920, PowerWatt: float64(util) * 7, UpdatedAt: "2026-10-19T10:00:00Z"}
+	if parts := strings.SplitN(name, "@", 3); len(parts) == 3 {
+		for _, c := range parts[0] {
+			g.Index = g.Index*10 + int(c-'0')
+		}
+	}
+	return g
+}
+
+func newModel() *model {
+	m := &model{
+		server:   "http://gpumon.example:8080",
+		interval: 5 * time.Second,
+		utilHist: map[string][]float64{},
+		tempHist: map[string][]float64{},
+		sortKey:  1,
+		reverse:  true,
+	}
+	m.apply(snapshot{
+		gpus: []report.GPUReport{
+			gpu("0@node-b@NVIDIA H100 80GB HBM3", 90, 71, map[string]string{"cluster": "training"}),
+			gpu("1@node-b@NVIDIA H100 80GB HBM3", 10, 45, map[string]string{"cluster": "training"}),
+			gpu("0@node-a@NVIDIA A100-SXM4-40GB", 55, 83, map[string]string{"cluster": "inference"}),
+			gpu("1@node-a@NVIDIA A100-SXM4-40GB", 55, 60, map[string]string{"cluster": "inference"}),
+			gpu("0@node-c@AMD Instinct MI300X", 0, 38, nil),
+		},
+		hosts: []report.HostReport{{Hostname: "node-a"}, {Hostname: "node-b"}, {Hostname: "node-c"}},
+		health: api.Health{Alerts: []alerts.Alert{
+			{Rule: "gpu_temp", Severity: alerts.Warning, Host: "node-a", GPU: "0@node-a@NVIDIA A100-SXM4-40GB", Message: "0@node-a@NVIDIA A100-SXM4-40GB is at 83°C"},
+			{Rule: "gpu_xid", Severity: alerts.Critical, Host: "node-a", GPU: "0@node-a@NVIDIA A100-SXM4-40GB", Message: "XID 79 on 0@node-a@NVIDIA A100-SXM4-40GB"},
+			{Rule: "gpu_idle", Severity: alerts.Warning, Host: "node-c", GPU: "0@node-c@AMD Instinct MI300X", Message: "0@node-c@AMD Instinct MI300X is idle"},
+			{Rule: "host_stale", Severity: alerts.Critical, Host: "node-d", Message: "node-d has not reported for 10m"},
+		}},
+		at: time.Date(2026, 10, 19, 10, 0, 5, 0, time.UTC),
+	})
+	return m
+}
+
+// order lists the rows as host/index.
+func order(m *model) string {
+	var names []string
+	for _, g := range m.rows() {
+		names = append(names, g.Host()+"/"+g.Name[:1])
+	}
+	return strings.Join(names, " ")
+}
+
+func TestRows(t *testing.T) {
+	tests := []struct {
+		name       string
+		sortKey    string
+		reverse    bool
+		filter     string
+		onlyAlerts bool
+		want       string
+	}{
+		// Ties are broken by name.
+		{"util", "util", true, "", false, "node-b/0 node-a/0 node-a/1 node-b/1 node-c/0"},
+		{"util ascending", "util", false, "", false, "node-c/0 node-b/1 node-a/0 node-a/1 node-b/0"},
+		{"temp", "temp", true, "", false, "node-a/0 node-b/0 node-a/1 node-b/1 node-c/0"},
+		{"mem", "mem", false, "", false, "node-c/0 node-b/1 node-a/0 node-a/1 node-b/0"},
+		{"power", "power", true, "", false, "node-b/0 node-a/0 node-a/1 node-b/1 node-c/0"},
+		{"alerts", "alerts", true, "", false, "node-a/0 node-c/0 node-b/0 node-a/1 node-b/1"},
+		// Hosts sort by name, their GPUs always by index.
+		{"host", "host", false, "", false, "node-a/0 node-a/1 node-b/0 node-b/1 node-c/0"},
+		{"host reversed", "host", true, "", false, "node-c/0 node-b/0 node-b/1 node-a/0 node-a/1"},
+		{"filter by host", "util", true, "NODE-B", false, "node-b/0 node-b/1"},
+		{"filter by model", "util", true, "a100", false, "node-a/0 node-a/1"},
+		{"filter by label value", "host", false, "infer", false, "node-a/0 node-a/1"},
+		{"filter matches nothing", "util", true, "node-z", false, ""},
+		{"only alerts", "util", true, "", true, "node-a/0 node-c/0"},
+		{"only alerts and filter", "util", true, "mi300", true, "node-c/0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newModel()
+			for i, k := range sortKeys {
+				if k == tt.sortKey {
+					m.sortKey = i
+				}
+			}
+			m.reverse, m.filter, m.onlyAlerts = tt.reverse, tt.filter, tt.onlyAlerts
+			if got := order(m); got != tt.want {
+				t.Errorf("rows %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"q", []string{"q"}},
+		{"jjk", []string{"j", "j", "k"}},
+		{"\x1b[A\x1b[B\x1b[C\x1b[D", []string{"up", "down", "right", "left"}},
+		{"\x1bOA\x1bOB", []string{"up", "down"}},
+		{"\x1b[5~\x1b[6~", []string{"pgup", "pgdn"}},
+		{"\x1b[H\x1b[F\x1b[1~\x1b[4~", []string{"home", "end", "home", "end"}},
+		{"\x1b[Aj", []string{"up", "j"}},
+		{"\x1b", []string{"esc"}},
+		{"\x1b\x1b[B", []string{"esc", "down"}},
+		// Unknown sequences come out as esc and the characters after it.
+		{"\x1b[Z", []string{"esc", "[", "Z"}},
+		{"\r\n", []string{"enter", "enter"}},
+		{"ab\x7f\x08", []string{"a", "b", "backspace", "backspace"}},
+		{"\x03", []string{"ctrl-c"}},
+		{"größe", []string{"g", "r", "ö", "ß", "e"}},
+		{"GPU🔥", []string{"G", "P", "U", "🔥"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for in := tt.in; len(in) > 0; {
+			var key string
+			key, in = nextKey(in)
+			got = append(got, key)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("nextKey(%q) gave %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// press sends keys, given as nextKey would decode them, to m.
+func press(m *model, keys ...string) bool {
+	for _, k := range keys {
+		if m.handleKey(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHandleKey(t *testing.T) {
+	m := newModel()
+	press(m, "j", "j", "down", "down", "down", "down")
+	if m.cursor != 4 {
+		t.Errorf("cursor %d after moving past the end, want 4", m.cursor)
+	}
+	press(m, "k", "up")
+	if m.cursor != 2 {
+		t.Errorf("cursor %d, want 2", m.cursor)
+	}
+	press(m, "home", "up", "pgdn")
+	if m.cursor != 4 {
+		t.Errorf("cursor %d after pgdn, want 4", m.cursor)
+	}
+
+	press(m, "g", "enter")
+	if m.detail != "0@node-b@NVIDIA H100 80GB HBM3" {
+		t.Errorf("detail %q, want the busiest GPU", m.detail)
+	}
+	press(m, "esc")
+	if m.detail != "" {
+		t.Errorf("esc left detail %q", m.detail)
+	}
+
+	press(m, "s")
+	if sortKeys[m.sortKey] != "temp" || !m.reverse {
+		t.Errorf("sort %s reverse %v, want temp descending", sortKeys[m.sortKey], m.reverse)
+	}
+	press(m, "s", "s", "s", "s")
+	if sortKeys[m.sortKey] != "host" || m.reverse {
+		t.Errorf("sort %s reverse %v, want host ascending", sortKeys[m.sortKey], m.reverse)
+	}
+	press(m, "r", "a")
+	if !m.reverse || !m.onlyAlerts || m.cursor != 0 {
+		t.Errorf("reverse %v, only alerts %v, cursor %d", m.reverse, m.onlyAlerts, m.cursor)
+	}
+
+	for _, k := range []string{"q", "ctrl-c"} {
+		if !press(newModel(), k) {
+			t.Errorf("%s does not quit", k)
+		}
+	}
+}
+
+func TestFilterPrompt(t *testing.T) {
+	m := newModel()
+	press(m, "G", "/", "n", "o", "d", "e", "-", "x", "backspace", "b")
+	if m.filter != "" || m.prompt == nil || string(m.prompt.buf) != "node-b" {
+		t.Fatalf("filter %q while typing %+v", m.filter, m.prompt)
+	}
+	// Keys go to the prompt, not the list.
+	if press(m, "q", "backspace", "up", "\x01") {
+		t.Fatal("q in the prompt quits")
+	}
+	press(m, "enter")
+	if m.filter != "node-b" || m.prompt != nil || m.cursor != 0 {
+		t.Errorf("filter %q, prompt %+v, cursor %d", m.filter, m.prompt, m.cursor)
+	}
+	if got := order(m); got != "node-b/0 node-b/1" {
+		t.Errorf("rows %q", got)
+	}
+
+	// esc keeps the old filter.
+	press(m, "/", "backspace", "backspace", "esc")
+	if m.filter != "node-b" || m.prompt != nil {
+		t.Errorf("filter %q after esc", m.filter)
+	}
+}
+
+func TestSelectorPrompt(t *testing.T) {
+	m := newModel()
+	press(m, "l")
+	press(m, strings.Split("env==a!b", "")...)
+	press(m, "enter")
+	if m.prompt == nil || m.prompt.label != "Invalid selector, try again" {
+		t.Fatalf("prompt %+v after an invalid selector", m.prompt)
+	}
+	if m.selector != "" || string(m.prompt.buf) != "env==a!b" {
+		t.Errorf("selector %q, buffer %q; want the selector unchanged and the input kept", m.selector, string(m.prompt.buf))
+	}
+
+	press(m, "backspace", "backspace", "enter")
+	if m.prompt != nil || m.selector != "env==a" {
+		t.Errorf("selector %q, prompt %+v", m.selector, m.prompt)
+	}
+
+	// An empty selector clears it.
+	press(m, "l", "backspace", "backspace", "backspace", "backspace", "backspace", "backspace", "enter")
+	if m.prompt != nil || m.selector != "" {
+		t.Errorf("selector %q, prompt %+v", m.selector, m.prompt)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values []float64
+		width  int
+		lo, hi float64
+		want   string
+	}{
+		{nil, 4, 0, 100, "    "},
+		{[]float64{0, 50, 100}, 5, 0, 100, "  ▁▄█"},
+		{[]float64{0, 14.3, 28.6, 42.9, 57.2, 71.5, 85.8, 100}, 8, 0, 100, "▁▂▃▄▅▆▇█"},
+		// The newest values are kept.
+		{[]float64{100, 100, 0, 100}, 2, 0, 100, "▁█"},
+		// Out of range values are clamped.
+		{[]float64{-20, 10, 120}, 3, 20, 100, "▁▁█"},
+	}
+	for _, tt := range tests {
+		got := sparkline(tt.values, tt.width, tt.lo, tt.hi)
+		if got != tt.want {
+			t.Errorf("sparkline(%v, %d) = %q, want %q", tt.values, tt.width, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n != tt.width {
+			t.Errorf("sparkline(%v, %d) is %d wide", tt.values, tt.width, n)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	for _, tt := range []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"node", 6, "node  "},
+		{"node", 4, "node"},
+		{"node-01", 5, "node…"},
+		{"größe", 4, "grö…"},
+		{"node", 1, "n"},
+		{"node", 0, ""},
+		{"", 3, "   "},
+	} {
+		if got := fit(tt.s, tt.width); got != tt.want {
+			t.Errorf("fit(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+var escapeSeq = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// checkScreen fails unless screen is exactly height lines, none wider
+// than width.
+func checkScreen(t *testing.T, screen string, width, height int) {
+	t.Helper()
+	if !strings.HasPrefix(screen, "\x1b[H") || strings.HasSuffix(screen, "\r\n") {
+		t.Errorf("screen does not start at home or ends with a newline: %q", screen)
+	}
+	lines := strings.Split(screen, "\r\n")
+	if len(lines) != height {
+		t.Errorf("%d lines, want %d:\n%s", len(lines), height, strings.Join(lines, "\n"))
+	}
+	for i, l := range lines {
+		plain := escapeSeq.ReplaceAllString(l, "")
+		if strings.ContainsAny(plain, "\r\n\x1b") {
+			t.Errorf("line %d has control characters: %q", i, l)
+		}
+		if n := utf8.RuneCountInString(plain); n > width {
+			t.Errorf("line %d is %d wide, want at most %d: %q", i, n, width, plain)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	sizes := [][2]int{{40, 10}, {41, 11}, {80, 24}, {120, 40}, {160, 50}}
+	views := []struct {
+		name  string
+		setup func(m *model)
+	}{
+		{"list", func(m *model) {}},
+		{"cursor at the end", func(m *model) { press(m, "G") }},
+		{"many GPUs", func(m *model) {
+			for i := 0; i < 60; i++ {
+				m.snap.gpus = append(m.snap.gpus, gpu(fmt.Sprintf("%d@node-x%s@NVIDIA H100", i%10, strings.Repeat("y", i)), i, 40+i, nil))
+				m.snap.health.Alerts = append(m.snap.health.Alerts, alerts.Alert{Severity: alerts.Warning, Host: "node-x", Message: strings.Repeat("warning ", i)})
+			}
+			press(m, "G")
+		}},
+		{"no GPUs", func(m *model) { m.snap = snapshot{} }},
+		{"only alerts", func(m *model) { press(m, "a") }},
+		{"filtered to nothing", func(m *model) { m.filter = "node-z" }},
+		{"long filter and selector", func(m *model) {
+			m.filter = strings.Repeat("f", 50)
+			m.selector = "cluster=training,env!=" + strings.Repeat("s", 50)
+		}},
+		{"error", func(m *model) {
+			m.apply(snapshot{err: errors.New("Get \"http://gpumon.example:8080/gpu/list\": connection refused")})
+		}},
+		{"prompt", func(m *model) {
+			press(m, append([]string{"/"}, strings.Split(strings.Repeat("typing ", 20), "")...)...)
+		}},
+		{"detail", func(m *model) { press(m, "enter") }},
+		{"detail with history", func(m *model) {
+			for i := 0; i < historyLen*2; i++ {
+				m.apply(m.snap)
+			}
+			press(m, "enter")
+		}},
+		{"detail of a GPU with MIG and processes", func(m *model) {
+			g := &m.snap.gpus[0]
+			g.MIGMode = true
+			g.ProcessNames = strings.Repeat("python train.py,", 30)
+			g.ThrottleReasons = []string{"hw_slowdown", "sw_thermal_slowdown", "sw_power_cap"}
+			g.XIDErrors = []int{13, 31, 43, 79}
+			for i := 0; i < 7; i++ {
+				g.MIGInstances = append(g.MIGInstances, report.MIGInstance{Profile: "1g.10gb", GPUInstanceID: i, ProcessNames: "worker,worker"})
+			}
+			press(m, "enter")
+		}},
+		{"detail of a GPU that is gone", func(m *model) { press(m, "enter"); m.snap.gpus = nil }},
+	}
+	for _, v := range views {
+		for _, s := range sizes {
+			t.Run(fmt.Sprintf("%s/%dx%d", v.name, s[0], s[1]), func(t *testing.T) {
+				m := newModel()
+				v.setup(m)
+				checkScreen(t, m.render(s[0], s[1]), s[0], s[1])
+			})
+		}
+	}
+}